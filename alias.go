@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 展开命令别名
+// 如果第一个参数是配置中定义的别名，则替换为别名对应的参数，其余参数原样追加在后面。
+// 例如配置 ship-prod: "-url https://prod.example.com/upload -file"，
+// 执行 docker_save_shell ship-prod app.tar 等价于
+// docker_save_shell -url https://prod.example.com/upload -file app.tar
+func expandAlias(args []string, aliases map[string]string) ([]string, error) {
+	if len(args) == 0 || len(aliases) == 0 {
+		return args, nil
+	}
+
+	expansion, ok := aliases[args[0]]
+	if !ok {
+		return args, nil
+	}
+
+	words, err := splitArgs(expansion)
+	if err != nil {
+		return nil, fmt.Errorf("别名 %s 格式错误: %w", args[0], err)
+	}
+
+	return append(words, args[1:]...), nil
+}
+
+// 按 shell 规则拆分参数字符串，支持单引号、双引号和反斜杠转义
+func splitArgs(s string) ([]string, error) {
+	var (
+		words   []string
+		current strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+
+	for _, r := range s {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, current.String())
+				current.Reset()
+				inWord = false
+			}
+		default:
+			current.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("引号未闭合")
+	}
+	if escaped {
+		return nil, fmt.Errorf("末尾存在未完成的转义")
+	}
+	if inWord {
+		words = append(words, current.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// 参数文件最大嵌套层数，防止 @a 引用 @b 再引用 @a 造成死循环
+const maxArgFileDepth = 8
+
+// 展开 @file 形式的参数文件
+// 文件中每行一个参数，忽略空行和以 # 开头的注释行，行首尾空白会被去掉。
+// 参数文件中可以再引用其他参数文件；需要传入以 @ 开头的普通参数时写成 @@xxx。
+func expandArgFiles(args []string) ([]string, error) {
+	return expandArgFilesDepth(args, 0)
+}
+
+func expandArgFilesDepth(args []string, depth int) ([]string, error) {
+	if depth > maxArgFileDepth {
+		return nil, fmt.Errorf("参数文件嵌套超过 %d 层", maxArgFileDepth)
+	}
+
+	var result []string
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "@@"):
+			result = append(result, arg[1:])
+		case strings.HasPrefix(arg, "@") && len(arg) > 1:
+			lines, err := readArgFile(arg[1:])
+			if err != nil {
+				return nil, err
+			}
+			expanded, err := expandArgFilesDepth(lines, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, expanded...)
+		default:
+			result = append(result, arg)
+		}
+	}
+	return result, nil
+}
+
+// 读取参数文件中的参数列表
+func readArgFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("无法打开参数文件: %w", err)
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取参数文件 %s 失败: %w", path, err)
+	}
+	return lines, nil
+}
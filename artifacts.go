@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 制品名称和版本号允许的字符
+var artifactNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// 保留的版本号，指向最新上传的版本
+const latestVersion = "latest"
+
+// artifactMeta 版本化制品的元数据，存放在版本目录下的 meta.json
+type artifactMeta struct {
+	Name    string    `json:"name"`
+	Version string    `json:"version"`
+	File    string    `json:"file"`
+	Size    int64     `json:"size"`
+	SHA256  string    `json:"sha256"`
+	Tree    string    `json:"tree_sha256,omitempty"`
+	Created time.Time `json:"created"`
+	Latest  bool      `json:"latest,omitempty"`
+
+	Uploader string            `json:"uploader,omitempty"` // 上传所用令牌的标签或 ID，未启用鉴权时为客户端地址
+	Labels   map[string]string `json:"labels,omitempty"`
+	Transfer string            `json:"transfer_id,omitempty"` // 上传该版本的传输 ID
+}
+
+// 版本化制品的存储根目录: <dir>/artifacts/<name>/<version>/
+func (s *server) artifactRoot() string {
+	return filepath.Join(s.opts.dir, "artifacts")
+}
+
+// 校验制品名称或版本号
+func validArtifactPart(kind, v string) error {
+	if !artifactNamePattern.MatchString(v) || len(v) > 128 {
+		return fmt.Errorf("非法的制品%s: %q", kind, v)
+	}
+	return nil
+}
+
+// 将上传的文件提交为不可变的制品版本，并更新 latest 指针
+func (s *server) commitArtifact(rf *receivedFile, name, version, uploader string, labels map[string]string) (*artifactMeta, int, error) {
+	if err := validArtifactPart("名称", name); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	if version == "" {
+		return nil, http.StatusBadRequest, errors.New("指定制品名称时必须同时指定版本号")
+	}
+	if err := validArtifactPart("版本号", version); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	if version == latestVersion {
+		return nil, http.StatusBadRequest, errors.New("latest 是保留的版本号")
+	}
+
+	nameDir := filepath.Join(s.artifactRoot(), name)
+	if err := os.MkdirAll(nameDir, 0o755); err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("创建制品目录失败: %w", err)
+	}
+
+	// Mkdir 是原子操作，借此保证同一版本只能被上传一次
+	versionDir := filepath.Join(nameDir, version)
+	if err := os.Mkdir(versionDir, 0o755); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return nil, http.StatusConflict, fmt.Errorf("制品 %s@%s 已存在，版本不可覆盖", name, version)
+		}
+		return nil, http.StatusInternalServerError, fmt.Errorf("创建版本目录失败: %w", err)
+	}
+
+	meta := &artifactMeta{
+		Name:    name,
+		Version: version,
+		File:    rf.name,
+		Size:    rf.size,
+		SHA256:  rf.storedSHA256(),
+		Tree:    rf.treeSHA256,
+		Created: time.Now(),
+
+		Uploader: uploader,
+		Labels:   labels,
+		Transfer: rf.transferID,
+	}
+
+	if err := os.Rename(rf.tmpPath, filepath.Join(versionDir, rf.name)); err != nil {
+		os.RemoveAll(versionDir)
+		return nil, http.StatusInternalServerError, fmt.Errorf("保存制品失败: %w", err)
+	}
+	rf.tmpPath = ""
+
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	// 状态存储在多个副本间共享时，版本目录只在本机唯一，由 Create 保证全局只能提交一次
+	if err := s.store.Create(artifactMetaKey(name, version), data); err != nil {
+		os.RemoveAll(versionDir)
+		if errors.Is(err, os.ErrExist) {
+			return nil, http.StatusConflict, fmt.Errorf("制品 %s@%s 已存在，版本不可覆盖", name, version)
+		}
+		return nil, http.StatusInternalServerError, fmt.Errorf("保存制品元数据失败: %w", err)
+	}
+	if err := s.store.Put(artifactLatestKey(name), []byte(version), 0); err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("更新 latest 指针失败: %w", err)
+	}
+
+	meta.Latest = true
+	return meta, http.StatusOK, nil
+}
+
+// 制品元数据在状态存储中的键
+func artifactMetaKey(name, version string) string {
+	return "artifacts/" + name + "/" + version + "/meta.json"
+}
+
+// 制品 latest 指针在状态存储中的键
+func artifactLatestKey(name string) string {
+	return "artifacts/" + name + "/" + latestVersion
+}
+
+// 以 JSON 格式原子写入文件
+func writeFileAtomic(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("写入 %s 失败: %w", filepath.Base(path), err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// 读取制品某个版本的元数据，version 为 latest 时解析指针
+func (s *server) loadArtifact(name, version string) (*artifactMeta, error) {
+	if err := validArtifactPart("名称", name); err != nil {
+		return nil, err
+	}
+
+	latest, _ := s.store.Get(artifactLatestKey(name))
+	if version == latestVersion {
+		if len(latest) == 0 {
+			return nil, os.ErrNotExist
+		}
+		version = string(latest)
+	}
+	if err := validArtifactPart("版本号", version); err != nil {
+		return nil, err
+	}
+
+	data, err := s.store.Get(artifactMetaKey(name, version))
+	if err != nil {
+		return nil, err
+	}
+	meta := &artifactMeta{}
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil, fmt.Errorf("解析制品元数据失败: %w", err)
+	}
+	meta.Latest = meta.Version == string(latest)
+	return meta, nil
+}
+
+// 列出制品版本，支持 ?name= 过滤
+func (s *server) handleListArtifacts(w http.ResponseWriter, r *http.Request) {
+	filter := r.URL.Query().Get("name")
+
+	all, err := s.allArtifacts()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, uploadResult{Error: err.Error()})
+		return
+	}
+
+	list := []*artifactMeta{}
+	for _, meta := range all {
+		if filter == "" || meta.Name == filter {
+			list = append(list, meta)
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// 读取所有制品版本的元数据，按名称、创建时间排序
+func (s *server) allArtifacts() ([]*artifactMeta, error) {
+	keys, err := s.store.List("artifacts/")
+	if err != nil {
+		return nil, fmt.Errorf("读取制品索引失败: %w", err)
+	}
+
+	var list []*artifactMeta
+	for _, key := range keys {
+		parts := strings.Split(key, "/")
+		if len(parts) != 4 || parts[3] != "meta.json" {
+			continue
+		}
+		if meta, err := s.loadArtifact(parts[1], parts[2]); err == nil {
+			list = append(list, meta)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name != list[j].Name {
+			return list[i].Name < list[j].Name
+		}
+		return list[i].Created.Before(list[j].Created)
+	})
+	return list, nil
+}
+
+// 下载制品的指定版本 (或 latest)
+func (s *server) handleDownloadArtifact(w http.ResponseWriter, r *http.Request) {
+	meta, err := s.loadArtifact(r.PathValue("name"), r.PathValue("version"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			writeJSON(w, http.StatusNotFound, uploadResult{Error: "制品不存在"})
+			return
+		}
+		writeJSON(w, http.StatusBadRequest, uploadResult{Error: err.Error()})
+		return
+	}
+
+	s.serveStoredFile(w, r, s.artifactFilePath(meta), meta.SHA256)
+}
+
+// 制品版本对应的存储文件路径
+func (s *server) artifactFilePath(meta *artifactMeta) string {
+	return filepath.Join(s.artifactRoot(), meta.Name, meta.Version, meta.File)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// -basic-auth 未写密码时读取的环境变量
+const basicPasswordEnv = "DOCKER_SAVE_SHELL_PASSWORD"
+
+// 由 -token、-basic-auth、-header 和传输 ID 构造附加到请求上的头部
+func requestHeaders(opts options) (http.Header, error) {
+	h := http.Header{}
+	if opts.transferID != "" {
+		h.Set(transferIDHeader, opts.transferID)
+	}
+	for _, line := range opts.headers {
+		k, v, ok := strings.Cut(line, ":")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" || strings.ContainsAny(k, " \t") {
+			return nil, fmt.Errorf("-header 格式应为 \"名称: 值\": %q", line)
+		}
+		h.Add(textproto.CanonicalMIMEHeaderKey(k), strings.TrimSpace(v))
+	}
+
+	if opts.token != "" && opts.basicAuth != "" {
+		return nil, errors.New("-token 与 -basic-auth 不能同时使用")
+	}
+	if (opts.token != "" || opts.basicAuth != "") && opts.negotiate {
+		return nil, errors.New("-token、-basic-auth 不能与 -negotiate 同时使用")
+	}
+	switch {
+	case opts.token != "":
+		h.Set("Authorization", "Bearer "+opts.token)
+	case opts.basicAuth != "":
+		user, password, ok := strings.Cut(opts.basicAuth, ":")
+		if !ok {
+			password = os.Getenv(basicPasswordEnv)
+		}
+		if user == "" || password == "" {
+			return nil, errors.New("-basic-auth 格式应为 user[:password]，未写密码时需设置 " + basicPasswordEnv)
+		}
+		h.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user+":"+password)))
+	}
+	return h, nil
+}
+
+// 为客户端发往 target 所在主机的请求附加头部，命令行指定的值覆盖请求原有的同名头部。
+// 重定向到其他主机时不附加，避免凭据泄露给第三方 (如制品库重定向到的对象存储)
+func (c *sessionClient) useHeaders(target string, h http.Header) error {
+	if len(h) == 0 {
+		return nil
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("地址格式错误: %w", err)
+	}
+	c.Client.Transport = staticHeaders{host: u.Host, header: h, next: c.Client.Transport}
+	return nil
+}
+
+// staticHeaders 为指定主机的请求附加固定的头部
+type staticHeaders struct {
+	host   string
+	header http.Header
+	next   http.RoundTripper
+}
+
+func (t staticHeaders) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host == t.host {
+		req = req.Clone(req.Context())
+		for k, v := range t.header {
+			req.Header[k] = v
+		}
+	}
+	return t.next.RoundTrip(req)
+}
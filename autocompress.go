@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// 测量链路带宽的探测数据量：先发送小探测，很快完成时 (高速网络) 再发送大探测以免低估
+	linkProbeSmall   = 1 << 20
+	linkProbeLarge   = 16 << 20
+	linkProbeTimeout = 10 * time.Second
+
+	// 采样数据少于该值时计时误差太大，直接使用默认的 zstd
+	minAutoSample = 64 * 1024
+)
+
+// 供自动选择的压缩方案，按压缩率从低到高排列
+var autoCandidates = []string{"zstd-fast", "zstd", "zstd-high"}
+
+// autoCompression -compress auto：比较各方案的有效吞吐 (单位时间内送达的原始数据量)，
+// 不压缩时为链路带宽，压缩时为 min(压缩速度, 链路带宽 / 压缩率)。
+// 与最优方案相差不超过 10% 时选择压缩率更高的方案，使高速局域网上压缩不成为瓶颈，慢速广域网上尽量节省流量
+type autoCompression struct {
+	link   float64 // 链路带宽 (字节/秒)，0 表示未知
+	reason string  // 选择的依据，build 之后记录到流水线的决策中
+}
+
+// 根据采样数据选择压缩阶段，返回空表示不压缩
+func (a *autoCompression) choose(sample []byte) string {
+	if skip, why := shouldSkipCompression(sample); skip {
+		a.reason = "自动压缩: 不压缩，" + why
+		return ""
+	}
+	if a.link <= 0 || len(sample) < minAutoSample {
+		a.reason = "自动压缩: zstd (链路带宽未知或数据较少)"
+		return "zstd"
+	}
+
+	names := append([]string{"none"}, autoCandidates...)
+	rates := []float64{a.link}
+	details := []string{fmt.Sprintf("none %s/s", formatBytes(int64(a.link)))}
+	for _, name := range autoCandidates {
+		cpu, ratio := measureZstd(sample, zstdLevels[name])
+		rates = append(rates, min(cpu, a.link/ratio))
+		details = append(details, fmt.Sprintf("%s %s/s (压缩率 %.0f%%，压缩速度 %s/s)", name, formatBytes(int64(rates[len(rates)-1])), ratio*100, formatBytes(int64(cpu))))
+	}
+
+	best := 0.0
+	for _, r := range rates {
+		best = max(best, r)
+	}
+	chosen := 0
+	for i, r := range rates {
+		if r >= best*0.9 {
+			chosen = i
+		}
+	}
+	a.reason = fmt.Sprintf("自动压缩: %s，链路 %s/s，有效吞吐: %s", names[chosen], formatBytes(int64(a.link)), strings.Join(details, "; "))
+	if chosen == 0 {
+		return ""
+	}
+	return names[chosen]
+}
+
+// 以指定级别压缩采样数据，返回压缩速度 (原始字节/秒) 和压缩率
+func measureZstd(sample []byte, level zstd.EncoderLevel) (float64, float64) {
+	enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(level))
+	defer enc.Close()
+	start := time.Now()
+	out := enc.EncodeAll(sample, nil)
+	elapsed := max(time.Since(start), time.Microsecond)
+	return float64(len(sample)) / elapsed.Seconds(), float64(len(out)) / float64(len(sample))
+}
+
+// 通过服务端的 /probe 测量链路带宽，-limit-rate 限速时取两者中较小者；无法测量时返回 0
+func measureLink(ctx context.Context, client *sessionClient, opts options) float64 {
+	var link float64
+	if endpoint, err := url.JoinPath(opts.serverURL, "probe"); err == nil && ctx.Err() == nil {
+		r := sendProbe(client, endpoint, linkProbeSmall, linkProbeTimeout)
+		if r.Err == nil && r.Duration < 200*time.Millisecond {
+			r = sendProbe(client, endpoint, linkProbeLarge, linkProbeTimeout)
+		}
+		if r.Err == nil {
+			link = r.speed()
+		}
+	}
+	if opts.limitRate > 0 && (link == 0 || float64(opts.limitRate) < link) {
+		link = float64(opts.limitRate)
+	}
+	return link
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// batchFile 批量任务文件：jobs 中每一项与 job export 导出的任务定义格式相同
+type batchFile struct {
+	Version     int            `yaml:"version" json:"version"`
+	Concurrency int            `yaml:"concurrency,omitempty" json:"concurrency,omitempty"` // 未指定 -concurrency 时使用
+	Jobs        []*transferJob `yaml:"jobs" json:"jobs"`
+}
+
+// batchResult 单个任务的执行结果
+type batchResult struct {
+	job      *transferJob
+	err      error
+	duration time.Duration
+}
+
+// 读取批量任务文件：YAML/JSON 为 batchFile 或任务列表；CSV 首行为列名，
+// source、target 以外的列为任务选项 (列名同任务文件中的 options 键，如 name、version、retries)。
+// 任务未指定 target 时使用 defaultTarget (命令行的 -url)
+func loadBatch(path, defaultTarget string) (*batchFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取批量任务文件失败: %w", err)
+	}
+
+	batch := &batchFile{}
+	switch {
+	case strings.EqualFold(filepath.Ext(path), ".csv"):
+		if batch.Jobs, err = parseBatchCSV(data); err != nil {
+			return nil, err
+		}
+	case strings.HasPrefix(strings.TrimSpace(string(data)), "[") || strings.HasPrefix(strings.TrimSpace(string(data)), "-"):
+		if err := yaml.Unmarshal(data, &batch.Jobs); err != nil {
+			return nil, fmt.Errorf("解析批量任务文件失败: %w", err)
+		}
+	default:
+		if err := yaml.Unmarshal(data, batch); err != nil {
+			return nil, fmt.Errorf("解析批量任务文件失败: %w", err)
+		}
+	}
+
+	if batch.Version > jobVersion {
+		return nil, fmt.Errorf("批量任务文件版本 %d 高于当前支持的版本 %d，请升级工具", batch.Version, jobVersion)
+	}
+	if len(batch.Jobs) == 0 {
+		return nil, errors.New("批量任务文件中没有任务")
+	}
+	for i, job := range batch.Jobs {
+		if job == nil {
+			return nil, fmt.Errorf("第 %d 个任务为空", i+1)
+		}
+		if job.Target == "" {
+			job.Target = defaultTarget
+		}
+		if job.Source == "" || job.Target == "" {
+			return nil, fmt.Errorf("第 %d 个任务缺少 source 或 target", i+1)
+		}
+		if job.Version > jobVersion {
+			return nil, fmt.Errorf("第 %d 个任务的版本 %d 高于当前支持的版本 %d", i+1, job.Version, jobVersion)
+		}
+	}
+	return batch, nil
+}
+
+// 解析 CSV 任务列表：每行转换为任务定义后按 YAML 解码，选项值的类型 (布尔、数字) 与任务文件一致
+func parseBatchCSV(data []byte) ([]*transferJob, error) {
+	r := csv.NewReader(strings.NewReader(string(data)))
+	r.Comment = '#'
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("读取 CSV 列名失败: %w", err)
+	}
+
+	var jobs []*transferJob
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			return jobs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("解析 CSV 失败: %w", err)
+		}
+
+		def := map[string]any{}
+		opts := map[string]any{}
+		for i, col := range header {
+			col = strings.TrimSpace(col)
+			v := strings.TrimSpace(record[i])
+			switch {
+			case v == "":
+			case col == "source" || col == "target":
+				def[col] = v
+			default:
+				var value any
+				if yaml.Unmarshal([]byte(v), &value) != nil {
+					value = v
+				}
+				opts[col] = value
+			}
+		}
+		def["options"] = opts
+
+		out, err := yaml.Marshal(def)
+		if err != nil {
+			return nil, err
+		}
+		job := &transferJob{}
+		if err := yaml.Unmarshal(out, job); err != nil {
+			return nil, fmt.Errorf("CSV 第 %d 行: %w", line, err)
+		}
+		jobs = append(jobs, job)
+	}
+}
+
+// 按并发上限执行批量任务，各任务独立重试、互不影响，全部结束后输出结果汇总；有任务失败时以 1 退出
+func runBatch(path, defaultTarget string, concurrency int) {
+	batch, err := loadBatch(path, defaultTarget)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if concurrency <= 0 {
+		concurrency = max(batch.Concurrency, 1)
+	}
+
+	// 先把所有任务还原为上传选项，定义有误时一个都不执行
+	all := make([]options, len(batch.Jobs))
+	for i, job := range batch.Jobs {
+		if all[i], err = job.options(); err != nil {
+			fmt.Printf("第 %d 个任务 (%s): %v\n", i+1, job.Source, err)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("📋 批量任务: %d 个，并发 %d\n", len(batch.Jobs), concurrency)
+	results := make([]batchResult, len(batch.Jobs))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, job := range batch.Jobs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			fmt.Printf("\n▶️  [%d/%d] %s → %s\n", i+1, len(batch.Jobs), job.Source, job.Target)
+			opts := all[i]
+			opts.retry = newRetryBudget(opts.retryBudget, opts.retryDeadline)
+			start := time.Now()
+			err := upload(context.Background(), opts)
+			results[i] = batchResult{job: job, err: err, duration: time.Since(start)}
+			if err != nil {
+				fmt.Printf("❌ [%d/%d] %s: %v\n", i+1, len(batch.Jobs), job.Source, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if printBatchResults(results) > 0 {
+		os.Exit(1)
+	}
+}
+
+// 输出各任务的结果，返回失败的任务数
+func printBatchResults(results []batchResult) int {
+	failed := 0
+	fmt.Println("\n📊 批量任务结果:")
+	fmt.Printf("%-4s %-6s %10s  %s\n", "#", "RESULT", "DURATION", "SOURCE → TARGET")
+	for i, r := range results {
+		status := "OK"
+		if r.err != nil {
+			status = "FAIL"
+			failed++
+		}
+		fmt.Printf("%-4d %-6s %10s  %s → %s\n", i+1, status, r.duration.Round(time.Second), r.job.Source, r.job.Target)
+		if r.err != nil {
+			fmt.Printf("%22s%v\n", "", r.err)
+		}
+	}
+	fmt.Printf("\n共 %d 个任务，成功 %d，失败 %d\n", len(results), len(results)-failed, failed)
+	return failed
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// 判断是否为块设备 (磁盘、分区、LVM 快照等)
+func isBlockDevice(info os.FileInfo) bool {
+	mode := info.Mode()
+	return mode&os.ModeDevice != 0 && mode&os.ModeCharDevice == 0
+}
+
+// 以块设备作为数据源：Stat 报告的大小为 0，需要向内核查询设备容量；
+// 上传的文件名取设备名加 .img，例如 /dev/vg0/snap 上传为 snap.img
+func openBlockDevice(file *os.File, p string) (*source, error) {
+	size, err := blockDeviceSize(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("获取块设备大小失败: %w", err)
+	}
+	return &source{ReadCloser: file, name: filepath.Base(p) + ".img", size: size}, nil
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// 其他平台上定位到设备末尾得到大小，再回到开头
+func blockDeviceSize(f *os.File) (int64, error) {
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// uploadSession 本机未完成的分块上传或 tus 上传，来自各自的断点文件；
+// 上传完成或已通知服务端放弃时断点文件即被删除，进程异常退出或超出时间窗口后留下的由 cancel 子命令处理
+type uploadSession struct {
+	ID       string
+	Kind     string // parts 或 tus
+	Location string
+	File     string
+	Created  time.Time
+
+	path string // 断点文件
+}
+
+func (s *uploadSession) remove() {
+	if s.path != "" {
+		os.Remove(s.path)
+	}
+}
+
+// 列出本机未完成的分块上传和 tus 断点，按创建时间排序
+func listUploadSessions() []*uploadSession {
+	var sessions []*uploadSession
+	paths, _ := filepath.Glob(filepath.Join(stateDir(), "parts", "*.json"))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		st := &partsState{}
+		if json.Unmarshal(data, st) != nil || st.Location == "" {
+			continue
+		}
+		sessions = append(sessions, &uploadSession{ID: st.ID, Kind: "parts", Location: st.Location, File: st.Source, Created: st.Created, path: path})
+	}
+	paths, _ = filepath.Glob(filepath.Join(stateDir(), "tus", "*.json"))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		st := &tusState{}
+		if json.Unmarshal(data, st) != nil || st.Location == "" {
+			continue
+		}
+		id := strings.TrimSuffix(filepath.Base(path), ".json")
+		sessions = append(sessions, &uploadSession{ID: id, Kind: "tus", Location: st.Location, File: st.Source, Created: st.Updated, path: path})
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Created.Before(sessions[j].Created) })
+	return sessions
+}
+
+// cancel 子命令：不带参数时列出未完成的上传；带 ID 时通知服务端放弃，立即删除已收到的数据
+func runCancel(args []string) {
+	var serverURL, token string
+	fs := newCommandFlags("cancel")
+	fs.StringVar(&serverURL, "url", "", "服务端地址；ID 不在本机记录中时 (如其他机器发起的上传) 向该服务端放弃")
+	fs.StringVar(&token, "token", "", "以 Bearer 令牌认证 (需要 upload 权限)")
+	ids := parseInterspersed(fs, args)
+
+	sessions := listUploadSessions()
+	if len(ids) == 0 {
+		if len(sessions) == 0 {
+			fmt.Println("没有未完成的上传")
+			return
+		}
+		for _, s := range sessions {
+			fmt.Printf("%s  %-5s  %s  %s → %s\n", s.ID, s.Kind, s.Created.Local().Format("2006-01-02 15:04:05"), s.File, s.Location)
+		}
+		return
+	}
+
+	failed := false
+	for _, id := range ids {
+		s := findUploadSession(sessions, id)
+		if s == nil && serverURL != "" {
+			location, err := url.JoinPath(serverURL, "uploads", id)
+			if err != nil {
+				fmt.Printf("服务端地址格式错误: %v\n", err)
+				os.Exit(1)
+			}
+			s = &uploadSession{ID: id, Kind: "parts", Location: location}
+		}
+		if s == nil {
+			fmt.Printf("❌ %s: 本机没有该上传的记录 (可用 -url 指定服务端)\n", id)
+			failed = true
+			continue
+		}
+		if err := cancelUpload(s, token); err != nil {
+			fmt.Printf("❌ %s: %v\n", id, err)
+			failed = true
+			continue
+		}
+		s.remove()
+		fmt.Printf("🗑️  已放弃上传 %s\n", id)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func findUploadSession(sessions []*uploadSession, id string) *uploadSession {
+	for _, s := range sessions {
+		if s.ID == id {
+			return s
+		}
+	}
+	return nil
+}
+
+// 向服务端发送 DELETE 放弃上传；服务端已不存在该上传 (已完成、过期或被清理) 时视为成功
+func cancelUpload(s *uploadSession, token string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "DELETE", s.Location, nil)
+	if err != nil {
+		return err
+	}
+	if s.Kind == "tus" {
+		req.Header.Set("Tus-Resumable", tusVersion)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound, http.StatusGone:
+		return nil
+	}
+	return fmt.Errorf("服务端返回 %s", responseError(resp))
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// 本机与服务端时钟相差超过该值时视为时钟偏差
+const maxClockSkew = 30 * time.Second
+
+// 时钟偏差的处理方式
+const (
+	clockSkewWarn   = "warn"   // 只提示
+	clockSkewAdjust = "adjust" // 提示并以服务端时间作为签名时间
+	clockSkewOff    = "off"    // 不检测
+)
+
+// clockSync 根据服务端响应的 Date 头检测本机时钟偏差。
+// 边缘设备的时钟常常不准，签名请求会因时间戳超出容忍范围被服务端拒绝，
+// 而 403 本身看不出原因，因此在这里提前发现并提示，需要时修正签名时间。
+type clockSync struct {
+	mode   string
+	offset atomic.Int64 // 服务端时间减本机时间 (纳秒)，仅在超过阈值时记录
+	warned atomic.Bool
+}
+
+// 解析 -clock-skew 参数
+func parseClockSkewMode(mode string) (string, error) {
+	switch mode {
+	case "", clockSkewWarn:
+		return clockSkewWarn, nil
+	case clockSkewAdjust, clockSkewOff:
+		return mode, nil
+	}
+	return "", fmt.Errorf("不支持的 -clock-skew: %s (可选 warn、adjust、off)", mode)
+}
+
+// 根据一次请求的响应更新偏差；sent 和 received 为请求发出和收到响应的本机时间
+func (c *clockSync) observe(resp *http.Response, sent, received time.Time) {
+	if c == nil || c.mode == clockSkewOff {
+		return
+	}
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+	// 服务端在请求处理期间生成 Date，取往返的中点作为对应的本机时间；Date 精度为秒，阈值远大于该误差
+	local := sent.Add(received.Sub(sent) / 2)
+	skew := date.Sub(local)
+	if skew > -maxClockSkew && skew < maxClockSkew {
+		c.offset.Store(0)
+		return
+	}
+	c.offset.Store(int64(skew))
+
+	if c.warned.CompareAndSwap(false, true) {
+		fmt.Printf("\n⚠️  本机时钟与服务端相差 %s，签名请求可能因时间戳无效被拒绝，请检查 NTP 同步\n", skew.Round(time.Second))
+		if c.mode == clockSkewAdjust {
+			fmt.Println("   已改用服务端时间作为签名时间")
+		}
+	}
+}
+
+// 当前的偏差，未超过阈值时为 0
+func (c *clockSync) skew() time.Duration {
+	if c == nil {
+		return 0
+	}
+	return time.Duration(c.offset.Load())
+}
+
+// 签名请求使用的时间：adjust 模式下按检测到的偏差修正为服务端时间
+func (c *clockSync) now() time.Time {
+	if c != nil && c.mode == clockSkewAdjust {
+		return time.Now().Add(c.skew())
+	}
+	return time.Now()
+}
+
+// 鉴权失败时附加的时钟提示，未检测到偏差时为空
+func (c *clockSync) authHint() string {
+	if skew := c.skew(); skew != 0 {
+		return fmt.Sprintf("本机时钟与服务端相差 %s，时钟偏差可能导致签名或令牌校验失败", skew.Round(time.Second))
+	}
+	return ""
+}
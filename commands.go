@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// command 一个顶层子命令
+type command struct {
+	name    string
+	args    string // 用法中子命令之后的部分
+	summary string
+	run     func(args []string, cfg *Config)
+}
+
+// 不需要配置文件的子命令
+func withoutConfig(run func(args []string)) func([]string, *Config) {
+	return func(args []string, _ *Config) { run(args) }
+}
+
+// 子命令表，顺序即 help 中列出的顺序。未给出子命令 (第一个参数是选项或文件) 时执行 upload，
+// 因此原有的 docker_save_shell -file ... -url ... 用法不变
+func commandTable() []command {
+	return []command{
+		{"upload", "-url <地址> [参数] <文件|目录|docker-daemon:镜像>...", "上传文件、目录或本机镜像 (默认子命令)", runUploadCommand},
+		{"save", "<镜像>... -url <地址> [上传参数]", "导出本机镜像 (同 docker save) 并边导出边上传", runSave},
+		{"load", "[-tag 标签] <归档>...", "将镜像归档 (可为 gzip/zstd 压缩) 加载到本机 docker", withoutConfig(runLoad)},
+		{"push", "[-to 仓库地址/名称:标签] <归档>", "将 docker save 归档直接推送到镜像仓库 (Distribution API v2)，不经过服务端", withoutConfig(runPush)},
+		{"cancel", "[-url 地址] [ID...]", "列出或放弃未完成的分块/tus 上传，让服务端立即清理已收到的数据", withoutConfig(runCancel)},
+		{"download", "-url URL [-out FILE] [name[@version]]", "下载服务端的文件或版本化制品，中断后可续传", withoutConfig(runDownload)},
+		{"serve", "[参数]", "启动接收服务 (serve token / serve gc 管理令牌和存储)", withoutConfig(runServe)},
+		{"list", "-url URL", "列出服务端的版本化制品", withoutConfig(runList)},
+		{"search", "-url URL [条件]", "按条件搜索服务端的制品", withoutConfig(runSearch)},
+		{"decrypt", "-key <密钥文件> [-o 输出] <文件.enc|->", "解密以 -encrypt-key 上传的文件", withoutConfig(runDecrypt)},
+		{"hash", "[-workers N] FILE...", "计算本地文件的树形摘要 (即上传后的制品 ID)", withoutConfig(runHash)},
+		{"repair", "-url URL -file LOCAL <远程文件名 | name@version>", "只重新上传远程文件中损坏的片段", withoutConfig(runRepair)},
+		{"diff-remote", "<本地文件> <远端名称> -url <地址>", "比较本地镜像归档与服务端文件的层摘要", withoutConfig(runDiffRemote)},
+		{"rollback", "-url URL <tag>", "让服务端把标签指回上一次加载前的镜像", withoutConfig(runRollback)},
+		{"request", "-url URL [-load] [-tag 标签] <镜像>...", "向服务端登记镜像请求，由订阅的守护进程送达", withoutConfig(runRequest)},
+		{"bundle", "<release.yaml> [-o 发布包.tar] [-url 地址 上传参数]", "生成带清单 (可签名) 的发布包，并可直接上传", runBundle},
+		{"unbundle", "<发布包.tar> -dir <目录> [-pub-key 公钥] [-load]", "校验并解包发布包", withoutConfig(runUnbundle)},
+		{"job", "export|import ...", "导出或导入可复用的传输任务", runJob},
+		{"daemon", "[-workers N] [-subscribe 任务模板]", "启动本地传输队列", withoutConfig(runDaemon)},
+		{"ctl", "list | show ID | add JOB.yaml | cancel ID | pause | resume | stats", "控制本地传输队列", withoutConfig(runCtl)},
+		{"doctor", "-url URL", "诊断到服务端的网络状况并给出调优建议", withoutConfig(runDoctor)},
+		{"stats", "[-days N] [目标地址]", "汇总本机的传输历史", withoutConfig(runStats)},
+		{"discover", "[参数]", "列出局域网内的接收服务", withoutConfig(runDiscover)},
+		{"enroll", "[参数]", "向 CA 申请客户端证书并安装为 mTLS 身份", withoutConfig(runEnroll)},
+		{"dict", "train|list -url <服务端地址>", "在服务端训练或列出 zstd 字典", withoutConfig(runDict)},
+		{"help", "[子命令]", "显示子命令列表或某个子命令的参数", runHelp},
+	}
+}
+
+func findCommand(name string) *command {
+	for _, c := range commandTable() {
+		if c.name == name {
+			return &c
+		}
+	}
+	return nil
+}
+
+// 创建子命令的参数集，-h / --help 时输出子命令的用法、说明和参数
+func newCommandFlags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		w := fs.Output()
+		if c := findCommand(name); c != nil {
+			fmt.Fprintf(w, "用法: docker_save_shell %s %s\n%s\n\n参数:\n", c.name, c.args, c.summary)
+		} else {
+			fmt.Fprintf(w, "用法: docker_save_shell %s [参数]\n\n参数:\n", name)
+		}
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// help 子命令：列出子命令，或显示指定子命令的参数
+func runHelp(args []string, cfg *Config) {
+	if len(args) > 0 {
+		c := findCommand(args[0])
+		if c == nil || c.name == "help" {
+			fmt.Printf("未知的子命令: %s\n", args[0])
+			os.Exit(1)
+		}
+		c.run([]string{"-h"}, cfg)
+		return
+	}
+	fmt.Println("用法: docker_save_shell <子命令> [参数]")
+	fmt.Println("不指定子命令时同 upload，如 docker_save_shell -file app.tar -url http://host:8080")
+	fmt.Println()
+	fmt.Println("子命令:")
+	width := 0
+	for _, c := range commandTable() {
+		width = max(width, len(c.name))
+	}
+	for _, c := range commandTable() {
+		fmt.Printf("  %-*s  %s\n", width, c.name, c.summary)
+	}
+	fmt.Println()
+	fmt.Println("docker_save_shell <子命令> -h 显示子命令的参数")
+}
+
+// 拆出子命令和它的参数；第一个参数不是已知的子命令时为 upload
+func splitCommand(args []string) (*command, []string) {
+	if len(args) > 0 {
+		switch args[0] {
+		case "-h", "-help", "--help":
+			return findCommand("help"), nil
+		}
+		if c := findCommand(args[0]); c != nil {
+			return c, args[1:]
+		}
+	}
+	return findCommand("upload"), args
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config 对应用户配置文件 (默认 ~/.docker_save_shell.yaml)
+type Config struct {
+	// 命令别名，例如 ship-prod: "-url https://prod.example.com/upload -file"
+	Aliases map[string]string `yaml:"aliases"`
+
+	// 默认数据处理流水线，例如 "read,gzip,upload"，命令行 --pipeline 优先
+	Pipeline string `yaml:"pipeline"`
+
+	// 传输指标推送的 Pushgateway 地址，构建节点统一配置后无需每次传 --pushgateway-url
+	Pushgateway string `yaml:"pushgateway_url"`
+
+	// 命名的上传配置，通过 -profile 选用。键为上传参数名 (不含 -)，值为字符串、布尔、数字，
+	// 可重复指定的参数 (如 header) 可写作列表，例如:
+	//   prod: {url: "https://prod.example.com/upload", token: "...", ca-cert: /etc/dss/prod-ca.pem}
+	Profiles map[string]map[string]any `yaml:"profiles"`
+}
+
+// 配置文件路径，可通过环境变量 DOCKER_SAVE_SHELL_CONFIG 覆盖
+func configPath() string {
+	if p := os.Getenv("DOCKER_SAVE_SHELL_CONFIG"); p != "" {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".docker_save_shell.yaml"
+	}
+	return filepath.Join(home, ".docker_save_shell.yaml")
+}
+
+// 读取配置文件，文件不存在时返回空配置
+func loadConfig() (*Config, error) {
+	path := configPath()
+	cfg := &Config{}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return cfg, nil
+		}
+		return nil, fmt.Errorf("读取配置文件 %s 失败: %w", path, err)
+	}
+
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("解析配置文件 %s 失败: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// 本地状态目录 (锁文件、续传状态等)，可通过环境变量 DOCKER_SAVE_SHELL_HOME 覆盖
+func stateDir() string {
+	if p := os.Getenv("DOCKER_SAVE_SHELL_HOME"); p != "" {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".docker_save_shell"
+	}
+	return filepath.Join(home, ".docker_save_shell")
+}
+
+// 用 -profile 指定的配置补全未在命令行指定的参数，命令行优先
+func applyProfile(fs *flag.FlagSet, cfg *Config, name string) error {
+	profile, ok := cfg.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(cfg.Profiles))
+		for n := range cfg.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return fmt.Errorf("配置文件 %s 中没有 profiles", configPath())
+		}
+		return fmt.Errorf("配置文件中没有名为 %s 的 profile (可选: %v)", name, names)
+	}
+
+	keys := make([]string, 0, len(profile))
+	for k := range profile {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key == "profile" || fs.Lookup(key) == nil {
+			return fmt.Errorf("profile %s 中的 %s 不是有效的上传参数", name, key)
+		}
+		// 命令行指定了另一种认证方式时，profile 中的认证方式同样视为被覆盖
+		if flagSet(fs, key) || ((key == "token" || key == "basic-auth") && (flagSet(fs, "token") || flagSet(fs, "basic-auth"))) {
+			continue
+		}
+		values := []any{profile[key]}
+		if list, ok := profile[key].([]any); ok {
+			values = list
+		}
+		for _, v := range values {
+			if err := fs.Set(key, fmt.Sprint(v)); err != nil {
+				return fmt.Errorf("profile %s 中 %s 的值无效: %w", name, key, err)
+			}
+		}
+	}
+	return nil
+}
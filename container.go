@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// serve 参数对应的环境变量前缀
+const serveEnvPrefix = "DOCKER_SAVE_SHELL_SERVE_"
+
+// 容器内的固定路径
+const (
+	containerDataDir   = "/data"
+	containerConfigDir = "/config"
+)
+
+// 值为本机文件路径的参数，生成编排文件时挂载到容器内
+var serveFileFlags = map[string]bool{"hooks": true, "tls-cert": true, "tls-key": true}
+
+// composeService docker compose 中的服务定义
+type composeService struct {
+	Build       string            `yaml:"build"`
+	Image       string            `yaml:"image"`
+	Restart     string            `yaml:"restart"`
+	Ports       []string          `yaml:"ports"`
+	Volumes     []string          `yaml:"volumes"`
+	Environment map[string]string `yaml:"environment,omitempty"`
+}
+
+type composeFile struct {
+	Services map[string]composeService `yaml:"services"`
+	Volumes  map[string]struct{}       `yaml:"volumes"`
+}
+
+// 在当前目录生成部署接收服务的 Dockerfile 和 compose.yaml，
+// 命令行上指定的 serve 参数转换为 compose 中的环境变量
+func writeContainerManifests(fs *flag.FlagSet, opts *serveOptions) error {
+	for _, name := range []string{"Dockerfile", "compose.yaml"} {
+		if _, err := os.Stat(name); err == nil {
+			return fmt.Errorf("%s 已存在，不覆盖", name)
+		}
+	}
+
+	_, port, err := net.SplitHostPort(opts.listen)
+	if err != nil || port == "" {
+		return fmt.Errorf("监听地址 %q 需要包含端口", opts.listen)
+	}
+
+	svc := composeService{
+		Build:   ".",
+		Image:   "docker_save_shell-receiver",
+		Restart: "unless-stopped",
+		Ports:   []string{port + ":" + port},
+		Volumes: []string{"receiver-data:" + containerDataDir},
+		Environment: map[string]string{
+			flagEnvName(serveEnvPrefix, "listen"): ":" + port,
+		},
+	}
+	var mountErr error
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "init-container", "listen", "dir":
+			return
+		}
+		value := f.Value.String()
+		if serveFileFlags[f.Name] && value != "" {
+			abs, err := filepath.Abs(value)
+			if err != nil {
+				mountErr = err
+				return
+			}
+			target := path.Join(containerConfigDir, filepath.Base(abs))
+			svc.Volumes = append(svc.Volumes, abs+":"+target+":ro")
+			value = target
+		}
+		svc.Environment[flagEnvName(serveEnvPrefix, f.Name)] = value
+	})
+	if mountErr != nil {
+		return mountErr
+	}
+	if opts.extractRoot != "" {
+		return errors.New("-extract-root 指向宿主机目录，请在 compose.yaml 中自行挂载后再设置")
+	}
+	if opts.allowLoad {
+		// 在宿主机的 Docker 中加载镜像
+		svc.Volumes = append(svc.Volumes, "/var/run/docker.sock:/var/run/docker.sock")
+	}
+
+	var compose bytes.Buffer
+	enc := yaml.NewEncoder(&compose)
+	enc.SetIndent(2)
+	err = enc.Encode(composeFile{
+		Services: map[string]composeService{"receiver": svc},
+		Volumes:  map[string]struct{}{"receiver-data": {}},
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile("Dockerfile", []byte(dockerfile(opts, port)), 0o644); err != nil {
+		return err
+	}
+	if err := os.WriteFile("compose.yaml", compose.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return nil
+}
+
+// 静态编译的单文件镜像；加载镜像需要 docker 命令，钩子需要 shell，此时改用相应的基础镜像
+func dockerfile(opts *serveOptions, port string) string {
+	base := "scratch"
+	switch {
+	case opts.allowLoad:
+		base = "docker:cli"
+	case opts.hooks != "":
+		base = "alpine:3"
+	}
+
+	var b strings.Builder
+	b.WriteString("# 由 docker_save_shell serve --init-container 生成，在本仓库根目录构建\n")
+	b.WriteString("FROM golang:1.25-alpine AS build\n")
+	b.WriteString("WORKDIR /src\n")
+	b.WriteString("COPY . .\n")
+	b.WriteString("RUN CGO_ENABLED=0 go build -trimpath -ldflags=\"-s -w\" -o /docker_save_shell .\n\n")
+	fmt.Fprintf(&b, "FROM %s\n", base)
+	b.WriteString("COPY --from=build /docker_save_shell /docker_save_shell\n")
+	fmt.Fprintf(&b, "ENV %s=:%s %s=%s\n", flagEnvName(serveEnvPrefix, "listen"), port, flagEnvName(serveEnvPrefix, "dir"), containerDataDir)
+	fmt.Fprintf(&b, "VOLUME %s\n", containerDataDir)
+	fmt.Fprintf(&b, "EXPOSE %s\n", port)
+	b.WriteString("ENTRYPOINT [\"/docker_save_shell\", \"serve\"]\n")
+	return b.String()
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// archiveLayer 镜像归档中的一层
+type archiveLayer struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+	Offset int64  `json:"offset,omitempty"` // 层数据在归档中的位置，归档压缩存储时为 0
+}
+
+// archiveImage 镜像归档中的一个镜像
+type archiveImage struct {
+	ID       string         `json:"id"`
+	Tags     []string       `json:"tags,omitempty"`
+	Platform string         `json:"platform,omitempty"` // 镜像配置中的 os/arch[/variant]
+	Layers   []archiveLayer `json:"layers"`
+}
+
+// archiveContents 已存储文件的内容索引，接收时解析一次并缓存
+type archiveContents struct {
+	File     string         `json:"file"`
+	Artifact string         `json:"artifact,omitempty"`
+	Version  string         `json:"version,omitempty"`
+	Format   string         `json:"format,omitempty"` // docker-archive；无法识别时为空
+	Images   []archiveImage `json:"images"`
+	Indexed  time.Time      `json:"indexed"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// docker save 生成的 manifest.json 中的一项
+type archiveManifestEntry struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// 内容索引的缓存路径：普通文件放在元数据目录，制品放在版本目录下
+func (s *server) contentsCachePath(path string) string {
+	if filepath.Dir(path) == filepath.Clean(s.opts.dir) {
+		return filepath.Join(serverMetaDir(s.opts.dir), "contents", filepath.Base(path)+".json")
+	}
+	return filepath.Join(filepath.Dir(path), "contents.json")
+}
+
+// 解析 docker-archive 并写入缓存；不是镜像归档时也缓存空结果，避免重复解析
+func (s *server) indexContents(path string) (*archiveContents, error) {
+	contents := &archiveContents{File: filepath.Base(path), Images: []archiveImage{}, Indexed: time.Now()}
+	images, err := parseDockerArchive(path)
+	if err != nil {
+		contents.Error = err.Error()
+	} else if images != nil {
+		contents.Format = "docker-archive"
+		contents.Images = images
+	}
+
+	cache := s.contentsCachePath(path)
+	if err := os.MkdirAll(filepath.Dir(cache), 0o755); err != nil {
+		return nil, fmt.Errorf("创建索引目录失败: %w", err)
+	}
+	if err := writeFileAtomic(cache, contents); err != nil {
+		return nil, err
+	}
+	return contents, nil
+}
+
+// 读取文件的内容索引，缓存不存在或比文件旧时重新解析
+func (s *server) fileContents(path string) (*archiveContents, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if data, err := os.ReadFile(s.contentsCachePath(path)); err == nil {
+		contents := &archiveContents{}
+		if json.Unmarshal(data, contents) == nil && !contents.Indexed.Before(info.ModTime()) {
+			return contents, nil
+		}
+	}
+	return s.indexContents(path)
+}
+
+// 镜像配置可能出现的位置 (旧格式的 <id>.json 或 OCI 布局的 blobs/sha256/)，读取时顺便缓存不超过该大小的条目
+const maxArchiveConfigSize = 256 << 10
+
+// 遍历 tar 归档读取 manifest.json、各层大小和镜像平台；不是 tar 或没有 manifest.json 时返回 nil
+func parseDockerArchive(path string) ([]archiveImage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// 未压缩时直接让 tar 在文件上 Seek 跳过层数据，并记录各条目数据的位置；压缩存储的文件只能顺序解压
+	var r io.Reader = f
+	br := bufio.NewReader(f)
+	dr, _, err := decompressReader(br)
+	if err != nil {
+		return nil, err
+	}
+	if dr != nil {
+		defer dr.Close()
+		r = dr
+	} else if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var manifest []archiveManifestEntry
+	sizes := map[string]int64{}
+	offsets := map[string]int64{}
+	links := map[string]string{}
+	configs := map[string][]byte{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if len(sizes) == 0 {
+				// 连第一个条目都读不出，说明不是 tar 归档
+				return nil, nil
+			}
+			return nil, fmt.Errorf("读取归档失败: %w", err)
+		}
+		name := strings.TrimPrefix(hdr.Name, "./")
+		sizes[name] = hdr.Size
+		if hdr.Typeflag == tar.TypeSymlink {
+			links[name] = filepath.ToSlash(filepath.Join(filepath.Dir(name), hdr.Linkname))
+		}
+		if dr == nil {
+			if offsets[name], err = f.Seek(0, io.SeekCurrent); err != nil {
+				return nil, err
+			}
+		}
+		switch {
+		case name == "manifest.json":
+			if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+				return nil, fmt.Errorf("解析 manifest.json 失败: %w", err)
+			}
+		case hdr.Size <= maxArchiveConfigSize && (strings.HasSuffix(name, ".json") || strings.HasPrefix(name, "blobs/sha256/")):
+			if data, err := io.ReadAll(tr); err == nil {
+				configs[name] = data
+			}
+		}
+	}
+	if manifest == nil {
+		return nil, nil
+	}
+
+	images := []archiveImage{}
+	for _, m := range manifest {
+		img := archiveImage{ID: archiveDigest(m.Config), Tags: m.RepoTags, Layers: []archiveLayer{}}
+		var p imagePlatform
+		if json.Unmarshal(configs[m.Config], &p) == nil {
+			img.Platform = p.String()
+		}
+		for _, l := range m.Layers {
+			// 新版 docker save 中重复的层是指向 blobs/ 的符号链接
+			for range 8 {
+				target, ok := links[l]
+				if !ok {
+					break
+				}
+				l = target
+			}
+			img.Layers = append(img.Layers, archiveLayer{Digest: archiveDigest(l), Size: sizes[l], Offset: offsets[l]})
+		}
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+// 将归档内的路径转换为摘要：blobs/sha256/<hex> (OCI 布局) 或 <hex>.json (旧格式配置)；
+// 旧格式的层 (<id>/layer.tar) 没有内容摘要，保留原路径
+func archiveDigest(name string) string {
+	if hex, ok := strings.CutPrefix(name, "blobs/sha256/"); ok {
+		return "sha256:" + hex
+	}
+	if hex, ok := strings.CutSuffix(name, ".json"); ok && !strings.Contains(hex, "/") {
+		return "sha256:" + hex
+	}
+	return name
+}
+
+// 判断归档中是否有镜像匹配查询：标签 (支持 * 和 ? 通配，可省略 docker.io/ 前缀)、镜像 ID 或层摘要前缀
+func (c *archiveContents) matches(query string) bool {
+	for _, img := range c.Images {
+		for _, tag := range img.Tags {
+			if globMatch(query, tag) || globMatch(query, strings.TrimPrefix(tag, "docker.io/")) {
+				return true
+			}
+		}
+		if strings.HasPrefix(img.ID, query) || strings.HasPrefix(img.ID, "sha256:"+query) {
+			return true
+		}
+		for _, l := range img.Layers {
+			if strings.HasPrefix(l.Digest, query) || strings.HasPrefix(l.Digest, "sha256:"+query) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 返回单个文件的内容索引
+func (s *server) handleContents(w http.ResponseWriter, r *http.Request) {
+	path, ok := s.storedTarget(w, r)
+	if !ok {
+		return
+	}
+	contents, err := s.fileContents(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			writeJSON(w, http.StatusNotFound, uploadResult{Error: "文件不存在"})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, uploadResult{Error: err.Error()})
+		return
+	}
+	if version := r.PathValue("version"); version != "" {
+		contents.Artifact, contents.Version = r.PathValue("name"), filepath.Base(filepath.Dir(path))
+	}
+	writeJSON(w, http.StatusOK, contents)
+}
+
+// 列出所有已存储文件和制品的内容，支持 ?image= 查询包含某个镜像的上传；
+// 浏览器访问 (Accept: text/html) 时返回页面
+func (s *server) handleListContents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("image")
+
+	var all []*archiveContents
+	err := s.eachStoredContents(func(_ string, contents *archiveContents) {
+		if query == "" || contents.matches(query) {
+			all = append(all, contents)
+		}
+	})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, uploadResult{Error: err.Error()})
+		return
+	}
+
+	if all == nil {
+		all = []*archiveContents{}
+	}
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		contentsPage.Execute(w, map[string]any{"Query": query, "Items": all})
+		return
+	}
+	writeJSON(w, http.StatusOK, all)
+}
+
+// 依次读取所有已存储文件和制品的路径和内容索引 (无法读取的跳过)，制品的索引带有名称和版本
+func (s *server) eachStoredContents(fn func(path string, contents *archiveContents)) error {
+	add := func(path, artifact, version string) {
+		contents, err := s.fileContents(path)
+		if err != nil {
+			return
+		}
+		contents.Artifact, contents.Version = artifact, version
+		fn(path, contents)
+	}
+
+	entries, err := os.ReadDir(s.opts.dir)
+	if err != nil {
+		return fmt.Errorf("读取存储目录失败: %w", err)
+	}
+	for _, e := range entries {
+		if e.Type().IsRegular() && !strings.HasPrefix(e.Name(), ".") {
+			add(filepath.Join(s.opts.dir, e.Name()), "", "")
+		}
+	}
+
+	artifacts, err := s.allArtifacts()
+	if err != nil {
+		return err
+	}
+	for _, meta := range artifacts {
+		add(s.artifactFilePath(meta), meta.Name, meta.Version)
+	}
+	return nil
+}
+
+var contentsPage = template.Must(template.New("contents").Funcs(template.FuncMap{
+	"bytes": formatBytes,
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>已存储的镜像</title>
+<style>body{font-family:sans-serif;margin:2em}table{border-collapse:collapse}td,th{border:1px solid #ccc;padding:4px 8px;text-align:left;vertical-align:top}code{font-size:90%}</style>
+</head><body>
+<h1>已存储的镜像</h1>
+<form><input name="image" value="{{.Query}}" placeholder="nginx:1.25"> <button>查找</button></form>
+<table>
+<tr><th>文件</th><th>制品</th><th>镜像标签</th><th>镜像 ID</th><th>层</th></tr>
+{{range .Items}}{{$item := .}}{{range .Images}}
+<tr><td>{{$item.File}}</td><td>{{if $item.Artifact}}{{$item.Artifact}}@{{$item.Version}}{{end}}</td>
+<td>{{range .Tags}}{{.}}<br>{{end}}</td><td><code>{{.ID}}</code></td>
+<td>{{range .Layers}}<code>{{.Digest}}</code> ({{bytes .Size}})<br>{{end}}</td></tr>
+{{else}}
+<tr><td>{{$item.File}}</td><td>{{if $item.Artifact}}{{$item.Artifact}}@{{$item.Version}}{{end}}</td><td colspan="3">{{if $item.Error}}{{$item.Error}}{{else}}不是镜像归档{{end}}</td></tr>
+{{end}}{{end}}
+</table>
+</body></html>
+`))
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// ctl 子命令：通过 Unix socket 控制本地守护进程
+func runCtl(args []string) {
+	var socket string
+	fs := newCommandFlags("ctl")
+	fs.StringVar(&socket, "socket", defaultDaemonSocket(), "守护进程控制接口的 Unix socket 路径")
+	fs.Parse(args)
+
+	usage := "用法: docker_save_shell ctl [-socket PATH] list | show ID | add JOB.yaml | cancel ID | pause | resume | stats"
+	if fs.NArg() == 0 {
+		fmt.Println(usage)
+		os.Exit(1)
+	}
+
+	c := newCtlClient(socket)
+	var err error
+	switch cmd, rest := fs.Arg(0), fs.Args()[1:]; {
+	case cmd == "list" && len(rest) == 0:
+		err = c.list()
+	case cmd == "show" && len(rest) == 1:
+		err = c.call("GET", "/jobs/"+rest[0], nil, nil)
+	case cmd == "add" && len(rest) == 1:
+		err = c.add(rest[0])
+	case cmd == "cancel" && len(rest) == 1:
+		err = c.call("POST", "/jobs/"+rest[0]+"/cancel", nil, nil)
+	case (cmd == "pause" || cmd == "resume") && len(rest) == 0:
+		err = c.call("POST", "/"+cmd, nil, nil)
+	case cmd == "stats" && len(rest) == 0:
+		err = c.call("GET", "/stats", nil, nil)
+	default:
+		fmt.Println(usage)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// ctlClient 守护进程控制接口的客户端
+type ctlClient struct {
+	http *http.Client
+}
+
+func newCtlClient(socket string) *ctlClient {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}
+	return &ctlClient{http: &http.Client{Transport: transport, Timeout: 30 * time.Second}}
+}
+
+// 调用控制接口；out 为 nil 时直接输出响应的 JSON
+func (c *ctlClient) call(method, path string, body io.Reader, out any) error {
+	req, err := http.NewRequest(method, "http://daemon"+path, body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("连接守护进程失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("请求失败: %s", responseError(resp))
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+
+	var v any
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return fmt.Errorf("解析响应失败: %w", err)
+	}
+	data, _ := json.MarshalIndent(v, "", "  ")
+	fmt.Println(string(data))
+	return nil
+}
+
+// 以表格列出队列中的任务
+func (c *ctlClient) list() error {
+	var jobs []*daemonJob
+	if err := c.call("GET", "/jobs", nil, &jobs); err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		fmt.Println("队列为空")
+		return nil
+	}
+
+	fmt.Printf("%-16s %-9s %16s  %-19s  %s\n", "ID", "STATE", "PROGRESS", "ADDED", "SOURCE -> TARGET")
+	for _, j := range jobs {
+		progress := formatBytes(j.Done)
+		if j.Total > 0 {
+			progress = fmt.Sprintf("%s (%d%%)", progress, j.Done*100/j.Total)
+		}
+		fmt.Printf("%-16s %-9s %16s  %-19s  %s -> %s\n", j.ID, j.State, progress, j.Added.Local().Format(time.DateTime), j.Job.Source, j.Job.Target)
+		if j.Error != "" {
+			fmt.Printf("%-16s ❌ %s\n", "", j.Error)
+		}
+	}
+	return nil
+}
+
+// 提交任务定义文件
+func (c *ctlClient) add(path string) error {
+	job, err := loadJob(path)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	var added daemonJob
+	if err := c.call("POST", "/jobs", bytes.NewReader(data), &added); err != nil {
+		return err
+	}
+	fmt.Printf("✅ 已加入队列: %s\n", added.ID)
+	return nil
+}
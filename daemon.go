@@ -0,0 +1,486 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 守护进程中任务的状态
+const (
+	jobQueued   = "queued"
+	jobRunning  = "running"
+	jobDone     = "done"
+	jobFailed   = "failed"
+	jobCanceled = "canceled"
+)
+
+// 最多保留的已结束任务数
+const maxFinishedJobs = 200
+
+// daemonJob 队列中的一个传输任务
+type daemonJob struct {
+	ID       string         `json:"id"`
+	Job      *transferJob   `json:"job"`
+	State    string         `json:"state"`
+	Error    string         `json:"error,omitempty"`
+	Added    time.Time      `json:"added"`
+	Started  time.Time      `json:"started,omitzero"`
+	Finished time.Time      `json:"finished,omitzero"`
+	Done     int64          `json:"done"`
+	Total    int64          `json:"total"`
+	Attempts int            `json:"attempts,omitempty"`
+	Retries  map[string]int `json:"retries,omitempty"` // 各层的重试次数
+
+	cancel    context.CancelFunc
+	canceling bool
+}
+
+// daemonStats 队列统计
+type daemonStats struct {
+	Uptime    string `json:"uptime"`
+	Paused    bool   `json:"paused"`
+	Workers   int    `json:"workers"`
+	Queued    int    `json:"queued"`
+	Running   int    `json:"running"`
+	Done      int    `json:"done"`
+	Failed    int    `json:"failed"`
+	Canceled  int    `json:"canceled"`
+	BytesDone int64  `json:"bytes_done"`
+}
+
+// daemon 本地传输队列，通过 Unix socket 上的 REST 接口控制
+type daemon struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	jobs      []*daemonJob
+	paused    bool
+	workers   int
+	started   time.Time
+	queuePath string
+	retries   int       // 失败后自动重试的次数
+	events    *eventLog // 未启用时为 nil
+}
+
+// 守护进程控制接口的默认 socket 路径
+func defaultDaemonSocket() string {
+	return filepath.Join(stateDir(), "daemon.sock")
+}
+
+// daemon 子命令：启动本地传输队列
+func runDaemon(args []string) {
+	var socket, events, sink, subscribe string
+	var workers, retries int
+	fs := newCommandFlags("daemon")
+	fs.StringVar(&socket, "socket", defaultDaemonSocket(), "控制接口的 Unix socket 路径")
+	fs.IntVar(&workers, "workers", 1, "同时执行的任务数")
+	fs.IntVar(&retries, "retries", 0, "任务失败后自动重试的次数")
+	fs.StringVar(&events, "events", "", "设为 json 时在标准输出逐行输出任务事件，其他日志改为输出到标准错误")
+	fs.StringVar(&subscribe, "subscribe", "", "订阅服务端的镜像请求并自动送达，值为任务模板 (job export 的输出，target 为服务端地址)")
+	fs.StringVar(&sink, "log-sink", "", "将任务事件发送到集中日志: syslog+udp://host:514、syslog+tcp://、syslog+tls://host:6514 或 eventlog (Windows)")
+	var prio priorityOptions
+	registerPriorityFlags(fs, &prio)
+	fs.Parse(args)
+
+	if err := prio.apply(); err != nil {
+		fmt.Printf("设置进程优先级失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	d := &daemon{
+		workers:   max(workers, 1),
+		retries:   max(retries, 0),
+		started:   time.Now(),
+		queuePath: filepath.Join(stateDir(), "daemon", "queue.json"),
+	}
+	switch events {
+	case "":
+	case "json":
+		// 标准输出只保留事件，便于采集方逐行解析
+		d.events = newEventLog(os.Stdout)
+		os.Stdout = os.Stderr
+	default:
+		fmt.Printf("不支持的事件格式: %s\n", events)
+		os.Exit(1)
+	}
+	if sink != "" {
+		ls, err := openLogSink(sink)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer ls.Close()
+		if d.events == nil {
+			d.events = &eventLog{}
+		}
+		d.events.sink = ls
+	}
+	var tpl *transferJob
+	if subscribe != "" {
+		var err error
+		if tpl, err = loadJob(subscribe); err == nil {
+			_, err = tpl.options()
+		}
+		if err != nil {
+			fmt.Printf("读取订阅任务模板失败: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	d.cond = sync.NewCond(&d.mu)
+	if err := d.load(); err != nil {
+		fmt.Printf("读取任务队列失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	ln, err := listenUnix(socket)
+	if err != nil {
+		fmt.Printf("监听控制接口失败: %v\n", err)
+		os.Exit(1)
+	}
+	defer os.Remove(socket)
+
+	for i := 0; i < d.workers; i++ {
+		go d.worker()
+	}
+	if subscribe != "" {
+		go d.subscribe(tpl)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /jobs", d.handleList)
+	mux.HandleFunc("POST /jobs", d.handleAdd)
+	mux.HandleFunc("GET /jobs/{id}", d.handleGet)
+	mux.HandleFunc("POST /jobs/{id}/cancel", d.handleCancel)
+	mux.HandleFunc("POST /pause", d.handlePause)
+	mux.HandleFunc("POST /resume", d.handlePause)
+	mux.HandleFunc("GET /stats", d.handleStats)
+
+	fmt.Printf("🛰️  传输队列已启动，控制接口: %s\n", socket)
+	if err := http.Serve(ln, mux); err != nil {
+		fmt.Printf("服务异常退出: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// 监听 Unix socket；已有 socket 文件但无人监听时视为残留并清除
+func listenUnix(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s 已有守护进程在运行", path)
+	}
+	os.Remove(path)
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// 控制接口可以添加任意上传任务，只允许当前用户访问
+	if err := os.Chmod(path, 0o600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// 读取上次退出时的队列，未完成的任务重新排队
+func (d *daemon) load() error {
+	data, err := os.ReadFile(d.queuePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &d.jobs); err != nil {
+		return err
+	}
+	for _, j := range d.jobs {
+		if j.State == jobRunning {
+			j.State = jobQueued
+			j.Done = 0
+		}
+	}
+	return nil
+}
+
+// 持久化队列并淘汰过旧的已结束任务，调用方需持有 d.mu
+func (d *daemon) save() {
+	finished := 0
+	for _, j := range d.jobs {
+		if j.finished() {
+			finished++
+		}
+	}
+	kept := d.jobs[:0]
+	for _, j := range d.jobs {
+		if j.finished() && finished > maxFinishedJobs {
+			finished--
+			continue
+		}
+		kept = append(kept, j)
+	}
+	d.jobs = kept
+
+	err := os.MkdirAll(filepath.Dir(d.queuePath), 0o700)
+	if err == nil {
+		err = writeFileAtomic(d.queuePath, d.jobs)
+	}
+	if err != nil {
+		fmt.Printf("⚠️  保存任务队列失败: %v\n", err)
+	}
+}
+
+// 任务是否已结束
+func (j *daemonJob) finished() bool {
+	return j.State == jobDone || j.State == jobFailed || j.State == jobCanceled
+}
+
+// 工作协程：依次取出排队的任务执行，暂停时等待
+func (d *daemon) worker() {
+	for {
+		d.mu.Lock()
+		var job *daemonJob
+		for job == nil {
+			if !d.paused {
+				for _, j := range d.jobs {
+					if j.State == jobQueued {
+						job = j
+						break
+					}
+				}
+			}
+			if job == nil {
+				d.cond.Wait()
+			}
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		job.State, job.Started, job.cancel = jobRunning, time.Now(), cancel
+		d.save()
+		d.mu.Unlock()
+
+		d.run(ctx, job)
+		cancel()
+	}
+}
+
+// 执行一个任务并记录结果，失败时按 -retries 退避重试，重试次数同时受任务的重试预算限制
+func (d *daemon) run(ctx context.Context, job *daemonJob) {
+	fmt.Printf("▶️  开始任务 %s: %s -> %s\n", job.ID, job.Job.Source, job.Job.Target)
+	d.events.emit(job.event("started"))
+
+	opts, err := job.Job.options()
+	if err == nil {
+		// 任务 ID 即传输 ID，事件、客户端日志与服务端日志中的 ID 一致
+		opts.transferID = job.ID
+		opts.retry = newRetryBudget(opts.retryBudget, opts.retryDeadline)
+		gate := progressGate{interval: opts.progressInterval}
+		opts.progress = func(done, total int64) {
+			d.mu.Lock()
+			job.Done, job.Total = done, total
+			d.mu.Unlock()
+			if gate.due(done, total) {
+				ev := job.event("chunk-progress")
+				ev.Done, ev.Total = done, total
+				d.events.emit(ev)
+			}
+		}
+		if opts.progressChunks {
+			opts.chunkDone = func(offset, n int64) {
+				ev := job.event("chunk")
+				ev.Done, ev.Chunk = offset, n
+				d.events.emit(ev)
+			}
+		}
+		for attempt := 1; ; attempt++ {
+			d.mu.Lock()
+			job.Attempts = attempt
+			d.mu.Unlock()
+
+			opts.attempt = attempt
+			err = upload(ctx, opts)
+			if err == nil || ctx.Err() != nil || attempt > d.retries {
+				break
+			}
+
+			delay := retryDelay(attempt)
+			if berr := opts.retry.take("transfer", delay); berr != nil {
+				fmt.Printf("⛔ 任务 %s 不再重试: %v\n", job.ID, berr)
+				break
+			}
+			fmt.Printf("🔁 任务 %s 第 %d 次失败，%s 后重试: %v\n", job.ID, attempt, delay, err)
+			ev := job.event("retrying")
+			ev.Attempt, ev.Delay, ev.Error = attempt, delay.String(), err.Error()
+			d.events.emit(ev)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	job.Finished, job.cancel = time.Now(), nil
+	job.Retries = opts.retry.usage()
+	switch {
+	case job.canceling:
+		job.State = jobCanceled
+	case err != nil:
+		job.State, job.Error = jobFailed, err.Error()
+	default:
+		job.State = jobDone
+	}
+	d.save()
+	fmt.Printf("⏹️  任务 %s 结束: %s\n", job.ID, job.State)
+	if s := opts.retry.summary(); s != "" {
+		fmt.Printf("🔁 任务 %s %s\n", job.ID, s)
+	}
+
+	ev := job.event(job.State)
+	ev.Done, ev.Total, ev.Attempt, ev.Error = job.Done, job.Total, job.Attempts, job.Error
+	ev.Retries = job.Retries
+	d.events.emit(ev)
+
+	if id := job.Job.Metadata[requestMetaKey]; id != "" && job.State != jobCanceled {
+		go reportRequest(opts, id, err)
+	}
+}
+
+// 第 attempt 次失败后的等待时间：5s 起指数增长，最长 5 分钟
+func retryDelay(attempt int) time.Duration {
+	if attempt > 6 {
+		return 5 * time.Minute
+	}
+	return min(5*time.Second<<(attempt-1), 5*time.Minute)
+}
+
+// 按 ID 查找任务，调用方需持有 d.mu
+func (d *daemon) find(id string) *daemonJob {
+	for _, j := range d.jobs {
+		if j.ID == id {
+			return j
+		}
+	}
+	return nil
+}
+
+func (d *daemon) handleList(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	writeJSON(w, http.StatusOK, d.jobs)
+}
+
+// 添加任务，请求体为任务定义 (与 job export 的输出格式相同，YAML 或 JSON)
+func (d *daemon) handleAdd(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxFormFieldSize))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, uploadResult{Error: "读取请求失败: " + err.Error()})
+		return
+	}
+	job, err := parseJob(data)
+	if err == nil {
+		_, err = job.options()
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, uploadResult{Error: err.Error()})
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	writeJSON(w, http.StatusOK, d.enqueue(job))
+}
+
+// 将任务加入队列并唤醒工作协程，调用方需持有 d.mu
+func (d *daemon) enqueue(job *transferJob) *daemonJob {
+	j := &daemonJob{ID: randomID(), Job: job, State: jobQueued, Added: time.Now(), Total: -1}
+	d.jobs = append(d.jobs, j)
+	d.save()
+	d.cond.Broadcast()
+	d.events.emit(j.event("queued"))
+	return j
+}
+
+func (d *daemon) handleGet(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	j := d.find(r.PathValue("id"))
+	if j == nil {
+		writeJSON(w, http.StatusNotFound, uploadResult{Error: "任务不存在"})
+		return
+	}
+	writeJSON(w, http.StatusOK, j)
+}
+
+// 取消任务：排队中的直接取消，执行中的中止上传
+func (d *daemon) handleCancel(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	j := d.find(r.PathValue("id"))
+	if j == nil {
+		writeJSON(w, http.StatusNotFound, uploadResult{Error: "任务不存在"})
+		return
+	}
+	switch j.State {
+	case jobQueued:
+		j.State, j.Finished = jobCanceled, time.Now()
+		d.save()
+		d.events.emit(j.event(jobCanceled))
+	case jobRunning:
+		j.canceling = true
+		j.cancel()
+	default:
+		writeJSON(w, http.StatusConflict, uploadResult{Error: "任务已结束: " + j.State})
+		return
+	}
+	writeJSON(w, http.StatusOK, j)
+}
+
+// 暂停或恢复队列；暂停后不再启动新任务，正在执行的任务继续完成
+func (d *daemon) handlePause(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.paused = r.URL.Path == "/pause"
+	d.cond.Broadcast()
+	writeJSON(w, http.StatusOK, map[string]bool{"paused": d.paused})
+}
+
+func (d *daemon) handleStats(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	st := daemonStats{
+		Uptime:  time.Since(d.started).Round(time.Second).String(),
+		Paused:  d.paused,
+		Workers: d.workers,
+	}
+	for _, j := range d.jobs {
+		switch j.State {
+		case jobQueued:
+			st.Queued++
+		case jobRunning:
+			st.Running++
+		case jobDone:
+			st.Done++
+			st.BytesDone += j.Done
+		case jobFailed:
+			st.Failed++
+		case jobCanceled:
+			st.Canceled++
+		}
+	}
+	writeJSON(w, http.StatusOK, st)
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"command_tool/pkg/uploader"
+)
+
+// 按层去重上传 (-dedup-layers)：同一镜像的相邻版本共享大部分层，只需发送服务端没有的层。协议：
+//
+//	POST /layers/check               JSON {layers: [摘要...]}，返回 {have: [摘要...]}，即已存储的归档中可以复用的层
+//	POST /layers/upload?name=<文件名> 请求体为一串帧，服务端按顺序还原出完整的归档，再按普通上传的流程校验和提交
+//
+// 帧的第一个字节为类型：
+//
+//	'D' 长度 (uint32) + 数据        原样写入的数据 (tar 头部、配置和服务端没有的层)
+//	'L' 长度 (uint64) + 摘要长度 (uint8) + 摘要   从已存储的归档中复制该层
+//	'F' 长度 (uint32) + JSON        表单字段 (tree_sha256 等)，为最后一帧
+//
+// 还原出的归档与本地文件逐字节相同，服务端以客户端计算的树形摘要校验
+const (
+	dedupData   = 'D'
+	dedupLayer  = 'L'
+	dedupFields = 'F'
+
+	dedupFrameSize    = 1 << 20 // 客户端每个数据帧的大小
+	maxDedupFrameSize = 4 << 20
+)
+
+// errNoDedup 无法按层去重，改为上传完整归档
+var errNoDedup = errors.New("无法按层去重")
+
+// layerSource 已存储的归档中一层数据的位置
+type layerSource struct {
+	path   string
+	offset int64
+	size   int64
+}
+
+// 收集已存储的未压缩镜像归档中的各层，按摘要索引
+func (s *server) layerSources() (map[string]layerSource, error) {
+	sources := map[string]layerSource{}
+	err := s.eachStoredContents(func(path string, contents *archiveContents) {
+		for _, img := range contents.Images {
+			for _, l := range img.Layers {
+				if l.Offset > 0 && strings.HasPrefix(l.Digest, "sha256:") {
+					sources[l.Digest] = layerSource{path: path, offset: l.Offset, size: l.Size}
+				}
+			}
+		}
+	})
+	return sources, err
+}
+
+// 返回请求的层中服务端已有的
+func (s *server) handleCheckLayers(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Layers []string `json:"layers"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, uploadResult{Error: "请求格式错误: " + err.Error()})
+		return
+	}
+	sources, err := s.layerSources()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, uploadResult{Error: err.Error()})
+		return
+	}
+	have := []string{}
+	for _, d := range req.Layers {
+		if _, ok := sources[d]; ok {
+			have = append(have, d)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"have": have})
+}
+
+// 按帧还原归档后交给普通上传的校验和提交流程
+func (s *server) handleDedupUpload(w http.ResponseWriter, r *http.Request) {
+	name, err := safeFileName(r.URL.Query().Get("name"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, uploadResult{Error: err.Error()})
+		return
+	}
+	sources, err := s.layerSources()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, uploadResult{Error: err.Error()})
+		return
+	}
+	tmp, err := os.CreateTemp(s.opts.dir, ".upload-*")
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, uploadResult{Error: "创建临时文件失败: " + err.Error()})
+		return
+	}
+	tmp.Chmod(0o644)
+	received := &receivedFile{tmpPath: tmp.Name(), name: name}
+	defer func() {
+		if received.tmpPath != "" {
+			os.Remove(received.tmpPath)
+		}
+	}()
+
+	h := sha256.New()
+	fields, reused, layers, err := readDedupFrames(bufio.NewReader(r.Body), io.MultiWriter(tmp, h), sources)
+	var size int64
+	if err == nil {
+		size, err = tmp.Seek(0, io.SeekCurrent)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, os.ErrNotExist) {
+			// 引用的层刚被删除或替换，客户端可以重新协商
+			status = http.StatusConflict
+		}
+		writeJSON(w, status, uploadResult{Error: "接收失败: " + err.Error()})
+		return
+	}
+	received.size = size
+	received.sha256 = hex.EncodeToString(h.Sum(nil))
+	fmt.Printf("♻️  按层去重接收: %s (复用 %d 层 %s，实际接收 %s)\n", name, layers, formatBytes(reused), formatBytes(size-reused))
+	s.acceptUpload(w, r, received, fields)
+}
+
+// 读取各帧并写出还原的数据，返回最后一帧中的表单字段和复用的字节数、层数
+func readDedupFrames(br *bufio.Reader, out io.Writer, sources map[string]layerSource) (map[string]string, int64, int, error) {
+	var reused int64
+	var layers int
+	for {
+		kind, err := br.ReadByte()
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("请求体不完整: %w", err)
+		}
+		switch kind {
+		case dedupData:
+			var n uint32
+			if err := binary.Read(br, binary.BigEndian, &n); err != nil {
+				return nil, 0, 0, err
+			}
+			if n > maxDedupFrameSize {
+				return nil, 0, 0, fmt.Errorf("数据帧过大: %d", n)
+			}
+			if _, err := io.CopyN(out, br, int64(n)); err != nil {
+				return nil, 0, 0, err
+			}
+		case dedupLayer:
+			var size uint64
+			var n uint8
+			if err := binary.Read(br, binary.BigEndian, &size); err != nil {
+				return nil, 0, 0, err
+			}
+			if err := binary.Read(br, binary.BigEndian, &n); err != nil {
+				return nil, 0, 0, err
+			}
+			digest := make([]byte, n)
+			if _, err := io.ReadFull(br, digest); err != nil {
+				return nil, 0, 0, err
+			}
+			src, ok := sources[string(digest)]
+			if !ok || src.size != int64(size) {
+				return nil, 0, 0, fmt.Errorf("层 %s: %w", digest, os.ErrNotExist)
+			}
+			if err := copyLayer(out, src); err != nil {
+				return nil, 0, 0, fmt.Errorf("复制层 %s 失败: %w", digest, err)
+			}
+			reused += src.size
+			layers++
+		case dedupFields:
+			var n uint32
+			if err := binary.Read(br, binary.BigEndian, &n); err != nil {
+				return nil, 0, 0, err
+			}
+			fields := map[string]string{}
+			if err := json.NewDecoder(io.LimitReader(br, min(int64(n), maxFormFieldSize))).Decode(&fields); err != nil {
+				return nil, 0, 0, fmt.Errorf("解析表单字段失败: %w", err)
+			}
+			return fields, reused, layers, nil
+		default:
+			return nil, 0, 0, fmt.Errorf("未知的帧类型 %q", kind)
+		}
+	}
+}
+
+func copyLayer(out io.Writer, src layerSource) error {
+	f, err := os.Open(src.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := io.Copy(out, io.NewSectionReader(f, src.offset, src.size))
+	if err == nil && n != src.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// 客户端：检查按层去重的参数。只支持本地未压缩的镜像归档，数据原样发送
+func checkDedupOptions(opts options) error {
+	switch {
+	case opts.tus, opts.parallelChunks > 0:
+		return errors.New("-dedup-layers 不能与 -tus、-parallel-chunks 同时使用")
+	case isRemoteSource(opts.filePath):
+		return errors.New("-dedup-layers 只能上传本地文件")
+	case opts.preset != "", isS3URL(opts.serverURL), isSFTPURL(opts.serverURL):
+		return errors.New("-dedup-layers 只能用于本工具的服务端")
+	case opts.pipeline != "" && opts.pipeline != defaultPipeline, opts.compress != "" && opts.compress != "none", opts.format != "" && opts.format != "tar", opts.encryptKey != "":
+		return errors.New("-dedup-layers 不支持 -pipeline、-compress、-format 和 -encrypt-key (服务端按原始归档中的位置复用各层)")
+	case opts.extractTo != "" || opts.sums:
+		return errors.New("-dedup-layers 不支持目录上传 (-extract-to、-sums)")
+	case opts.form.custom():
+		return errors.New("-dedup-layers 不以 multipart 表单发送，不支持 -form、-field-name")
+	}
+	info, err := os.Stat(opts.filePath)
+	if err != nil {
+		return fmt.Errorf("无法获取文件信息: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return errors.New("-dedup-layers 只能上传普通文件")
+	}
+	return nil
+}
+
+// 按层去重上传：询问服务端已有的层，其余数据按帧发送。无法去重 (不是镜像归档、服务端不支持或没有可复用的层) 时
+// 返回 errNoDedup，由调用方改为普通上传
+func dedupUpload(ctx context.Context, client *sessionClient, opts options, sla *slaMonitor, rec *transferRecord) error {
+	images, err := parseDockerArchive(opts.filePath)
+	if err != nil {
+		return fmt.Errorf("解析镜像归档失败: %w", err)
+	}
+	var layers []archiveLayer
+	seen := map[string]bool{}
+	for _, img := range images {
+		for _, l := range img.Layers {
+			if l.Offset > 0 && strings.HasPrefix(l.Digest, "sha256:") && !seen[l.Digest] {
+				seen[l.Digest] = true
+				layers = append(layers, l)
+			}
+		}
+	}
+	if len(layers) == 0 {
+		return fmt.Errorf("%w: 不是未压缩的镜像归档", errNoDedup)
+	}
+
+	base, err := url.Parse(opts.serverURL)
+	if err != nil {
+		return fmt.Errorf("服务端地址格式错误: %w", err)
+	}
+	endpoint := base.ResolveReference(&url.URL{Path: "layers"}).String()
+	have, err := checkLayers(ctx, client, endpoint+"/check", layers)
+	if err != nil {
+		return err
+	}
+	var reuse []archiveLayer
+	var reused int64
+	for _, l := range layers {
+		if have[l.Digest] {
+			reuse = append(reuse, l)
+			reused += l.Size
+		}
+	}
+	if len(reuse) == 0 {
+		return fmt.Errorf("%w: 服务端没有可复用的层", errNoDedup)
+	}
+	slices.SortFunc(reuse, func(a, b archiveLayer) int { return int(a.Offset - b.Offset) })
+
+	file, err := os.Open(opts.filePath)
+	if err != nil {
+		return fmt.Errorf("无法打开文件: %w", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("无法获取文件信息: %w", err)
+	}
+	size := info.Size()
+	fileName := info.Name()
+	if opts.asciiName && !isASCIIName(fileName) {
+		fileName = asciiName(fileName)
+	}
+	fmt.Printf("📁 文件: %s\n", fileName)
+	fmt.Printf("📊 大小: %s\n", formatBytes(size))
+	fmt.Printf("🎯 目标: %s\n", opts.serverURL)
+	fmt.Printf("♻️  服务端已有 %d/%d 层 (%s)，只发送其余的 %s\n", len(reuse), len(layers), formatBytes(reused), formatBytes(size-reused))
+
+	bar := newTransferBar(size-reused, "📤 上传 "+fileName)
+	if opts.hideBar {
+		bar = hiddenBar(size - reused)
+	}
+	limiter := opts.uploadLimiter()
+	body := newStreamBody()
+	defer body.Close()
+	body.start(func() error {
+		return writeDedupFrames(ctx, body.w, file, size, reuse, opts, func(r io.Reader) io.Reader {
+			if limiter != nil {
+				r = &limitedReader{ctx: ctx, r: r, l: limiter}
+			}
+			return io.TeeReader(&slaCounter{r: r, m: sla}, bar)
+		})
+	})
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint+"/upload?name="+url.QueryEscape(fileName), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	rec.attempted = true
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		body.Close()
+		if perr := body.wait(); perr != nil {
+			err = perr
+		}
+		return windowError(ctx, fmt.Errorf("上传失败: %w", err))
+	}
+	defer resp.Body.Close()
+	bar.Finish()
+	body.Close()
+	if err := body.wait(); err != nil {
+		return windowError(ctx, fmt.Errorf("读取文件失败: %w", err))
+	}
+	rec.Bytes, rec.Duration = body.sent.Load(), time.Since(start).Seconds()
+	if opts.progress != nil {
+		opts.progress(size, size)
+	}
+	return reportUploadResponse(resp, opts, "")
+}
+
+// 询问服务端已有哪些层；服务端不支持时返回 errNoDedup
+func checkLayers(ctx context.Context, client *sessionClient, endpoint string, layers []archiveLayer) (map[string]bool, error) {
+	digests := make([]string, len(layers))
+	for i, l := range layers {
+		digests[i] = l.Digest
+	}
+	data, err := json.Marshal(map[string]any{"layers": digests})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("查询服务端的层失败: %w", err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+		return nil, fmt.Errorf("查询服务端的层失败: %s", responseError(resp))
+	case resp.StatusCode != http.StatusOK:
+		// 旧版服务端没有该接口 (请求会落到普通上传上)
+		return nil, fmt.Errorf("%w: 服务端不支持 (%s)", errNoDedup, resp.Status)
+	}
+	var result struct {
+		Have []string `json:"have"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("%w: 无法解析服务端的响应", errNoDedup)
+	}
+	have := map[string]bool{}
+	for _, d := range result.Have {
+		have[d] = true
+	}
+	return have, nil
+}
+
+// 写出各帧：复用的层以引用发送，其余数据 (经 wrap 计入进度和限速) 原样发送；同时计算整个文件的摘要，
+// 随最后的表单字段发送供服务端校验
+func writeDedupFrames(ctx context.Context, w io.Writer, file *os.File, size int64, reuse []archiveLayer, opts options, wrap func(io.Reader) io.Reader) error {
+	sha := sha256.New()
+	tree := uploader.NewTreeHasher()
+	hashes := io.MultiWriter(sha, tree)
+	buf := make([]byte, dedupFrameSize)
+
+	sendData := func(from, to int64) error {
+		r := wrap(&contextReader{ctx: ctx, r: io.NewSectionReader(file, from, to-from)})
+		for {
+			n, err := io.ReadFull(r, buf)
+			if n > 0 {
+				hashes.Write(buf[:n])
+				if err := writeFrameHeader(w, dedupData, uint32(n)); err != nil {
+					return err
+				}
+				if _, err := w.Write(buf[:n]); err != nil {
+					return err
+				}
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	var pos int64
+	for _, l := range reuse {
+		if err := sendData(pos, l.Offset); err != nil {
+			return err
+		}
+		// 复用的层不发送，但仍计入摘要
+		if _, err := io.Copy(hashes, &contextReader{ctx: ctx, r: io.NewSectionReader(file, l.Offset, l.Size)}); err != nil {
+			return fmt.Errorf("读取文件失败: %w", err)
+		}
+		if _, err := w.Write([]byte{dedupLayer}); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.BigEndian, uint64(l.Size)); err != nil {
+			return err
+		}
+		if _, err := w.Write(append([]byte{byte(len(l.Digest))}, l.Digest...)); err != nil {
+			return err
+		}
+		pos = l.Offset + l.Size
+	}
+	if err := sendData(pos, size); err != nil {
+		return err
+	}
+
+	var sum string
+	if opts.checksum {
+		sum = hex.EncodeToString(sha.Sum(nil))
+		fmt.Printf("\n🔐 SHA-256: %s\n", sum)
+	}
+	fields, err := uploadFields(opts, sum)
+	if err != nil {
+		return err
+	}
+	fields["tree_sha256"] = tree.Sum()
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	if err := writeFrameHeader(w, dedupFields, uint32(len(data))); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func writeFrameHeader(w io.Writer, kind byte, n uint32) error {
+	header := [5]byte{kind}
+	binary.BigEndian.PutUint32(header[1:], n)
+	_, err := w.Write(header[:])
+	return err
+}
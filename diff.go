@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+// errNotFound 服务端不存在查询的文件或制品
+var errNotFound = errors.New("服务端不存在该文件")
+
+// diff-remote 子命令：比较本地镜像归档与服务端已存储文件的层摘要，
+// 用于确认服务端上的是否正是本地构建的镜像
+func runDiffRemote(args []string) {
+	var opts options
+	fs := newCommandFlags("diff-remote")
+	fs.StringVar(&opts.serverURL, "url", "", "服务端地址 (必须)")
+	registerClientFlags(fs, &opts)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: docker_save_shell diff-remote <本地文件> <远端名称> -url <地址>")
+		fmt.Fprintln(fs.Output(), "远端名称为服务端的文件名，或 name@version 形式的制品 (省略版本号时依次查找同名文件和 latest 制品)")
+		fs.PrintDefaults()
+	}
+	positional := parseInterspersed(fs, args)
+	if opts.serverURL == "" || len(positional) != 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	client, closeClient := mustRemoteClient(opts)
+	same, err := diffRemote(client, positional[0], opts.serverURL, positional[1])
+	closeClient()
+	if err != nil {
+		fmt.Printf("比较失败: %v\n", err)
+		os.Exit(1)
+	}
+	if !same {
+		os.Exit(1)
+	}
+}
+
+// 比较本地归档与远端文件，返回两者的镜像和层是否完全一致
+func diffRemote(client *http.Client, path, serverURL, ref string) (bool, error) {
+	local, err := parseDockerArchive(path)
+	if err != nil {
+		return false, fmt.Errorf("解析本地归档失败: %w", err)
+	}
+	if local == nil {
+		return false, fmt.Errorf("%s 不是镜像归档 (docker save 的输出)", path)
+	}
+
+	name, version, isArtifact := strings.Cut(ref, "@")
+	var remote *archiveContents
+	if isArtifact {
+		remote, err = fetchContents(client, serverURL, "artifacts", name, version)
+	} else {
+		remote, err = fetchContents(client, serverURL, "files", name)
+		if errors.Is(err, errNotFound) {
+			remote, err = fetchContents(client, serverURL, "artifacts", name, latestVersion)
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+	if remote.Format != "docker-archive" {
+		if remote.Error != "" {
+			return false, fmt.Errorf("服务端无法解析该文件: %s", remote.Error)
+		}
+		return false, fmt.Errorf("远端文件 %s 不是镜像归档", remote.File)
+	}
+
+	target := remote.File
+	if remote.Artifact != "" {
+		target = remote.Artifact + "@" + remote.Version
+	}
+	fmt.Printf("📁 本地: %s\n", path)
+	fmt.Printf("🎯 远端: %s\n", target)
+	differs := printImageDiff(local, remote.Images)
+	if differs == 0 {
+		fmt.Println("\n✅ 远端文件与本地归档的镜像和层完全一致")
+		return true, nil
+	}
+	fmt.Printf("\n❌ 共 %d 处不同\n", differs)
+	return false, nil
+}
+
+// 查询服务端文件的内容索引，elem 为 /files/{name} 或 /artifacts/{name}/{version} 的路径各段
+func fetchContents(client *http.Client, serverURL string, elem ...string) (*archiveContents, error) {
+	endpoint, err := url.JoinPath(serverURL, append(elem, "contents")...)
+	if err != nil {
+		return nil, fmt.Errorf("服务端地址格式错误: %w", err)
+	}
+	resp, err := client.Get(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("查询远端内容失败: %s", responseError(resp))
+	}
+	contents := &archiveContents{}
+	if err := json.NewDecoder(resp.Body).Decode(contents); err != nil {
+		return nil, fmt.Errorf("解析响应失败: %w", err)
+	}
+	return contents, nil
+}
+
+// 镜像的显示名称：第一个标签，没有标签时为镜像 ID
+func imageName(img archiveImage) string {
+	if len(img.Tags) > 0 {
+		return img.Tags[0]
+	}
+	return img.ID
+}
+
+// 按标签配对两边的镜像 (各只有一个镜像时直接配对)，逐层输出差异，返回不同之处的数量
+func printImageDiff(local, remote []archiveImage) int {
+	differs := 0
+	matched := make([]bool, len(remote))
+	for _, img := range local {
+		j := slices.IndexFunc(remote, func(r archiveImage) bool {
+			return slices.ContainsFunc(img.Tags, func(t string) bool { return slices.Contains(r.Tags, t) })
+		})
+		if j < 0 && len(local) == 1 && len(remote) == 1 {
+			j = 0
+		}
+		if j < 0 || matched[j] {
+			fmt.Printf("\n🐳 %s\n   ➕ 仅本地存在 (%s)\n", imageName(img), img.ID)
+			differs++
+			continue
+		}
+		matched[j] = true
+		differs += printLayerDiff(img, remote[j])
+	}
+	for j, img := range remote {
+		if !matched[j] {
+			fmt.Printf("\n🐳 %s\n   ➖ 仅远端存在 (%s)\n", imageName(img), img.ID)
+			differs++
+		}
+	}
+	return differs
+}
+
+// 按顺序比较一个镜像的各层：层的顺序决定文件系统的叠加结果，同一位置的摘要不同即视为不同
+func printLayerDiff(local, remote archiveImage) int {
+	differs := 0
+	fmt.Printf("\n🐳 %s\n", imageName(local))
+	if local.ID == remote.ID {
+		fmt.Printf("   = 镜像 ID  %s\n", local.ID)
+	} else {
+		fmt.Printf("   ≠ 镜像 ID  本地 %s\n              远端 %s\n", local.ID, remote.ID)
+		differs++
+	}
+	for i := range max(len(local.Layers), len(remote.Layers)) {
+		switch {
+		case i >= len(remote.Layers):
+			l := local.Layers[i]
+			fmt.Printf("   + 层 %-3d  %s (%s，仅本地)\n", i+1, l.Digest, formatBytes(l.Size))
+			differs++
+		case i >= len(local.Layers):
+			r := remote.Layers[i]
+			fmt.Printf("   - 层 %-3d  %s (%s，仅远端)\n", i+1, r.Digest, formatBytes(r.Size))
+			differs++
+		case local.Layers[i].Digest == remote.Layers[i].Digest:
+			l := local.Layers[i]
+			fmt.Printf("   = 层 %-3d  %s (%s)\n", i+1, l.Digest, formatBytes(l.Size))
+		default:
+			l, r := local.Layers[i], remote.Layers[i]
+			fmt.Printf("   ≠ 层 %-3d  本地 %s (%s)\n             远端 %s (%s)\n", i+1, l.Digest, formatBytes(l.Size), r.Digest, formatBytes(r.Size))
+			differs++
+		}
+	}
+	return differs
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// dirFilter 打包目录时的文件过滤规则，模式同时匹配相对路径和文件名，例如 *.yaml、conf/*.ini
+type dirFilter struct {
+	include []string // 非空时只打包匹配的文件
+	exclude []string // 匹配的文件和目录不打包
+}
+
+func matchAny(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, rel); ok {
+			return true
+		}
+		if ok, _ := path.Match(p, path.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// 检查过滤规则中的模式是否合法
+func (f dirFilter) validate() error {
+	for _, p := range append(append([]string{}, f.include...), f.exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("非法的匹配模式 %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// 以目录作为数据源：边遍历边生成 tar 流，不创建临时归档文件。
+// 归档内的路径以目录名开头，解包后还原为同名目录；压缩由流水线的 gzip 等阶段完成。
+// sums 非空时同时记录每个文件的摘要，归档读完后即完整。
+func openDirSource(dir string, filter dirFilter, sums *checksumManifest) (*source, error) {
+	if err := filter.validate(); err != nil {
+		return nil, err
+	}
+	dir = filepath.Clean(dir)
+	base := filepath.Base(dir)
+	if abs, err := filepath.Abs(dir); err == nil {
+		base = filepath.Base(abs)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeDirTar(pw, dir, base, filter, sums))
+	}()
+	return &source{ReadCloser: pr, name: base + ".tar", size: -1, dir: true}, nil
+}
+
+// 将目录写为 tar 流
+func writeDirTar(w io.Writer, dir, base string, filter dirFilter, sums *checksumManifest) error {
+	tw := tar.NewWriter(w)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel != "." && matchAny(filter.exclude, rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() && len(filter.include) > 0 && !matchAny(filter.include, rel) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		var link string
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		case !info.Mode().IsRegular() && !info.IsDir():
+			// 设备文件、管道和套接字不打包
+			return nil
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return fmt.Errorf("生成 %s 的归档头失败: %w", rel, err)
+		}
+		hdr.Name = path.Join(base, rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		var dst io.Writer = tw
+		h := sha256.New()
+		if sums != nil {
+			dst = io.MultiWriter(tw, h)
+		}
+		if _, err := io.CopyN(dst, f, hdr.Size); err != nil {
+			return fmt.Errorf("打包 %s 失败: %w", rel, err)
+		}
+		if sums != nil {
+			sums.add(hdr.Name, hex.EncodeToString(h.Sum(nil)))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// 非 Unix 平台暂不支持查询剩余空间
+func diskFree(dir string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 下载会话令牌的头部：服务端随下载响应返回，客户端续传时原样带回
+const downloadSessionHeader = "X-Download-Session"
+
+// 文件当前内容对应的下载会话令牌: <大小>.<摘要>。
+// 制品使用上传时记录的 SHA-256；普通文件没有记录摘要，以修改时间代替
+func downloadSession(info os.FileInfo, digest string) string {
+	if digest == "" {
+		digest = "m" + strconv.FormatInt(info.ModTime().UnixNano(), 16)
+	}
+	return strconv.FormatInt(info.Size(), 10) + "." + strings.ToLower(digest)
+}
+
+// 令牌中的摘要，普通文件 (以修改时间代替摘要) 返回空
+func sessionDigest(token string) string {
+	_, digest, _ := strings.Cut(token, ".")
+	if strings.HasPrefix(digest, "m") {
+		return ""
+	}
+	return digest
+}
+
+// downloadState 未完成的下载，保存在 <状态目录>/downloads/ 下，按下载地址区分
+type downloadState struct {
+	URL     string `json:"url"`
+	Out     string `json:"out"`
+	Session string `json:"session"`
+	Size    int64  `json:"size"`
+}
+
+func downloadStatePath(target string) string {
+	sum := sha256.Sum256([]byte(target))
+	return filepath.Join(stateDir(), "downloads", hex.EncodeToString(sum[:8])+".json")
+}
+
+// 读取该地址未完成的下载，没有时返回 nil
+func loadDownloadState(target string) *downloadState {
+	data, err := os.ReadFile(downloadStatePath(target))
+	if err != nil {
+		return nil
+	}
+	st := &downloadState{}
+	if json.Unmarshal(data, st) != nil || st.URL != target || st.Session == "" {
+		return nil
+	}
+	return st
+}
+
+func (st *downloadState) save() error {
+	path := downloadStatePath(st.URL)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, st)
+}
+
+func (st *downloadState) remove() {
+	os.Remove(downloadStatePath(st.URL))
+}
+
+// 续传请求带回的令牌与文件当前内容不符 (文件已被替换) 时返回 412，客户端应丢弃已下载的部分
+func checkDownloadSession(w http.ResponseWriter, r *http.Request, session string) bool {
+	if want := r.Header.Get(downloadSessionHeader); want != "" && want != session {
+		writeJSON(w, http.StatusPreconditionFailed, uploadResult{Error: fmt.Sprintf("文件已变化 (续传令牌 %s，当前 %s)", want, session)})
+		return false
+	}
+	return true
+}
+
+// storedSum 普通文件上传时记录的 SHA-256，下载时作为令牌中的摘要供客户端校验。
+// 保存在 <存储目录>/.dss/sums/<文件名>.json，文件被替换 (大小或修改时间变化) 后失效
+type storedSum struct {
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	SHA256   string    `json:"sha256"`
+}
+
+func (s *server) sumPath(name string) string {
+	return filepath.Join(serverMetaDir(s.opts.dir), "sums", name+".json")
+}
+
+// 记录刚保存的文件的摘要，失败只影响下载时能否校验
+func (s *server) recordSum(path, digest string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	sumPath := s.sumPath(filepath.Base(path))
+	if err := os.MkdirAll(filepath.Dir(sumPath), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(sumPath, storedSum{Size: info.Size(), Modified: info.ModTime(), SHA256: digest})
+}
+
+// 文件被 repair 按片修复后内容恢复为上传时的数据，但修改时间已变化，按新的大小和修改时间沿用原来记录的摘要
+func (s *server) refreshSum(path string) error {
+	data, err := os.ReadFile(s.sumPath(filepath.Base(path)))
+	if err != nil {
+		return err
+	}
+	var sum storedSum
+	if err := json.Unmarshal(data, &sum); err != nil {
+		return err
+	}
+	return s.recordSum(path, sum.SHA256)
+}
+
+// 文件记录的摘要，没有记录或文件已被替换时返回空
+func (s *server) storedDigest(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	data, err := os.ReadFile(s.sumPath(filepath.Base(path)))
+	if err != nil {
+		return ""
+	}
+	var sum storedSum
+	if json.Unmarshal(data, &sum) != nil || sum.Size != info.Size() || !sum.Modified.Equal(info.ModTime()) {
+		return ""
+	}
+	return sum.SHA256
+}
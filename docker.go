@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// dockerEndpoint 执行 docker 命令的守护进程 (-docker-host / -docker-context)。
+// 均未指定时由 docker CLI 按 DOCKER_HOST、DOCKER_CONTEXT 和当前 context 决定，
+// 因此也可以直接设置这些环境变量，在笔记本上导出只存在于构建机上的镜像
+type dockerEndpoint struct {
+	host     string // ssh://user@host 或 tcp://host:2376
+	context  string // docker context 名称
+	certPath string // tcp:// 地址启用 TLS 时的证书目录 (ca.pem、cert.pem、key.pem)
+}
+
+func (e dockerEndpoint) validate() error {
+	if e.host != "" && e.context != "" {
+		return errors.New("-docker-host 与 -docker-context 不能同时指定")
+	}
+	if e.certPath != "" && !strings.HasPrefix(e.host, "tcp://") {
+		return errors.New("-docker-cert-path 只能与 tcp:// 形式的 -docker-host 同时使用")
+	}
+	return nil
+}
+
+// 放在 docker 子命令之前的全局参数
+func (e dockerEndpoint) globalArgs() []string {
+	var args []string
+	switch {
+	case e.host != "":
+		args = append(args, "-H", e.host)
+	case e.context != "":
+		args = append(args, "--context", e.context)
+	}
+	if e.certPath != "" {
+		args = append(args, "--tlsverify",
+			"--tlscacert", filepath.Join(e.certPath, "ca.pem"),
+			"--tlscert", filepath.Join(e.certPath, "cert.pem"),
+			"--tlskey", filepath.Join(e.certPath, "key.pem"))
+	}
+	return args
+}
+
+func (e dockerEndpoint) command(ctx context.Context, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, "docker", append(e.globalArgs(), args...)...)
+}
+
+// 用于输出的守护进程描述，使用本机默认的守护进程时为空
+func (e dockerEndpoint) String() string {
+	switch {
+	case e.host != "":
+		return e.host
+	case e.context != "":
+		return "context " + e.context
+	case os.Getenv("DOCKER_HOST") != "":
+		return os.Getenv("DOCKER_HOST")
+	case os.Getenv("DOCKER_CONTEXT") != "":
+		return "context " + os.Getenv("DOCKER_CONTEXT")
+	}
+	return ""
+}
+
+// 执行 docker 命令并返回标准输出，失败时附带标准错误输出
+func dockerCommand(args ...string) (string, error) {
+	return dockerEndpoint{}.run(args...)
+}
+
+func (e dockerEndpoint) run(args ...string) (string, error) {
+	cmd := e.command(context.Background(), args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("docker %s 失败: %s", args[0], msg)
+	}
+	return stdout.String(), nil
+}
+
+// 查询镜像 ID，镜像不存在时返回空字符串
+func dockerImageID(ref string) string {
+	out, err := dockerCommand("image", "inspect", "--format", "{{.Id}}", ref)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(out)
+}
+
+// dockerImage docker image inspect 得到的镜像信息
+type dockerImage struct {
+	ID     string
+	Size   int64 // 解压后的大小
+	Layers int
+}
+
+func (e dockerEndpoint) inspectImage(ref string) (*dockerImage, error) {
+	out, err := e.run("image", "inspect", "--format", "{{.Id}} {{.Size}} {{len .RootFS.Layers}}", ref)
+	if err != nil {
+		return nil, err
+	}
+	img := &dockerImage{}
+	if _, err := fmt.Sscan(out, &img.ID, &img.Size, &img.Layers); err != nil {
+		return nil, fmt.Errorf("解析镜像 %s 的信息失败: %w", ref, err)
+	}
+	return img, nil
+}
+
+// docker 报告镜像不存在 (区别于守护进程无法连接等错误)
+func isNoSuchImage(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "No such image")
+}
+
+// 拉取镜像，docker pull 的进度直接输出到终端
+func (e dockerEndpoint) pull(ref string) error {
+	cmd := e.command(context.Background(), "pull", ref)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("docker pull %s 失败: %w", ref, err)
+	}
+	return nil
+}
+
+// 执行 docker load，返回加载的镜像 (标签或镜像 ID)
+func dockerLoad(path string) ([]string, error) {
+	out, err := dockerCommand("load", "-i", path)
+	if err != nil {
+		return nil, err
+	}
+	return parseLoadedImages(out), nil
+}
+
+// 从 docker load 的输出中取出加载的镜像 (标签或镜像 ID)
+func parseLoadedImages(out string) []string {
+	var loaded []string
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if ref, ok := strings.CutPrefix(line, "Loaded image: "); ok {
+			loaded = append(loaded, strings.TrimSpace(ref))
+		} else if id, ok := strings.CutPrefix(line, "Loaded image ID: "); ok {
+			loaded = append(loaded, strings.TrimSpace(id))
+		}
+	}
+	return loaded
+}
+
+// 给镜像打标签
+func dockerTag(src, dst string) error {
+	_, err := dockerCommand("tag", src, dst)
+	return err
+}
+
+// 查询 docker 守护进程的平台 (os/arch)
+func dockerPlatform(ctx context.Context) (string, error) {
+	out, err := exec.CommandContext(ctx, "docker", "version", "--format", "{{.Server.Os}}/{{.Server.Arch}}").Output()
+	if err != nil {
+		return "", fmt.Errorf("docker 不可用: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// 检查 docker 守护进程是否可用
+func dockerPing(ctx context.Context) (string, error) {
+	out, err := exec.CommandContext(ctx, "docker", "version", "--format", "{{.Server.Version}}").CombinedOutput()
+	if err != nil {
+		msg := strings.TrimSpace(string(out))
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("docker 不可用: %s", msg)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
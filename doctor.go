@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// 服务端单次探测最多接收的字节数
+const maxProbeSize = 256 << 20
+
+// tcpStats 连接的内核 TCP 统计 (仅 Linux 支持)
+type tcpStats struct {
+	RTT     time.Duration // 平滑往返时延
+	MinRTT  time.Duration // 最小往返时延
+	MSS     uint32        // 发送方向的最大报文段长度
+	PMTU    uint32        // 路径 MTU
+	Retrans uint32        // 累计重传的报文段数
+	SegsOut uint32        // 累计发送的报文段数
+	Cwnd    uint32        // 拥塞窗口 (报文段数)
+}
+
+// 重传率
+func (t *tcpStats) retransRate() float64 {
+	if t.SegsOut == 0 {
+		return 0
+	}
+	return float64(t.Retrans) / float64(t.SegsOut)
+}
+
+func (t *tcpStats) print() {
+	fmt.Printf("   RTT: %s (最小 %s)\n", t.RTT, t.MinRTT)
+	fmt.Printf("   路径 MTU: %d, MSS: %d, 拥塞窗口: %d 段\n", t.PMTU, t.MSS, t.Cwnd)
+	fmt.Printf("   重传: %d/%d 段 (%.2f%%)\n", t.Retrans, t.SegsOut, t.retransRate()*100)
+}
+
+// probeResult 一次探测的结果
+type probeResult struct {
+	Bytes    int64
+	Duration time.Duration
+	Stats    *tcpStats // 读取失败或不支持时为 nil
+	Stalled  bool      // 超时仍未完成
+	Err      error
+}
+
+// 吞吐量 (字节/秒)
+func (p *probeResult) speed() float64 {
+	if p.Duration <= 0 {
+		return 0
+	}
+	return float64(p.Bytes) / p.Duration.Seconds()
+}
+
+// 接收并丢弃探测数据，返回实际接收的字节数和耗时
+func (s *server) handleProbe(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	n, err := io.Copy(io.Discard, io.LimitReader(r.Body, maxProbeSize))
+	if err != nil {
+		http.Error(w, "接收探测数据失败", http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"bytes": n, "seconds": time.Since(start).Seconds()})
+}
+
+// doctor 子命令：诊断到服务端的网络状况，给出 MTU/TCP 相关的调优建议
+func runDoctor(args []string) {
+	var serverURL string
+	size := byteSize(16 << 20)
+	fs := newCommandFlags("doctor")
+	fs.StringVar(&serverURL, "url", "", "服务端地址 (必须)")
+	fs.Var(&size, "size", "吞吐测试发送的数据量")
+	timeout := fs.Duration("timeout", 30*time.Second, "单次探测的超时时间，大数据量探测超时通常意味着 PMTU 黑洞")
+	fs.Parse(args)
+
+	if serverURL == "" {
+		fmt.Println("错误：缺少必要参数 -url")
+		fs.Usage()
+		os.Exit(1)
+	}
+	if size > maxProbeSize {
+		size = maxProbeSize
+	}
+
+	if err := doctor(serverURL, int64(size), *timeout); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func doctor(serverURL string, size int64, timeout time.Duration) error {
+	endpoint, err := url.JoinPath(serverURL, "probe")
+	if err != nil {
+		return fmt.Errorf("服务端地址格式错误: %w", err)
+	}
+	client := newSessionClient(randomID(), 0)
+
+	// 1. 小请求：确认连通性和往返时延，数据包都小于 MTU，不受 PMTU 问题影响
+	fmt.Println("🔍 连通性检查...")
+	ping := sendProbe(client, endpoint, 1<<10, timeout)
+	if ping.Err != nil {
+		fmt.Printf("❌ 无法连接服务端: %v\n", ping.Err)
+		if e := classifyNetError(ping.Err); e.Hint != "" {
+			fmt.Printf("💡 %s\n", e.Hint)
+		}
+		return errors.New("诊断未完成")
+	}
+	fmt.Printf("✅ 连接正常，请求耗时 %s\n", ping.Duration.Round(time.Millisecond))
+
+	// 2. 基线：1 MiB 的短时探测，作为持续吞吐的参照
+	fmt.Println("\n🔍 基线探测 (1 MiB)...")
+	baseline := sendProbe(client, endpoint, 1<<20, timeout)
+	if baseline.Err == nil {
+		fmt.Printf("   %s/s\n", formatBytes(int64(baseline.speed())))
+	} else {
+		fmt.Printf("⚠️  基线探测失败: %v\n", baseline.Err)
+	}
+
+	// 3. 持续吞吐
+	fmt.Printf("\n🔍 吞吐测试 (%s)...\n", formatBytes(size))
+	bulk := sendProbe(client, endpoint, size, timeout)
+	if bulk.Err == nil {
+		fmt.Printf("   %s/s\n", formatBytes(int64(bulk.speed())))
+	} else {
+		fmt.Printf("⚠️  吞吐测试失败: %v\n", bulk.Err)
+	}
+	if bulk.Stats != nil {
+		fmt.Println("\n📊 TCP 连接统计:")
+		bulk.Stats.print()
+	}
+
+	fmt.Println()
+	hints := networkHints(&ping, &baseline, &bulk)
+	if len(hints) == 0 {
+		fmt.Println("✅ 未发现明显的网络问题")
+	}
+	for _, h := range hints {
+		fmt.Printf("💡 %s\n", h)
+	}
+	if bulk.Err == nil {
+		fmt.Printf("📦 建议分块大小: %s\n", formatBytes(suggestedChunkSize(bulk.speed(), bulk.Stats)))
+	}
+	return nil
+}
+
+// 发送一次探测；超时前读取连接的 TCP 统计，避免连接关闭后无法读取
+func sendProbe(client *sessionClient, endpoint string, size int64, timeout time.Duration) probeResult {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	result := probeResult{Bytes: size}
+	timer := time.AfterFunc(timeout, func() {
+		if conn := client.dialer.lastConn(); conn != nil {
+			result.Stats, _ = readTCPStats(conn)
+		}
+		result.Stalled = true
+		cancel()
+	})
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, io.LimitReader(&probeData{x: 2463534242}, size))
+	if err != nil {
+		timer.Stop()
+		result.Err = err
+		return result
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err == nil {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("服务端返回 %s", responseError(resp))
+		}
+	}
+	result.Duration = time.Since(start)
+	if !timer.Stop() {
+		// 超时回调已执行或正在执行，等待 cancel 生效后再读取结果
+		<-ctx.Done()
+		if result.Stalled {
+			result.Err = fmt.Errorf("%s 内未完成", timeout)
+		}
+		return result
+	}
+
+	result.Err = err
+	if conn := client.dialer.lastConn(); conn != nil {
+		result.Stats, _ = readTCPStats(conn)
+	}
+	return result
+}
+
+// probeData 生成探测数据 (不可压缩的伪随机字节，避免中间设备压缩影响测量)
+type probeData struct {
+	x uint32
+}
+
+func (d *probeData) Read(p []byte) (int, error) {
+	for i := range p {
+		d.x ^= d.x << 13
+		d.x ^= d.x >> 17
+		d.x ^= d.x << 5
+		p[i] = byte(d.x)
+	}
+	return len(p), nil
+}
+
+// 根据探测结果判断常见的网络问题
+func networkHints(ping, baseline, bulk *probeResult) []string {
+	var hints []string
+
+	// 小请求正常而大数据量传输停滞，是 PMTU 黑洞的典型表现：
+	// 大包被中间设备丢弃，但 ICMP "需要分片" 消息被过滤，发送方无法得知应减小报文
+	if ping.Err == nil && bulk.Stalled {
+		hints = append(hints,
+			"小请求正常但大数据量传输停滞，疑似 PMTU 黑洞 (大包被丢弃且 ICMP 被过滤)",
+			"可尝试: sysctl -w net.ipv4.tcp_mtu_probing=1，或将网卡 MTU 调低 (如 ip link set dev eth0 mtu 1400)",
+			"经过 VPN/隧道时，请确认隧道设备开启了 MSS clamping")
+	}
+
+	if bulk.Stats != nil {
+		hints = append(hints, tcpHints(bulk.Stats, bulk.speed(), bulk.Err == nil)...)
+	}
+
+	// 持续吞吐远低于基线：通常是链路限速、丢包后拥塞窗口反复收缩
+	if baseline.Err == nil && bulk.Err == nil && bulk.speed() < baseline.speed()/4 {
+		hints = append(hints, fmt.Sprintf("持续吞吐 (%s/s) 远低于基线 (%s/s)，链路可能存在限速或持续丢包",
+			formatBytes(int64(bulk.speed())), formatBytes(int64(baseline.speed()))))
+	}
+	return hints
+}
+
+// 根据 TCP 统计判断丢包、封装和窗口问题；completed 表示传输已完成，speed 为其吞吐量
+func tcpHints(st *tcpStats, speed float64, completed bool) []string {
+	var hints []string
+	if rate := st.retransRate(); rate > 0.02 && st.SegsOut > 100 {
+		hints = append(hints, fmt.Sprintf("重传率 %.1f%%，链路存在明显丢包，请检查网卡错误计数、无线信号或运营商线路", rate*100))
+	}
+	if st.PMTU > 0 && st.PMTU < 1500 {
+		hints = append(hints, fmt.Sprintf("路径 MTU 为 %d，链路经过隧道或 PPPoE 封装，如传输异常可尝试调低 MTU", st.PMTU))
+	}
+	// 高延迟链路上吞吐受限于窗口大小 (带宽时延积)
+	if st.RTT > 100*time.Millisecond && completed && speed < 10<<20 {
+		hints = append(hints, fmt.Sprintf("RTT 为 %s，高延迟链路的吞吐受 TCP 窗口限制，可增大 net.core.wmem_max 和 net.ipv4.tcp_wmem 或使用 BBR 拥塞控制", st.RTT.Round(time.Millisecond)))
+	}
+	return hints
+}
+
+// 按实测吞吐推荐分块大小：每块约 10 秒传完，丢包严重时减半以降低重传代价
+func suggestedChunkSize(speed float64, st *tcpStats) int64 {
+	size := int64(speed * 10)
+	if st != nil && st.retransRate() > 0.05 {
+		size /= 2
+	}
+	return min(max(size, 1<<20), 64<<20)
+}
+
+// 输出上传连接的 TCP 统计和调优建议 (-verbose)
+func printUploadDiagnostics(client *sessionClient, bytes int64, elapsed time.Duration) {
+	conn := client.dialer.lastConn()
+	if conn == nil {
+		return
+	}
+	st, err := readTCPStats(conn)
+	if err != nil {
+		return
+	}
+	fmt.Println("\n📊 TCP 连接统计:")
+	st.print()
+
+	speed := float64(bytes) / elapsed.Seconds()
+	for _, h := range tcpHints(st, speed, true) {
+		fmt.Printf("💡 %s\n", h)
+	}
+}
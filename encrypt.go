@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// 加密数据的格式：文件头 (encryptMagic + 7 字节随机前缀) 之后是若干 AES-256-GCM 分段，
+// 每段明文 64 KiB，nonce 由前缀、4 字节段序号和末段标记组成；末段标记防止数据在分段边界处被截断
+const (
+	encryptMagic       = "DSSENC1\n"
+	encryptSuffix      = ".enc"
+	encryptPrefixSize  = 7
+	encryptSegmentSize = 64 * 1024
+)
+
+// 读取 -encrypt-key / -decrypt-key 指定的密钥文件：64 个十六进制字符 (可用 openssl rand -hex 32 生成) 或 32 字节原始数据
+func loadEncryptKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取密钥失败: %w", err)
+	}
+	if len(data) == 32 {
+		return data, nil
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("密钥文件 %s 格式错误: 需要 32 字节 (64 个十六进制字符) 的 AES-256 密钥", path)
+	}
+	return key, nil
+}
+
+func newEncryptAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// 第 counter 段的 nonce
+func encryptNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encryptPrefixSize:], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// 流水线的 encrypt 阶段：只能作为最后一个阶段，压缩和摘要都针对加密前的数据
+func encryptStage(key []byte) func(r io.Reader) io.Reader {
+	return func(r io.Reader) io.Reader {
+		er := &encryptReader{src: bufio.NewReader(r), segment: make([]byte, encryptSegmentSize)}
+		er.aead, er.err = newEncryptAEAD(key)
+		if er.err == nil {
+			prefix := make([]byte, encryptPrefixSize)
+			_, er.err = rand.Read(prefix)
+			er.prefix = prefix
+			er.buf.WriteString(encryptMagic)
+			er.buf.Write(prefix)
+		}
+		return er
+	}
+}
+
+// encryptReader 与 compressReader 一样在调用方的 Read 中同步加密
+type encryptReader struct {
+	src     *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	segment []byte
+	buf     bytes.Buffer
+	done    bool
+	err     error
+}
+
+func (er *encryptReader) Read(p []byte) (int, error) {
+	for er.buf.Len() == 0 && !er.done && er.err == nil {
+		n, err := io.ReadFull(er.src, er.segment)
+		last := false
+		switch {
+		case err == io.EOF || err == io.ErrUnexpectedEOF:
+			last = true
+		case err != nil:
+			er.err = err
+			continue
+		default:
+			// 恰好读满一段时需要看后面是否还有数据才能确定是否为末段
+			if _, perr := er.src.Peek(1); perr == io.EOF {
+				last = true
+			} else if perr != nil {
+				er.err = perr
+				continue
+			}
+		}
+		er.buf.Write(er.aead.Seal(nil, encryptNonce(er.prefix, er.counter, last), er.segment[:n], nil))
+		if last {
+			er.done = true
+		} else if er.counter++; er.counter == 0 {
+			er.err = errors.New("加密数据过大")
+		}
+	}
+	if er.buf.Len() == 0 {
+		if er.err != nil {
+			return 0, er.err
+		}
+		return 0, io.EOF
+	}
+	return er.buf.Read(p)
+}
+
+// 数据是否为 encrypt 阶段的输出
+func isEncrypted(br *bufio.Reader) bool {
+	head, _ := br.Peek(len(encryptMagic))
+	return string(head) == encryptMagic
+}
+
+// 解密 encrypt 阶段的输出，任何一段校验失败 (密钥错误、数据损坏或被截断) 时 Read 返回错误
+func newDecryptReader(br *bufio.Reader, key []byte) (io.Reader, error) {
+	aead, err := newEncryptAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, len(encryptMagic)+encryptPrefixSize)
+	if _, err := io.ReadFull(br, header); err != nil || string(header[:len(encryptMagic)]) != encryptMagic {
+		return nil, errors.New("不是加密数据或文件头已损坏")
+	}
+	return &decryptReader{
+		src:     br,
+		aead:    aead,
+		prefix:  header[len(encryptMagic):],
+		segment: make([]byte, encryptSegmentSize+aead.Overhead()),
+	}, nil
+}
+
+type decryptReader struct {
+	src     *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	segment []byte
+	plain   []byte
+	done    bool
+}
+
+func (dr *decryptReader) Read(p []byte) (int, error) {
+	for len(dr.plain) == 0 && !dr.done {
+		n, err := io.ReadFull(dr.src, dr.segment)
+		last := false
+		switch {
+		case err == io.EOF || err == io.ErrUnexpectedEOF:
+			last = true
+		case err != nil:
+			return 0, err
+		default:
+			if _, perr := dr.src.Peek(1); perr == io.EOF {
+				last = true
+			} else if perr != nil {
+				return 0, perr
+			}
+		}
+		plain, err := dr.aead.Open(dr.segment[:0], encryptNonce(dr.prefix, dr.counter, last), dr.segment[:n], nil)
+		if err != nil {
+			return 0, fmt.Errorf("解密第 %d 段失败: 密钥错误或数据已损坏", dr.counter)
+		}
+		dr.plain = plain
+		dr.done = last
+		dr.counter++
+	}
+	if len(dr.plain) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, dr.plain)
+	dr.plain = dr.plain[n:]
+	return n, nil
+}
+
+// 为流水线启用加密：keyPath 非空时在末尾加入 encrypt 阶段 (已写在 -pipeline 中时沿用)，
+// 流水线中有 encrypt 阶段却没有密钥时报错
+func (p *pipeline) useEncryptKey(keyPath string) error {
+	i := slices.IndexFunc(p.stages, func(st stage) bool { return st.encrypt })
+	if keyPath == "" {
+		if i >= 0 {
+			return errors.New("流水线中的 encrypt 阶段需要 -encrypt-key")
+		}
+		return nil
+	}
+	key, err := loadEncryptKey(keyPath)
+	if err != nil {
+		return err
+	}
+	if i < 0 {
+		p.stages = append(p.stages, stageRegistry["encrypt"])
+		i = len(p.stages) - 1
+	}
+	if i != len(p.stages)-1 {
+		return errors.New("encrypt 必须是流水线的最后一个阶段 (加密后的数据无法再压缩或转换)")
+	}
+	p.stages[i].wrap = encryptStage(key)
+	return nil
+}
+
+// decrypt 子命令：在本地解密 encrypt 阶段上传的文件 (如从服务端下载的 .enc 文件)
+func runDecrypt(args []string) {
+	fs := newCommandFlags("decrypt")
+	keyPath := fs.String("key", "", "上传时 -encrypt-key 所用的密钥文件 (必须)")
+	out := fs.String("o", "", "输出文件，默认为去掉 .enc 后缀的输入文件名，- 为标准输出")
+	fs.Parse(args)
+
+	if *keyPath == "" || fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := decryptFile(fs.Arg(0), *out, *keyPath); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func decryptFile(path, out, keyPath string) error {
+	key, err := loadEncryptKey(keyPath)
+	if err != nil {
+		return err
+	}
+	in := os.Stdin
+	if path != "-" {
+		if in, err = os.Open(path); err != nil {
+			return fmt.Errorf("无法打开文件: %w", err)
+		}
+		defer in.Close()
+	}
+	dr, err := newDecryptReader(bufio.NewReader(in), key)
+	if err != nil {
+		return err
+	}
+
+	if out == "" {
+		out = strings.TrimSuffix(path, encryptSuffix)
+		if out == path || path == "-" {
+			return errors.New("无法从输入文件名确定输出文件，请用 -o 指定")
+		}
+	}
+	if out == "-" {
+		_, err := io.Copy(os.Stdout, dr)
+		return err
+	}
+	// 先写入临时文件，解密全部成功后才出现在目标位置
+	tmp, err := os.CreateTemp(filepath.Dir(out), ".decrypt-*")
+	if err != nil {
+		return fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, dr); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), out); err != nil {
+		return fmt.Errorf("保存文件失败: %w", err)
+	}
+	fmt.Printf("🔓 已解密到 %s\n", out)
+	return nil
+}
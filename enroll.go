@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 未指定 -profile 时使用的客户端身份
+const defaultProfile = "default"
+
+// 客户端身份 (mTLS 证书和私钥) 的保存目录: <状态目录>/identity/<profile>/
+func identityDir(profile string) string {
+	return filepath.Join(stateDir(), "identity", profile)
+}
+
+// 已登记的客户端证书和私钥路径，尚未登记时返回空
+func enrolledIdentity(profile string) (cert, key string) {
+	dir := identityDir(profile)
+	cert, key = filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	if _, err := os.Stat(cert); err != nil {
+		return "", ""
+	}
+	if _, err := os.Stat(key); err != nil {
+		return "", ""
+	}
+	return cert, key
+}
+
+// enroll 子命令：生成密钥和 CSR，提交给 CA 签发客户端证书并安装为该 profile 的 mTLS 身份；
+// 无法直接访问 CA 时用 -print 输出 CSR，拿到证书后再用 -install 安装
+func runEnroll(args []string) {
+	var caURL, cn, profile, token, caCert, install string
+	var sans stringList
+	var printOnly bool
+	host, _ := os.Hostname()
+	fs := newCommandFlags("enroll")
+	fs.StringVar(&caURL, "ca-url", "", "CA 的签发地址，以 POST 提交 PEM 格式的 CSR，返回 PEM 格式的证书 (链)")
+	fs.StringVar(&cn, "cn", host, "证书的 CommonName")
+	fs.Var(&sans, "san", "证书的 DNS 名称或 IP 地址 (SAN)，可重复指定")
+	fs.StringVar(&profile, "profile", defaultProfile, "安装到的客户端身份，上传时未指定 -client-cert 即使用 "+defaultProfile)
+	fs.StringVar(&token, "token", "", "提交 CSR 时使用的一次性注册令牌 (Authorization: Bearer)")
+	fs.StringVar(&caCert, "ca-cert", "", "校验 CA 签发地址的 CA 证书 (PEM)")
+	fs.BoolVar(&printOnly, "print", false, "只生成密钥并输出 CSR，不提交")
+	fs.StringVar(&install, "install", "", "安装由 CA 签发的证书文件 (配合之前的 -print 使用)")
+	fs.Parse(args)
+
+	var err error
+	switch {
+	case install != "":
+		err = installIdentity(profile, install)
+	case printOnly || caURL == "":
+		var csr []byte
+		if csr, err = prepareEnrollment(profile, cn, sans); err == nil {
+			os.Stdout.Write(csr)
+			fmt.Fprintf(os.Stderr, "📝 已生成私钥，将上面的 CSR 提交给 CA，拿到证书后执行: docker_save_shell enroll -profile %s -install <证书文件>\n", profile)
+		}
+	default:
+		err = enroll(caURL, profile, cn, sans, token, caCert)
+	}
+	if err != nil {
+		fmt.Printf("登记失败: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// 生成私钥 (保存为待安装状态) 并返回 PEM 格式的 CSR
+func prepareEnrollment(profile, cn string, sans []string) ([]byte, error) {
+	if cn == "" {
+		return nil, errors.New("缺少 -cn")
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.CertificateRequest{Subject: pkix.Name{CommonName: cn}}
+	for _, san := range sans {
+		if ip := net.ParseIP(san); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, san)
+		}
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, tmpl, key)
+	if err != nil {
+		return nil, fmt.Errorf("生成 CSR 失败: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := identityDir(profile)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("创建身份目录失败: %w", err)
+	}
+	// 证书安装前不替换正在使用的私钥
+	pending := filepath.Join(dir, "client.key.pending")
+	if err := os.WriteFile(pending, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return nil, fmt.Errorf("保存私钥失败: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
+
+// 提交 CSR 并安装 CA 返回的证书
+func enroll(caURL, profile, cn string, sans []string, token, caCert string) error {
+	csr, err := prepareEnrollment(profile, cn, sans)
+	if err != nil {
+		return err
+	}
+	tlsCfg, err := tlsClientOptions{caCert: caCert}.config()
+	if err != nil {
+		return err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsCfg
+	client := &http.Client{Timeout: time.Minute, Transport: transport}
+
+	req, err := http.NewRequest("POST", caURL, bytes.NewReader(csr))
+	if err != nil {
+		return fmt.Errorf("CA 地址格式错误: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/pkcs10")
+	req.Header.Set("Accept", "application/x-pem-file")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	fmt.Printf("📨 提交 CSR: CN=%s → %s\n", cn, caURL)
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("提交 CSR 失败: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("CA 拒绝签发: %s", responseError(resp))
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("读取证书失败: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(identityDir(profile), "issued-*.pem")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return installIdentity(profile, tmp.Name())
+}
+
+// 校验证书与待安装的私钥匹配后，替换该 profile 的证书和私钥
+func installIdentity(profile, certPath string) error {
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		return fmt.Errorf("读取证书失败: %w", err)
+	}
+	var leaf *x509.Certificate
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" && leaf == nil {
+			if leaf, err = x509.ParseCertificate(block.Bytes); err != nil {
+				return fmt.Errorf("解析证书失败: %w", err)
+			}
+		}
+	}
+	if leaf == nil {
+		return errors.New("返回的内容中没有 PEM 格式的证书")
+	}
+
+	dir := identityDir(profile)
+	pending := filepath.Join(dir, "client.key.pending")
+	keyPEM, err := os.ReadFile(pending)
+	if err != nil {
+		return fmt.Errorf("没有待安装的私钥，请先执行 enroll -profile %s -print: %w", profile, err)
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return errors.New("待安装的私钥不是 PEM 格式")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("解析私钥失败: %w", err)
+	}
+	pub, ok := leaf.PublicKey.(*ecdsa.PublicKey)
+	if priv, isEC := key.(*ecdsa.PrivateKey); !ok || !isEC || !pub.Equal(&priv.PublicKey) {
+		return errors.New("证书与本机生成的私钥不匹配")
+	}
+
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	if err := os.WriteFile(certFile+".tmp", data, 0o644); err != nil {
+		return fmt.Errorf("保存证书失败: %w", err)
+	}
+	// 先换私钥再换证书：中途失败时旧证书与新私钥不匹配，加载时会报错而不是静默使用错误的身份
+	if err := os.Rename(pending, keyFile); err != nil {
+		return fmt.Errorf("安装私钥失败: %w", err)
+	}
+	if err := os.Rename(certFile+".tmp", certFile); err != nil {
+		return fmt.Errorf("安装证书失败: %w", err)
+	}
+
+	fmt.Printf("✅ 已安装客户端证书 (profile %s)\n", profile)
+	fmt.Printf("   主体: %s\n", leaf.Subject.CommonName)
+	fmt.Printf("   签发: %s\n", leaf.Issuer.CommonName)
+	fmt.Printf("   有效期至: %s\n", leaf.NotAfter.Local().Format(time.DateTime))
+	if names := append(leaf.DNSNames, ipStrings(leaf.IPAddresses)...); len(names) > 0 {
+		fmt.Printf("   SAN: %s\n", strings.Join(names, ", "))
+	}
+	fmt.Printf("   证书: %s\n   私钥: %s\n", certFile, keyFile)
+	return nil
+}
+
+func ipStrings(ips []net.IP) []string {
+	s := make([]string, len(ips))
+	for i, ip := range ips {
+		s[i] = ip.String()
+	}
+	return s
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 进度事件的默认间隔 (-progress-interval)，避免刷屏
+const progressEventInterval = time.Second
+
+// jobEvent 任务生命周期事件，每行一个 JSON 对象
+type jobEvent struct {
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event"` // queued / started / chunk-progress / chunk / retrying / done / failed / canceled
+	Job     string    `json:"job"`
+	Source  string    `json:"source,omitempty"`
+	Target  string    `json:"target,omitempty"`
+	Done    int64     `json:"done,omitempty"`
+	Total   int64     `json:"total,omitempty"`
+	Attempt int       `json:"attempt,omitempty"`
+	Delay   string    `json:"delay,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Chunk   int64     `json:"chunk,omitempty"` // chunk 事件中确认的块大小，Done 为块之后的位置
+
+	Retries map[string]int `json:"retries,omitempty"` // 任务结束时各层的重试次数
+}
+
+// eventLog 以 JSON Lines 输出事件，供 systemd/journald 或进程管理器采集；
+// 配置了 -log-sink 时同时发送到 syslog 或 Windows 事件日志
+type eventLog struct {
+	mu   sync.Mutex
+	enc  *json.Encoder // 未启用 -events json 时为 nil
+	sink logSink
+}
+
+func newEventLog(w io.Writer) *eventLog {
+	return &eventLog{enc: json.NewEncoder(w)}
+}
+
+// 输出一个事件；未启用事件日志 (nil) 时什么也不做
+func (l *eventLog) emit(ev jobEvent) {
+	if l == nil {
+		return
+	}
+	ev.Time = time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.enc != nil {
+		l.enc.Encode(ev)
+	}
+	// 进度事件过于频繁，不发送到集中日志
+	if l.sink != nil && ev.Event != "chunk-progress" && ev.Event != "chunk" {
+		sendLog(l.sink, ev.logEntry())
+	}
+}
+
+// 转换为结构化日志：失败为 error，重试为 warning，其余为 info
+func (ev *jobEvent) logEntry() *logEntry {
+	e := &logEntry{
+		Time:     ev.Time,
+		Severity: severityInfo,
+		Event:    ev.Event,
+		Message:  fmt.Sprintf("任务 %s %s: %s -> %s", ev.Job, ev.Event, ev.Source, ev.Target),
+		Fields:   map[string]string{"job": ev.Job, "source": ev.Source, "target": ev.Target},
+	}
+	switch ev.Event {
+	case jobFailed:
+		e.Severity = severityError
+	case "retrying":
+		e.Severity = severityWarning
+	}
+	if ev.Total > 0 {
+		e.Fields["done"] = strconv.FormatInt(ev.Done, 10)
+		e.Fields["total"] = strconv.FormatInt(ev.Total, 10)
+	}
+	if ev.Attempt > 0 {
+		e.Fields["attempt"] = strconv.Itoa(ev.Attempt)
+	}
+	if ev.Delay != "" {
+		e.Fields["delay"] = ev.Delay
+	}
+	for layer, n := range ev.Retries {
+		e.Fields["retries_"+layer] = strconv.Itoa(n)
+	}
+	if ev.Error != "" {
+		e.Fields["error"] = ev.Error
+		e.Message += ": " + ev.Error
+	}
+	return e
+}
+
+// 生成任务相关的事件
+func (j *daemonJob) event(name string) jobEvent {
+	return jobEvent{Event: name, Job: j.ID, Source: j.Job.Source, Target: j.Job.Target}
+}
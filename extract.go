@@ -0,0 +1,220 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// 将客户端指定的解包目标解析为 --extract-root 下的路径，拒绝绝对路径和跳出根目录的路径
+func (s *server) extractTarget(rel string) (string, error) {
+	if s.opts.extractRoot == "" {
+		return "", errors.New("服务端未启用 --extract-root")
+	}
+	clean := path.Clean(strings.ReplaceAll(rel, `\`, "/"))
+	if clean == "." || path.IsAbs(clean) || !localPath(clean) {
+		return "", fmt.Errorf("非法的解包目标: %s", rel)
+	}
+	return filepath.Join(s.opts.extractRoot, filepath.FromSlash(clean)), nil
+}
+
+// 将目录打包的归档解包到 dest：先解包到同级的临时目录，完成后与原目录交换，
+// 读取方要么看到完整的旧内容，要么看到完整的新内容。
+func (s *server) extractBundle(archive, dest string) error {
+	s.extractMu.Lock()
+	defer s.extractMu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return fmt.Errorf("创建解包目录失败: %w", err)
+	}
+	staging, err := os.MkdirTemp(filepath.Dir(dest), ".extract-*")
+	if err != nil {
+		return fmt.Errorf("创建临时目录失败: %w", err)
+	}
+	defer os.RemoveAll(staging)
+	os.Chmod(staging, 0o755)
+
+	if err := untarBundle(archive, staging); err != nil {
+		return err
+	}
+	return swapDir(staging, dest)
+}
+
+// 打开归档 (可为 gzip/zstd 压缩)，返回 tar 读取器和关闭函数
+func openBundle(archive string) (*tar.Reader, func(), error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, nil, err
+	}
+	br := bufio.NewReader(f)
+	var src io.Reader = br
+	dr, _, err := decompressReader(br)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if dr != nil {
+		src = dr
+	}
+	return tar.NewReader(src), func() {
+		if dr != nil {
+			dr.Close()
+		}
+		f.Close()
+	}, nil
+}
+
+// 解包归档，去掉打包时加在最前面的目录名。
+// 所有写入都经由 os.Root 进行，已解包的符号链接无法把后续条目引到 dir 之外
+func untarBundle(archive, dir string) error {
+	tr, closeBundle, err := openBundle(archive)
+	if err != nil {
+		return err
+	}
+	defer closeBundle()
+
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("读取归档失败: %w", err)
+		}
+
+		name, err := bundleEntryPath(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			continue
+		}
+		target := filepath.FromSlash(name)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := root.MkdirAll(target, hdr.FileInfo().Mode().Perm()|0o700); err != nil {
+				return fmt.Errorf("解包 %s 失败: %w", name, err)
+			}
+		case tar.TypeReg:
+			if err := root.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return fmt.Errorf("解包 %s 失败: %w", name, err)
+			}
+			out, err := root.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_EXCL, hdr.FileInfo().Mode().Perm())
+			if err != nil {
+				return fmt.Errorf("解包 %s 失败: %w", name, err)
+			}
+			_, err = io.Copy(out, tr)
+			if cerr := out.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return fmt.Errorf("解包 %s 失败: %w", name, err)
+			}
+		case tar.TypeSymlink:
+			// 链接目标必须留在解包目录内，否则可借此读写目录外的文件
+			if path.IsAbs(hdr.Linkname) || !localPath(path.Join(path.Dir(name), hdr.Linkname)) {
+				return fmt.Errorf("归档中的符号链接 %s -> %s 指向解包目录之外", name, hdr.Linkname)
+			}
+			if err := root.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return fmt.Errorf("解包 %s 失败: %w", name, err)
+			}
+			if err := root.Symlink(hdr.Linkname, target); err != nil {
+				return fmt.Errorf("解包 %s 失败: %w", name, err)
+			}
+		case tar.TypeLink:
+			link, err := bundleEntryPath(hdr.Linkname)
+			if err != nil || link == "" {
+				return fmt.Errorf("归档中的硬链接 %s -> %s 非法", name, hdr.Linkname)
+			}
+			if err := root.Link(filepath.FromSlash(link), target); err != nil {
+				return fmt.Errorf("解包 %s 失败: %w", name, err)
+			}
+		default:
+			// 设备文件等其他类型不解包
+		}
+	}
+}
+
+// 不解包，直接从归档中读出指定的普通文件 (按去掉第一级目录后的路径匹配)，每个文件最多读取 limit 字节
+func readBundleFiles(archive string, limit int64, names ...string) (map[string][]byte, error) {
+	tr, closeBundle, err := openBundle(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer closeBundle()
+
+	found := map[string][]byte{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return found, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("读取归档失败: %w", err)
+		}
+		name, err := bundleEntryPath(hdr.Name)
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg || !slices.Contains(names, name) {
+			continue
+		}
+		if hdr.Size > limit {
+			return nil, fmt.Errorf("归档中的 %s 过大", name)
+		}
+		if found[name], err = io.ReadAll(tr); err != nil {
+			return nil, fmt.Errorf("读取归档失败: %w", err)
+		}
+	}
+}
+
+// 校验归档内的路径并去掉第一级目录，返回相对路径 (为空表示顶层目录本身)
+func bundleEntryPath(name string) (string, error) {
+	name = strings.TrimPrefix(path.Clean(strings.ReplaceAll(name, `\`, "/")), "./")
+	if path.IsAbs(name) || !localPath(name) {
+		return "", fmt.Errorf("归档中包含非法路径: %s", name)
+	}
+	_, rest, _ := strings.Cut(name, "/")
+	return rest, nil
+}
+
+// 判断相对路径是否停留在当前目录内
+func localPath(p string) bool {
+	p = path.Clean(p)
+	return p != ".." && !strings.HasPrefix(p, "../")
+}
+
+// 用 staging 替换 dest：原目录先改名为备份，新目录就位后再删除备份，失败时还原
+func swapDir(staging, dest string) error {
+	backup := ""
+	if _, err := os.Lstat(dest); err == nil {
+		backup = fmt.Sprintf("%s.old-%s", dest, randomID())
+		if err := os.Rename(dest, backup); err != nil {
+			return fmt.Errorf("替换目录失败: %w", err)
+		}
+	}
+	if err := os.Rename(staging, dest); err != nil {
+		if backup != "" {
+			os.Rename(backup, dest)
+		}
+		return fmt.Errorf("替换目录失败: %w", err)
+	}
+	if backup != "" {
+		os.RemoveAll(backup)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// 无法通过分解去掉附加符号的常见拉丁字母
+var latinFolds = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'Æ': "AE", 'œ': "oe", 'Œ': "OE", 'ø': "o", 'Ø': "O",
+	'đ': "d", 'Đ': "D", 'ł': "l", 'Ł': "L", 'þ': "th", 'Þ': "TH", 'ð': "d", 'Ð': "D",
+}
+
+// 将文件名转换为纯 ASCII (-ascii-name，也用作 filename* 的退路):
+// 带附加符号的拉丁字母去掉符号 (é → e)，全角字符转为半角，
+// 其他字符 (如汉字) 写作 u+码位 (中 → u4E2D)，保证不同的名称转换后仍然不同
+func asciiName(name string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(name) {
+		switch {
+		case r < 0x20 || r == 0x7f:
+			b.WriteByte('_')
+		case r < 0x80:
+			b.WriteRune(r)
+		case unicode.Is(unicode.Mn, r):
+			// 分解出的附加符号
+		case r >= 0xFF01 && r <= 0xFF5E:
+			b.WriteRune(r - 0xFEE0)
+		case r == 0x3000:
+			b.WriteByte(' ')
+		default:
+			if s, ok := latinFolds[r]; ok {
+				b.WriteString(s)
+			} else {
+				fmt.Fprintf(&b, "u%04X", r)
+			}
+		}
+	}
+	return b.String()
+}
+
+// 是否只包含可打印的 ASCII 字符
+func isASCIIName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < 0x20 || name[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
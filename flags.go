@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// stringList 可重复指定的字符串参数，例如 -meta a=1 -meta b=2
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// 将 key=value 形式的列表转换为 map，后出现的同名键覆盖前者
+func labelMap(list []string) map[string]string {
+	m := map[string]string{}
+	for _, l := range list {
+		k, v, _ := strings.Cut(l, "=")
+		m[k] = v
+	}
+	return m
+}
+
+// byteSize 以字节为单位的大小参数，支持 K/M/G/T 后缀 (按 1024 进位)，例如 512M、1.5G
+type byteSize int64
+
+func (b *byteSize) String() string {
+	if *b == 0 {
+		return "0"
+	}
+	return formatBytes(int64(*b))
+}
+
+func (b *byteSize) Set(v string) error {
+	n, err := parseByteSize(v)
+	if err != nil {
+		return err
+	}
+	*b = byteSize(n)
+	return nil
+}
+
+// 解析带单位的大小，单位可写作 K、KB、KiB 等形式，大小写不敏感
+func parseByteSize(v string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(v))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	mult := int64(1)
+	if s != "" {
+		if i := strings.IndexByte("KMGTPE", s[len(s)-1]); i >= 0 {
+			mult = int64(1) << (10 * (i + 1))
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("非法的大小: %q", v)
+	}
+	return int64(n * float64(mult)), nil
+}
+
+// 参数对应的环境变量名：前缀 + 参数名大写 (- 换为 _)，例如 DOCKER_SAVE_SHELL_SERVE_STATE_STORE
+func flagEnvName(prefix, name string) string {
+	return prefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// 用环境变量补全未在命令行指定的参数，命令行优先
+func applyEnvFlags(fs *flag.FlagSet, prefix string) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || err != nil {
+			return
+		}
+		env := flagEnvName(prefix, f.Name)
+		if v, ok := os.LookupEnv(env); ok {
+			if e := fs.Set(f.Name, v); e != nil {
+				err = fmt.Errorf("环境变量 %s 的值无效: %w", env, e)
+			}
+		}
+	})
+	return err
+}
+
+// 参数是否在命令行上指定过
+func flagSet(fs *flag.FlagSet, name string) bool {
+	found := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"cmp"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// formOptions multipart 表单的格式细节，用于兼容对边界字符、头部写法较挑剔的 WAF 和老旧服务端
+type formOptions struct {
+	boundary         string // 空: 默认随机边界; webkit: 浏览器风格; 其他: 固定边界字符串
+	headerCase       string // canonical (Content-Disposition) / lower (content-disposition)
+	headerOrder      string // disposition-first / type-first
+	filenameEncoding string // auto / legacy / rfc5987 / html5
+
+	// 文件分段的字段名 (默认 file) 和文件之前附加的字段 key=value，用于匹配其他接收端的表单约定
+	fieldName string
+	fields    stringList
+}
+
+// 本工具的服务端按字段名解释的表单字段，由对应的参数生成，不能用 -form 指定
+var reservedFormFields = []string{
+	"sha256", "inner_sha256", "tree_sha256", "if_exists", "name",
+	"artifact_name", "artifact_version", "labels", "bundle", "extract_to", "docker_load", "docker_tag", "smoke",
+}
+
+// 是否指定了 -form 或非默认的 -field-name；只有以 multipart 表单上传时才有意义
+func (o formOptions) custom() bool {
+	return len(o.fields) > 0 || o.fieldName != "" && o.fieldName != "file"
+}
+
+// 注册表单格式相关的参数
+func registerFormFlags(fs *flag.FlagSet, o *formOptions) {
+	fs.StringVar(&o.boundary, "form-boundary", "", "multipart 边界: 默认随机十六进制，webkit 为浏览器风格 (----WebKitFormBoundary...)，其他值作为固定边界")
+	fs.StringVar(&o.headerCase, "form-header-case", "canonical", "分段头部的大小写: canonical 或 lower")
+	fs.StringVar(&o.headerOrder, "form-header-order", "disposition-first", "分段头部的顺序: disposition-first 或 type-first")
+	fs.StringVar(&o.fieldName, "field-name", "file", "文件分段的表单字段名，接收端不是本工具的服务端时按其约定设置 (如 package)；本工具的服务端接受任意字段名的文件分段")
+	fs.Var(&o.fields, "form", "附加的表单字段 key=value，写在文件之前，可重复指定 (如 -form project=foo -form version=1.2)")
+	fs.StringVar(&o.filenameEncoding, "filename-encoding", "auto", "Content-Disposition 中文件名的编码: auto (非 ASCII 文件名使用 rfc5987)、legacy (原样 UTF-8，反斜杠转义)、rfc5987 (ASCII 的 filename 加 filename*=UTF-8'')、html5 (浏览器风格的百分号转义)")
+}
+
+// 检查参数取值
+func (o formOptions) validate() error {
+	switch o.headerCase {
+	case "", "canonical", "lower":
+	default:
+		return fmt.Errorf("不支持的 -form-header-case: %s", o.headerCase)
+	}
+	switch o.headerOrder {
+	case "", "disposition-first", "type-first":
+	default:
+		return fmt.Errorf("不支持的 -form-header-order: %s", o.headerOrder)
+	}
+	switch o.filenameEncoding {
+	case "", "auto", "legacy", "rfc5987", "html5":
+	default:
+		return fmt.Errorf("不支持的 -filename-encoding: %s", o.filenameEncoding)
+	}
+	if o.fieldName != "" && strings.ContainsAny(o.fieldName, "\r\n") {
+		return errors.New("-field-name 不能包含换行")
+	}
+	if slices.Contains(reservedFormFields, o.fieldName) {
+		return fmt.Errorf("-field-name 不能使用保留字段 %s", o.fieldName)
+	}
+	for _, f := range o.fields {
+		k, _, ok := strings.Cut(f, "=")
+		if !ok || k == "" || strings.ContainsAny(k, "\r\n") {
+			return fmt.Errorf("非法的表单字段 %q，格式应为 key=value", f)
+		}
+		if slices.Contains(reservedFormFields, k) {
+			return fmt.Errorf("-form 不能指定保留字段 %s (由对应的参数生成)", k)
+		}
+	}
+	if o.boundary != "" && o.boundary != "webkit" {
+		return validBoundary(o.boundary)
+	}
+	return nil
+}
+
+// RFC 2046: 1-70 个 bchars，且不能以空格结尾
+func validBoundary(b string) error {
+	if len(b) < 1 || len(b) > 70 || strings.HasSuffix(b, " ") {
+		return errors.New("-form-boundary 长度应为 1-70 个字符且不能以空格结尾")
+	}
+	for _, r := range b {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("'()+_,-./:=? ", r)) {
+			return fmt.Errorf("-form-boundary 包含非法字符 %q", r)
+		}
+	}
+	return nil
+}
+
+// formWriter 按 formOptions 写出 multipart/form-data，方法与 multipart.Writer 一致
+type formWriter struct {
+	w        io.Writer
+	opts     formOptions
+	boundary string
+	started  bool
+}
+
+func (o formOptions) newWriter(w io.Writer) *formWriter {
+	boundary := o.boundary
+	switch boundary {
+	case "":
+		boundary = randomBoundary(hexAlphabet, 60)
+	case "webkit":
+		boundary = "----WebKitFormBoundary" + randomBoundary(alnumAlphabet, 16)
+	}
+	return &formWriter{w: w, opts: o, boundary: boundary}
+}
+
+const (
+	hexAlphabet   = "0123456789abcdef"
+	alnumAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+func randomBoundary(alphabet string, n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	for i := range b {
+		b[i] = alphabet[int(b[i])%len(alphabet)]
+	}
+	return string(b)
+}
+
+// 请求的 Content-Type (边界含特殊字符时加引号)
+func (f *formWriter) FormDataContentType() string {
+	b := f.boundary
+	if strings.ContainsAny(b, "()<>@,;:\\\"/[]?= ") {
+		b = `"` + b + `"`
+	}
+	return "multipart/form-data; boundary=" + b
+}
+
+// 写出分段的边界和头部，返回写入分段内容的 Writer
+func (f *formWriter) createPart(disposition, contentType string) (io.Writer, error) {
+	var b strings.Builder
+	if f.started {
+		b.WriteString("\r\n")
+	}
+	f.started = true
+	fmt.Fprintf(&b, "--%s\r\n", f.boundary)
+
+	dispKey, typeKey := "Content-Disposition", "Content-Type"
+	if f.opts.headerCase == "lower" {
+		dispKey, typeKey = "content-disposition", "content-type"
+	}
+	headers := []string{dispKey + ": " + disposition}
+	if contentType != "" {
+		if f.opts.headerOrder == "type-first" {
+			headers = append([]string{typeKey + ": " + contentType}, headers...)
+		} else {
+			headers = append(headers, typeKey+": "+contentType)
+		}
+	}
+	for _, h := range headers {
+		b.WriteString(h + "\r\n")
+	}
+	b.WriteString("\r\n")
+	if _, err := io.WriteString(f.w, b.String()); err != nil {
+		return nil, err
+	}
+	return f.w, nil
+}
+
+// 创建文件分段，contentType 为空时使用 application/octet-stream
+func (f *formWriter) CreateFormFile(field, filename, contentType string) (io.Writer, error) {
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	disposition := fmt.Sprintf(`form-data; name="%s"; %s`, escapeQuotes(field), f.opts.filenameParam(filename))
+	return f.createPart(disposition, contentType)
+}
+
+// 写出 -form 附加的字段，再创建 -field-name 指定的文件分段。
+// 附加字段在文件之前，边读取边解析表单的接收端在收到文件时即可得到项目、版本等信息
+func (f *formWriter) CreateUploadFile(filename, contentType string) (io.Writer, error) {
+	for _, kv := range f.opts.fields {
+		k, v, _ := strings.Cut(kv, "=")
+		if err := f.WriteField(k, v); err != nil {
+			return nil, err
+		}
+	}
+	return f.CreateFormFile(cmp.Or(f.opts.fieldName, "file"), filename, contentType)
+}
+
+// 写入普通字段
+func (f *formWriter) WriteField(name, value string) error {
+	w, err := f.createPart(fmt.Sprintf(`form-data; name="%s"`, escapeQuotes(name)), "")
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, value)
+	return err
+}
+
+// 写出结束边界
+func (f *formWriter) Close() error {
+	prefix := ""
+	if f.started {
+		prefix = "\r\n"
+	}
+	_, err := fmt.Fprintf(f.w, "%s--%s--\r\n", prefix, f.boundary)
+	return err
+}
+
+// Content-Disposition 中的文件名参数
+// 只写原样 UTF-8 的 filename 时，部分接收端按 Latin-1 等编码解析导致中文文件名乱码，
+// 因此默认 (auto) 对非 ASCII 文件名同时写出 filename* (RFC 5987/6266)
+func (o formOptions) filenameParam(name string) string {
+	encoding := o.filenameEncoding
+	if encoding == "" || encoding == "auto" {
+		encoding = "legacy"
+		if !isASCIIName(name) {
+			encoding = "rfc5987"
+		}
+	}
+	switch encoding {
+	case "rfc5987":
+		// 不支持 filename* 的接收端退回使用 ASCII 的 filename
+		return fmt.Sprintf(`filename="%s"; filename*=UTF-8''%s`, escapeQuotes(asciiName(name)), rfc5987Escape(name))
+	case "html5":
+		return fmt.Sprintf(`filename="%s"`, html5Escaper.Replace(name))
+	}
+	return fmt.Sprintf(`filename="%s"`, escapeQuotes(name))
+}
+
+// 与 mime/multipart 相同的引号转义
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
+
+// WHATWG HTML 表单提交对文件名的转义
+var html5Escaper = strings.NewReplacer("\n", "%0A", "\r", "%0D", `"`, "%22")
+
+// RFC 5987 的 ext-value：attr-char 以外的字节按 UTF-8 百分号编码
+func rfc5987Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
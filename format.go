@@ -0,0 +1,299 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// -format 可选的归档格式及对应的流水线阶段。源数据总是 tar 流 (镜像为 docker save 的输出，目录打包为 tar)，
+// 在流水线中转换为接收方需要的格式
+var archiveFormats = map[string]string{
+	"tar":      "",
+	"tar.gz":   "gzip",
+	"tar.zst":  "zstd",
+	"oci":      "oci",
+	"squashfs": "squashfs",
+}
+
+// 在 spec 的读取阶段之后插入 -format 对应的阶段。tar.gz / tar.zst 即固定的压缩，不能再与 -compress 同时指定
+func formatPipeline(spec, format, compress string) (string, error) {
+	if format == "" || format == "tar" {
+		return spec, nil
+	}
+	st, ok := archiveFormats[format]
+	if !ok {
+		return "", fmt.Errorf("不支持的格式: %s (可选 tar、tar.gz、tar.zst、oci、squashfs)", format)
+	}
+	if formatCompressed(format) && compress != "" && compress != "none" {
+		return "", fmt.Errorf("-format %s 已经包含压缩，不能再指定 -compress", format)
+	}
+	if format == "squashfs" {
+		if _, err := exec.LookPath("sqfstar"); err != nil {
+			return "", errors.New("-format squashfs 需要 sqfstar (squashfs-tools 4.6 及以上)")
+		}
+	}
+	if spec == "" {
+		spec = defaultPipeline
+	}
+	rest, _ := strings.CutPrefix(spec, "read,")
+	return "read," + st + "," + rest, nil
+}
+
+// -format 是否为压缩的 tar
+func formatCompressed(format string) bool {
+	return stageRegistry[archiveFormats[format]].compressor
+}
+
+// 格式转换阶段给文件名换上的扩展名：替换结尾的 .tar，没有时追加
+func formatFileName(name, ext string) string {
+	return strings.TrimSuffix(name, ".tar") + ext
+}
+
+// oci 阶段：将 docker save 的输出转换为 OCI 镜像布局 (oci-layout、index.json、blobs/sha256/) 的 tar。
+// 新版 docker 导出的归档已是 OCI 布局，按原样输出；旧格式的层 (<id>/layer.tar) 在写出前需要知道摘要，
+// 逐个缓存到临时文件，内存占用与镜像大小无关
+func ociStage(r io.Reader) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(convertToOCI(r, pw))
+	}()
+	return pr
+}
+
+// OCI 布局中的内容描述
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+func convertToOCI(r io.Reader, w io.Writer) error {
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+	blobs := map[string]ociDescriptor{} // 归档内路径 → 写出的 blob
+	written := map[string]bool{}        // 已写出的 blob 摘要
+	links := map[string]string{}        // 旧格式中指向其他层的符号链接
+	var manifest []archiveManifestEntry
+	var index []byte
+
+	writeBlob := func(name string, size int64, digest string, body io.Reader) error {
+		blobs[name] = ociDescriptor{Digest: "sha256:" + digest, Size: size}
+		if written[digest] {
+			return nil
+		}
+		written[digest] = true
+		if err := tw.WriteHeader(&tar.Header{Name: "blobs/sha256/" + digest, Mode: 0o644, Size: size, Format: tar.FormatPAX}); err != nil {
+			return err
+		}
+		_, err := io.Copy(tw, body)
+		return err
+	}
+	writeJSONFile := func(name string, v any) (ociDescriptor, error) {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return ociDescriptor{}, err
+		}
+		sum := sha256.Sum256(data)
+		digest := hex.EncodeToString(sum[:])
+		if name == "" {
+			err = writeBlob(digest, int64(len(data)), digest, bytes.NewReader(data))
+		} else {
+			err = writeRaw(tw, name, data)
+		}
+		return ociDescriptor{Digest: "sha256:" + digest, Size: int64(len(data))}, err
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("读取镜像归档失败: %w", err)
+		}
+		name := strings.TrimPrefix(hdr.Name, "./")
+		switch {
+		case name == "manifest.json":
+			if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+				return fmt.Errorf("解析 manifest.json 失败: %w", err)
+			}
+		case name == "index.json":
+			if index, err = io.ReadAll(tr); err != nil {
+				return err
+			}
+		case name == "oci-layout", name == "repositories":
+			// 最后统一写出
+		case hdr.Typeflag == tar.TypeSymlink:
+			links[name] = path.Join(path.Dir(name), hdr.Linkname)
+		case hdr.Typeflag != tar.TypeReg:
+		case strings.HasPrefix(name, "blobs/sha256/"):
+			// 已按内容寻址，原样写出
+			if err := writeBlob(name, hdr.Size, strings.TrimPrefix(name, "blobs/sha256/"), tr); err != nil {
+				return err
+			}
+		case path.Base(name) == "json" || path.Base(name) == "VERSION":
+			// 旧格式各层的元数据，OCI 布局中不需要
+		default:
+			if err := spoolBlob(tr, hdr.Size, func(digest string, body io.Reader) error {
+				return writeBlob(name, hdr.Size, digest, body)
+			}); err != nil {
+				return fmt.Errorf("转换 %s 失败: %w", name, err)
+			}
+		}
+	}
+	if manifest == nil {
+		return errors.New("-format oci 只能用于镜像 (docker save 的输出)")
+	}
+
+	lookup := func(name string) (ociDescriptor, error) {
+		for range 8 {
+			target, ok := links[name]
+			if !ok {
+				break
+			}
+			name = target
+		}
+		d, ok := blobs[name]
+		if !ok {
+			return d, fmt.Errorf("镜像归档中缺少 %s", name)
+		}
+		return d, nil
+	}
+
+	if index == nil {
+		var items []ociDescriptor
+		for i, m := range manifest {
+			config, err := lookup(m.Config)
+			if err != nil {
+				return err
+			}
+			config.MediaType = "application/vnd.oci.image.config.v1+json"
+			image := struct {
+				SchemaVersion int             `json:"schemaVersion"`
+				MediaType     string          `json:"mediaType"`
+				Config        ociDescriptor   `json:"config"`
+				Layers        []ociDescriptor `json:"layers"`
+			}{SchemaVersion: 2, MediaType: "application/vnd.oci.image.manifest.v1+json", Config: config, Layers: []ociDescriptor{}}
+			manifest[i].Config = "blobs/sha256/" + strings.TrimPrefix(config.Digest, "sha256:")
+			for j, l := range m.Layers {
+				layer, err := lookup(l)
+				if err != nil {
+					return err
+				}
+				layer.MediaType = "application/vnd.oci.image.layer.v1.tar"
+				image.Layers = append(image.Layers, layer)
+				manifest[i].Layers[j] = "blobs/sha256/" + strings.TrimPrefix(layer.Digest, "sha256:")
+			}
+			desc, err := writeJSONFile("", image)
+			if err != nil {
+				return err
+			}
+			desc.MediaType = image.MediaType
+			// 每个标签一项，没有标签的镜像也要列出
+			tags := m.RepoTags
+			if len(tags) == 0 {
+				tags = []string{""}
+			}
+			for _, tag := range tags {
+				item := desc
+				if tag != "" {
+					ref := tag
+					if i := strings.LastIndexByte(tag, ':'); i > strings.LastIndexByte(tag, '/') {
+						ref = tag[i+1:]
+					}
+					item.Annotations = map[string]string{"io.containerd.image.name": tag, "org.opencontainers.image.ref.name": ref}
+				}
+				items = append(items, item)
+			}
+		}
+		if _, err := writeJSONFile("index.json", map[string]any{"schemaVersion": 2, "mediaType": "application/vnd.oci.image.index.v1+json", "manifests": items}); err != nil {
+			return err
+		}
+	} else if err := writeRaw(tw, "index.json", index); err != nil {
+		return err
+	}
+	// 保留 manifest.json (已指向 blobs/)，转换后的归档仍可直接 docker load
+	if _, err := writeJSONFile("manifest.json", manifest); err != nil {
+		return err
+	}
+	if _, err := writeJSONFile("oci-layout", map[string]string{"imageLayoutVersion": "1.0.0"}); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func writeRaw(tw *tar.Writer, name string, data []byte) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), Format: tar.FormatPAX}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// 将 size 字节缓存到临时文件并计算摘要，再交给 write 从头读取
+func spoolBlob(r io.Reader, size int64, write func(digest string, body io.Reader) error) error {
+	tmp, err := os.CreateTemp("", "dss-blob-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(r, size)); err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return write(hex.EncodeToString(h.Sum(nil)), tmp)
+}
+
+// squashfs 阶段：由 sqfstar 将 tar 流中的条目生成 squashfs 文件系统镜像。
+// squashfs 需要回写超级块，只能先生成到临时文件，完成后再发送
+func squashfsStage(r io.Reader) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(buildSquashfs(r, pw))
+	}()
+	return pr
+}
+
+func buildSquashfs(r io.Reader, w io.Writer) error {
+	dir, err := os.MkdirTemp("", "dss-squashfs-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	image := filepath.Join(dir, "image.sqsh")
+
+	cmd := exec.Command("sqfstar", "-quiet", "-no-progress", image)
+	cmd.Stdin = r
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return fmt.Errorf("sqfstar 失败: %s", msg)
+	}
+	f, err := os.Open(image)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
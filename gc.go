@@ -0,0 +1,221 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gcItem 一项可回收的数据
+type gcItem struct {
+	Path string `json:"path"`
+	Kind string `json:"kind"` // upload (中断的上传) / tmp (残留的临时文件) / index (失效的内容索引) / artifact (过期的制品版本)
+	Size int64  `json:"size"`
+
+	key string // 对应的状态存储键，删除数据前先从索引中移除
+}
+
+// gcReport 一次垃圾回收的结果
+type gcReport struct {
+	Started   time.Time `json:"started"`
+	Duration  string    `json:"duration"`
+	DryRun    bool      `json:"dry_run"`
+	Items     []gcItem  `json:"items"`
+	Reclaimed int64     `json:"reclaimed_bytes"`
+	Errors    []string  `json:"errors,omitempty"`
+}
+
+// gcMetrics 服务启动以来的回收统计，通过 GET /gc 查询
+type gcMetrics struct {
+	mu             sync.Mutex
+	Runs           int       `json:"runs"`
+	RemovedItems   int       `json:"removed_items"`
+	ReclaimedBytes int64     `json:"reclaimed_bytes"`
+	Last           *gcReport `json:"last,omitempty"`
+}
+
+// 扫描并回收存储目录中的无用数据；dryRun 时只报告不删除
+func (s *server) collectGarbage(dryRun bool) *gcReport {
+	report := &gcReport{Started: time.Now(), DryRun: dryRun, Items: []gcItem{}}
+	defer func() { report.Duration = time.Since(report.Started).Round(time.Millisecond).String() }()
+
+	var items []gcItem
+	cutoff := report.Started.Add(-s.opts.gcUploadTTL)
+
+	// 中断的上传：接收中的临时文件会持续更新修改时间，超过期限未更新视为已放弃
+	entries, _ := os.ReadDir(s.opts.dir)
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && strings.HasPrefix(e.Name(), ".upload-") && info.ModTime().Before(cutoff) {
+			items = append(items, gcItem{Path: filepath.Join(s.opts.dir, e.Name()), Kind: "upload", Size: info.Size()})
+		}
+	}
+
+	// 元数据目录和制品目录中原子写入残留的 .tmp 文件，以及对应文件已删除的内容索引
+	for _, root := range []string{serverMetaDir(s.opts.dir), s.artifactRoot()} {
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			if strings.HasSuffix(path, ".tmp") && info.ModTime().Before(cutoff) {
+				items = append(items, gcItem{Path: path, Kind: "tmp", Size: info.Size()})
+			}
+			return nil
+		})
+	}
+	indexes, _ := os.ReadDir(filepath.Join(serverMetaDir(s.opts.dir), "contents"))
+	for _, e := range indexes {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(s.opts.dir, name)); os.IsNotExist(err) {
+			info, _ := e.Info()
+			items = append(items, gcItem{Path: filepath.Join(serverMetaDir(s.opts.dir), "contents", e.Name()), Kind: "index", Size: info.Size()})
+		}
+	}
+
+	// 过期的制品版本：latest 指向的版本永远保留
+	if s.opts.artifactTTL > 0 {
+		artifacts, err := s.allArtifacts()
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+		}
+		for _, meta := range artifacts {
+			if meta.Latest || report.Started.Sub(meta.Created) < s.opts.artifactTTL {
+				continue
+			}
+			dir := filepath.Join(s.artifactRoot(), meta.Name, meta.Version)
+			items = append(items, gcItem{Path: dir, Kind: "artifact", Size: dirSize(dir), key: artifactMetaKey(meta.Name, meta.Version)})
+		}
+	}
+
+	for _, item := range items {
+		if !dryRun {
+			if item.key != "" {
+				if err := s.store.Delete(item.key); err != nil {
+					report.Errors = append(report.Errors, err.Error())
+					continue
+				}
+			}
+			if err := os.RemoveAll(item.Path); err != nil {
+				report.Errors = append(report.Errors, err.Error())
+				continue
+			}
+		}
+		report.Items = append(report.Items, item)
+		report.Reclaimed += item.Size
+	}
+
+	if !dryRun {
+		s.gc.mu.Lock()
+		s.gc.Runs++
+		s.gc.RemovedItems += len(report.Items)
+		s.gc.ReclaimedBytes += report.Reclaimed
+		s.gc.Last = report
+		s.gc.mu.Unlock()
+	}
+	return report
+}
+
+// 计算目录下所有文件的总大小
+func dirSize(dir string) int64 {
+	var total int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
+
+// 按固定间隔在后台执行回收
+func (s *server) gcLoop() {
+	ticker := time.NewTicker(s.opts.gcInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		printGCReport(s.collectGarbage(s.opts.gcDryRun))
+	}
+}
+
+// 输出回收结果
+func printGCReport(report *gcReport) {
+	if len(report.Items) == 0 && len(report.Errors) == 0 {
+		return
+	}
+	action := "已回收"
+	if report.DryRun {
+		action = "可回收"
+	}
+	fmt.Printf("🧹 垃圾回收: %s %d 项，共 %s\n", action, len(report.Items), formatBytes(report.Reclaimed))
+	for _, item := range report.Items {
+		fmt.Printf("   [%s] %s (%s)\n", item.Kind, item.Path, formatBytes(item.Size))
+	}
+	for _, e := range report.Errors {
+		fmt.Printf("   ⚠️  %s\n", e)
+	}
+}
+
+// 查询回收统计
+func (s *server) handleGCStats(w http.ResponseWriter, r *http.Request) {
+	s.gc.mu.Lock()
+	defer s.gc.mu.Unlock()
+	writeJSON(w, http.StatusOK, &s.gc)
+}
+
+// 立即执行一次回收，?dry_run=true 时只返回可回收的内容
+func (s *server) handleGC(w http.ResponseWriter, r *http.Request) {
+	report := s.collectGarbage(r.URL.Query().Get("dry_run") == "true")
+	printGCReport(report)
+	writeJSON(w, http.StatusOK, report)
+}
+
+// serve gc 子命令：不启动服务，直接对存储目录执行一次回收
+func runGC(args []string) {
+	var opts serveOptions
+	var dryRun bool
+	fs := flag.NewFlagSet("serve gc", flag.ExitOnError)
+	fs.StringVar(&opts.dir, "dir", "./data", "存储目录")
+	fs.BoolVar(&dryRun, "dry-run", false, "只报告可回收的内容，不删除")
+	fs.StringVar(&opts.stateStore, "state-store", "file", "制品索引等共享状态的存储: file 或 redis://host:6379/0")
+	registerGCFlags(fs, &opts)
+	fs.Parse(args)
+	if err := applyEnvFlags(fs, serveEnvPrefix); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	store, err := openStateStore(opts.stateStore, opts.dir)
+	if err != nil {
+		fmt.Printf("打开状态存储失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	s := &server{opts: opts, store: store}
+	report := s.collectGarbage(dryRun)
+	if len(report.Items) == 0 {
+		fmt.Println("✅ 没有需要回收的数据")
+	}
+	printGCReport(report)
+	if len(report.Errors) > 0 {
+		os.Exit(1)
+	}
+}
+
+// 注册回收期限相关的参数，serve 和 serve gc 共用
+func registerGCFlags(fs *flag.FlagSet, opts *serveOptions) {
+	fs.DurationVar(&opts.gcUploadTTL, "upload-ttl", 24*time.Hour, "中断的上传和临时文件超过该时长未更新即被回收")
+	fs.DurationVar(&opts.artifactTTL, "artifact-ttl", 0, "非 latest 的制品版本超过该时长即被回收 (0 表示永久保留)")
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// githubRelease Release API 返回中用到的字段
+type githubRelease struct {
+	ID        int64  `json:"id"`
+	HTMLURL   string `json:"html_url"`
+	UploadURL string `json:"upload_url"` // 形如 https://uploads.github.com/repos/o/r/releases/1/assets{?name,label}
+}
+
+// 由仓库地址得到仓库的 API 地址:
+// https://github.com/o/r → https://api.github.com/repos/o/r，
+// GitHub Enterprise https://ghe/o/r → https://ghe/api/v3/repos/o/r，已是 API 地址时原样使用
+func githubRepoAPI(base *url.URL, name string, opts options) (*url.URL, error) {
+	if opts.artifactVersion == "" {
+		return nil, errors.New("github-release 需要通过 -version 指定 Release 的标签")
+	}
+	path := strings.Trim(strings.TrimSuffix(base.Path, ".git"), "/")
+	if strings.HasPrefix(path, "repos/") || strings.Contains(path, "api/v3/repos/") {
+		return base, nil
+	}
+	owner, repo, ok := strings.Cut(path, "/")
+	if !ok || owner == "" || repo == "" || strings.Contains(repo, "/") {
+		return nil, errors.New("github-release 的 -url 应为仓库地址，如 https://github.com/owner/repo")
+	}
+	api := &url.URL{Scheme: base.Scheme, Host: base.Host}
+	if base.Host == "github.com" {
+		api.Host = "api.github.com"
+		return api.JoinPath("repos", owner, repo), nil
+	}
+	return api.JoinPath("api", "v3", "repos", owner, repo), nil
+}
+
+// GitHub 访问令牌 (需要仓库的 contents 写权限)，在 Actions 中可直接使用 GITHUB_TOKEN
+func githubAuth(req *http.Request) error {
+	token := os.Getenv(presetTokenEnv)
+	if token == "" {
+		token = os.Getenv("GITHUB_TOKEN")
+	}
+	if token == "" {
+		return fmt.Errorf("缺少凭据，请设置 %s 或 GITHUB_TOKEN", presetTokenEnv)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	return nil
+}
+
+// 按标签查找 Release (不存在时创建)，将请求改为上传到该 Release 的附件地址
+func githubReleaseAsset(ctx context.Context, client *http.Client, req *http.Request, name string, opts options) error {
+	repo := req.URL
+	release, err := githubAPI(ctx, client, req.Header, "GET", repo.JoinPath("releases", "tags", opts.artifactVersion), nil)
+	if errors.Is(err, errGitHubNotFound) {
+		release, err = githubAPI(ctx, client, req.Header, "POST", repo.JoinPath("releases"),
+			map[string]any{"tag_name": opts.artifactVersion, "name": opts.artifactVersion})
+		if err == nil {
+			fmt.Printf("🏷️  已创建 Release: %s\n", release.HTMLURL)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("获取 Release %s 失败: %w", opts.artifactVersion, err)
+	}
+
+	upload, _, _ := strings.Cut(release.UploadURL, "{")
+	target, err := url.Parse(upload)
+	if err != nil || upload == "" {
+		return fmt.Errorf("Release 的附件上传地址无效: %q", release.UploadURL)
+	}
+	target.RawQuery = url.Values{"name": {name}}.Encode()
+	req.URL, req.Host = target, ""
+	return nil
+}
+
+var errGitHubNotFound = errors.New("not found")
+
+// 调用 Release API
+func githubAPI(ctx context.Context, client *http.Client, header http.Header, method string, u *url.URL, payload any) (*githubRelease, error) {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range []string{"Authorization", "Accept", "X-GitHub-Api-Version"} {
+		req.Header.Set(k, header.Get(k))
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound && method == "GET":
+		return nil, errGitHubNotFound
+	case resp.StatusCode/100 != 2:
+		var e struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal(data, &e)
+		return nil, fmt.Errorf("GitHub 返回 %s: %s", resp.Status, e.Message)
+	}
+	var release githubRelease
+	if err := json.Unmarshal(data, &release); err != nil {
+		return nil, fmt.Errorf("解析 GitHub 响应失败: %w", err)
+	}
+	return &release, nil
+}
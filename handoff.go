@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// 传输窗口 (--max-duration) 到期时返回的错误
+var errWindowExpired = errors.New("传输窗口已到期")
+
+// 传输窗口到期时的退出码 (EX_TEMPFAIL)，便于调度脚本区分“稍后重试”和真正的失败
+const exitWindowExpired = 75
+
+// 上传被 Ctrl-C 或 SIGTERM 中断时返回的错误
+var errInterrupted = errors.New("上传已被中断")
+
+// 被中断时的退出码 (128 + SIGINT)
+const exitInterrupted = 130
+
+// 收到 Ctrl-C 或 SIGTERM 时以 errInterrupted 取消的 context，上传据此中止并通知服务端清理未完成的上传；
+// 再次中断时按默认方式立即退出
+func interruptContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			signal.Stop(signals)
+			fmt.Printf("\n⛔ 收到 %v，正在中止上传...\n", sig)
+			cancel(errInterrupted)
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel(nil)
+	}
+}
+
+// 根据 --max-duration 创建带截止时间的 context，maxDuration 为 0 表示不限制
+func transferContext(parent context.Context, maxDuration time.Duration) (context.Context, context.CancelFunc) {
+	if maxDuration <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeoutCause(parent, maxDuration, errWindowExpired)
+}
+
+// 将 context 到期转换为 errWindowExpired，因违反 SLA 中止时返回 *slaViolation，其他错误原样返回
+func windowError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	cause := context.Cause(ctx)
+	if errors.Is(cause, errWindowExpired) {
+		return errWindowExpired
+	}
+	if errors.Is(cause, errInterrupted) {
+		return errInterrupted
+	}
+	var sla *slaViolation
+	if errors.As(cause, &sla) {
+		return sla
+	}
+	return err
+}
+
+// 打印交接信息：窗口到期原因以及稍后继续传输的完整命令；
+// 只有 -tus 和 -parallel-chunks 记录了续传状态，其他方式再次执行时从头上传
+func printHandoff(maxDuration time.Duration, resumable bool) {
+	fmt.Printf("\n⏰ 已达到最大传输时长 %s，传输已安全中止\n", maxDuration)
+	if resumable {
+		fmt.Println("▶️  稍后执行以下命令从中断处继续传输:")
+	} else {
+		fmt.Println("▶️  当前上传方式不支持续传，稍后执行以下命令将从头重新上传 (大文件可改用 -tus 或 -parallel-chunks):")
+	}
+	fmt.Printf("   %s\n", resumeCommand())
+}
+
+// 还原当前进程的完整命令行，参数按 shell 规则加引号
+func resumeCommand() string {
+	words := make([]string, len(os.Args))
+	for i, arg := range os.Args {
+		words[i] = shellQuote(arg)
+	}
+	return strings.Join(words, " ")
+}
+
+// 对包含特殊字符的参数加单引号
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	if !strings.ContainsAny(s, " \t\n'\"\\$`!*?[]{}()<>|&;#~") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// contextReader 在 context 结束后停止读取，使本地读取阶段也能响应传输窗口
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
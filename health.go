@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// 单项就绪检查的超时时间
+const readyCheckTimeout = 5 * time.Second
+
+// checkResult 一项依赖检查的结果
+type checkResult struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// readinessCheck 就绪检查项，返回的字符串作为说明
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) (string, error)
+}
+
+// 存活检查：进程能处理请求即可
+func (s *server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// 就绪检查：依次检查存储目录可写、剩余空间以及启用的外部依赖，任一失败返回 503
+func (s *server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ready := true
+	results := []checkResult{}
+	for _, c := range s.readinessChecks() {
+		ctx, cancel := context.WithTimeout(r.Context(), readyCheckTimeout)
+		detail, err := c.check(ctx)
+		cancel()
+
+		res := checkResult{Name: c.name, OK: err == nil, Detail: detail}
+		if err != nil {
+			res.Detail = err.Error()
+			ready = false
+		}
+		results = append(results, res)
+	}
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not ready", http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]any{"status": status, "checks": results})
+}
+
+// 根据服务端配置列出需要执行的检查
+func (s *server) readinessChecks() []readinessCheck {
+	checks := []readinessCheck{
+		{"storage", s.checkStorage},
+	}
+	if s.opts.minFreeSpace > 0 {
+		checks = append(checks, readinessCheck{"free_space", s.checkFreeSpace})
+	}
+	if rs, ok := s.store.(*redisStore); ok {
+		checks = append(checks, readinessCheck{"redis", func(ctx context.Context) (string, error) {
+			return "", rs.ping()
+		}})
+	}
+	if s.opts.allowLoad {
+		checks = append(checks, readinessCheck{"docker", dockerPing})
+	}
+	return checks
+}
+
+// 在存储目录创建并删除一个临时文件，确认可写
+func (s *server) checkStorage(ctx context.Context) (string, error) {
+	f, err := os.CreateTemp(s.opts.dir, ".readyz-*")
+	if err != nil {
+		return "", fmt.Errorf("存储目录不可写: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("ok"); err != nil {
+		f.Close()
+		return "", fmt.Errorf("存储目录不可写: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("存储目录不可写: %w", err)
+	}
+	return s.opts.dir, nil
+}
+
+// 检查存储目录所在文件系统的剩余空间是否高于阈值
+func (s *server) checkFreeSpace(ctx context.Context) (string, error) {
+	free, err := diskFree(s.opts.dir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return "当前平台不支持查询剩余空间", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("查询剩余空间失败: %w", err)
+	}
+	detail := fmt.Sprintf("剩余 %s，阈值 %s", formatBytes(free), formatBytes(int64(s.opts.minFreeSpace)))
+	if free < int64(s.opts.minFreeSpace) {
+		return "", errors.New("剩余空间不足: " + detail)
+	}
+	return detail, nil
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 本地传输历史最多保留的记录数，超出后丢弃最旧的记录
+const maxHistoryRecords = 20000
+
+// historyMu 串行化本进程内对历史文件的写入 (守护进程的多个工作协程)
+var historyMu sync.Mutex
+
+// transferRecord 一次传输的结果，追加到本地历史记录 (JSON Lines)
+type transferRecord struct {
+	Time     time.Time `json:"time"`
+	Transfer string    `json:"transfer_id,omitempty"`
+	Target   string    `json:"target"` // scheme://host[:port]，按目标站点统计
+	URL      string    `json:"url"`
+	File     string    `json:"file"`
+	Bytes    int64     `json:"bytes"`
+	Duration float64   `json:"duration"`          // 秒
+	RTT      float64   `json:"rtt,omitempty"`     // 毫秒，读取不到 TCP 统计时为空
+	Retrans  float64   `json:"retrans,omitempty"` // 重传率
+	OK       bool      `json:"ok"`
+	Error    string    `json:"error,omitempty"`
+
+	attempted bool // 已开始网络传输；之前的失败 (参数错误、加锁失败等) 与链路无关，不记录
+}
+
+// 历史记录文件
+func historyPath() string {
+	return filepath.Join(stateDir(), "history.jsonl")
+}
+
+// 统一目标的表示：只保留协议和主机，同一站点的不同路径合并统计
+func historyTarget(raw string) string {
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return raw
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// 传输速度 (字节/秒)
+func (r *transferRecord) speed() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / r.Duration
+}
+
+// 追加一条记录；写入失败只输出警告，不影响传输结果
+func recordTransfer(rec *transferRecord, err error) {
+	if !rec.attempted {
+		return
+	}
+	rec.OK = err == nil
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	if err := appendHistory(rec); err != nil {
+		fmt.Printf("⚠️  写入传输历史失败: %v\n", err)
+	}
+}
+
+func appendHistory(rec *transferRecord) error {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	path := historyPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	info, serr := f.Stat()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	// 平均每条记录约 200 字节，文件明显超过上限时再整理，避免每次都读全文件
+	if serr == nil && info.Size() > maxHistoryRecords*300 {
+		return trimHistory(path)
+	}
+	return nil
+}
+
+// 只保留最新的 maxHistoryRecords 条记录
+func trimHistory(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	if len(lines) <= maxHistoryRecords {
+		return nil
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, bytes.Join(lines[len(lines)-maxHistoryRecords:], nil), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// 读取历史记录，target 非空时只返回该目标的记录
+func loadHistory(target string) ([]transferRecord, error) {
+	f, err := os.Open(historyPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []transferRecord
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for sc.Scan() {
+		var rec transferRecord
+		if json.Unmarshal(sc.Bytes(), &rec) != nil {
+			continue // 写入中断留下的半行
+		}
+		if target == "" || rec.Target == target {
+			records = append(records, rec)
+		}
+	}
+	return records, sc.Err()
+}
+
+// historyBucket 一段时间内的汇总
+type historyBucket struct {
+	transfers int
+	failures  int
+	bytes     int64
+	seconds   float64
+	rttSum    float64
+	rttCount  int
+}
+
+func (b *historyBucket) add(rec *transferRecord) {
+	b.transfers++
+	if !rec.OK {
+		b.failures++
+	}
+	if rec.OK && rec.Duration > 0 {
+		b.bytes += rec.Bytes
+		b.seconds += rec.Duration
+	}
+	if rec.RTT > 0 {
+		b.rttSum += rec.RTT
+		b.rttCount++
+	}
+}
+
+// 成功传输的平均速度 (按字节加权)
+func (b *historyBucket) speed() float64 {
+	if b.seconds <= 0 {
+		return 0
+	}
+	return float64(b.bytes) / b.seconds
+}
+
+func (b *historyBucket) rtt() float64 {
+	if b.rttCount == 0 {
+		return 0
+	}
+	return b.rttSum / float64(b.rttCount)
+}
+
+func (b *historyBucket) failureRate() float64 {
+	if b.transfers == 0 {
+		return 0
+	}
+	return float64(b.failures) / float64(b.transfers)
+}
+
+// stats 子命令：按目标站点汇总本机的传输历史，显示每天的吞吐、失败率和 RTT 以及变化趋势
+func runStats(args []string) {
+	fs := newCommandFlags("stats")
+	days := fs.Int("days", 14, "统计最近多少天")
+	fs.Usage = func() {
+		fmt.Println("用法: docker_save_shell stats [-days N] [目标地址]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	var err error
+	if fs.NArg() == 0 {
+		err = printTargetSummary(*days)
+	} else {
+		err = printTargetStats(historyTarget(fs.Arg(0)), *days)
+	}
+	if err != nil {
+		fmt.Printf("读取传输历史失败: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// 列出所有目标的汇总
+func printTargetSummary(days int) error {
+	records, err := loadHistory("")
+	if err != nil {
+		return err
+	}
+	since := time.Now().AddDate(0, 0, -days)
+	buckets := map[string]*historyBucket{}
+	for i := range records {
+		rec := &records[i]
+		if rec.Time.Before(since) {
+			continue
+		}
+		if buckets[rec.Target] == nil {
+			buckets[rec.Target] = &historyBucket{}
+		}
+		buckets[rec.Target].add(rec)
+	}
+	if len(buckets) == 0 {
+		fmt.Printf("最近 %d 天没有传输记录\n", days)
+		return nil
+	}
+
+	targets := make([]string, 0, len(buckets))
+	for t := range buckets {
+		targets = append(targets, t)
+	}
+	sort.Strings(targets)
+
+	fmt.Printf("最近 %d 天:\n", days)
+	fmt.Printf("%-36s %6s %8s %12s %10s\n", "目标", "次数", "失败率", "平均速度", "RTT")
+	for _, t := range targets {
+		b := buckets[t]
+		fmt.Printf("%-36s %6d %7.1f%% %10s/s %10s\n", t, b.transfers, b.failureRate()*100, formatBytes(int64(b.speed())), formatRTT(b.rtt()))
+	}
+	return nil
+}
+
+// 显示单个目标每天的统计和趋势
+func printTargetStats(target string, days int) error {
+	records, err := loadHistory(target)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	since := time.Date(now.Year(), now.Month(), now.Day()-days+1, 0, 0, 0, 0, now.Location())
+	daily := make([]historyBucket, days)
+	var recent, earlier historyBucket
+	for i := range records {
+		rec := &records[i]
+		if rec.Time.Before(since) {
+			continue
+		}
+		day := int(rec.Time.Sub(since) / (24 * time.Hour))
+		if day >= days {
+			continue
+		}
+		daily[day].add(rec)
+		// 以统计区间的中点分为前后两半，比较两半的指标得到趋势
+		if day >= days/2 {
+			recent.add(rec)
+		} else {
+			earlier.add(rec)
+		}
+	}
+	if recent.transfers+earlier.transfers == 0 {
+		fmt.Printf("%s 最近 %d 天没有传输记录\n", target, days)
+		return nil
+	}
+
+	fmt.Printf("📈 %s 最近 %d 天:\n", target, days)
+	fmt.Printf("%-10s %6s %6s %12s %10s\n", "日期", "次数", "失败", "平均速度", "RTT")
+	for i, b := range daily {
+		if b.transfers == 0 {
+			continue
+		}
+		fmt.Printf("%-10s %6d %6d %10s/s %10s\n", since.AddDate(0, 0, i).Format("2006-01-02"), b.transfers, b.failures, formatBytes(int64(b.speed())), formatRTT(b.rtt()))
+	}
+
+	if recent.transfers == 0 || earlier.transfers == 0 {
+		return nil
+	}
+	fmt.Println("\n趋势 (后半段对比前半段):")
+	fmt.Printf("   速度:   %s\n", trend(earlier.speed(), recent.speed(), true))
+	fmt.Printf("   失败率: %.1f%% → %.1f%%\n", earlier.failureRate()*100, recent.failureRate()*100)
+	if earlier.rtt() > 0 && recent.rtt() > 0 {
+		fmt.Printf("   RTT:    %s\n", trend(earlier.rtt(), recent.rtt(), false))
+	}
+	if recent.speed() < earlier.speed()*0.7 || recent.failureRate() > earlier.failureRate()+0.1 {
+		fmt.Println("⚠️  到该目标的链路质量在下降，可运行 doctor 进一步诊断")
+	}
+	return nil
+}
+
+// 描述指标的变化，higherBetter 决定升降对应的好坏
+func trend(before, after float64, higherBetter bool) string {
+	if before <= 0 {
+		return "-"
+	}
+	change := (after - before) / before * 100
+	arrow := "→ 持平"
+	switch {
+	case change > 10:
+		arrow = "↑ 变差"
+		if higherBetter {
+			arrow = "↑ 改善"
+		}
+	case change < -10:
+		arrow = "↓ 改善"
+		if higherBetter {
+			arrow = "↓ 变差"
+		}
+	}
+	if higherBetter {
+		return fmt.Sprintf("%s/s → %s/s (%+.0f%%) %s", formatBytes(int64(before)), formatBytes(int64(after)), change, arrow)
+	}
+	return fmt.Sprintf("%s → %s (%+.0f%%) %s", formatRTT(before), formatRTT(after), change, arrow)
+}
+
+func formatRTT(ms float64) string {
+	if ms <= 0 {
+		return "-"
+	}
+	if ms < 1 {
+		return fmt.Sprintf("%.2f ms", ms)
+	}
+	return fmt.Sprintf("%.1f ms", ms)
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 钩子默认的执行超时
+const defaultHookTimeout = 10 * time.Minute
+
+// verify_exec 清单及其签名的大小上限
+const maxSumsSize = 4 << 20
+
+// hookConfig 服务端钩子配置文件 (serve -hooks hooks.yaml)
+type hookConfig struct {
+	PostReceive []postReceiveHook `yaml:"post_receive"`
+}
+
+// postReceiveHook 文件保存后执行的动作，run 和 verify_exec 二选一
+type postReceiveHook struct {
+	Name    string `yaml:"name"`
+	Match   string `yaml:"match"`   // 按上传的文件名匹配，* 和 ? 通配，为空匹配所有文件
+	Timeout string `yaml:"timeout"` // 默认 10m
+
+	// 执行外部命令，文件信息通过 DSS_* 环境变量传入
+	Run string `yaml:"run"`
+
+	// 内置动作：校验签名和摘要后再执行包内的安装脚本
+	VerifyExec *verifyExecHook `yaml:"verify_exec"`
+
+	timeout time.Duration
+}
+
+// verifyExecHook 校验后执行：用于通过本通道下发签名的系统/固件更新包。
+// 更新包是目录打包的 tar (可压缩)，根目录下需包含 SHA256SUMS 及其 Ed25519 签名 SHA256SUMS.sig，
+// 包内每个文件都必须列在清单中且摘要一致、清单签名有效，全部通过后才执行安装脚本。
+type verifyExecHook struct {
+	PublicKey string `yaml:"public_key"` // Ed25519 公钥 (PEM)
+	Sums      string `yaml:"sums"`       // 清单文件名，默认 SHA256SUMS
+	Script    string `yaml:"script"`     // 包内的安装脚本，以解包目录为工作目录执行
+
+	key ed25519.PublicKey
+}
+
+// hookResult 一个钩子的执行结果，随上传结果返回给客户端
+type hookResult struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// receivedInfo 传给钩子的已保存文件信息
+type receivedInfo struct {
+	path     string
+	name     string
+	sha256   string
+	artifact string
+	version  string
+	transfer string // 客户端的传输 ID，未提供时为空
+}
+
+// 读取并校验钩子配置
+func loadHooks(path string) (*hookConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取钩子配置失败: %w", err)
+	}
+	cfg := &hookConfig{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("解析钩子配置失败: %w", err)
+	}
+
+	for i := range cfg.PostReceive {
+		h := &cfg.PostReceive[i]
+		if h.Name == "" {
+			h.Name = fmt.Sprintf("post_receive[%d]", i)
+		}
+		if (h.Run == "") == (h.VerifyExec == nil) {
+			return nil, fmt.Errorf("钩子 %s 需要且只能指定 run 或 verify_exec 之一", h.Name)
+		}
+		h.timeout = defaultHookTimeout
+		if h.Timeout != "" {
+			if h.timeout, err = time.ParseDuration(h.Timeout); err != nil {
+				return nil, fmt.Errorf("钩子 %s 的 timeout 格式错误: %w", h.Name, err)
+			}
+		}
+		if v := h.VerifyExec; v != nil {
+			if v.Script == "" {
+				return nil, fmt.Errorf("钩子 %s 缺少 verify_exec.script", h.Name)
+			}
+			if v.Sums == "" {
+				v.Sums = "SHA256SUMS"
+			}
+			if v.key, err = loadEd25519PublicKey(v.PublicKey); err != nil {
+				return nil, fmt.Errorf("钩子 %s: %w", h.Name, err)
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// 读取 Ed25519 公钥 (PKIX PEM，可由 openssl pkey -pubout 导出)
+func loadEd25519PublicKey(path string) (ed25519.PublicKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取公钥失败: %w", err)
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("公钥不是 PEM 格式")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("解析公钥失败: %w", err)
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("公钥必须是 Ed25519 密钥")
+	}
+	return pub, nil
+}
+
+// 依次执行匹配的钩子，遇到失败即停止
+func (s *server) runPostReceiveHooks(info receivedInfo) ([]hookResult, error) {
+	if s.hooks == nil {
+		return nil, nil
+	}
+	var results []hookResult
+	for _, h := range s.hooks.PostReceive {
+		if h.Match != "" && !globMatch(h.Match, info.name) {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+		var output string
+		var err error
+		if h.VerifyExec != nil {
+			output, err = h.VerifyExec.run(ctx, info)
+		} else {
+			output, err = runHookCommand(ctx, h.Run, "", info)
+		}
+		cancel()
+
+		res := hookResult{Name: h.Name, OK: err == nil, Output: output}
+		results = append(results, res)
+		if err != nil {
+			results[len(results)-1].Error = err.Error()
+			return results, fmt.Errorf("钩子 %s 失败: %w", h.Name, err)
+		}
+		fmt.Printf("🪝 钩子 %s 执行成功\n", h.Name)
+	}
+	return results, nil
+}
+
+// 执行外部命令，合并标准输出和标准错误作为输出
+func runHookCommand(ctx context.Context, command, dir string, info receivedInfo) (string, error) {
+	words, err := splitArgs(command)
+	if err != nil || len(words) == 0 {
+		return "", fmt.Errorf("钩子命令配置错误: %s", command)
+	}
+	cmd := exec.CommandContext(ctx, words[0], words[1:]...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"DSS_FILE="+info.path,
+		"DSS_NAME="+info.name,
+		"DSS_SHA256="+info.sha256,
+		"DSS_ARTIFACT="+info.artifact,
+		"DSS_VERSION="+info.version,
+		"DSS_TRANSFER_ID="+info.transfer,
+	)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err = cmd.Run()
+	if ctx.Err() != nil {
+		err = fmt.Errorf("执行超时: %w", ctx.Err())
+	}
+	return strings.TrimSpace(out.String()), err
+}
+
+// 先直接从归档中读出清单和签名并校验，签名有效才解包到临时目录，核对清单后执行安装脚本
+func (v *verifyExecHook) run(ctx context.Context, info receivedInfo) (string, error) {
+	sumsName, sigName := path.Clean(v.Sums), path.Clean(v.Sums)+".sig"
+	files, err := readBundleFiles(info.path, maxSumsSize, sumsName, sigName)
+	if err != nil {
+		return "", fmt.Errorf("读取更新包失败: %w", err)
+	}
+	sums, ok := files[sumsName]
+	if !ok {
+		return "", fmt.Errorf("更新包中缺少 %s", v.Sums)
+	}
+	sig, ok := files[sigName]
+	if !ok {
+		return "", fmt.Errorf("更新包中缺少 %s.sig", v.Sums)
+	}
+	if !ed25519.Verify(v.key, sums, sig) {
+		return "", fmt.Errorf("%s 的签名无效", v.Sums)
+	}
+
+	dir, err := os.MkdirTemp("", "dss-verify-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := untarBundle(info.path, dir); err != nil {
+		return "", fmt.Errorf("解包失败: %w", err)
+	}
+
+	listed, err := parseSums(sums)
+	if err != nil {
+		return "", err
+	}
+	if err := verifyBundleFiles(dir, listed, sumsName); err != nil {
+		return "", err
+	}
+	if _, ok := listed[path.Clean(v.Script)]; !ok {
+		return "", fmt.Errorf("安装脚本 %s 不在 %s 中", v.Script, v.Sums)
+	}
+	fmt.Printf("🔏 %s 签名和摘要校验通过，执行 %s\n", info.name, v.Script)
+
+	script := filepath.Join(dir, filepath.FromSlash(path.Clean(v.Script)))
+	os.Chmod(script, 0o755)
+	return runHookCommand(ctx, script, dir, info)
+}
+
+// 解析 sha256sum 格式的清单，返回 相对路径 -> 摘要
+func parseSums(data []byte) (map[string]string, error) {
+	listed := map[string]string{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		sum, name, ok := strings.Cut(line, " ")
+		name = strings.TrimPrefix(strings.TrimLeft(name, " "), "*") // 二进制模式的标记
+		if !ok || len(sum) != sha256.Size*2 || name == "" {
+			return nil, fmt.Errorf("清单格式错误: %s", line)
+		}
+		clean := path.Clean(name)
+		if path.IsAbs(clean) || !localPath(clean) {
+			return nil, fmt.Errorf("清单中包含非法路径: %s", name)
+		}
+		listed[clean] = strings.ToLower(sum)
+	}
+	return listed, sc.Err()
+}
+
+// 核对包内每个文件：必须列在清单中且摘要一致，清单中的文件也必须都存在
+func verifyBundleFiles(dir string, listed map[string]string, sumsName string) error {
+	seen := map[string]bool{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		rel = filepath.ToSlash(rel)
+		if rel == sumsName || rel == sumsName+".sig" {
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return fmt.Errorf("更新包中不允许包含链接或特殊文件: %s", rel)
+		}
+		want, ok := listed[rel]
+		if !ok {
+			return fmt.Errorf("文件 %s 不在 %s 中", rel, sumsName)
+		}
+		got, err := fileSHA256(p)
+		if err != nil {
+			return err
+		}
+		if got != want {
+			return fmt.Errorf("文件 %s 摘要不匹配", rel)
+		}
+		seen[rel] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for name := range listed {
+		if !seen[name] {
+			return fmt.Errorf("清单中的文件 %s 不存在", name)
+		}
+	}
+	return nil
+}
+
+func fileSHA256(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
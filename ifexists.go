@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 服务端在 /ping 响应中通告同名文件默认处理方式的响应头；没有该头部的旧版服务端总是覆盖，且不接受客户端指定
+const ifExistsHeader = "X-Dss-If-Exists"
+
+// 上传的文件与存储目录中已有文件同名时的处理方式
+const (
+	ifExistsFail      = "fail"                // 拒绝上传 (409)
+	ifExistsOverwrite = "overwrite"           // 覆盖原文件 (默认，与旧版本一致)
+	ifExistsSuffix    = "suffix"              // 另存为 name-1.ext、name-2.ext ...
+	ifExistsSkipSame  = "skip-if-same-digest" // 内容相同时保留原文件，不同时拒绝
+)
+
+var ifExistsPolicies = []string{ifExistsFail, ifExistsOverwrite, ifExistsSuffix, ifExistsSkipSame}
+
+// 响应中的 collision 字段：同名文件已存在时实际所做的处理
+const (
+	collisionOverwritten = "overwritten"
+	collisionRenamed     = "renamed"
+	collisionSkipped     = "skipped"
+)
+
+// 服务端因目标已存在 (409) 拒绝上传时的退出码 (EX_CANTCREAT)
+const exitExists = 73
+
+func validIfExists(policy string) error {
+	for _, p := range ifExistsPolicies {
+		if policy == p {
+			return nil
+		}
+	}
+	return fmt.Errorf("非法的同名文件处理方式 %q，可选: %s", policy, strings.Join(ifExistsPolicies, "、"))
+}
+
+// 将收到的文件按同名文件的处理方式提交到存储目录，返回最终的文件名和所做的处理 (没有同名文件时为空)。
+// policy 为客户端指定的处理方式，为空时使用服务端的 -if-exists；出错时同时返回 HTTP 状态码
+func (s *server) storeFile(r *http.Request, rf *receivedFile, policy string) (name, collision string, status int, err error) {
+	if policy == "" {
+		policy = s.opts.ifExists
+	}
+	if err := validIfExists(policy); err != nil {
+		return "", "", http.StatusBadRequest, err
+	}
+	target := filepath.Join(s.opts.dir, rf.name)
+
+	switch policy {
+	case ifExistsOverwrite:
+		_, statErr := os.Stat(target)
+		existed := statErr == nil
+		// 服务端默认不覆盖时，客户端要求覆盖等同于删除原文件，需要 delete 权限
+		if existed && s.opts.ifExists != ifExistsOverwrite {
+			if status, err := authorizeRequest(s.opts.dir, r, scopeDelete); err != nil {
+				return "", "", status, fmt.Errorf("覆盖同名文件 %s 需要 delete 权限: %w", rf.name, err)
+			}
+		}
+		if err := os.Rename(rf.tmpPath, target); err != nil {
+			return "", "", http.StatusInternalServerError, fmt.Errorf("保存文件失败: %w", err)
+		}
+		rf.tmpPath = ""
+		if existed {
+			collision = collisionOverwritten
+		}
+		return rf.name, collision, 0, nil
+
+	case ifExistsSkipSame:
+		if _, err := os.Stat(target); err == nil {
+			existing := s.storedDigest(target)
+			if existing == "" {
+				if existing, err = fileSHA256(target); err != nil {
+					return "", "", http.StatusInternalServerError, fmt.Errorf("计算已有文件的摘要失败: %w", err)
+				}
+			}
+			if existing != rf.storedSHA256() {
+				return "", "", http.StatusConflict, fmt.Errorf("服务端已有内容不同的同名文件 %s", rf.name)
+			}
+			return rf.name, collisionSkipped, 0, nil
+		}
+		fallthrough
+
+	case ifExistsFail:
+		err := linkNew(rf, target)
+		if errors.Is(err, os.ErrExist) {
+			return "", "", http.StatusConflict, fmt.Errorf("服务端已有同名文件 %s", rf.name)
+		}
+		if err != nil {
+			return "", "", http.StatusInternalServerError, err
+		}
+		return rf.name, "", 0, nil
+	}
+
+	// suffix：依次尝试原文件名和加序号的文件名，直到创建成功
+	base, ext := splitFileExt(rf.name)
+	for i := 0; i < 10000; i++ {
+		name = rf.name
+		if i > 0 {
+			name = fmt.Sprintf("%s-%d%s", base, i, ext)
+		}
+		err := linkNew(rf, filepath.Join(s.opts.dir, name))
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", "", http.StatusInternalServerError, err
+		}
+		if i > 0 {
+			collision = collisionRenamed
+		}
+		return name, collision, 0, nil
+	}
+	return "", "", http.StatusConflict, fmt.Errorf("%s 的同名文件过多", rf.name)
+}
+
+// 以硬链接将临时文件提交为 target，target 已存在时返回 os.ErrExist；
+// 与先检查再 rename 不同，并发上传同名文件时只有一个能成功
+func linkNew(rf *receivedFile, target string) error {
+	if err := os.Link(rf.tmpPath, target); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return os.ErrExist
+		}
+		return fmt.Errorf("保存文件失败: %w", err)
+	}
+	os.Remove(rf.tmpPath)
+	rf.tmpPath = ""
+	return nil
+}
+
+// 拆出文件名和扩展名，.tar.gz、.tar.zst 等整体作为扩展名
+func splitFileExt(name string) (base, ext string) {
+	ext = filepath.Ext(name)
+	base = strings.TrimSuffix(name, ext)
+	if inner := filepath.Ext(base); inner == ".tar" {
+		base, ext = strings.TrimSuffix(base, inner), inner+ext
+	}
+	if base == "" {
+		return name, ""
+	}
+	return base, ext
+}
+
+// -if-exists 只用于本工具服务端保存的普通文件
+func checkIfExistsOptions(opts options) error {
+	if err := validIfExists(opts.ifExists); err != nil {
+		return fmt.Errorf("-if-exists: %w", err)
+	}
+	switch {
+	case opts.artifactName != "":
+		return errors.New("-if-exists 不能与 -name 同时使用 (制品的版本不可覆盖)")
+	case opts.tus, opts.preset != "", isS3URL(opts.serverURL), isSFTPURL(opts.serverURL):
+		return errors.New("-if-exists 只能用于本工具的服务端")
+	}
+	return nil
+}
+
+// 确认服务端支持客户端指定同名文件的处理方式，返回服务端的默认处理方式
+func negotiateIfExists(ctx context.Context, client *sessionClient, serverURL string) (string, error) {
+	h, err := pingReceiver(ctx, client, serverURL)
+	if err != nil {
+		return "", fmt.Errorf("查询服务端的同名文件处理方式失败: %w", err)
+	}
+	policy := h.Get(ifExistsHeader)
+	if policy == "" {
+		return "", errors.New("服务端不支持 -if-exists (版本过旧)，同名文件总是被覆盖")
+	}
+	return policy, nil
+}
+
+// 输出服务端对同名文件所做的处理
+func printCollision(data []byte) {
+	var res uploadResult
+	if err := json.Unmarshal(data, &res); err != nil {
+		return
+	}
+	switch res.Collision {
+	case collisionOverwritten:
+		fmt.Printf("♻️  已覆盖服务端的同名文件 %s\n", res.Name)
+	case collisionRenamed:
+		fmt.Printf("📛 服务端已有同名文件，另存为 %s\n", res.Name)
+	case collisionSkipped:
+		fmt.Printf("⏭️  服务端已有内容相同的 %s，未重复保存\n", res.Name)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// bundle 默认同时执行的 docker save 数
+const defaultSaveJobs = 4
+
+// 以 jobs 个并发的 docker save 将各镜像分别导出到 dir，返回与 refs 一一对应的文件
+func saveImages(docker dockerEndpoint, refs []string, dir string, jobs int) ([]string, error) {
+	files := make([]string, len(refs))
+	errs := make([]error, len(refs))
+	sem := make(chan struct{}, max(jobs, 1))
+	var wg sync.WaitGroup
+	for i, ref := range refs {
+		files[i] = filepath.Join(dir, fmt.Sprintf("%03d-%s", i, imageFileName([]string{ref})))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			fmt.Printf("🐳 导出镜像: %s\n", ref)
+			if _, err := docker.run("save", "-o", files[i], ref); err != nil {
+				errs[i] = fmt.Errorf("导出 %s 失败: %w", ref, err)
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// 将多个 docker save 归档合并为一个：同名条目 (层、配置等按内容寻址的文件) 只保留一份，
+// manifest.json、repositories 和 index.json 合并后写在最后 (docker load 先解开整个归档再读取)。
+// 返回因共享而省去的条目数和字节数
+func mergeImageArchives(files []string, output string) (shared int, saved int64, err error) {
+	out, err := os.Create(output)
+	if err != nil {
+		return 0, 0, err
+	}
+	tw := tar.NewWriter(out)
+	m := &archiveMerger{tw: tw, seen: map[string]bool{}, repositories: map[string]map[string]string{}, configs: map[string]bool{}}
+	for _, file := range files {
+		if err = m.add(file); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = m.writeMetadata()
+	}
+	if cerr := tw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return m.shared, m.saved, err
+}
+
+// archiveMerger 合并镜像归档的状态
+type archiveMerger struct {
+	tw   *tar.Writer
+	seen map[string]bool
+
+	manifest     []json.RawMessage            // manifest.json 各项，按 Config 去重
+	configs      map[string]bool              // 已加入 manifest 的 Config
+	repositories map[string]map[string]string // 旧格式的 repositories: 仓库 → 标签 → 层 ID
+	index        map[string]json.RawMessage   // OCI index.json，manifests 以外的字段取第一个归档的
+	indexItems   []json.RawMessage
+	indexDigests map[string]bool
+
+	shared int
+	saved  int64
+}
+
+func (m *archiveMerger) add(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("读取 %s 失败: %w", filepath.Base(file), err)
+		}
+		name := strings.TrimPrefix(hdr.Name, "./")
+		switch name {
+		case "manifest.json":
+			err = m.addManifest(tr)
+		case "repositories":
+			err = m.addRepositories(tr)
+		case "index.json":
+			err = m.addIndex(tr)
+		default:
+			err = m.copyEntry(hdr, name, tr)
+		}
+		if err != nil {
+			return fmt.Errorf("合并 %s 中的 %s 失败: %w", filepath.Base(file), name, err)
+		}
+	}
+}
+
+// 原样写出条目，已写过的同名条目跳过
+func (m *archiveMerger) copyEntry(hdr *tar.Header, name string, r io.Reader) error {
+	if m.seen[name] {
+		if hdr.Typeflag == tar.TypeReg {
+			m.shared++
+			m.saved += hdr.Size
+		}
+		return nil
+	}
+	m.seen[name] = true
+	if err := m.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := io.Copy(m.tw, r)
+	return err
+}
+
+func (m *archiveMerger) addManifest(r io.Reader) error {
+	var items []json.RawMessage
+	if err := json.NewDecoder(r).Decode(&items); err != nil {
+		return err
+	}
+	for _, item := range items {
+		var entry archiveManifestEntry
+		if err := json.Unmarshal(item, &entry); err != nil {
+			return err
+		}
+		if m.configs[entry.Config] {
+			continue
+		}
+		m.configs[entry.Config] = true
+		m.manifest = append(m.manifest, item)
+	}
+	return nil
+}
+
+func (m *archiveMerger) addRepositories(r io.Reader) error {
+	var repos map[string]map[string]string
+	if err := json.NewDecoder(r).Decode(&repos); err != nil {
+		return err
+	}
+	for repo, tags := range repos {
+		if m.repositories[repo] == nil {
+			m.repositories[repo] = map[string]string{}
+		}
+		for tag, id := range tags {
+			m.repositories[repo][tag] = id
+		}
+	}
+	return nil
+}
+
+func (m *archiveMerger) addIndex(r io.Reader) error {
+	var index map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&index); err != nil {
+		return err
+	}
+	var items []json.RawMessage
+	if raw, ok := index["manifests"]; ok {
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return err
+		}
+	}
+	if m.index == nil {
+		m.index, m.indexDigests = index, map[string]bool{}
+	}
+	for _, item := range items {
+		var desc struct {
+			Digest string `json:"digest"`
+		}
+		if err := json.Unmarshal(item, &desc); err != nil {
+			return err
+		}
+		if m.indexDigests[desc.Digest] {
+			continue
+		}
+		m.indexDigests[desc.Digest] = true
+		m.indexItems = append(m.indexItems, item)
+	}
+	return nil
+}
+
+// 写出合并后的 manifest.json、repositories 和 index.json
+func (m *archiveMerger) writeMetadata() error {
+	write := func(name string, v any) error {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if err := m.tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), Format: tar.FormatPAX}); err != nil {
+			return err
+		}
+		_, err = m.tw.Write(data)
+		return err
+	}
+	if m.manifest == nil {
+		return fmt.Errorf("归档中没有 manifest.json，不是 docker save 的输出")
+	}
+	if err := write("manifest.json", m.manifest); err != nil {
+		return err
+	}
+	if len(m.repositories) > 0 {
+		if err := write("repositories", m.repositories); err != nil {
+			return err
+		}
+	}
+	if m.index != nil {
+		items, err := json.Marshal(m.indexItems)
+		if err != nil {
+			return err
+		}
+		m.index["manifests"] = items
+		if err := write("index.json", m.index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
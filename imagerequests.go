@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 镜像请求：接收方知道自己需要哪些镜像时，由它登记请求，订阅了服务端的客户端守护进程 (daemon -subscribe)
+// 以长轮询取走请求，从本机镜像导出并上传，再回报结果。适合接收方掌握需求、客户端不便主动推送的现场
+//
+//	POST /requests              登记请求，JSON {image, load, tag}
+//	GET  /requests              列出请求
+//	GET  /requests/next?wait=   长轮询取走最早的待处理请求，超时返回 204
+//	POST /requests/{id}/result  回报结果，JSON {error}，error 为空表示成功
+const (
+	requestPending = "pending"
+	requestClaimed = "claimed"
+
+	// 长轮询最长等待时间
+	maxRequestWait = 60 * time.Second
+	// 取走后超过该时间仍未回报结果的请求重新交给其他客户端
+	requestClaimTTL = 30 * time.Minute
+)
+
+// imageRequest 一个镜像请求
+type imageRequest struct {
+	ID      string    `json:"id"`
+	Image   string    `json:"image"`
+	Load    bool      `json:"load,omitempty"` // 上传后在服务端 docker load
+	Tag     string    `json:"tag,omitempty"`  // 加载后打上的标签
+	State   string    `json:"state"`          // pending、claimed、done 或 failed
+	Client  string    `json:"client,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// imageRequests 服务端登记的镜像请求，只保存在内存中
+type imageRequests struct {
+	mu    sync.Mutex
+	items []*imageRequest
+	wake  chan struct{} // 有新请求时关闭并替换，唤醒等待中的长轮询
+}
+
+// 等待新请求的通道，调用方持有 mu
+func (q *imageRequests) waitChan() chan struct{} {
+	if q.wake == nil {
+		q.wake = make(chan struct{})
+	}
+	return q.wake
+}
+
+func (q *imageRequests) notify() {
+	if q.wake != nil {
+		close(q.wake)
+		q.wake = nil
+	}
+}
+
+// 取走最早的待处理请求 (包括取走后超时未回报的)，没有时返回 nil，调用方持有 mu
+func (q *imageRequests) claim(client string) *imageRequest {
+	now := time.Now()
+	for _, req := range q.items {
+		if req.State == requestPending || req.State == requestClaimed && now.Sub(req.Updated) > requestClaimTTL {
+			req.State, req.Client, req.Updated = requestClaimed, client, now
+			return req
+		}
+	}
+	return nil
+}
+
+// 淘汰过旧的已结束请求，调用方持有 mu
+func (q *imageRequests) trim() {
+	finished := 0
+	for _, req := range q.items {
+		if req.State == jobDone || req.State == jobFailed {
+			finished++
+		}
+	}
+	kept := q.items[:0]
+	for _, req := range q.items {
+		if (req.State == jobDone || req.State == jobFailed) && finished > maxFinishedJobs {
+			finished--
+			continue
+		}
+		kept = append(kept, req)
+	}
+	q.items = kept
+}
+
+func (s *server) handleCreateRequest(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Image string `json:"image"`
+		Load  bool   `json:"load"`
+		Tag   string `json:"tag"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxFormFieldSize)).Decode(&in); err != nil {
+		writeJSON(w, http.StatusBadRequest, uploadResult{Error: "请求格式错误: " + err.Error()})
+		return
+	}
+	if in.Image == "" || strings.HasPrefix(in.Image, "-") || strings.Contains(in.Image, ",") {
+		writeJSON(w, http.StatusBadRequest, uploadResult{Error: fmt.Sprintf("镜像名称无效: %q", in.Image)})
+		return
+	}
+	now := time.Now()
+	req := &imageRequest{ID: randomID(), Image: in.Image, Load: in.Load || in.Tag != "", Tag: in.Tag, State: requestPending, Created: now, Updated: now}
+
+	s.requests.mu.Lock()
+	s.requests.items = append(s.requests.items, req)
+	s.requests.trim()
+	s.requests.notify()
+	out := *req
+	s.requests.mu.Unlock()
+	fmt.Printf("📮 登记镜像请求 %s: %s\n", req.ID, req.Image)
+	writeJSON(w, http.StatusCreated, out)
+}
+
+func (s *server) handleListRequests(w http.ResponseWriter, r *http.Request) {
+	s.requests.mu.Lock()
+	defer s.requests.mu.Unlock()
+	list := make([]imageRequest, 0, len(s.requests.items))
+	for _, req := range s.requests.items {
+		list = append(list, *req)
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// 长轮询：有待处理的请求时立即取走返回，否则等待新请求直到超时 (204)
+func (s *server) handleNextRequest(w http.ResponseWriter, r *http.Request) {
+	wait := maxRequestWait
+	if v := r.URL.Query().Get("wait"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			writeJSON(w, http.StatusBadRequest, uploadResult{Error: "非法的 wait: " + v})
+			return
+		}
+		wait = min(d, maxRequestWait)
+	}
+	client := r.URL.Query().Get("client")
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+	for {
+		s.requests.mu.Lock()
+		req := s.requests.claim(client)
+		var out imageRequest
+		if req != nil {
+			out = *req
+		}
+		wake := s.requests.waitChan()
+		s.requests.mu.Unlock()
+		if req != nil {
+			fmt.Printf("📮 镜像请求 %s 已由 %s 取走\n", out.ID, cmp.Or(client, "客户端"))
+			writeJSON(w, http.StatusOK, out)
+			return
+		}
+		select {
+		case <-wake:
+		case <-timeout.C:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *server) handleRequestResult(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxFormFieldSize)).Decode(&in); err != nil {
+		writeJSON(w, http.StatusBadRequest, uploadResult{Error: "请求格式错误: " + err.Error()})
+		return
+	}
+	s.requests.mu.Lock()
+	defer s.requests.mu.Unlock()
+	for _, req := range s.requests.items {
+		if req.ID != r.PathValue("id") {
+			continue
+		}
+		req.State, req.Error, req.Updated = jobDone, in.Error, time.Now()
+		if in.Error != "" {
+			req.State = jobFailed
+		}
+		fmt.Printf("📮 镜像请求 %s (%s): %s\n", req.ID, req.Image, req.State)
+		writeJSON(w, http.StatusOK, *req)
+		return
+	}
+	writeJSON(w, http.StatusNotFound, uploadResult{Error: "请求不存在"})
+}
+
+// request 子命令：向服务端登记镜像请求，由订阅的客户端守护进程送达
+func runRequest(args []string) {
+	var serverURL, token, tag string
+	var load bool
+	fs := newCommandFlags("request")
+	fs.StringVar(&serverURL, "url", "", "服务端地址 (必须)")
+	fs.StringVar(&token, "token", "", "以 Bearer 令牌认证 (需要 load 权限)")
+	fs.BoolVar(&load, "load", false, "送达后在服务端 docker load")
+	fs.StringVar(&tag, "tag", "", "加载后打上的标签 (隐含 -load)")
+	images := parseInterspersed(fs, args)
+
+	if serverURL == "" || len(images) == 0 {
+		fmt.Println("用法: docker_save_shell request -url URL [-token 令牌] [-load] [-tag 标签] <镜像>...")
+		os.Exit(1)
+	}
+	endpoint, err := url.JoinPath(serverURL, "requests")
+	if err != nil {
+		fmt.Printf("服务端地址格式错误: %v\n", err)
+		os.Exit(1)
+	}
+	for _, image := range images {
+		data, _ := json.Marshal(map[string]any{"image": image, "load": load, "tag": tag})
+		req, err := http.NewRequest("POST", endpoint, bytes.NewReader(data))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			fmt.Printf("请求失败: %v\n", err)
+			os.Exit(1)
+		}
+		if resp.StatusCode != http.StatusCreated {
+			fmt.Printf("登记 %s 失败: %s\n", image, responseError(resp))
+			resp.Body.Close()
+			os.Exit(1)
+		}
+		var created imageRequest
+		err = json.NewDecoder(resp.Body).Decode(&created)
+		resp.Body.Close()
+		if err != nil {
+			fmt.Printf("解析响应失败: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("📮 %s: 已登记请求 %s\n", image, created.ID)
+	}
+}
+
+// 客户端：任务元数据中记录镜像请求 ID 的键，任务结束后据此回报结果
+const requestMetaKey = "request_id"
+
+// 按任务模板的地址、认证头和 TLS 设置创建访问服务端请求接口的客户端
+func requestClient(opts options) (*sessionClient, error) {
+	client := newSessionClient(randomID(), maxRequestWait+30*time.Second)
+	if err := client.useTLS(opts.tls); err != nil {
+		return nil, err
+	}
+	headers, err := requestHeaders(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.useHeaders(opts.serverURL, headers); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func requestsEndpoint(serverURL string, elem ...string) (string, error) {
+	base, err := url.Parse(serverURL)
+	if err != nil {
+		return "", fmt.Errorf("服务端地址格式错误: %w", err)
+	}
+	return base.ResolveReference(&url.URL{Path: "requests"}).JoinPath(elem...).String(), nil
+}
+
+// 订阅服务端的镜像请求：长轮询取走请求，本机有该镜像时按任务模板加入队列，没有时直接回报失败
+func (d *daemon) subscribe(tpl *transferJob) {
+	opts, err := tpl.options()
+	if err != nil {
+		fmt.Printf("⚠️  订阅任务模板无效: %v\n", err)
+		return
+	}
+	client, err := requestClient(opts)
+	if err != nil {
+		fmt.Printf("⚠️  订阅失败: %v\n", err)
+		return
+	}
+	next, err := requestsEndpoint(opts.serverURL, "next")
+	if err != nil {
+		fmt.Printf("⚠️  订阅失败: %v\n", err)
+		return
+	}
+	host, _ := os.Hostname()
+	next += "?wait=" + maxRequestWait.String() + "&client=" + url.QueryEscape(host)
+	fmt.Printf("📮 已订阅 %s 的镜像请求\n", opts.serverURL)
+
+	failures := 0
+	for {
+		req, err := pollRequest(client, next)
+		if err != nil {
+			failures++
+			delay := retryDelay(failures)
+			fmt.Printf("⚠️  获取镜像请求失败，%s 后重试: %v\n", delay, err)
+			time.Sleep(delay)
+			continue
+		}
+		failures = 0
+		if req == nil {
+			continue
+		}
+		fmt.Printf("📮 收到镜像请求 %s: %s\n", req.ID, req.Image)
+		if _, err := opts.docker.inspectImage(req.Image); err != nil && !(isNoSuchImage(err) && opts.pull) {
+			if isNoSuchImage(err) {
+				err = fmt.Errorf("本机不存在镜像 %s", req.Image)
+			}
+			fmt.Printf("⚠️  无法满足镜像请求 %s: %v\n", req.ID, err)
+			reportRequest(opts, req.ID, err)
+			continue
+		}
+
+		job := *tpl
+		job.Source = imageSourcePrefix + req.Image
+		job.Options.RemoteLoad = job.Options.RemoteLoad || req.Load
+		if req.Tag != "" {
+			job.Options.RemoteTag = req.Tag
+		}
+		job.Metadata = map[string]string{}
+		for k, v := range tpl.Metadata {
+			job.Metadata[k] = v
+		}
+		job.Metadata[requestMetaKey] = req.ID
+		d.enqueue(&job)
+	}
+}
+
+// 长轮询一次，没有请求时返回 nil
+func pollRequest(client *sessionClient, endpoint string) (*imageRequest, error) {
+	resp, err := client.Get(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		return nil, nil
+	case http.StatusOK:
+	default:
+		return nil, errors.New(responseError(resp))
+	}
+	req := &imageRequest{}
+	if err := json.NewDecoder(resp.Body).Decode(req); err != nil {
+		return nil, fmt.Errorf("解析镜像请求失败: %w", err)
+	}
+	return req, nil
+}
+
+// 回报镜像请求的结果，失败只给出警告 (请求超时后会重新交给客户端)
+func reportRequest(opts options, id string, result error) {
+	body := map[string]string{}
+	if result != nil {
+		body["error"] = result.Error()
+	}
+	err := func() error {
+		client, err := requestClient(opts)
+		if err != nil {
+			return err
+		}
+		endpoint, err := requestsEndpoint(opts.serverURL, id, "result")
+		if err != nil {
+			return err
+		}
+		data, _ := json.Marshal(body)
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return errors.New(responseError(resp))
+		}
+		return nil
+	}()
+	if err != nil {
+		fmt.Printf("⚠️  回报镜像请求 %s 的结果失败: %v\n", id, err)
+	}
+}
@@ -0,0 +1,549 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 任务定义文件格式版本
+const jobVersion = 1
+
+// transferJob 可导出/导入的完整传输任务定义
+type transferJob struct {
+	Version  int               `yaml:"version" json:"version"`
+	Source   string            `yaml:"source" json:"source"`
+	Target   string            `yaml:"target" json:"target"`
+	Options  jobOptions        `yaml:"options,omitempty" json:"options,omitempty"`
+	Metadata map[string]string `yaml:"metadata,omitempty" json:"metadata,omitempty"`
+}
+
+// jobOptions 任务中记录的上传选项
+type jobOptions struct {
+	Pipeline      string   `yaml:"pipeline,omitempty" json:"pipeline,omitempty"`
+	ForceCompress bool     `yaml:"force_compress,omitempty" json:"force_compress,omitempty"`
+	MaxDuration   string   `yaml:"max_duration,omitempty" json:"max_duration,omitempty"`
+	Name          string   `yaml:"name,omitempty" json:"name,omitempty"`
+	Version       string   `yaml:"version,omitempty" json:"version,omitempty"`
+	Labels        []string `yaml:"labels,omitempty" json:"labels,omitempty"`
+	RemoteLoad    bool     `yaml:"remote_load,omitempty" json:"remote_load,omitempty"`
+	RemoteTag     string   `yaml:"remote_tag,omitempty" json:"remote_tag,omitempty"`
+	Smoke         string   `yaml:"smoke,omitempty" json:"smoke,omitempty"`
+	Preflight     bool     `yaml:"preflight,omitempty" json:"preflight,omitempty"`
+	RequireArch   bool     `yaml:"require_arch_match,omitempty" json:"require_arch_match,omitempty"`
+	ClockSkew     string   `yaml:"clock_skew,omitempty" json:"clock_skew,omitempty"`
+	ReadLimit     string   `yaml:"read_limit,omitempty" json:"read_limit,omitempty"`
+	LimitRate     string   `yaml:"limit_rate,omitempty" json:"limit_rate,omitempty"`
+	Via           string   `yaml:"via,omitempty" json:"via,omitempty"`
+	Negotiate     bool     `yaml:"negotiate,omitempty" json:"negotiate,omitempty"`
+	SPN           string   `yaml:"spn,omitempty" json:"spn,omitempty"`
+	ProxyNTLM     string   `yaml:"proxy_ntlm,omitempty" json:"proxy_ntlm,omitempty"`
+	Pushgateway   string   `yaml:"pushgateway_url,omitempty" json:"pushgateway_url,omitempty"`
+	Preset        string   `yaml:"preset,omitempty" json:"preset,omitempty"`
+	ASCIIName     bool     `yaml:"ascii_name,omitempty" json:"ascii_name,omitempty"`
+	Checksum      bool     `yaml:"checksum,omitempty" json:"checksum,omitempty"`
+	Compress      string   `yaml:"compress,omitempty" json:"compress,omitempty"`
+	ZstdDict      bool     `yaml:"zstd_dict,omitempty" json:"zstd_dict,omitempty"`
+	EncryptKey    string   `yaml:"encrypt_key,omitempty" json:"encrypt_key,omitempty"`
+	BasicAuth     string   `yaml:"basic_auth,omitempty" json:"basic_auth,omitempty"` // 只记录用户名，密码执行时读取环境变量
+	Headers       []string `yaml:"headers,omitempty" json:"headers,omitempty"`
+	Proxy         string   `yaml:"proxy,omitempty" json:"proxy,omitempty"`
+	CACert        string   `yaml:"ca_cert,omitempty" json:"ca_cert,omitempty"`
+	ClientCert    string   `yaml:"client_cert,omitempty" json:"client_cert,omitempty"`
+	ClientKey     string   `yaml:"client_key,omitempty" json:"client_key,omitempty"`
+	Insecure      bool     `yaml:"insecure,omitempty" json:"insecure,omitempty"`
+	Tus           bool     `yaml:"tus,omitempty" json:"tus,omitempty"`
+	TusChunkSize  string   `yaml:"tus_chunk_size,omitempty" json:"tus_chunk_size,omitempty"`
+
+	ParallelChunks int    `yaml:"parallel_chunks,omitempty" json:"parallel_chunks,omitempty"`
+	PartSize       string `yaml:"part_size,omitempty" json:"part_size,omitempty"`
+	S3Endpoint     string `yaml:"s3_endpoint,omitempty" json:"s3_endpoint,omitempty"`
+	S3Region       string `yaml:"s3_region,omitempty" json:"s3_region,omitempty"`
+	SSHKey         string `yaml:"ssh_key,omitempty" json:"ssh_key,omitempty"`
+	DedupLayers    bool   `yaml:"dedup_layers,omitempty" json:"dedup_layers,omitempty"`
+	IfExists       string `yaml:"if_exists,omitempty" json:"if_exists,omitempty"`
+	Format         string `yaml:"format,omitempty" json:"format,omitempty"`
+
+	MaxResponse   string   `yaml:"max_response_bytes,omitempty" json:"max_response_bytes,omitempty"`
+	Retries       int      `yaml:"retries,omitempty" json:"retries,omitempty"`
+	RetryBackoff  string   `yaml:"retry_backoff,omitempty" json:"retry_backoff,omitempty"`
+	RetryBudget   *int     `yaml:"retry_budget,omitempty" json:"retry_budget,omitempty"`
+	RetryDeadline string   `yaml:"retry_deadline,omitempty" json:"retry_deadline,omitempty"`
+	Include       []string `yaml:"include,omitempty" json:"include,omitempty"`
+	Exclude       []string `yaml:"exclude,omitempty" json:"exclude,omitempty"`
+	ExtractTo     string   `yaml:"extract_to,omitempty" json:"extract_to,omitempty"`
+	Sums          bool     `yaml:"sums,omitempty" json:"sums,omitempty"`
+	SumsKey       string   `yaml:"sums_key,omitempty" json:"sums_key,omitempty"`
+
+	DockerHost     string `yaml:"docker_host,omitempty" json:"docker_host,omitempty"`
+	DockerContext  string `yaml:"docker_context,omitempty" json:"docker_context,omitempty"`
+	DockerCertPath string `yaml:"docker_cert_path,omitempty" json:"docker_cert_path,omitempty"`
+	Pull           bool   `yaml:"pull,omitempty" json:"pull,omitempty"`
+	PruneAfter     bool   `yaml:"prune_after_upload,omitempty" json:"prune_after_upload,omitempty"`
+
+	ResponseTimeout string `yaml:"response_timeout,omitempty" json:"response_timeout,omitempty"`
+
+	SLAMinSpeed    string `yaml:"sla_min_speed,omitempty" json:"sla_min_speed,omitempty"`
+	SLAWindow      string `yaml:"sla_window,omitempty" json:"sla_window,omitempty"`
+	SLAMaxDuration string `yaml:"sla_max_duration,omitempty" json:"sla_max_duration,omitempty"`
+	SLAWebhook     string `yaml:"sla_webhook,omitempty" json:"sla_webhook,omitempty"`
+	SLAFail        bool   `yaml:"sla_fail,omitempty" json:"sla_fail,omitempty"`
+
+	ProgressURL      string `yaml:"progress_url,omitempty" json:"progress_url,omitempty"`
+	ProgressInterval string `yaml:"progress_interval,omitempty" json:"progress_interval,omitempty"`
+	ProgressChunks   bool   `yaml:"progress_chunks,omitempty" json:"progress_chunks,omitempty"`
+
+	FormBoundary     string `yaml:"form_boundary,omitempty" json:"form_boundary,omitempty"`
+	FormHeaderCase   string `yaml:"form_header_case,omitempty" json:"form_header_case,omitempty"`
+	FormHeaderOrder  string `yaml:"form_header_order,omitempty" json:"form_header_order,omitempty"`
+	FilenameEncoding string `yaml:"filename_encoding,omitempty" json:"filename_encoding,omitempty"`
+
+	FormFieldName string   `yaml:"form_field_name,omitempty" json:"form_field_name,omitempty"`
+	FormFields    []string `yaml:"form_fields,omitempty" json:"form_fields,omitempty"`
+}
+
+// job 子命令：job export / job import
+func runJob(args []string, cfg *Config) {
+	if len(args) == 0 {
+		fmt.Println("用法: docker_save_shell job export|import ...")
+		os.Exit(1)
+	}
+
+	var err error
+	switch args[0] {
+	case "export":
+		err = runJobExport(args[1:], cfg)
+	case "import":
+		err = runJobImport(args[1:])
+	default:
+		err = fmt.Errorf("未知的 job 子命令: %s", args[0])
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// 将上传参数序列化为任务定义文件
+func runJobExport(args []string, cfg *Config) error {
+	var opts options
+	var meta stringList
+	var output string
+
+	fs := flag.NewFlagSet("job export", flag.ExitOnError)
+	registerUploadFlags(fs, &opts)
+	fs.Var(&meta, "meta", "附加元数据 key=value，可重复指定")
+	fs.StringVar(&output, "o", "", "输出文件 (默认输出到标准输出)")
+	fs.Parse(args)
+	if err := applyConfig(fs, &opts, cfg); err != nil {
+		return err
+	}
+
+	if opts.filePath == "" || opts.serverURL == "" {
+		return errors.New("错误：缺少必要参数 -file 或 -url")
+	}
+
+	job, err := jobFromOptions(opts, meta)
+	if err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("序列化任务失败: %w", err)
+	}
+
+	if output == "" {
+		os.Stdout.Write(data)
+		return nil
+	}
+	if err := os.WriteFile(output, data, 0o644); err != nil {
+		return fmt.Errorf("写入任务文件失败: %w", err)
+	}
+	fmt.Printf("✅ 任务已导出: %s\n", output)
+	return nil
+}
+
+// 读取任务定义文件并执行
+func runJobImport(args []string) error {
+	var dryRun bool
+	fs := flag.NewFlagSet("job import", flag.ExitOnError)
+	fs.BoolVar(&dryRun, "dry-run", false, "只显示任务内容，不执行")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("用法: docker_save_shell job import [-dry-run] job.yaml")
+	}
+
+	job, err := loadJob(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	opts, err := job.options()
+	if err != nil {
+		return err
+	}
+
+	job.print()
+	if dryRun {
+		return nil
+	}
+
+	runUpload(opts)
+	return nil
+}
+
+// 由上传选项构造任务定义
+func jobFromOptions(opts options, meta []string) (*transferJob, error) {
+	job := &transferJob{
+		Version: jobVersion,
+		Source:  opts.filePath,
+		Target:  opts.serverURL,
+		Options: jobOptions{
+			Pipeline:      opts.pipeline,
+			ForceCompress: opts.forceCompress,
+			Name:          opts.artifactName,
+			Version:       opts.artifactVersion,
+			Labels:        opts.labels,
+			RemoteLoad:    opts.remoteLoad,
+			RemoteTag:     opts.remoteTag,
+			Smoke:         opts.smoke,
+			Preflight:     opts.preflight,
+			RequireArch:   opts.requireArch,
+			Via:           opts.via,
+			Negotiate:     opts.negotiate,
+			SPN:           opts.spn,
+			ProxyNTLM:     credentialUser(opts.proxyNTLM),
+			Pushgateway:   opts.pushgateway,
+			Preset:        opts.preset,
+			ASCIIName:     opts.asciiName,
+			Checksum:      opts.checksum,
+			Compress:      opts.compress,
+			ZstdDict:      opts.zstdDict,
+			EncryptKey:    opts.encryptKey,
+			BasicAuth:     credentialUser(opts.basicAuth),
+			Headers:       opts.headers,
+			Proxy:         opts.proxy,
+			CACert:        opts.tls.caCert,
+			ClientCert:    opts.tls.clientCert,
+			ClientKey:     opts.tls.clientKey,
+			Insecure:      opts.tls.insecure,
+			Tus:           opts.tus,
+			Include:       opts.include,
+			Exclude:       opts.exclude,
+			ExtractTo:     opts.extractTo,
+			Sums:          opts.sums,
+			SumsKey:       opts.sumsKey,
+
+			DockerHost:     opts.docker.host,
+			DockerContext:  opts.docker.context,
+			DockerCertPath: opts.docker.certPath,
+			Pull:           opts.pull,
+			PruneAfter:     opts.pruneAfterUpload,
+
+			ProgressURL:      opts.progressURL,
+			ProgressInterval: opts.progressInterval.String(),
+			ProgressChunks:   opts.progressChunks,
+		},
+	}
+
+	// 记录绝对路径，保证换一个工作目录或换一个操作人执行时结果一致
+	if !isRemoteSource(job.Source) {
+		abs, err := filepath.Abs(job.Source)
+		if err != nil {
+			return nil, fmt.Errorf("解析源文件路径失败: %w", err)
+		}
+		job.Source = abs
+	}
+
+	if opts.maxDuration > 0 {
+		job.Options.MaxDuration = opts.maxDuration.String()
+	}
+	if opts.responseTimeout != defaultResponseTimeout {
+		job.Options.ResponseTimeout = opts.responseTimeout.String()
+	}
+	if opts.sla.minSpeed > 0 {
+		job.Options.SLAMinSpeed = strconv.FormatInt(int64(opts.sla.minSpeed), 10)
+		job.Options.SLAWindow = opts.sla.window.String()
+	}
+	if opts.sla.maxDuration > 0 {
+		job.Options.SLAMaxDuration = opts.sla.maxDuration.String()
+	}
+	if opts.tus && opts.tusChunk != defaultTusChunkSize {
+		job.Options.TusChunkSize = strconv.FormatInt(int64(opts.tusChunk), 10)
+	}
+	if opts.parallelChunks > 0 {
+		job.Options.ParallelChunks = opts.parallelChunks
+	}
+	if opts.partSize != defaultPartSize {
+		job.Options.PartSize = strconv.FormatInt(int64(opts.partSize), 10)
+	}
+	job.Options.S3Endpoint, job.Options.S3Region = opts.s3.endpoint, opts.s3.region
+	job.Options.DedupLayers = opts.dedupLayers
+	job.Options.IfExists = opts.ifExists
+	job.Options.SSHKey = opts.sshKey
+	job.Options.Format = opts.format
+	if opts.retries > 0 {
+		job.Options.Retries = opts.retries
+		job.Options.RetryBackoff = opts.retryBackoff.String()
+	}
+	if opts.retryBudget != defaultRetryBudget {
+		job.Options.RetryBudget = &opts.retryBudget
+	}
+	if opts.retryDeadline > 0 {
+		job.Options.RetryDeadline = opts.retryDeadline.String()
+	}
+	if opts.maxResponse != defaultMaxResponse && opts.maxResponse > 0 {
+		job.Options.MaxResponse = strconv.FormatInt(int64(opts.maxResponse), 10)
+	}
+	if opts.readLimit > 0 {
+		job.Options.ReadLimit = strconv.FormatInt(int64(opts.readLimit), 10)
+	}
+	if opts.limitRate > 0 {
+		job.Options.LimitRate = strconv.FormatInt(int64(opts.limitRate), 10)
+	}
+	if opts.clockSkew != clockSkewWarn {
+		job.Options.ClockSkew = opts.clockSkew
+	}
+	job.Options.SLAWebhook = opts.sla.webhook
+	job.Options.SLAFail = opts.sla.fail
+
+	// 表单格式只记录与默认值不同的设置
+	job.Options.FormBoundary = opts.form.boundary
+	if opts.form.headerCase != "canonical" {
+		job.Options.FormHeaderCase = opts.form.headerCase
+	}
+	if opts.form.headerOrder != "disposition-first" {
+		job.Options.FormHeaderOrder = opts.form.headerOrder
+	}
+	if opts.form.filenameEncoding != "auto" {
+		job.Options.FilenameEncoding = opts.form.filenameEncoding
+	}
+	if opts.form.fieldName != "file" {
+		job.Options.FormFieldName = opts.form.fieldName
+	}
+	job.Options.FormFields = opts.form.fields
+
+	for _, kv := range meta {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("元数据格式错误 (应为 key=value): %s", kv)
+		}
+		if job.Metadata == nil {
+			job.Metadata = map[string]string{}
+		}
+		job.Metadata[key] = value
+	}
+	return job, nil
+}
+
+// 读取并解析任务定义文件
+func loadJob(path string) (*transferJob, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取任务文件失败: %w", err)
+	}
+	return parseJob(data)
+}
+
+// 解析任务定义 (YAML 或 JSON)
+func parseJob(data []byte) (*transferJob, error) {
+	job := &transferJob{}
+	if err := yaml.Unmarshal(data, job); err != nil {
+		return nil, fmt.Errorf("解析任务文件失败: %w", err)
+	}
+	if job.Version > jobVersion {
+		return nil, fmt.Errorf("任务文件版本 %d 高于当前支持的版本 %d，请升级工具", job.Version, jobVersion)
+	}
+	if job.Source == "" || job.Target == "" {
+		return nil, errors.New("任务文件缺少 source 或 target")
+	}
+	return job, nil
+}
+
+// 将任务定义还原为上传选项
+func (j *transferJob) options() (options, error) {
+	opts := options{
+		filePath:        j.Source,
+		serverURL:       j.Target,
+		pipeline:        j.Options.Pipeline,
+		forceCompress:   j.Options.ForceCompress,
+		artifactName:    j.Options.Name,
+		artifactVersion: j.Options.Version,
+		labels:          j.Options.Labels,
+		remoteLoad:      j.Options.RemoteLoad,
+		remoteTag:       j.Options.RemoteTag,
+		smoke:           j.Options.Smoke,
+		preflight:       j.Options.Preflight,
+		requireArch:     j.Options.RequireArch,
+		clockSkew:       j.Options.ClockSkew,
+		via:             j.Options.Via,
+		negotiate:       j.Options.Negotiate,
+		spn:             j.Options.SPN,
+		proxyNTLM:       j.Options.ProxyNTLM,
+		pushgateway:     j.Options.Pushgateway,
+		preset:          j.Options.Preset,
+		asciiName:       j.Options.ASCIIName,
+		checksum:        j.Options.Checksum,
+		compress:        j.Options.Compress,
+		zstdDict:        j.Options.ZstdDict,
+		encryptKey:      j.Options.EncryptKey,
+		basicAuth:       j.Options.BasicAuth,
+		headers:         j.Options.Headers,
+		proxy:           j.Options.Proxy,
+		tus:             j.Options.Tus,
+		retries:         j.Options.Retries,
+		retryBackoff:    2 * time.Second,
+		retryBudget:     defaultRetryBudget,
+		responseTimeout: defaultResponseTimeout,
+		include:         j.Options.Include,
+		exclude:         j.Options.Exclude,
+		extractTo:       j.Options.ExtractTo,
+		sums:            j.Options.Sums,
+		sumsKey:         j.Options.SumsKey,
+		progressURL:     j.Options.ProgressURL,
+		progressChunks:  j.Options.ProgressChunks,
+		pull:            j.Options.Pull,
+		docker: dockerEndpoint{
+			host:     j.Options.DockerHost,
+			context:  j.Options.DockerContext,
+			certPath: j.Options.DockerCertPath,
+		},
+		tls: tlsClientOptions{
+			caCert:     j.Options.CACert,
+			clientCert: j.Options.ClientCert,
+			clientKey:  j.Options.ClientKey,
+			insecure:   j.Options.Insecure,
+		},
+	}
+	if j.Options.MaxDuration != "" {
+		d, err := time.ParseDuration(j.Options.MaxDuration)
+		if err != nil {
+			return opts, fmt.Errorf("max_duration 格式错误: %w", err)
+		}
+		opts.maxDuration = d
+	}
+
+	if j.Options.TusChunkSize != "" {
+		if err := opts.tusChunk.Set(j.Options.TusChunkSize); err != nil {
+			return opts, fmt.Errorf("tus_chunk_size 格式错误: %w", err)
+		}
+	}
+	opts.parallelChunks = j.Options.ParallelChunks
+	opts.partSize = defaultPartSize
+	opts.s3 = s3Options{endpoint: j.Options.S3Endpoint, region: j.Options.S3Region}
+	opts.sshKey = j.Options.SSHKey
+	opts.dedupLayers = j.Options.DedupLayers
+	opts.ifExists = j.Options.IfExists
+	opts.format = j.Options.Format
+	if j.Options.PartSize != "" {
+		if err := opts.partSize.Set(j.Options.PartSize); err != nil {
+			return opts, fmt.Errorf("part_size 格式错误: %w", err)
+		}
+	}
+	if j.Options.RetryBackoff != "" {
+		d, err := time.ParseDuration(j.Options.RetryBackoff)
+		if err != nil {
+			return opts, fmt.Errorf("retry_backoff 格式错误: %w", err)
+		}
+		opts.retryBackoff = d
+	}
+	if j.Options.RetryBudget != nil {
+		opts.retryBudget = *j.Options.RetryBudget
+	}
+	if j.Options.RetryDeadline != "" {
+		d, err := time.ParseDuration(j.Options.RetryDeadline)
+		if err != nil {
+			return opts, fmt.Errorf("retry_deadline 格式错误: %w", err)
+		}
+		opts.retryDeadline = d
+	}
+	if j.Options.MaxResponse != "" {
+		if err := opts.maxResponse.Set(j.Options.MaxResponse); err != nil {
+			return opts, fmt.Errorf("max_response_bytes 格式错误: %w", err)
+		}
+	}
+	if j.Options.ReadLimit != "" {
+		if err := opts.readLimit.Set(j.Options.ReadLimit); err != nil {
+			return opts, fmt.Errorf("read_limit 格式错误: %w", err)
+		}
+	}
+	if j.Options.LimitRate != "" {
+		if err := opts.limitRate.Set(j.Options.LimitRate); err != nil {
+			return opts, fmt.Errorf("limit_rate 格式错误: %w", err)
+		}
+	}
+	if j.Options.ProgressInterval != "" {
+		if err := opts.progressInterval.Set(j.Options.ProgressInterval); err != nil {
+			return opts, fmt.Errorf("progress_interval 格式错误: %w", err)
+		}
+	}
+
+	opts.pruneAfterUpload = j.Options.PruneAfter
+	opts.sla.webhook, opts.sla.fail = j.Options.SLAWebhook, j.Options.SLAFail
+	opts.form = formOptions{
+		boundary:         j.Options.FormBoundary,
+		headerCase:       j.Options.FormHeaderCase,
+		headerOrder:      j.Options.FormHeaderOrder,
+		filenameEncoding: j.Options.FilenameEncoding,
+		fieldName:        j.Options.FormFieldName,
+		fields:           j.Options.FormFields,
+	}
+	if j.Options.SLAMinSpeed != "" {
+		if err := opts.sla.minSpeed.Set(j.Options.SLAMinSpeed); err != nil {
+			return opts, fmt.Errorf("sla_min_speed 格式错误: %w", err)
+		}
+	}
+	for _, d := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"response_timeout", j.Options.ResponseTimeout, &opts.responseTimeout},
+		{"sla_window", j.Options.SLAWindow, &opts.sla.window},
+		{"sla_max_duration", j.Options.SLAMaxDuration, &opts.sla.maxDuration},
+	} {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return opts, fmt.Errorf("%s 格式错误: %w", d.name, err)
+		}
+		*d.dst = v
+	}
+	return opts, nil
+}
+
+// 显示任务内容，便于执行前复核
+func (j *transferJob) print() {
+	fmt.Println("📋 传输任务:")
+	fmt.Printf("   源:   %s\n", j.Source)
+	fmt.Printf("   目标: %s\n", j.Target)
+	if j.Options.Pipeline != "" {
+		fmt.Printf("   流水线: %s\n", j.Options.Pipeline)
+	}
+	if j.Options.Name != "" {
+		fmt.Printf("   制品: %s@%s\n", j.Options.Name, j.Options.Version)
+	}
+	if j.Options.MaxDuration != "" {
+		fmt.Printf("   最大时长: %s\n", j.Options.MaxDuration)
+	}
+
+	keys := make([]string, 0, len(j.Metadata))
+	for k := range j.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Printf("   %s: %s\n", k, j.Metadata[k])
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// load 子命令：将镜像归档加载到本机 (或 -docker-host 指定的) docker，gzip/zstd 压缩的归档边解压边加载
+func runLoad(args []string) {
+	var docker dockerEndpoint
+	var tag string
+	fs := newCommandFlags("load")
+	fs.StringVar(&docker.host, "docker-host", "", "加载到的守护进程地址 (ssh://user@host 或 tcp://host:2376)，默认按 DOCKER_HOST/DOCKER_CONTEXT")
+	fs.StringVar(&docker.context, "docker-context", "", "加载使用的 docker context")
+	fs.StringVar(&docker.certPath, "docker-cert-path", "", "tcp:// 形式的 -docker-host 启用 TLS 时的证书目录")
+	fs.StringVar(&tag, "tag", "", "给加载的镜像打上的标签 (归档中只能有一个镜像)")
+	files := parseInterspersed(fs, args)
+
+	if len(files) == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := docker.validate(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if tag != "" && len(files) > 1 {
+		fmt.Println("错误：-tag 只能用于一个归档")
+		os.Exit(1)
+	}
+	for _, file := range files {
+		loaded, err := loadArchive(docker, file)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("🐳 已加载镜像: %s\n", strings.Join(loaded, ", "))
+		if tag == "" {
+			continue
+		}
+		if len(loaded) != 1 {
+			fmt.Printf("错误：%s 中有 %d 个镜像，无法打标签\n", file, len(loaded))
+			os.Exit(1)
+		}
+		if _, err := docker.run("tag", loaded[0], tag); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("🏷️  已打标签: %s\n", tag)
+	}
+}
+
+// 将归档 (- 为标准输入) 以 docker load 加载，返回加载的镜像
+func loadArchive(docker dockerEndpoint, file string) ([]string, error) {
+	var src io.Reader = os.Stdin
+	size := int64(-1)
+	name := "stdin"
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if info, err := f.Stat(); err == nil {
+			size = info.Size()
+		}
+		src, name = f, filepath.Base(file)
+	}
+
+	bar := newTransferBar(size, "📥 加载 "+name)
+	br := bufio.NewReaderSize(io.TeeReader(src, bar), 1<<20)
+	var r io.Reader = br
+	dr, format, err := decompressReader(br)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if dr != nil {
+		defer dr.Close()
+		r = dr
+		fmt.Printf("🗜️  %s 压缩，边解压边加载\n", format)
+	}
+
+	cmd := docker.command(context.Background(), "load")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = r, &stdout, &stderr
+	err = cmd.Run()
+	bar.Finish()
+	fmt.Println()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return nil, fmt.Errorf("docker load %s 失败: %s", name, msg)
+	}
+	return parseLoadedImages(stdout.String()), nil
+}
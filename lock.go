@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 其他主机持有的锁超过该时长未释放即视为残留锁
+const lockStaleAfter = 24 * time.Hour
+
+// 内容无法解析的锁文件在创建后该时长内视为正在写入
+const lockWriteGrace = 10 * time.Second
+
+// 锁文件内容，记录持有者信息便于判断是否残留
+type lockInfo struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Key     string    `json:"key"`
+	Created time.Time `json:"created"`
+}
+
+// fileLock 同一个上传对象 (文件 / 续传会话) 的单实例锁
+type fileLock struct {
+	path string
+}
+
+// 获取 key 对应的锁，key 通常是待上传文件的路径或 URL
+// 锁已被其他存活进程持有时返回错误；持有者已退出或锁已过期时自动接管；
+// force 为 true 时无条件清除旧锁。
+func acquireLock(key string, force bool) (*fileLock, error) {
+	if !isRemoteSource(key) {
+		if abs, err := filepath.Abs(key); err == nil {
+			key = abs
+		}
+	}
+
+	dir := filepath.Join(stateDir(), "locks")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("创建锁目录失败: %w", err)
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	path := filepath.Join(dir, hex.EncodeToString(sum[:8])+".lock")
+
+	if force {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("清除锁文件失败: %w", err)
+		}
+	}
+
+	host, _ := os.Hostname()
+	data, _ := json.Marshal(lockInfo{
+		PID:     os.Getpid(),
+		Host:    host,
+		Key:     key,
+		Created: time.Now(),
+	})
+
+	// 先写好临时文件再硬链接到锁文件路径，链接是原子的且目标已存在时失败，
+	// 其他实例不会看到内容尚未写入的锁文件而误判为残留
+	tmp, err := os.CreateTemp(dir, ".lock-*")
+	if err != nil {
+		return nil, fmt.Errorf("创建锁文件失败: %w", err)
+	}
+	_, werr := tmp.Write(data)
+	cerr := tmp.Close()
+	defer os.Remove(tmp.Name())
+	if werr != nil || cerr != nil {
+		return nil, fmt.Errorf("写入锁文件失败: %w", errors.Join(werr, cerr))
+	}
+
+	// 最多尝试两次：第一次失败且判定为残留锁时清除后重试
+	for attempt := 0; attempt < 2; attempt++ {
+		err := os.Link(tmp.Name(), path)
+		if err != nil && !errors.Is(err, os.ErrExist) {
+			// 文件系统不支持硬链接时退回直接创建
+			err = writeLockFile(path, data)
+		}
+		if err == nil {
+			return &fileLock{path: path}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("创建锁文件失败: %w", err)
+		}
+
+		holder, stale := inspectLock(path, host)
+		if !stale && holder.PID == 0 {
+			return nil, fmt.Errorf("%s 正在被其他实例加锁，请稍后重试", key)
+		}
+		if !stale {
+			return nil, fmt.Errorf("%s 正在被进程 %d@%s 处理 (自 %s 起)，如确认无其他实例运行可使用 --force-unlock",
+				key, holder.PID, holder.Host, holder.Created.Format(time.DateTime))
+		}
+
+		fmt.Printf("⚠️  清除残留锁: %s\n", path)
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("清除残留锁失败: %w", err)
+		}
+	}
+
+	return nil, fmt.Errorf("获取锁 %s 失败，请稍后重试", path)
+}
+
+// 以 O_EXCL 创建锁文件并写入内容
+func writeLockFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	_, werr := f.Write(data)
+	cerr := f.Close()
+	if werr != nil || cerr != nil {
+		os.Remove(path)
+		return fmt.Errorf("写入锁文件失败: %w", errors.Join(werr, cerr))
+	}
+	return nil
+}
+
+// 读取锁文件并判断是否为残留锁
+func inspectLock(path, host string) (lockInfo, bool) {
+	var info lockInfo
+
+	data, err := os.ReadFile(path)
+	if err != nil || json.Unmarshal(data, &info) != nil {
+		// 刚创建的锁文件可能还在写入 (不支持硬链接的文件系统)，视为被持有；
+		// 更早的锁文件损坏 (例如写入途中断电)，视为残留
+		if st, serr := os.Stat(path); serr == nil && time.Since(st.ModTime()) < lockWriteGrace {
+			info.Created = st.ModTime()
+			return info, false
+		}
+		return info, true
+	}
+
+	if info.Host == host {
+		return info, !processAlive(info.PID)
+	}
+	return info, time.Since(info.Created) > lockStaleAfter
+}
+
+// 释放锁
+func (l *fileLock) Release() {
+	if l == nil {
+		return
+	}
+	os.Remove(l.path)
+}
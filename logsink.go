@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 日志级别，取值与 syslog 的 severity 一致
+type logSeverity int
+
+const (
+	severityError   logSeverity = 3
+	severityWarning logSeverity = 4
+	severityInfo    logSeverity = 6
+)
+
+// logEntry 一条结构化日志
+type logEntry struct {
+	Time     time.Time
+	Severity logSeverity
+	Event    string            // 事件名，如 received / failed
+	Message  string            // 供人阅读的描述
+	Fields   map[string]string // 结构化字段
+}
+
+// logSink 将结构化日志发送到集中日志系统，供 daemon 和 serve 使用
+type logSink interface {
+	write(e *logEntry) error
+	Close() error
+}
+
+// 按 -log-sink 打开日志输出:
+//
+//	syslog+udp://host:514、syslog+tcp://host:514、syslog+tls://host:6514 (RFC 5424)
+//	eventlog 或 eventlog://<source> (Windows 事件日志)
+//
+// syslog 地址可带参数 ?facility=local0 (默认 daemon)，tls 可带 ?ca=/path/ca.pem
+func openLogSink(spec string) (logSink, error) {
+	if spec == "eventlog" {
+		return openEventLog(syslogAppName)
+	}
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("日志输出地址格式错误: %w", err)
+	}
+	switch u.Scheme {
+	case "eventlog":
+		source := u.Host
+		if source == "" {
+			source = syslogAppName
+		}
+		return openEventLog(source)
+	case "syslog+udp", "syslog+tcp", "syslog+tls":
+		return newSyslogSink(u)
+	}
+	return nil, fmt.Errorf("不支持的日志输出: %s (可选 syslog+udp://、syslog+tcp://、syslog+tls://、eventlog)", spec)
+}
+
+// 发送失败只输出警告，日志系统不可用不影响传输
+func sendLog(sink logSink, e *logEntry) {
+	if sink == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if err := sink.write(e); err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️  发送日志失败: %v\n", err)
+	}
+}
+
+// syslog 消息中的应用名，同时作为 Windows 事件日志的默认事件源
+const syslogAppName = "docker_save_shell"
+
+// 结构化数据的 SD-ID (name@<私有企业号>，32473 为 RFC 5612 保留给文档示例的企业号)
+const syslogSDID = "dss@32473"
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "daemon": 3, "auth": 4, "syslog": 5,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogSink 以 RFC 5424 格式发送到 syslog 服务器；
+// TCP/TLS 使用 RFC 6587 的长度前缀分帧，连接断开时在下一条日志重新连接
+type syslogSink struct {
+	network  string // udp / tcp
+	addr     string
+	tls      *tls.Config
+	facility int
+	hostname string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newSyslogSink(u *url.URL) (*syslogSink, error) {
+	s := &syslogSink{network: "tcp", addr: u.Host, facility: syslogFacilities["daemon"]}
+	if u.Port() == "" {
+		port := "514"
+		if u.Scheme == "syslog+tls" {
+			port = "6514"
+		}
+		s.addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	switch u.Scheme {
+	case "syslog+udp":
+		s.network = "udp"
+	case "syslog+tls":
+		s.tls = &tls.Config{ServerName: u.Hostname()}
+		if ca := u.Query().Get("ca"); ca != "" {
+			pem, err := os.ReadFile(ca)
+			if err != nil {
+				return nil, fmt.Errorf("读取 CA 证书失败: %w", err)
+			}
+			s.tls.RootCAs = x509.NewCertPool()
+			if !s.tls.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("CA 证书 %s 中没有有效的证书", ca)
+			}
+		}
+	}
+	if name := u.Query().Get("facility"); name != "" {
+		f, ok := syslogFacilities[name]
+		if !ok {
+			return nil, fmt.Errorf("不支持的 syslog facility: %s", name)
+		}
+		s.facility = f
+	}
+	s.hostname, _ = os.Hostname()
+	if s.hostname == "" {
+		s.hostname = "-"
+	}
+
+	// 启动时先连接一次，地址或证书错误尽早暴露
+	if err := s.connect(); err != nil {
+		return nil, fmt.Errorf("连接 syslog 服务器失败: %w", err)
+	}
+	return s, nil
+}
+
+func (s *syslogSink) connect() error {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var err error
+	if s.tls != nil {
+		s.conn, err = tls.DialWithDialer(dialer, "tcp", s.addr, s.tls)
+	} else {
+		s.conn, err = dialer.Dial(s.network, s.addr)
+	}
+	return err
+}
+
+func (s *syslogSink) write(e *logEntry) error {
+	msg := s.format(e)
+	if s.network != "udp" {
+		msg = fmt.Appendf(nil, "%d %s", len(msg), msg)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// 连接可能已被服务端关闭，失败时重连后再试一次
+	for attempt := 0; ; attempt++ {
+		if s.conn == nil {
+			if err := s.connect(); err != nil {
+				return err
+			}
+		}
+		s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		_, err := s.conn.Write(msg)
+		if err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+		if attempt > 0 {
+			return err
+		}
+	}
+}
+
+// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [SD-ID k="v" ...] BOM MSG
+func (s *syslogSink) format(e *logEntry) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %d %s ", s.facility*8+int(e.Severity),
+		e.Time.Format("2006-01-02T15:04:05.000000Z07:00"), s.hostname, syslogAppName, os.Getpid(), syslogField(e.Event, 32))
+
+	if len(e.Fields) == 0 {
+		b.WriteString("-")
+	} else {
+		b.WriteString("[" + syslogSDID)
+		for _, k := range sortedKeys(e.Fields) {
+			fmt.Fprintf(&b, ` %s="%s"`, syslogField(k, 32), sdEscaper.Replace(e.Fields[k]))
+		}
+		b.WriteString("]")
+	}
+	if e.Message != "" {
+		b.WriteString(" \ufeff" + e.Message) // MSG 以 BOM 开头表示 UTF-8
+	}
+	return []byte(b.String())
+}
+
+// 结构化数据参数值中需转义的字符
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// MSGID、参数名等头部字段只允许可见 ASCII 字符，且不能包含 = ] " 和空格
+func syslogField(s string, maxLen int) string {
+	s = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, s)
+	if s == "" {
+		return "-"
+	}
+	if len(s) > maxLen {
+		s = s[:maxLen]
+	}
+	return s
+}
+
+func (s *syslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// 按键排序，保证输出稳定
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Windows 事件日志的正文：描述加上逐行的 key=value 字段
+func eventLogText(e *logEntry) string {
+	var b strings.Builder
+	b.WriteString(e.Message)
+	for _, k := range sortedKeys(e.Fields) {
+		fmt.Fprintf(&b, "\n%s=%s", k, e.Fields[k])
+	}
+	return b.String()
+}
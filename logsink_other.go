@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+func openEventLog(source string) (logSink, error) {
+	return nil, errors.New("Windows 事件日志仅在 Windows 上可用")
+}
@@ -0,0 +1,51 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// 事件 ID (EventCreate 注册的事件源接受 1-1000)
+const (
+	eventIDInfo    = 1
+	eventIDWarning = 2
+	eventIDError   = 3
+)
+
+// winEventLog 写入 Windows 应用程序事件日志
+type winEventLog struct {
+	log *eventlog.Log
+}
+
+// 打开事件源；首次使用时尝试注册 (需要管理员权限，已注册时忽略错误)，
+// 未注册的事件源也能写入，只是事件查看器会提示找不到描述
+func openEventLog(source string) (logSink, error) {
+	if err := eventlog.InstallAsEventCreate(source, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil && !strings.Contains(err.Error(), "exists") {
+		fmt.Printf("⚠️  注册事件源 %s 失败 (需要管理员权限): %v\n", source, err)
+	}
+	l, err := eventlog.Open(source)
+	if err != nil {
+		return nil, fmt.Errorf("打开 Windows 事件日志失败: %w", err)
+	}
+	return &winEventLog{log: l}, nil
+}
+
+func (w *winEventLog) write(e *logEntry) error {
+	text := eventLogText(e)
+	switch {
+	case e.Severity <= severityError:
+		return w.log.Error(eventIDError, text)
+	case e.Severity == severityWarning:
+		return w.log.Warning(eventIDWarning, text)
+	default:
+		return w.log.Info(eventIDInfo, text)
+	}
+}
+
+func (w *winEventLog) Close() error {
+	return w.log.Close()
+}
@@ -1,161 +1,980 @@
 package main
 
 import (
-	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/schollz/progressbar/v3"
+
+	"command_tool/pkg/uploader"
 )
 
-func main() {
-	filePath := flag.String("file", "", "要上传的文件路径 (必须)")
-	serverURL := flag.String("url", "", "后端接收地址 (必须)")
-	flag.Parse()
+// 上传选项
+type options struct {
+	filePath      string
+	profile       string   // 配置文件中的命名配置
+	files         []string // 命令行中所有的 -file，见 sourceList
+	serverURL     string
+	forceUnlock   bool
+	maxDuration   time.Duration
+	pipeline      string
+	forceCompress bool
+	format        string // 交给接收方的归档格式: tar、tar.gz、tar.zst、oci、squashfs
+	verbose       bool
+	quiet         bool   // 不显示进度和过程信息，只输出最终结果
+	progressStyle string // 进度显示方式: bar、plain、none，为空时按 stderr 是否为终端选择
+	preflight     bool
+	requireArch   bool       // 镜像平台与接收端不一致时中止上传 (默认只警告)
+	clockSkew     string     // 时钟偏差的处理方式: warn / adjust / off
+	readLimit     byteSize   // 读取源文件的速度上限 (字节/秒)，与网络限速相互独立
+	limitRate     byteSize   // 发送速度上限 (字节/秒)，按实际发送的 (压缩后) 数据计量
+	via           string     // 经由 SSH 跳板机转发上传请求: [user@]host[:port]
+	negotiate     bool       // 使用 Kerberos 票据进行 SPNEGO 认证
+	spn           string     // Kerberos 服务主体名，默认 HTTP/<目标主机>
+	proxyNTLM     string     // 出口代理的 NTLM 凭据: DOMAIN\user[:password]
+	pushgateway   string     // 传输结束后推送指标的 Prometheus Pushgateway 地址
+	preset        string     // 目标制品库的预设 (nexus-raw 等)，为空时上传到本工具的服务端
+	asciiName     bool       // 上传时将文件名转换为纯 ASCII
+	checksum      bool       // 边上传边计算发送数据的 SHA-256，交给服务端校验
+	compress      string     // 上传时压缩的格式 (gzip、zstd、zstd-fast、zstd-high、auto、none)，是 -pipeline 的简写
+	zstdDict      bool       // zstd 压缩时使用服务端由历史制品训练的字典
+	encryptKey    string     // 加密上传的 AES-256 密钥文件，流水线末尾加入 encrypt 阶段
+	token         string     // 以 Bearer 令牌认证
+	basicAuth     string     // 以 HTTP Basic 认证，user[:password]
+	headers       stringList // 附加的请求头 "名称: 值"
+	proxy         string     // 代理地址，取代 HTTP_PROXY/HTTPS_PROXY
+	tus           bool       // 以 tus 协议上传到 -url，中断后重新执行可续传
+	tusChunk      byteSize   // tus 每次 PATCH 的大小
+	maxResponse   byteSize   // 内存中最多缓存的响应体大小，超出时保存到临时文件
+	attempt       int        // 第几次尝试 (守护进程重试时递增)
+
+	// 本次传输的 ID，附在所有请求上 (X-Transfer-Id) 并记入历史、进度事件和告警，重试时不变；为空时自动生成
+	transferID string
+
+	// 上传失败 (网络错误或 408/429/5xx) 后自动重试的次数和首次等待时间
+	retries      int
+	retryBackoff time.Duration
+
+	// 各层重试共用的预算：合计次数上限和截止时长，retry 为本次传输实际使用的预算
+	retryBudget   int
+	retryDeadline time.Duration
+	retry         *retryBudget
+
+	// 源中已按策略评估过的镜像 (bundle 打包的镜像)，上传时不再读取源
+	policyImages []string
+
+	// -file 为目录时打包的文件过滤规则
+	include   stringList
+	exclude   stringList
+	extractTo string // 服务端将目录归档解包到的路径 (相对于服务端的 --extract-root)
+
+	// 为批次内的文件生成并上传 SHA256SUMS 清单，可选签名
+	sums    bool
+	sumsKey string
+
+	// 版本化制品的名称和版本号，服务端据此保存为不可变版本
+	artifactName    string
+	artifactVersion string
+	labels          stringList // 制品的元数据标签 key=value，可用于 search 查询
+
+	// 上传完成后请求服务端 docker load，并可重新打标签
+	remoteLoad bool
+	remoteTag  string
+	smoke      string // 加载后在新镜像的容器中执行的冒烟命令，通过后才切换 remoteTag
+
+	// 导出 docker-daemon: 镜像所用的守护进程，镜像不存在时是否先拉取
+	docker dockerEndpoint
+	pull   bool
+
+	// -url 为 s3:// 时的对象存储地址和区域
+	s3 s3Options
+
+	// -url 为 sftp:// 时登录所用的私钥，为空时由 ssh 按默认密钥、agent 和 ~/.ssh/config 认证
+	sshKey string
+
+	// 分块并行上传：并发连接数 (0 为普通上传) 和块大小
+	parallelChunks int
+	partSize       byteSize
+
+	// 服务端确认摘要一致后删除源文件或本机镜像
+	pruneAfterUpload bool
+
+	// 请求体发送完后等待服务器响应的时长，与上传本身的耗时无关
+	responseTimeout time.Duration
+
+	// 镜像归档只发送服务端还没有的层
+	dedupLayers bool
+
+	// -file - 从标准输入读取时的上传文件名
+	stdinName string
+
+	// 服务端已有同名文件时的处理方式，为空时按服务端的默认设置
+	ifExists string
+
+	// 传输 SLA 阈值
+	sla slaOptions
+
+	// multipart 表单的格式细节
+	form formOptions
+
+	// HTTPS 的 CA、客户端证书设置
+	tls tlsClientOptions
+
+	// 多个上传共用的发送限速 (并发上传多个文件时由 runFiles 设置)，为 nil 时按 limitRate 单独限速
+	sendLimiter *rateLimiter
+
+	// 以 JSON POST 进度事件的地址，事件的间隔和是否包含 tus 块级事件
+	progressURL      string
+	progressInterval progressInterval
+	progressChunks   bool
+
+	// 读取进度回调 (守护进程等嵌入场景使用)，total 未知时为 -1
+	progress func(done, total int64)
+	// tus 每确认一块的回调：offset 为块之后的位置，n 为块的大小
+	chunkDone func(offset, n int64)
+	hideBar   bool // 不绘制进度条 (由调用方通过 progress 自行显示进度)
+}
 
-	if *filePath == "" || *serverURL == "" {
-		fmt.Println("错误：缺少必要参数")
-		flag.Usage()
+// 注册上传相关的命令行参数
+func registerUploadFlags(fs *flag.FlagSet, opts *options) {
+	fs.StringVar(&opts.profile, "profile", "", "使用配置文件 ~/.docker_save_shell.yaml 中 profiles 下的同名配置 (url、token、ca-cert 等)，命令行参数优先；同时选用 enroll 为该 profile 登记的客户端证书")
+	fs.Var(sourceList{opts}, "file", "要上传的文件路径 (必须)，为目录时即时打包为 tar 上传，docker-daemon:<镜像> 则导出本机镜像上传，- 从标准输入读取；可重复指定或写通配符 (也可作为位置参数)，多个文件依次上传")
+	fs.StringVar(&opts.stdinName, "stdin-name", defaultStdinName, "-file - 从标准输入读取时的上传文件名 (如 app.tar)")
+	fs.Var(&opts.include, "include", "-file 为目录时只打包匹配的文件 (如 *.yaml)，可重复指定")
+	fs.Var(&opts.exclude, "exclude", "-file 为目录时不打包匹配的文件或目录 (如 .git)，可重复指定")
+	fs.BoolVar(&opts.sums, "sums", false, "-file 为目录时同时上传目录内各文件的 SHA256SUMS 清单，解包后可用 sha256sum -c 校验")
+	fs.StringVar(&opts.sumsKey, "sums-key", "", "用该 Ed25519 私钥 (PEM) 对 SHA256SUMS 签名，签名另存为 .sig 文件")
+	fs.StringVar(&opts.extractTo, "extract-to", "", "-file 为目录时由服务端解包到该路径 (相对于服务端的 --extract-root)，原目录被整体替换")
+	fs.StringVar(&opts.serverURL, "url", "", "后端接收地址 (必须)")
+	fs.BoolVar(&opts.forceUnlock, "force-unlock", false, "强制清除该文件残留的锁后再上传")
+	fs.DurationVar(&opts.maxDuration, "max-duration", 0, "最大传输时长 (如 2h)，到期后安全中止并打印继续传输的命令")
+	fs.DurationVar(&opts.responseTimeout, "response-timeout", defaultResponseTimeout, "数据全部发送后等待服务器响应 (校验、加载等) 的最长时间，不限制上传本身的耗时 (0 表示不限)")
+	fs.StringVar(&opts.pipeline, "pipeline", "", "数据处理流水线，例如 read,gzip,upload (默认 "+defaultPipeline+")")
+	fs.BoolVar(&opts.zstdDict, "zstd-dict", false, "zstd 压缩时使用服务端由历史制品训练的字典 (见 dict train)，适合频繁上传的相似小文件；服务端没有字典时按普通 zstd 压缩")
+	fs.StringVar(&opts.compress, "compress", "", "上传时边读取边压缩: gzip、zstd、zstd-fast、zstd-high 或 none，文件名追加 .gz/.zst 后缀 (等同于 -pipeline read,<格式>,upload)；auto 时测量链路带宽和压缩速度，自动选择不压缩或 zstd 的级别")
+	fs.StringVar(&opts.encryptKey, "encrypt-key", "", "以该文件中的 AES-256 密钥 (64 个十六进制字符，可用 openssl rand -hex 32 生成) 加密后上传，文件名追加 .enc 后缀；持有同一密钥的服务端 (serve -decrypt-key) 解密后存储，否则只保存密文，可用 decrypt 子命令解密")
+	fs.BoolVar(&opts.forceCompress, "force-compress", false, "总是压缩，不根据采样结果自动跳过压缩阶段")
+	fs.StringVar(&opts.format, "format", "", "交给接收方的归档格式: tar (默认)、tar.gz、tar.zst、oci (OCI 镜像布局的 tar，仅用于镜像)、squashfs (需要 sqfstar)，在流水线中边读取边转换")
+	fs.BoolVar(&opts.verbose, "verbose", false, "输出详细信息 (包括流水线各阶段统计)")
+	fs.BoolVar(&opts.quiet, "quiet", false, "不显示进度和过程信息，只输出最终结果 (失败时输出错误)")
+	fs.StringVar(&opts.progressStyle, "progress", "", "进度显示方式: bar 进度条，plain 每 10 秒输出一行百分比 (适合 CI 日志)，none 不显示 (默认 stderr 是终端时为 bar，否则为 plain)")
+	registerClientFlags(fs, opts)
+	fs.Var(&opts.limitRate, "limit-rate", "发送速度上限 (每秒，如 10M)，避免大文件占满出口带宽；压缩时按压缩后的数据计量")
+	fs.StringVar(&opts.progressURL, "progress-url", "", "上传过程中以 JSON POST 进度事件到该地址 (started、chunk-progress、done/failed，格式同 daemon -events json)")
+	fs.Var(&opts.progressInterval, "progress-interval", "进度事件的间隔: 时长 (如 10s) 或百分比 (如 5%)，用于 -progress-url 和 daemon 的 JSON 事件 (默认 1s)")
+	fs.BoolVar(&opts.progressChunks, "progress-chunks", false, "-tus 上传时每确认一块额外输出 chunk 事件 (块之后的位置和块大小)")
+	fs.Var(&opts.readLimit, "read-limit", "读取源文件的速度上限 (每秒，如 20M)，用于保护繁忙主机上的机械盘或共享 NFS")
+	fs.StringVar(&opts.clockSkew, "clock-skew", clockSkewWarn, "本机与服务端时钟偏差的处理: warn 提示, adjust 提示并以服务端时间签名, off 不检测")
+	fs.BoolVar(&opts.checksum, "checksum", false, "边上传边计算发送数据的 SHA-256，随表单发送由服务端校验 (预设上传时作为 "+checksumHeader+" trailer 发送)，结束时输出摘要")
+	fs.StringVar(&opts.docker.host, "docker-host", "", "导出 docker-daemon: 镜像的守护进程地址 (ssh://user@构建机 或 tcp://构建机:2376)，镜像经本机转发上传 (默认按 DOCKER_HOST/DOCKER_CONTEXT)")
+	fs.StringVar(&opts.docker.context, "docker-context", "", "导出 docker-daemon: 镜像使用的 docker context (见 docker context ls)")
+	fs.BoolVar(&opts.pruneAfterUpload, "prune-after-upload", false, "服务端确认摘要一致后删除源文件 (docker-daemon: 数据源则 docker rmi 删除镜像)，为磁盘紧张的构建机腾出空间；隐含 -checksum")
+	fs.BoolVar(&opts.pull, "pull", false, "docker-daemon: 镜像不存在时先 docker pull (显示拉取进度)，默认直接报错")
+	fs.StringVar(&opts.docker.certPath, "docker-cert-path", "", "tcp:// 形式的 -docker-host 启用 TLS 时的证书目录 (ca.pem、cert.pem、key.pem)")
+	fs.BoolVar(&opts.requireArch, "require-arch-match", false, "上传镜像归档前检查镜像的 os/arch 与接收端一致，不一致时中止 (默认只警告)")
+	fs.BoolVar(&opts.preflight, "preflight", false, "传输前先发送 HEAD 请求检查 DNS、TLS、鉴权和路由，失败时立即退出")
+	fs.StringVar(&opts.artifactName, "name", "", "制品名称，指定后服务端按版本保存 (需同时指定 -version)")
+	fs.StringVar(&opts.artifactVersion, "version", "", "制品版本号，同一版本不可覆盖")
+	fs.Var(&opts.labels, "label", "制品的元数据标签 key=value，可重复指定")
+	fs.BoolVar(&opts.remoteLoad, "remote-load", false, "上传完成后由服务端执行 docker load (服务端需启用 --allow-load)")
+	fs.StringVar(&opts.remoteTag, "remote-tag", "", "远程加载后给镜像打的标签，原标签指向的镜像可通过 rollback 恢复")
+	fs.StringVar(&opts.smoke, "smoke", "", "远程加载后以新镜像启动临时容器执行该命令 (如 \"myapp --version\")，成功后才打 -remote-tag 标签 (服务端需启用 --allow-smoke)")
+	fs.Var(&opts.sla.minSpeed, "sla-min-speed", "最低传输速度 (每秒，如 1M)，统计窗口内平均速度低于该值时告警")
+	fs.DurationVar(&opts.sla.window, "sla-window", 5*time.Minute, "-sla-min-speed 的统计窗口")
+	fs.DurationVar(&opts.sla.maxDuration, "sla-max-duration", 0, "传输超过该时长仍未完成时告警")
+	fs.StringVar(&opts.preset, "preset", "", "按常见制品库的方式上传: "+strings.Join(presetNames(), ", ")+" (-preset list 查看说明)")
+	fs.StringVar(&opts.transferID, "transfer-id", "", "本次传输的 ID (字母、数字、_ 和 -)，附在所有请求上并记入日志，便于与代理和服务端的日志关联，默认自动生成")
+	fs.StringVar(&opts.pushgateway, "pushgateway-url", "", "传输结束后将指标 (字节数、耗时、结果、重试次数) 推送到该 Prometheus Pushgateway")
+	fs.StringVar(&opts.sla.webhook, "sla-webhook", "", "违反 SLA 时以 JSON POST 告警的地址")
+	fs.BoolVar(&opts.sla.fail, "sla-fail", false, "违反 SLA 时中止传输 (守护进程会按 -retries 重试)")
+	registerFormFlags(fs, &opts.form)
+	fs.BoolVar(&opts.asciiName, "ascii-name", false, "上传时将文件名转换为纯 ASCII (é → e，汉字写作 u+码位)，用于无法处理非 ASCII 文件名的接收端")
+	fs.IntVar(&opts.retries, "retries", 0, "上传因网络错误或 408/429/5xx 失败后自动重试的次数，每次重试从头读取文件")
+	fs.DurationVar(&opts.retryBackoff, "retry-backoff", 2*time.Second, "首次重试前的等待时间，之后每次翻倍 (最长 5 分钟)，服务端返回 Retry-After 时以其为准")
+	fs.IntVar(&opts.retryBudget, "retry-budget", defaultRetryBudget, "一次传输中各层重试 (整体重试、分块重试等) 合计的次数上限，0 表示不限")
+	fs.DurationVar(&opts.retryDeadline, "retry-deadline", 0, "从开始传输起超过该时长后不再重试 (0 表示不限)")
+	fs.BoolVar(&opts.tus, "tus", false, "以 tus 断点续传协议上传 (-url 为 tus 服务的创建地址)，中断后重新执行同一命令从已确认的位置继续")
+	opts.tusChunk = defaultTusChunkSize
+	fs.Var(&opts.tusChunk, "tus-chunk-size", "-tus 每次 PATCH 发送的大小，每块确认后才记入断点")
+	fs.IntVar(&opts.parallelChunks, "parallel-chunks", 0, "将文件切成固定大小的块，以 N 个连接并行上传后再提交 (类似 S3 分段上传)，适合高延迟链路上的大文件")
+	opts.partSize = defaultPartSize
+	fs.Var(&opts.partSize, "part-size", "-parallel-chunks 和 s3:// 分段上传每块的大小")
+	fs.StringVar(&opts.ifExists, "if-exists", "", "服务端已有同名文件时的处理方式: "+strings.Join(ifExistsPolicies, "、")+" (默认按服务端的 -if-exists)；因同名文件被拒绝时以 73 退出")
+	fs.BoolVar(&opts.dedupLayers, "dedup-layers", false, "上传镜像归档前先询问服务端已有哪些层，只发送缺少的层，由服务端用已存储的层还原完整归档 (适合增量发布)")
+	fs.StringVar(&opts.s3.endpoint, "s3-endpoint", "", "-url 为 s3://bucket/key 时的对象存储地址 (MinIO 等，如 http://minio:9000)，默认按区域使用 AWS S3 (也可用 AWS_ENDPOINT_URL_S3 设置)")
+	fs.StringVar(&opts.sshKey, "ssh-key", "", "-url 为 sftp://user@host:/path 时登录所用的私钥文件 (默认使用 ssh 的默认密钥和 agent)")
+	fs.StringVar(&opts.s3.region, "s3-region", "", "S3 区域 (默认取 AWS_REGION / AWS_DEFAULT_REGION，都未设置时为 us-east-1)")
+	opts.maxResponse = defaultMaxResponse
+	fs.Var(&opts.maxResponse, "max-response-bytes", "内存中最多缓存的服务端响应大小，超出时完整响应保存到临时文件，终端只显示开头部分")
+}
+
+// 连接服务端的认证、TLS 和代理参数，上传和 list、download 等访问服务端的子命令共用
+func registerClientFlags(fs *flag.FlagSet, opts *options) {
+	fs.BoolVar(&opts.negotiate, "negotiate", false, "使用本机的 Kerberos 票据 (kinit) 进行 Negotiate/SPNEGO 认证")
+	fs.StringVar(&opts.spn, "spn", "", "Kerberos 服务主体名 (默认 HTTP/<目标主机>)")
+	fs.StringVar(&opts.tls.caCert, "ca-cert", "", "额外信任的 CA 证书 (PEM)，用于内部 CA 签发的服务端证书，系统信任的 CA 仍然有效")
+	fs.StringVar(&opts.tls.clientCert, "client-cert", "", "mTLS 客户端证书 (PEM)，需同时指定 -client-key (默认使用 enroll 登记的证书)")
+	fs.StringVar(&opts.tls.clientKey, "client-key", "", "mTLS 客户端私钥 (PEM)")
+	fs.BoolVar(&opts.tls.insecure, "insecure", false, "不校验服务端证书 (仅用于测试)")
+	fs.StringVar(&opts.token, "token", "", "以 Authorization: Bearer <令牌> 认证 (只发送给 -url 所在主机)")
+	fs.StringVar(&opts.basicAuth, "basic-auth", "", "以 HTTP Basic 认证，格式 user[:password]，省略密码时读取 "+basicPasswordEnv)
+	fs.Var(&opts.headers, "header", "附加请求头 \"名称: 值\"，可重复指定 (只发送给 -url 所在主机，覆盖同名的默认请求头)")
+	fs.StringVar(&opts.proxy, "proxy", "", "经由该代理上传: http://、https:// 或 socks5://[user:pass@]host:port (默认使用 HTTP_PROXY/HTTPS_PROXY，NO_PROXY 中的地址总是直连)")
+	fs.StringVar(&opts.proxyNTLM, "proxy-ntlm", "", "以 NTLM 认证通过出口代理 (HTTPS_PROXY)，格式 DOMAIN\\user[:password]，省略密码时读取 DOCKER_SAVE_SHELL_PROXY_PASSWORD")
+	fs.StringVar(&opts.via, "via", "", "经由 SSH 跳板机 [user@]host[:port] 转发上传 (自动建立 ssh -D 代理)")
+}
+
+// 用配置文件中的默认值补全未在命令行指定的选项
+func applyConfig(fs *flag.FlagSet, opts *options, cfg *Config) error {
+	if opts.profile != "" {
+		if err := applyProfile(fs, cfg, opts.profile); err != nil {
+			return err
+		}
+	}
+	if opts.pipeline == "" {
+		opts.pipeline = cfg.Pipeline
+	}
+	if opts.pushgateway == "" {
+		opts.pushgateway = cfg.Pushgateway
+	}
+	// 未指定客户端证书时使用 enroll 为该 profile 登记的身份
+	if opts.tls.clientCert == "" && opts.tls.clientKey == "" {
+		opts.tls.clientCert, opts.tls.clientKey = enrolledIdentity(cmp.Or(opts.profile, defaultProfile))
+	}
+	return setProgressStyle(*opts)
+}
+
+func main() {
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Printf("加载配置失败: %v\n", err)
 		os.Exit(1)
 	}
 
-	file, err := os.Open(*filePath)
+	args, err := expandArgFiles(os.Args[1:])
 	if err != nil {
-		fmt.Printf("无法打开文件: %v\n", err)
+		fmt.Printf("展开参数文件失败: %v\n", err)
 		os.Exit(1)
 	}
-	defer file.Close()
 
-	fileInfo, err := file.Stat()
+	args, err = expandAlias(args, cfg.Aliases)
 	if err != nil {
-		fmt.Printf("无法获取文件信息: %v\n", err)
+		fmt.Printf("展开别名失败: %v\n", err)
 		os.Exit(1)
 	}
 
-	fileSize := fileInfo.Size()
-	fileName := filepath.Base(*filePath)
+	cmd, args := splitCommand(args)
+	cmd.run(args, cfg)
+}
 
-	fmt.Printf("📁 文件: %s\n", fileName)
-	fmt.Printf("📊 大小: %s\n", formatBytes(fileSize))
-	fmt.Printf("🎯 目标: %s\n", *serverURL)
+// upload 子命令 (默认)：上传文件、目录或本机镜像
+func runUploadCommand(args []string, cfg *Config) {
+	var opts options
+	var prio priorityOptions
+	var jobsFile string
+	var concurrency int
+	fs := newCommandFlags("upload")
+	registerUploadFlags(fs, &opts)
+	registerPriorityFlags(fs, &prio)
+	fs.StringVar(&jobsFile, "jobs", "", "批量执行任务文件 (YAML/JSON 任务列表或 CSV) 中的所有上传，结束后输出各任务的结果，有任务失败时以 1 退出")
+	fs.IntVar(&concurrency, "concurrency", 0, "-jobs 同时执行的任务数 (默认取任务文件中的 concurrency，未指定时为 1)，上传多个文件时同时上传的文件数 (默认 1)")
+	fs.Parse(args)
+	if err := applyConfig(fs, &opts, cfg); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
+	if err := prio.apply(); err != nil {
+		fmt.Printf("设置进程优先级失败: %v\n", err)
+		os.Exit(1)
+	}
 
-	// 创建multipart部分
-	part, err := writer.CreateFormFile("file", fileName)
+	if opts.preset == "list" {
+		printPresets()
+		return
+	}
+	if jobsFile != "" {
+		runBatch(jobsFile, opts.serverURL, concurrency)
+		return
+	}
+	files, err := expandSources(append(opts.files, fs.Args()...))
 	if err != nil {
-		fmt.Printf("创建表单字段失败: %v\n", err)
+		fmt.Println(err)
 		os.Exit(1)
 	}
+	if len(files) == 0 || opts.serverURL == "" {
+		fmt.Println("错误：缺少必要参数")
+		fs.Usage()
+		os.Exit(1)
+	}
+	if len(files) > 1 {
+		runFiles(opts, files, concurrency)
+		return
+	}
 
-	// ==================== 4. 创建进度条 ====================
-	bar := progressbar.NewOptions64(
-		fileSize,
-		progressbar.OptionSetDescription(fmt.Sprintf("📤 上传 %s", fileName)),
-		progressbar.OptionSetWriter(os.Stderr),
-		progressbar.OptionShowBytes(true),
-		progressbar.OptionSetWidth(30),
-		progressbar.OptionThrottle(65*time.Millisecond),
-		progressbar.OptionShowCount(),
-		progressbar.OptionOnCompletion(func() {
-			fmt.Fprint(os.Stderr, "\n")
-		}),
-		progressbar.OptionSpinnerType(14),
-		progressbar.OptionSetRenderBlankState(true),
-		progressbar.OptionSetTheme(progressbar.Theme{
-			Saucer:        "=",
-			SaucerHead:    ">",
-			SaucerPadding: " ",
-			BarStart:      "[",
-			BarEnd:        "]",
-		}),
-	)
-
-	// 使用带进度条的Reader包装文件
-	teeReader := io.TeeReader(file, bar)
+	opts.filePath = files[0]
+	runUpload(opts)
+}
 
-	// 复制文件内容到表单（通过进度条Reader）
-	_, err = io.Copy(part, teeReader)
+// 执行上传并根据结果退出
+func runUpload(opts options) {
+	opts.retry = newRetryBudget(opts.retryBudget, opts.retryDeadline)
+	if opts.transferID == "" {
+		opts.transferID = randomID()
+	}
+	restore := func() {}
+	if opts.quiet {
+		restore = silenceStdout()
+	}
+	start := time.Now()
+	ctx, stop := interruptContext(context.Background())
+	err := upload(ctx, opts)
+	stop()
+	restore()
+	if s := opts.retry.summary(); s != "" {
+		fmt.Printf("🔁 %s\n", s)
+	}
 	if err != nil {
-		fmt.Printf("读取文件失败: %v\n", err)
+		if errors.Is(err, errWindowExpired) {
+			printHandoff(opts.maxDuration, opts.tus || opts.parallelChunks > 0)
+			os.Exit(exitWindowExpired)
+		}
+		fmt.Println(err)
+		if errors.Is(err, errInterrupted) {
+			os.Exit(exitInterrupted)
+		}
+		if uploadIDPattern.MatchString(opts.transferID) {
+			fmt.Printf("🔖 传输 ID: %s\n", opts.transferID)
+		}
+		// 目标已存在 (同名文件或制品版本) 时以单独的退出码区分
+		var serr *statusError
+		if errors.As(err, &serr) && serr.code == http.StatusConflict {
+			os.Exit(exitExists)
+		}
 		os.Exit(1)
 	}
+	if opts.quiet {
+		fmt.Printf("✅ %s → %s (%s)\n", opts.filePath, opts.serverURL, time.Since(start).Round(time.Millisecond))
+	}
+}
 
-	writer.Close()
+// 上传单个文件，取消 ctx 即中止上传；开始网络传输后的结果记入本地传输历史，并按 -pushgateway-url 推送指标
+func upload(ctx context.Context, opts options) error {
+	if opts.retry == nil {
+		opts.retry = newRetryBudget(opts.retryBudget, opts.retryDeadline)
+	}
+	if opts.transferID == "" {
+		opts.transferID = randomID()
+	} else if !uploadIDPattern.MatchString(opts.transferID) {
+		return fmt.Errorf("非法的传输 ID: %q (只能包含字母、数字、_ 和 -，最长 64 个字符)", opts.transferID)
+	}
+	fmt.Printf("🔖 传输 ID: %s\n", opts.transferID)
+	if opts.filePath == stdinSource && opts.retries > 0 {
+		fmt.Println("⚠️  标准输入只能读取一次，失败后不重试")
+		opts.retries = 0
+	}
+	var poster *progressPoster
+	if opts.progressURL != "" {
+		poster = newProgressPoster(opts)
+		poster.attach(&opts)
+		poster.send(poster.event("started"), true)
+	}
+	var rec *transferRecord
+	var err error
+	for attempt := 1; ; attempt++ {
+		// 每次尝试都重新打开数据源，从头读取
+		rec = &transferRecord{Time: time.Now(), Transfer: opts.transferID, Target: historyTarget(opts.serverURL), URL: opts.serverURL, File: opts.filePath}
+		err = uploadFile(ctx, opts, rec)
+		recordTransfer(rec, err)
+		if err == nil || attempt > opts.retries || !retryableError(ctx, err) {
+			break
+		}
+
+		delay := backoffDelay(opts.retryBackoff, attempt)
+		var serr *statusError
+		if errors.As(err, &serr) && serr.retryAfter > 0 {
+			delay = min(serr.retryAfter, 5*time.Minute)
+		}
+		if berr := opts.retry.take("transfer", delay); berr != nil {
+			fmt.Printf("⛔ 不再重试: %v\n", berr)
+			break
+		}
+		fmt.Printf("🔁 第 %d 次上传失败，%s 后重试: %v\n", attempt, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if opts.pushgateway != "" {
+		pushTransferMetrics(opts.pushgateway, rec, opts.retry.retries(), err)
+	}
+	if poster != nil {
+		poster.finish(err)
+	}
+	return err
+}
 
-	// ==================== 5. 发送请求（带上传进度） ====================
-	fmt.Println("\n🚀 正在连接到服务器...")
+func uploadFile(ctx context.Context, opts options, rec *transferRecord) error {
+	if opts.artifactName != "" && opts.artifactVersion == "" {
+		return errors.New("指定 -name 时必须同时指定 -version")
+	}
+	if opts.smoke != "" && !opts.remoteLoad && opts.remoteTag == "" {
+		return errors.New("-smoke 需要与 -remote-load 或 -remote-tag 同时使用")
+	}
+	if len(opts.labels) > 0 && opts.artifactName == "" {
+		return errors.New("-label 只能用于版本化制品 (需同时指定 -name)")
+	}
+	for _, l := range opts.labels {
+		if k, _, ok := strings.Cut(l, "="); !ok || k == "" {
+			return fmt.Errorf("非法的标签 %q，格式应为 key=value", l)
+		}
+	}
+	if err := opts.form.validate(); err != nil {
+		return err
+	}
+	if err := opts.docker.validate(); err != nil {
+		return err
+	}
+	if opts.pruneAfterUpload {
+		if err := checkPruneSource(opts); err != nil {
+			return err
+		}
+		// 删除前要由服务端确认摘要，因此总是发送摘要
+		opts.checksum = true
+	}
+	if opts.parallelChunks < 0 {
+		return fmt.Errorf("非法的并发数: %d", opts.parallelChunks)
+	}
+	if opts.parallelChunks > 0 {
+		if err := checkPartsOptions(opts); err != nil {
+			return err
+		}
+	}
+	if opts.dedupLayers {
+		if err := checkDedupOptions(opts); err != nil {
+			return err
+		}
+	}
+	if opts.ifExists != "" {
+		if err := checkIfExistsOptions(opts); err != nil {
+			return err
+		}
+	}
+	if isS3URL(opts.serverURL) {
+		if err := checkS3Options(opts); err != nil {
+			return err
+		}
+	}
+	if isSFTPURL(opts.serverURL) {
+		if err := checkSFTPOptions(opts); err != nil {
+			return err
+		}
+	}
+	// 策略拒绝的镜像不检查、不拉取，也不开始 docker save
+	source := opts.filePath
+	if !isRemoteSource(source) {
+		if abs, err := filepath.Abs(source); err == nil {
+			source = abs
+		}
+	}
+	if err := enforceSourcePolicy(source, opts.serverURL, opts.policyImages); err != nil {
+		return err
+	}
+	if isImageSource(opts.filePath) {
+		if err := checkImages(opts.docker, imageRefs(opts.filePath), opts.pull); err != nil {
+			return err
+		}
+	}
 
-	// 创建请求
-	req, err := http.NewRequest("POST", *serverURL, body)
-	if err != nil {
-		fmt.Printf("创建请求失败: %v\n", err)
-		os.Exit(1)
+	if opts.tus {
+		if err := checkTusOptions(opts); err != nil {
+			return err
+		}
 	}
-	req.Header.Set("Content-Type", writer.FormDataContentType())
 
-	// 发送请求
-	client := &http.Client{
-		Timeout: 30 * time.Minute, // 大文件需要更长时间
+	var preset *uploadPreset
+	if opts.preset != "" {
+		p, err := lookupPreset(opts.preset)
+		if err != nil {
+			return err
+		}
+		if err := p.check(opts); err != nil {
+			return err
+		}
+		preset = p
 	}
 
-	resp, err := client.Do(req)
+	// 标准输入由各自的管道提供，不存在同时上传同一数据源的冲突
+	if opts.filePath != stdinSource {
+		lock, err := acquireLock(opts.filePath, opts.forceUnlock)
+		if err != nil {
+			return fmt.Errorf("获取文件锁失败: %w", err)
+		}
+		defer lock.Release()
+	}
+
+	spec, err := compressPipeline(opts.pipeline, opts.compress)
 	if err != nil {
-		fmt.Printf("发送请求失败: %v\n", err)
-		os.Exit(1)
+		return err
+	}
+	if spec, err = formatPipeline(spec, opts.format, opts.compress); err != nil {
+		return err
+	}
+	pl, err := parsePipeline(spec)
+	if err != nil {
+		return err
+	}
+	if err := pl.useEncryptKey(opts.encryptKey); err != nil {
+		return err
+	}
+	// -format tar.gz / tar.zst 明确要求压缩后的格式，不因内容不可压缩而跳过
+	pl.forceCompress = opts.forceCompress || formatCompressed(opts.format)
+	if opts.zstdDict && !strings.Contains(spec, "zstd") {
+		// -compress auto 选出的级别在读取数据后才确定，字典只用于明确指定的 zstd
+		return errors.New("-zstd-dict 需要与 -compress zstd 同时使用")
+	}
+
+	ctx, cancel := transferContext(ctx, opts.maxDuration)
+	defer cancel()
+
+	ctx, sla := startSLAMonitor(ctx, opts.sla, opts.transferID, opts.filePath, opts.serverURL)
+	defer sla.stop()
+
+	// 目标解析出多个地址时固定连接其中一个，会话 ID 同时作为服务端的上传 ID
+	// 上传耗时与文件大小成正比，不设总超时；只限制发送完后等待响应的时间
+	client, tunnel, err := newUploadClient(ctx, opts, 0)
+	if err != nil {
+		return err
+	}
+	if tunnel != nil {
+		defer tunnel.Close()
+	}
+	client.transport.ResponseHeaderTimeout = opts.responseTimeout
+	if client.clock.mode, err = parseClockSkewMode(opts.clockSkew); err != nil {
+		return err
+	}
+	sourceClient, err := newSourceClient(opts, tunnel)
+	if err != nil {
+		return err
+	}
+
+	if opts.preflight {
+		if err := preflight(ctx, client, opts.serverURL); err != nil {
+			return err
+		}
+		fmt.Println("✅ 预检通过")
+	}
+	if opts.ifExists != "" {
+		policy, err := negotiateIfExists(ctx, client, opts.serverURL)
+		if err != nil {
+			return err
+		}
+		if opts.verbose {
+			fmt.Printf("📛 同名文件: %s (服务端默认 %s)\n", opts.ifExists, policy)
+		}
 	}
-	defer resp.Body.Close()
 
-	// ==================== 6. 读取响应（带下载进度） ====================
-	fmt.Println("\n📥 正在接收服务器响应...")
+	if opts.tus {
+		return tusUpload(ctx, client, opts, sla, rec)
+	}
+	if opts.parallelChunks > 0 {
+		return partsUpload(ctx, client, opts, sla, rec)
+	}
+	// 上传到本工具的服务端 (而不是制品库或对象存储)
+	ownServer := preset == nil && !isS3URL(opts.serverURL) && !isSFTPURL(opts.serverURL)
+	if ownServer {
+		if err := checkPlatform(ctx, client, opts); err != nil {
+			return err
+		}
+	}
+	if opts.dedupLayers {
+		err := dedupUpload(ctx, client, opts, sla, rec)
+		if !errors.Is(err, errNoDedup) {
+			return err
+		}
+		fmt.Printf("♻️  %v，上传完整归档\n", err)
+	}
+	if opts.zstdDict {
+		if preset != nil {
+			return errors.New("-zstd-dict 只能用于本工具的服务端")
+		}
+		dict, id, err := negotiateZstdDict(ctx, client, opts)
+		if err != nil {
+			return err
+		}
+		if dict != nil {
+			pl.useZstdDict(dict)
+			fmt.Printf("📚 zstd 字典: %08x\n", id)
+		} else {
+			fmt.Println("📚 服务端没有可用的 zstd 字典，按普通 zstd 压缩")
+		}
+	}
+	if opts.compress == "auto" {
+		pl.auto = &autoCompression{}
+		if ownServer {
+			pl.auto.link = measureLink(ctx, client, opts)
+		}
+		if pl.auto.link > 0 {
+			fmt.Printf("📶 链路带宽: %s/s\n", formatBytes(int64(pl.auto.link)))
+		}
+	}
 
-	// 获取响应体大小（如果服务器提供了Content-Length）
-	contentLength := resp.ContentLength
+	file, err := openSource(ctx, opts.filePath, dirFilter{include: opts.include, exclude: opts.exclude}, opts.sums || opts.sumsKey != "", opts.docker, sourceClient)
+	if err = windowError(ctx, err); err != nil {
+		return err
+	}
+	defer file.Close()
+	if opts.extractTo != "" && !file.dir {
+		return errors.New("-extract-to 只能用于目录 (-file 为目录时)")
+	}
+	if (opts.sums || opts.sumsKey != "") && !file.dir {
+		return errors.New("-sums 只能用于目录 (-file 为目录时)")
+	}
 
-	var responseBody []byte
-	if contentLength > 0 {
-		// 如果知道响应体大小，显示进度条
-		bar2 := progressbar.NewOptions64(
-			contentLength,
-			progressbar.OptionSetDescription("📥 下载响应"),
-			progressbar.OptionSetWriter(os.Stderr),
-			progressbar.OptionShowBytes(true),
-			progressbar.OptionSetWidth(30),
-		)
+	fileSize := file.size
+	fileName := file.name
+	if opts.filePath == stdinSource && opts.stdinName != "" {
+		fileName = opts.stdinName
+	}
+	if opts.asciiName && !isASCIIName(fileName) {
+		fileName = asciiName(fileName)
+		fmt.Printf("🔤 上传文件名: %s\n", fileName)
+	}
 
-		// 使用带进度条的Reader读取响应
-		respBodyReader := progressbar.NewReader(resp.Body, bar2)
-		responseBody, err = io.ReadAll(&respBodyReader)
+	fmt.Printf("📁 文件: %s\n", fileName)
+	if fileSize >= 0 {
+		fmt.Printf("📊 大小: %s\n", formatBytes(fileSize))
 	} else {
-		// 不知道大小，直接读取
-		responseBody, err = io.ReadAll(resp.Body)
+		fmt.Println("📊 大小: 未知")
 	}
+	fmt.Printf("🎯 目标: %s\n", opts.serverURL)
 
-	if err != nil {
-		fmt.Printf("读取响应失败: %v\n", err)
-		os.Exit(1)
+	// ==================== 4. 创建进度条 ====================
+	description := fmt.Sprintf("📤 上传 %s", fileName)
+	var src io.Reader = file
+	if opts.readLimit > 0 {
+		src = newLimitedReader(ctx, file, int64(opts.readLimit))
+		description += fmt.Sprintf(" [读取 ≤%s/s]", formatBytes(int64(opts.readLimit)))
+		fmt.Printf("🐢 读取限速: %s/s\n", formatBytes(int64(opts.readLimit)))
+	}
+	sendLimiter := opts.uploadLimiter()
+	if sendLimiter != nil {
+		description += fmt.Sprintf(" [发送 ≤%s/s]", formatBytes(int64(opts.limitRate)))
+		fmt.Printf("🐢 发送限速: %s/s\n", formatBytes(int64(opts.limitRate)))
+	}
+	bar := newTransferBar(fileSize, description)
+	if opts.hideBar {
+		bar = hiddenBar(fileSize)
+	}
+
+	// 使用带进度条的Reader包装文件
+	// 同时计算原始数据摘要 (经 -format 转换后、压缩前)，启用压缩时随表单发送，供服务端校验解压结果
+	// 树形摘要作为制品 ID，同样基于原始数据计算
+	rawHash := sha256.New()
+	tree := uploader.NewTreeHasher()
+	sinks := []io.Writer{bar}
+	if opts.progress != nil {
+		sinks = append(sinks, &progressWriter{total: fileSize, report: opts.progress})
+	}
+	teeReader := io.TeeReader(&slaCounter{r: &contextReader{ctx: ctx, r: src}, m: sla}, io.MultiWriter(sinks...))
+
+	// 接入流水线，压缩等阶段可能根据采样结果被跳过，因此文件名在此之后确定
+	pipeReader := pl.build(teeReader, io.MultiWriter(rawHash, tree))
+	if sendLimiter != nil {
+		pipeReader = &limitedReader{ctx: ctx, r: pipeReader, l: sendLimiter}
+	}
+	fileName = pl.fileName(fileName)
+	// 压缩后进度条仍按原始数据计量，另在描述中显示已发送的压缩数据量
+	partType := ""
+	if c := pl.compressor(); c != nil {
+		partType = c.mediaType
+		pipeReader = &compressedProgress{r: pipeReader, bar: bar, description: description, format: c.name}
+	}
+	// 密文不再是压缩格式
+	if pl.encrypted() {
+		partType = ""
+	}
+	if pl.auto != nil && !opts.verbose {
+		fmt.Printf("\n🗜️  %s\n", pl.auto.reason)
+	}
+	if opts.verbose {
+		for _, d := range pl.decisions {
+			fmt.Printf("\n🔎 %s\n", d)
+		}
+	}
+
+	// -checksum 对实际发送的数据 (压缩后) 计算摘要，与服务端收到的数据直接比较；
+	// 经由 pkg/uploader 发送时由其计算
+	sentHash := sha256.New()
+	if opts.checksum && (isS3URL(opts.serverURL) || isSFTPURL(opts.serverURL)) {
+		pipeReader = io.TeeReader(pipeReader, sentHash)
+	}
+
+	if isS3URL(opts.serverURL) {
+		// 对象存储以分段上传接收，读取、压缩、限速和进度显示与普通上传相同
+		if err := s3Upload(ctx, client, opts, pipeReader, fileSize, fileName, cmp.Or(partType, "application/octet-stream"), rec); err != nil {
+			return err
+		}
+		if c := pl.compressor(); c != nil {
+			raw, out := pl.byteCounts()
+			if raw > 0 {
+				fmt.Printf("🗜️  %s 压缩: 原始 %s → 发送 %s (%.1f%%)\n", c.name, formatBytes(raw), formatBytes(out), float64(out)/float64(raw)*100)
+			}
+		}
+		if opts.checksum {
+			fmt.Printf("🔐 SHA-256: %s\n", hex.EncodeToString(sentHash.Sum(nil)))
+		}
+		// 每块的 SHA-256 都参与签名并由 S3 校验，提交成功即说明数据完整
+		if opts.pruneAfterUpload {
+			pruneSource(opts)
+		}
+		return nil
+	}
+
+	if isSFTPURL(opts.serverURL) {
+		if err := sftpUpload(ctx, opts, pipeReader, fileName, rec); err != nil {
+			return err
+		}
+		if opts.checksum {
+			fmt.Printf("🔐 SHA-256: %s\n", hex.EncodeToString(sentHash.Sum(nil)))
+		}
+		// SSH 通道本身保证数据完整，上传后已核对文件大小
+		if opts.pruneAfterUpload {
+			pruneSource(opts)
+		}
+		return nil
+	}
+
+	// 发送经由 pkg/uploader，由 cliTransport 按目标组织请求；
+	// 长度只在数据未经压缩或加密时已知
+	bodySize := int64(-1)
+	if !pl.compressed() && !pl.encrypted() {
+		bodySize = fileSize
+	}
+	var artifactID string
+	transport := &cliTransport{client: client, opts: opts, preset: preset, rec: rec, partType: partType}
+	// 制品库直接保存请求体，以 Content-Encoding 标明压缩格式；multipart 中由文件分段的 Content-Type 标明
+	if c := pl.compressor(); c != nil && preset != nil && !pl.encrypted() {
+		transport.encoding = c.encoding
+	}
+	transport.fields = func(req *uploader.Request) ([][2]string, error) {
+		// 其余字段依赖文件内容的摘要，写在文件之后
+		artifactID = tree.Sum()
+		fields := [][2]string{{"tree_sha256", artifactID}}
+		if opts.artifactName != "" {
+			fields = append(fields, [2]string{"artifact_name", opts.artifactName}, [2]string{"artifact_version", opts.artifactVersion})
+			if len(opts.labels) > 0 {
+				labels, err := json.Marshal(labelMap(opts.labels))
+				if err != nil {
+					return nil, err
+				}
+				fields = append(fields, [2]string{"labels", string(labels)})
+			}
+		}
+		if file.dir {
+			fields = append(fields, [2]string{"bundle", "dir"})
+			if opts.extractTo != "" {
+				fields = append(fields, [2]string{"extract_to", opts.extractTo})
+			}
+		}
+		if opts.remoteLoad || opts.remoteTag != "" {
+			fields = append(fields, [2]string{"docker_load", "true"}, [2]string{"docker_tag", opts.remoteTag})
+			if opts.smoke != "" {
+				fields = append(fields, [2]string{"smoke", opts.smoke})
+			}
+		}
+		if opts.ifExists != "" {
+			fields = append(fields, [2]string{"if_exists", opts.ifExists})
+		}
+		if pl.compressed() || pl.encrypted() {
+			fields = append(fields, [2]string{"inner_sha256", hex.EncodeToString(rawHash.Sum(nil))})
+		}
+		if opts.checksum {
+			fields = append(fields, [2]string{"sha256", req.SHA256()})
+		}
+		return fields, nil
+	}
+
+	_, err = uploader.New(transport).Upload(ctx, pipeReader, bodySize, uploader.Options{
+		URL:        opts.serverURL,
+		FileName:   fileName,
+		TransferID: opts.transferID,
+	})
+	// 服务端返回的错误状态在下面显示响应后处理
+	var rejected *uploader.StatusError
+	if err != nil && !errors.As(err, &rejected) {
+		return err
+	}
+	if artifactID == "" {
+		artifactID = tree.Sum()
+	}
+	responseBody, uploadStart := transport.response, transport.start
+	bodySize = transport.sent
+
+	pl.recordUpload(bodySize, time.Since(uploadStart))
+	rec.Bytes, rec.Duration = bodySize, time.Since(uploadStart).Seconds()
+	if conn := client.dialer.lastConn(); conn != nil {
+		if st, err := readTCPStats(conn); err == nil {
+			rec.RTT = float64(st.RTT.Microseconds()) / 1000
+			rec.Retrans = st.retransRate()
+		}
 	}
 
-	fmt.Printf("\n 响应状态码: %d\n", resp.StatusCode)
+	fmt.Printf("\n 响应状态码: %d\n", transport.status)
 
-	if resp.StatusCode == http.StatusOK {
+	// 制品库创建文件时可能返回 201/204
+	ok := transport.status == http.StatusOK || preset != nil && transport.status/100 == 2
+	if ok {
 		fmt.Println("上传成功!")
 	} else {
 		fmt.Printf("上传失败\n")
 	}
 
-	fmt.Printf("📝 服务器返回: %s\n", string(responseBody))
+	fmt.Printf("📝 服务器返回: %s\n", responseBody.display(!ok))
+	if opts.smoke != "" {
+		printSmokeResult(responseBody.data)
+	}
+	if ok && preset == nil {
+		printCollision(responseBody.data)
+	}
+	fmt.Printf("🆔 制品 ID: %s\n", artifactID)
+	if c := pl.compressor(); c != nil {
+		raw, out := pl.byteCounts()
+		if raw > 0 {
+			fmt.Printf("🗜️  %s 压缩: 原始 %s → 发送 %s (%.1f%%)\n", c.name, formatBytes(raw), formatBytes(out), float64(out)/float64(raw)*100)
+		}
+	}
+	if opts.checksum {
+		fmt.Printf("🔐 SHA-256: %s\n", transport.sha256)
+	}
+
+	if opts.verbose {
+		pl.report()
+		printUploadDiagnostics(client, bodySize, time.Since(uploadStart))
+	}
+	if !ok {
+		serr := &statusError{code: transport.status, retryAfter: parseRetryAfter(transport.header.Get("Retry-After"))}
+		if transport.status == http.StatusUnauthorized || transport.status == http.StatusForbidden {
+			serr.hint = client.clock.authHint()
+		}
+		return serr
+	}
+
+	if file.sums != nil {
+		name := strings.TrimSuffix(file.name, ".tar") + ".SHA256SUMS"
+		if err := uploadManifest(ctx, client.Client, opts.serverURL, name, file.sums, opts.sumsKey, opts.form); err != nil {
+			return err
+		}
+	}
+	if opts.pruneAfterUpload {
+		// 预设的制品库以 2xx 表示已按 trailer 校验摘要；本工具的服务端还会在响应中返回收到的摘要
+		if preset == nil {
+			if err := confirmDigest(responseBody.data, transport.sha256); err != nil {
+				fmt.Printf("⚠️  %v，保留源文件\n", err)
+				return nil
+			}
+		}
+		pruneSource(opts)
+	}
+	return nil
 }
 
 // ==================== 辅助函数 ====================
 
+// 按 -negotiate、-ca-cert、-token、-header、-proxy、-proxy-ntlm 和 -via 创建访问 opts.serverURL 的会话客户端，
+// 上传和 list、download 等访问服务端的子命令共用；经 -via 建立的隧道由调用方关闭
+func newUploadClient(ctx context.Context, opts options, timeout time.Duration) (*sessionClient, *sshTunnel, error) {
+	client := newSessionClient(randomID(), timeout)
+	if opts.negotiate {
+		if err := client.useNegotiate(opts.spn); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := client.useTLS(opts.tls); err != nil {
+		return nil, nil, err
+	}
+	headers, err := requestHeaders(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := client.useHeaders(opts.serverURL, headers); err != nil {
+		return nil, nil, err
+	}
+	if opts.proxy != "" {
+		if opts.via != "" {
+			return nil, nil, errors.New("-proxy 与 -via 不能同时使用")
+		}
+		if err := client.useProxy(opts.proxy); err != nil {
+			return nil, nil, err
+		}
+	}
+	if opts.proxyNTLM != "" {
+		if strings.HasPrefix(opts.proxy, "socks") {
+			return nil, nil, errors.New("-proxy-ntlm 只能用于 HTTP 代理")
+		}
+		if opts.via != "" {
+			return nil, nil, errors.New("-proxy-ntlm 与 -via 不能同时使用")
+		}
+		if err := client.useNTLMProxy(opts.proxyNTLM); err != nil {
+			return nil, nil, err
+		}
+	}
+	var tunnel *sshTunnel
+	if opts.via != "" {
+		if tunnel, err = openSSHTunnel(ctx, opts.via); err != nil {
+			return nil, nil, err
+		}
+		client.transport.Proxy = http.ProxyURL(tunnel.proxyURL)
+	}
+	return client, tunnel, nil
+}
+
+// 按 -progress 创建文件传输进度，进度条在 size 为 -1 时显示为旋转指示器
+func newTransferBar(size int64, description string) transferBar {
+	switch progressStyle {
+	case progressStyleNone:
+		return hiddenBar(size)
+	case progressStylePlain:
+		return newPlainBar(size, description)
+	}
+	return progressbar.NewOptions64(
+		size,
+		progressbar.OptionSetDescription(description),
+		progressbar.OptionSetWriter(os.Stderr),
+		progressbar.OptionShowBytes(true),
+		progressbar.OptionSetWidth(30),
+		progressbar.OptionThrottle(65*time.Millisecond),
+		progressbar.OptionShowCount(),
+		progressbar.OptionOnCompletion(func() {
+			fmt.Fprint(os.Stderr, "\n")
+		}),
+		progressbar.OptionSpinnerType(14),
+		progressbar.OptionSetRenderBlankState(true),
+		progressbar.OptionSetTheme(progressbar.Theme{
+			Saucer:        "=",
+			SaucerHead:    ">",
+			SaucerPadding: " ",
+			BarStart:      "[",
+			BarEnd:        "]",
+		}),
+	)
+}
+
 // 格式化字节大小为可读格式
 func formatBytes(bytes int64) string {
 	const unit = 1024
@@ -170,6 +989,19 @@ func formatBytes(bytes int64) string {
 	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
 }
 
+// progressWriter 累计写入的字节数并回调
+type progressWriter struct {
+	done   int64
+	total  int64
+	report func(done, total int64)
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.done += int64(len(p))
+	w.report(w.done, w.total)
+	return len(p), nil
+}
+
 // ProgressReader 用于跟踪进度的Reader
 type ProgressReader struct {
 	io.Reader
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// mDNS 服务类型 (服务名不超过 15 个字符，见 RFC 6763)
+const mdnsService = "_dss._tcp.local."
+
+// mDNS 组播地址
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// mdnsAnnouncer 在局域网内通告接收服务 (DNS-SD over mDNS)，响应 PTR/SRV/TXT/A 查询
+type mdnsAnnouncer struct {
+	instance dnsmessage.Name // <名称>._dss._tcp.local.
+	host     dnsmessage.Name // <主机名>.local.
+	service  dnsmessage.Name
+	port     uint16
+	txt      []string
+}
+
+// 启动通告；ctx 取消时停止
+func advertiseMDNS(ctx context.Context, name string, port int, txt []string) error {
+	host, _ := os.Hostname()
+	host = mdnsLabel(strings.Split(host, ".")[0])
+	if name == "" {
+		name = host
+	}
+	a := &mdnsAnnouncer{port: uint16(port), txt: txt}
+	var err error
+	if a.instance, err = dnsmessage.NewName(mdnsLabel(name) + "." + mdnsService); err != nil {
+		return fmt.Errorf("mDNS 服务名无效: %w", err)
+	}
+	if a.host, err = dnsmessage.NewName(host + ".local."); err != nil {
+		return fmt.Errorf("mDNS 主机名无效: %w", err)
+	}
+	a.service = dnsmessage.MustNewName(mdnsService)
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return fmt.Errorf("监听 mDNS 失败: %w", err)
+	}
+	context.AfterFunc(ctx, func() { conn.Close() })
+
+	// 启动时主动通告两次，已在浏览的客户端无需重新查询
+	go func() {
+		for i := 0; i < 2; i++ {
+			if msg, err := a.response(0, true); err == nil {
+				conn.WriteToUDP(msg, mdnsGroup)
+			}
+			time.Sleep(time.Second)
+		}
+	}()
+	go a.serve(conn)
+	return nil
+}
+
+func (a *mdnsAnnouncer) serve(conn *net.UDPConn) {
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		var m dnsmessage.Message
+		if m.Unpack(buf[:n]) != nil || m.Header.Response {
+			continue
+		}
+		if !a.wanted(m.Questions) {
+			continue
+		}
+		// 源端口不是 5353 的为简单查询 (RFC 6762 6.7)，单播回复并带上原查询 ID
+		unicast := src.Port != mdnsGroup.Port
+		msg, err := a.response(m.Header.ID, !unicast)
+		if err != nil {
+			continue
+		}
+		if unicast {
+			conn.WriteToUDP(msg, src)
+		} else {
+			conn.WriteToUDP(msg, mdnsGroup)
+		}
+	}
+}
+
+// 查询是否涉及本服务
+func (a *mdnsAnnouncer) wanted(questions []dnsmessage.Question) bool {
+	for _, q := range questions {
+		switch q.Name.String() {
+		case a.service.String(), a.instance.String(), a.host.String(), "_services._dns-sd._udp.local.":
+			return true
+		}
+	}
+	return false
+}
+
+// 构造应答：PTR 指向实例，附带 SRV、TXT 和主机的 IPv4 地址
+func (a *mdnsAnnouncer) response(id uint16, multicast bool) ([]byte, error) {
+	class := dnsmessage.ClassINET
+	flush := class
+	if multicast {
+		flush |= 1 << 15 // cache-flush 位，只用于组播应答中的唯一记录
+	}
+	ttl := uint32(120)
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, Response: true, Authoritative: true},
+		Answers: []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: a.service, Type: dnsmessage.TypePTR, Class: class, TTL: ttl},
+			Body:   &dnsmessage.PTRResource{PTR: a.instance},
+		}},
+		Additionals: []dnsmessage.Resource{
+			{
+				Header: dnsmessage.ResourceHeader{Name: a.instance, Type: dnsmessage.TypeSRV, Class: flush, TTL: ttl},
+				Body:   &dnsmessage.SRVResource{Target: a.host, Port: a.port},
+			},
+			{
+				Header: dnsmessage.ResourceHeader{Name: a.instance, Type: dnsmessage.TypeTXT, Class: flush, TTL: ttl},
+				Body:   &dnsmessage.TXTResource{TXT: a.txt},
+			},
+		},
+	}
+	for _, ip := range localIPv4s() {
+		msg.Additionals = append(msg.Additionals, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: a.host, Type: dnsmessage.TypeA, Class: flush, TTL: ttl},
+			Body:   &dnsmessage.AResource{A: [4]byte(ip.To4())},
+		})
+	}
+	return msg.Pack()
+}
+
+// 本机非回环的 IPv4 地址
+func localIPv4s() []net.IP {
+	var ips []net.IP
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && ipnet.IP.To4() != nil {
+			ips = append(ips, ipnet.IP.To4())
+		}
+	}
+	return ips
+}
+
+// DNS 标签中不允许出现点号，长度不超过 63
+func mdnsLabel(s string) string {
+	s = strings.ReplaceAll(s, ".", "-")
+	if len(s) > 63 {
+		s = s[:63]
+	}
+	if s == "" {
+		s = "receiver"
+	}
+	return s
+}
+
+// discoveredReceiver 发现的接收服务
+type discoveredReceiver struct {
+	Name        string
+	Host        string
+	Port        uint16
+	Addrs       []string
+	TLS         bool
+	Fingerprint string // 服务端证书的 SHA-256 指纹
+	Path        string
+}
+
+// 接收服务的上传地址
+func (r *discoveredReceiver) url() string {
+	scheme := "http"
+	if r.TLS {
+		scheme = "https"
+	}
+	host := strings.TrimSuffix(r.Host, ".")
+	if len(r.Addrs) > 0 {
+		host = r.Addrs[0]
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(host, strconv.Itoa(int(r.Port))), r.Path)
+}
+
+// 发送 PTR 查询并在 timeout 内收集应答
+func discoverReceivers(timeout time.Duration) ([]*discoveredReceiver, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	query := dnsmessage.Message{Questions: []dnsmessage.Question{{
+		Name: dnsmessage.MustNewName(mdnsService), Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET,
+	}}}
+	msg, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.WriteToUDP(msg, mdnsGroup); err != nil {
+		return nil, fmt.Errorf("发送 mDNS 查询失败: %w", err)
+	}
+
+	found := map[string]*discoveredReceiver{}
+	hosts := map[string][]string{}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			}
+			return nil, err
+		}
+		var m dnsmessage.Message
+		if m.Unpack(buf[:n]) != nil || !m.Header.Response {
+			continue
+		}
+		for _, rr := range append(m.Answers, m.Additionals...) {
+			name := rr.Header.Name.String()
+			switch body := rr.Body.(type) {
+			case *dnsmessage.SRVResource:
+				r := receiverFor(found, name)
+				r.Host, r.Port = body.Target.String(), body.Port
+			case *dnsmessage.TXTResource:
+				r := receiverFor(found, name)
+				for _, kv := range body.TXT {
+					k, v, _ := strings.Cut(kv, "=")
+					switch k {
+					case "path":
+						r.Path = v
+					case "tls":
+						r.TLS = v == "1"
+					case "fp":
+						r.Fingerprint = v
+					}
+				}
+			case *dnsmessage.AResource:
+				hosts[name] = append(hosts[name], net.IP(body.A[:]).String())
+			}
+		}
+	}
+
+	var list []*discoveredReceiver
+	for _, r := range found {
+		if r.Port == 0 {
+			continue
+		}
+		r.Addrs = dedupe(hosts[r.Host])
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+func receiverFor(found map[string]*discoveredReceiver, instance string) *discoveredReceiver {
+	r, ok := found[instance]
+	if !ok {
+		r = &discoveredReceiver{Name: strings.TrimSuffix(instance, "."+mdnsService)}
+		found[instance] = r
+	}
+	return r
+}
+
+func dedupe(list []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, s := range list {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// discover 子命令：列出局域网内的接收服务，交互式终端中可选择一个，选中的地址输出到标准输出
+func runDiscover(args []string) {
+	fs := newCommandFlags("discover")
+	timeout := fs.Duration("timeout", 2*time.Second, "等待应答的时间")
+	fs.Parse(args)
+
+	receivers, err := discoverReceivers(*timeout)
+	if err != nil {
+		fmt.Printf("发现接收服务失败: %v\n", err)
+		os.Exit(1)
+	}
+	if len(receivers) == 0 {
+		fmt.Fprintln(os.Stderr, "未发现接收服务 (确认服务端以 serve -mdns 启动，且与本机处于同一网段)")
+		os.Exit(1)
+	}
+
+	// 列表输出到标准错误，标准输出只保留选中的地址，便于 url=$(docker_save_shell discover)
+	for i, r := range receivers {
+		fmt.Fprintf(os.Stderr, "%2d) %-20s %s\n", i+1, r.Name, r.url())
+		if r.Fingerprint != "" {
+			fmt.Fprintf(os.Stderr, "    证书指纹: sha256:%s\n", r.Fingerprint)
+		}
+	}
+
+	choice := 0
+	if len(receivers) > 1 {
+		if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+			fmt.Fprintln(os.Stderr, "发现多个接收服务，请在交互式终端中运行以进行选择")
+			os.Exit(1)
+		}
+		fmt.Fprint(os.Stderr, "选择接收服务编号: ")
+		line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		n, err := strconv.Atoi(strings.TrimSpace(line))
+		if err != nil || n < 1 || n > len(receivers) {
+			fmt.Fprintln(os.Stderr, "无效的编号")
+			os.Exit(1)
+		}
+		choice = n - 1
+	}
+	fmt.Println(receivers[choice].url())
+}
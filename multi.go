@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sourceList -file 参数：可重复指定，最后一次的值同时作为单文件上传的 filePath
+type sourceList struct{ opts *options }
+
+func (l sourceList) String() string {
+	if l.opts == nil {
+		return ""
+	}
+	return strings.Join(l.opts.files, ",")
+}
+
+func (l sourceList) Set(v string) error {
+	l.opts.files = append(l.opts.files, v)
+	l.opts.filePath = v
+	return nil
+}
+
+// 展开 -file 和位置参数中的通配符 (*、?、[...])，按指定顺序返回去重后的数据源；
+// 远程地址和本机镜像原样保留，没有匹配任何文件的通配符视为错误
+func expandSources(patterns []string) ([]string, error) {
+	var sources []string
+	seen := map[string]bool{}
+	add := func(p string) {
+		if !seen[p] {
+			seen[p] = true
+			sources = append(sources, p)
+		}
+	}
+	for _, p := range patterns {
+		if isRemoteSource(p) || !strings.ContainsAny(p, "*?[") {
+			add(p)
+			continue
+		}
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			return nil, fmt.Errorf("通配符格式错误 %q: %w", p, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("没有匹配 %s 的文件", p)
+		}
+		for _, m := range matches {
+			add(m)
+		}
+	}
+	return sources, nil
+}
+
+// 上传多个文件，同时最多上传 concurrency 个；每个文件各自重试，全部结束后输出结果汇总，有文件失败时以 1 退出。
+// 并发上传时每个文件的进度由 multiBar 各占一行绘制
+func runFiles(opts options, files []string, concurrency int) {
+	if opts.artifactName != "" {
+		fmt.Println("上传多个文件时不能指定 -name，各文件会写入同一制品版本")
+		os.Exit(1)
+	}
+	concurrency = min(max(concurrency, 1), len(files))
+	// -limit-rate 限制的是总带宽，并发上传的文件共用同一个令牌桶
+	if opts.limitRate > 0 {
+		opts.sendLimiter = newRateLimiter(int64(opts.limitRate))
+	}
+
+	var bars *multiBar
+	restore := func() {}
+	switch {
+	case opts.quiet:
+		// 只输出最后的结果汇总
+		restore = silenceStdout()
+	case concurrency > 1:
+		fmt.Printf("📦 %d 个文件，并发 %d\n", len(files), concurrency)
+		// plain 方式下各文件分别输出单行进度
+		if progressStyle == progressStyleBar {
+			bars = newMultiBar(concurrency)
+			restore = bars.captureStdout()
+		}
+	}
+
+	results := make([]batchResult, len(files))
+	// 空闲的进度行编号，同时充当并发上限
+	slots := make(chan int, concurrency)
+	for i := range concurrency {
+		slots <- i
+	}
+	ctx, stop := interruptContext(context.Background())
+	defer stop()
+	var wg sync.WaitGroup
+	for i, file := range files {
+		slot := <-slots
+		if ctx.Err() != nil {
+			// 已被中断，不再开始新的上传
+			results[i] = batchResult{job: &transferJob{Source: file, Target: opts.serverURL}, err: errInterrupted}
+			slots <- slot
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer func() { slots <- slot; wg.Done() }()
+			fmt.Printf("\n▶️  [%d/%d] %s → %s\n", i+1, len(files), file, opts.serverURL)
+			o := opts
+			o.filePath = file
+			if opts.transferID != "" {
+				// 指定的传输 ID 作为前缀，各文件仍各有一个 ID
+				o.transferID = fmt.Sprintf("%s-%d", opts.transferID, i+1)
+			}
+			if bars != nil {
+				o.progress, o.hideBar = bars.begin(slot, filepath.Base(file)), true
+				defer bars.end(slot)
+			}
+			start := time.Now()
+			err := upload(ctx, o)
+			results[i] = batchResult{job: &transferJob{Source: file, Target: opts.serverURL}, err: err, duration: time.Since(start)}
+			if err != nil {
+				fmt.Printf("❌ [%d/%d] %s: %v\n", i+1, len(files), file, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	restore()
+	if bars != nil {
+		bars.close()
+	}
+	if printBatchResults(results) > 0 {
+		if errors.Is(context.Cause(ctx), errInterrupted) {
+			os.Exit(exitInterrupted)
+		}
+		os.Exit(1)
+	}
+}
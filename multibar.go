@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// 多行进度的刷新间隔
+const multiBarInterval = 200 * time.Millisecond
+
+// multiBar 并发上传多个文件时，在终端底部为每个工作协程绘制一行进度；
+// 上传过程的其他输出经 captureStdout 转发到进度行上方，不会与进度行交错。
+// stderr 不是终端时不绘制，输出保持原样
+type multiBar struct {
+	mu    sync.Mutex
+	out   io.Writer // 绘制进度的终端 (stderr)
+	tty   bool
+	slots []barSlot
+	drawn int // 上次绘制的行数，重绘前先清除
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// barSlot 一个工作协程当前上传的文件
+type barSlot struct {
+	name  string
+	done  int64
+	total int64 // -1 表示大小未知
+	start time.Time
+}
+
+func newMultiBar(workers int) *multiBar {
+	m := &multiBar{
+		out:   os.Stderr,
+		tty:   term.IsTerminal(int(os.Stderr.Fd())),
+		slots: make([]barSlot, workers),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(multiBarInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.mu.Lock()
+				m.redraw()
+				m.mu.Unlock()
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+// 开始在 slot 上显示文件 name 的进度，返回作为 options.progress 的回调
+func (m *multiBar) begin(slot int, name string) func(done, total int64) {
+	m.mu.Lock()
+	m.slots[slot] = barSlot{name: name, total: -1, start: time.Now()}
+	m.mu.Unlock()
+	return func(done, total int64) {
+		m.mu.Lock()
+		m.slots[slot].done, m.slots[slot].total = done, total
+		m.mu.Unlock()
+	}
+}
+
+// 清空 slot
+func (m *multiBar) end(slot int) {
+	m.mu.Lock()
+	m.slots[slot] = barSlot{}
+	m.mu.Unlock()
+}
+
+// 停止刷新并清除进度行
+func (m *multiBar) close() {
+	close(m.stop)
+	<-m.done
+	m.mu.Lock()
+	m.clear()
+	m.mu.Unlock()
+}
+
+// 将 os.Stdout 替换为管道，逐行转发到进度行上方；返回的函数恢复 os.Stdout 并等待剩余输出写完。
+// stderr 不是终端时不需要转发
+func (m *multiBar) captureStdout() (restore func()) {
+	if !m.tty {
+		return func() {}
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return func() {}
+	}
+	orig := os.Stdout
+	os.Stdout = w
+	forwarded := make(chan struct{})
+	go func() {
+		defer close(forwarded)
+		sc := bufio.NewScanner(r)
+		for sc.Scan() {
+			m.mu.Lock()
+			m.clear()
+			fmt.Fprintln(orig, sc.Text())
+			m.redraw()
+			m.mu.Unlock()
+		}
+	}()
+	return func() {
+		os.Stdout = orig
+		w.Close()
+		<-forwarded
+		r.Close()
+	}
+}
+
+// 清除上次绘制的进度行，调用方持有 mu
+func (m *multiBar) clear() {
+	if !m.tty || m.drawn == 0 {
+		return
+	}
+	fmt.Fprintf(m.out, "\033[%dA\033[J", m.drawn)
+	m.drawn = 0
+}
+
+// 重绘所有进度行，调用方持有 mu
+func (m *multiBar) redraw() {
+	if !m.tty {
+		return
+	}
+	var b strings.Builder
+	lines := 0
+	for _, s := range m.slots {
+		if s.name == "" {
+			continue
+		}
+		b.WriteString("\033[2K")
+		b.WriteString(s.render())
+		b.WriteByte('\n')
+		lines++
+	}
+	if m.drawn > 0 {
+		fmt.Fprintf(m.out, "\033[%dA", m.drawn)
+	}
+	if lines < m.drawn {
+		b.WriteString("\033[J")
+	}
+	fmt.Fprint(m.out, b.String())
+	m.drawn = lines
+}
+
+// 渲染一行进度: 文件名 [=====>    ] 45% 12 MB / 30 MB 3.2 MB/s
+func (s barSlot) render() string {
+	const width = 30
+	name := []rune(s.name)
+	if len(name) > 28 {
+		name = append([]rune("…"), name[len(name)-27:]...)
+	}
+	speed := ""
+	if elapsed := time.Since(s.start).Seconds(); elapsed > 0 {
+		speed = formatBytes(int64(float64(s.done)/elapsed)) + "/s"
+	}
+	if s.total <= 0 {
+		return fmt.Sprintf("📤 %-28s %s %s", string(name), formatBytes(s.done), speed)
+	}
+	filled := int(min(s.done*width/s.total, width))
+	bar := strings.Repeat("=", filled)
+	if filled < width {
+		bar += ">" + strings.Repeat(" ", width-filled-1)
+	}
+	return fmt.Sprintf("📤 %-28s [%s] %3d%% %s / %s %s", string(name), bar, s.done*100/s.total,
+		formatBytes(s.done), formatBytes(s.total), speed)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	krbclient "github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+)
+
+// 用本机的 Kerberos 凭据缓存 (kinit 获得的票据) 创建客户端
+func newKerberosClient() (*krbclient.Client, error) {
+	confPath := os.Getenv("KRB5_CONFIG")
+	if confPath == "" {
+		confPath = "/etc/krb5.conf"
+	}
+	conf, err := config.Load(confPath)
+	if err != nil {
+		return nil, fmt.Errorf("读取 Kerberos 配置 %s 失败: %w", confPath, err)
+	}
+
+	ccPath, err := credentialCachePath()
+	if err != nil {
+		return nil, err
+	}
+	cache, err := credentials.LoadCCache(ccPath)
+	if err != nil {
+		return nil, fmt.Errorf("读取凭据缓存 %s 失败 (是否已执行 kinit?): %w", ccPath, err)
+	}
+	cl, err := krbclient.NewFromCCache(cache, conf, krbclient.DisablePAFXFAST(true))
+	if err != nil {
+		return nil, fmt.Errorf("加载 Kerberos 票据失败: %w", err)
+	}
+	return cl, nil
+}
+
+// 凭据缓存路径：KRB5CCNAME (仅支持 FILE 类型)，默认 /tmp/krb5cc_<uid>
+func credentialCachePath() (string, error) {
+	name := os.Getenv("KRB5CCNAME")
+	if name == "" {
+		uid := os.Getuid()
+		if uid < 0 {
+			return "", errors.New("当前平台没有 Kerberos 凭据缓存文件，请设置 KRB5CCNAME 指向 kinit 生成的缓存文件")
+		}
+		return "/tmp/krb5cc_" + strconv.Itoa(uid), nil
+	}
+	if kind, path, ok := strings.Cut(name, ":"); ok {
+		if kind != "FILE" {
+			return "", fmt.Errorf("不支持 %s 类型的凭据缓存，请改用 FILE 类型 (例如 KRB5CCNAME=FILE:/tmp/krb5cc_%d)", kind, os.Getuid())
+		}
+		return path, nil
+	}
+	return name, nil
+}
+
+// negotiateAuth 为每个请求附加 SPNEGO (Negotiate) 认证头，
+// 用于前面有要求 Kerberos 认证的企业网关的接收端
+type negotiateAuth struct {
+	krb  *krbclient.Client
+	spn  string // 服务主体名，为空时按请求的主机名生成 HTTP/<host>
+	next http.RoundTripper
+}
+
+func (t negotiateAuth) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	if err := spnego.SetSPNEGOHeader(t.krb, req, t.spn); err != nil {
+		return nil, fmt.Errorf("获取 Kerberos 服务票据失败: %w", err)
+	}
+	return t.next.RoundTrip(req)
+}
+
+// 启用 Negotiate 认证
+func (c *sessionClient) useNegotiate(spn string) error {
+	krb, err := newKerberosClient()
+	if err != nil {
+		return err
+	}
+	c.Client.Transport = negotiateAuth{krb: krb, spn: spn, next: c.Client.Transport}
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-ntlmssp"
+)
+
+// ntlmProxy 经由需要 NTLM 认证的 HTTP 代理建立隧道。
+// NTLM 的质询/应答必须在同一条连接上完成，标准库的代理支持只能发送一次固定的认证头，
+// 因此由拨号器自行完成 CONNECT 握手，建立隧道后再交给 Transport 使用 (https 目标由 Transport 在隧道内做 TLS)。
+type ntlmProxy struct {
+	proxy    func(*http.Request) (*url.URL, error)
+	user     string // 可带域名: DOMAIN\user 或 user@domain
+	password string
+	dial     func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// 解析 -proxy-ntlm 的凭据 DOMAIN\user[:password]，未写密码时读取环境变量 DOCKER_SAVE_SHELL_PROXY_PASSWORD
+func parseNTLMCredentials(s string) (user, password string, err error) {
+	user, password, ok := strings.Cut(s, ":")
+	if !ok {
+		password = os.Getenv("DOCKER_SAVE_SHELL_PROXY_PASSWORD")
+	}
+	if user == "" || password == "" {
+		return "", "", errors.New("-proxy-ntlm 格式应为 DOMAIN\\user[:password]，未写密码时需设置 DOCKER_SAVE_SHELL_PROXY_PASSWORD")
+	}
+	return user, password, nil
+}
+
+// 去掉凭据中的密码，导出任务定义时不写入明文密码
+func credentialUser(credentials string) string {
+	user, _, _ := strings.Cut(credentials, ":")
+	return user
+}
+
+// 为客户端启用 NTLM 代理认证，代理地址沿用 Transport 原有的代理设置 (-proxy 或 HTTPS_PROXY 等环境变量)
+func (c *sessionClient) useNTLMProxy(credentials string) error {
+	user, password, err := parseNTLMCredentials(credentials)
+	if err != nil {
+		return err
+	}
+	if c.transport.Proxy == nil {
+		return errors.New("未配置代理，-proxy-ntlm 需要指定 -proxy 或设置 HTTPS_PROXY、HTTP_PROXY")
+	}
+	p := &ntlmProxy{proxy: c.transport.Proxy, user: user, password: password, dial: c.transport.DialContext}
+	c.transport.Proxy = nil
+	c.transport.DialContext = p.DialContext
+	return nil
+}
+
+// 按目标地址选择代理；拨号时不知道请求的协议，依次按 https 和 http 查询代理设置
+func (p *ntlmProxy) proxyFor(addr string) (*url.URL, error) {
+	for _, scheme := range []string{"https", "http"} {
+		u, err := p.proxy(&http.Request{URL: &url.URL{Scheme: scheme, Host: addr}})
+		if err != nil || u != nil {
+			return u, err
+		}
+	}
+	return nil, nil
+}
+
+func (p *ntlmProxy) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	proxyURL, err := p.proxyFor(addr)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL == nil {
+		// NO_PROXY 中的地址直接连接
+		return p.dial(ctx, network, addr)
+	}
+
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "80")
+	}
+	conn, err := p.dial(ctx, network, proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("连接代理失败: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	tunnel, err := p.connect(conn, addr)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tunnel, nil
+}
+
+// 发送 CONNECT 并完成 NTLM 握手：协商 → 代理返回 407 和质询 → 认证
+func (p *ntlmProxy) connect(conn net.Conn, addr string) (net.Conn, error) {
+	user, domain, domainNeeded := ntlmssp.GetDomain(p.user)
+	negotiate, err := ntlmssp.NewNegotiateMessage(domain, "")
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := sendConnect(conn, br, addr, negotiate)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusProxyAuthRequired {
+		challenge, err := ntlmChallenge(resp)
+		if err != nil {
+			return nil, err
+		}
+		auth, err := ntlmssp.ProcessChallenge(challenge, user, p.password, domainNeeded)
+		if err != nil {
+			return nil, fmt.Errorf("处理 NTLM 质询失败: %w", err)
+		}
+		if resp, err = sendConnect(conn, br, addr, auth); err != nil {
+			return nil, err
+		}
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusProxyAuthRequired:
+		return nil, errors.New("代理 NTLM 认证失败，请检查用户名、域和密码")
+	default:
+		return nil, fmt.Errorf("代理拒绝 CONNECT: %s", resp.Status)
+	}
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// 发送一次带 NTLM 消息的 CONNECT 请求并读取响应 (响应体读完，保持连接可继续使用)
+func sendConnect(conn net.Conn, br *bufio.Reader, addr string, msg []byte) (*http.Response, error) {
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{
+			"Proxy-Authorization": {"NTLM " + base64.StdEncoding.EncodeToString(msg)},
+			"Proxy-Connection":    {"Keep-Alive"},
+		},
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, fmt.Errorf("发送 CONNECT 失败: %w", err)
+	}
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, fmt.Errorf("读取代理响应失败: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	return resp, nil
+}
+
+// 从 407 响应中取出 NTLM 质询
+func ntlmChallenge(resp *http.Response) ([]byte, error) {
+	for _, h := range resp.Header.Values("Proxy-Authenticate") {
+		if data, ok := strings.CutPrefix(h, "NTLM "); ok {
+			return base64.StdEncoding.DecodeString(strings.TrimSpace(data))
+		}
+	}
+	return nil, errors.New("代理未返回 NTLM 质询 (代理可能不支持 NTLM 认证)")
+}
+
+// bufferedConn 先读出握手时多读入缓冲区的数据
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
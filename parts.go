@@ -0,0 +1,1135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 分块并行上传 (-parallel-chunks)：与 S3 分段上传类似，大文件切成固定大小的块，由多个连接同时发送，
+// 单个连接受延迟限制的高延迟链路上可以成倍提高吞吐。协议：
+//
+//	POST   /uploads                创建上传，JSON {name, size}，返回 {id}
+//	PUT    /uploads/{id}           上传一块，X-Part-Index / X-Part-Offset 为块的序号和在文件中的位置，
+//	                               X-Part-SHA256 (可选) 为该块的摘要
+//	POST   /uploads/{id}/complete  所有块到齐后提交，JSON {fields}，字段与普通上传的表单字段相同
+//	GET    /uploads/{id}           查询上传，返回已收到的块 {parts: [{index, offset, size}]}
+//	DELETE /uploads/{id}           放弃上传
+//	POST   /uploads/{id}/heartbeat 心跳，客户端在上传期间定期发送
+//
+// 创建的响应中 idle_timeout 为服务端的 -upload-idle-timeout (秒)：超过该时长没有收到块或心跳的上传
+// 视为客户端已退出，立即删除临时文件，而不是等到 -upload-ttl 后由 gc 回收。客户端被中断时主动放弃上传，
+// 异常退出时可用 cancel 子命令放弃。
+//
+// 流量控制：创建和每块的响应都带有 X-Upload-Window，即服务端当前允许同时发送的块数。
+// 存储写入缓慢时窗口减半，写入恢复后逐块增大；客户端据此调整并发，
+// 超出窗口的块被拒绝 (429 和 Retry-After)，而不是在服务端或代理中排队直到超时。
+//
+// 会话和已收到的块记录在状态存储 (-state-store) 中，块写入存储目录中的临时文件：服务端重启后上传仍可继续，
+// 多个副本共用 Redis 和存储目录时，同一上传的块可以由不同副本接收。客户端在本机记录断点 (见 partsState)，
+// 重新执行同一上传时查询服务端已收到的块，只发送缺少的块
+const (
+	partIndexHeader  = "X-Part-Index"
+	partOffsetHeader = "X-Part-Offset"
+	partSHA256Header = "X-Part-SHA256"
+	partWindowHeader = "X-Upload-Window"
+
+	defaultPartSize = 16 << 20
+	// 每块上传失败后的最多重试次数 (同时受重试预算限制)
+	maxPartAttempts = 5
+
+	defaultPartWindow = 8
+	// -upload-idle-timeout 的默认值，客户端每隔其 1/3 发送一次心跳
+	defaultUploadIdleTimeout = 10 * time.Minute
+	// 一块写入存储超过该时长即视为存储跟不上，窗口减半；低于其 1/4 时窗口加一
+	partSlowWrite = 15 * time.Second
+)
+
+// partUpload 本副本打开的一次分块上传：各块按偏移量直接写入存储目录中预先创建的临时文件。
+// 会话信息和已收到的块保存在状态存储中，服务端重启或后续的块由其他副本接收时据此恢复；
+// 窗口和正在写入的块数只在本副本内统计
+type partUpload struct {
+	mu   sync.Mutex
+	id   string
+	meta partMeta
+	file *os.File
+
+	window   int // 当前允许同时写入的块数
+	inflight int // 正在写入的块数
+}
+
+// partMeta 状态存储中的分块上传会话
+type partMeta struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Temp    string    `json:"temp"` // 存储目录中的临时文件名
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"` // 最后一次收到块或心跳的时间
+}
+
+// 分块上传在状态存储中的键：<id>/meta.json 为会话，<id>/parts/<序号> 为已收到的块 [偏移量, 长度]。
+// 每块一个键，多个副本同时接收同一上传的块时互不覆盖
+const partStorePrefix = ".dss/uploads/"
+
+func partMetaKey(id string) string {
+	return partStorePrefix + id + "/meta.json"
+}
+
+func partKey(id string, index int) string {
+	return partStorePrefix + id + "/parts/" + strconv.Itoa(index)
+}
+
+// 提交时以 Create 写入，保证同一上传只被一个请求 (或副本) 提交
+func partCommitKey(id string) string {
+	return partStorePrefix + id + "/commit"
+}
+
+// 申领一个写入名额，窗口已满时返回 false；同时返回当前窗口
+func (u *partUpload) acquire() (int, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.inflight >= u.window {
+		return u.window, false
+	}
+	u.inflight++
+	return u.window, true
+}
+
+// 归还写入名额，按这一块写入存储的耗时调整窗口 (不超过 limit)，返回调整后的窗口
+func (u *partUpload) release(index int, wrote time.Duration, limit int) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.inflight--
+	switch {
+	case wrote > partSlowWrite && u.window > 1:
+		u.window = max(u.window/2, 1)
+		fmt.Printf("🐢 %s: 块 %d 写入存储耗时 %s，窗口减小为 %d\n", u.meta.Name, index, wrote.Round(time.Millisecond), u.window)
+	case wrote < partSlowWrite/4 && u.window < limit:
+		u.window++
+	}
+	return u.window
+}
+
+func (u *partUpload) busy() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.inflight > 0
+}
+
+// timedWriter 累计写入的耗时，用于区分存储和网络的速度
+type timedWriter struct {
+	w       io.Writer
+	elapsed time.Duration
+}
+
+func (t *timedWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := t.w.Write(p)
+	t.elapsed += time.Since(start)
+	return n, err
+}
+
+// partSessions 本副本打开的分块上传 (临时文件句柄和窗口)，会话是否存在以状态存储为准
+type partSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*partUpload
+}
+
+// 关闭并移除本副本打开的上传
+func (ps *partSessions) drop(id string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if u := ps.sessions[id]; u != nil {
+		u.file.Close()
+		delete(ps.sessions, id)
+	}
+}
+
+func (ps *partSessions) cached(id string) *partUpload {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.sessions[id]
+}
+
+// 取得分块上传：本副本未打开时按状态存储中的会话打开临时文件；
+// 上传不存在或已被提交、放弃、回收 (包括由其他副本) 时返回 nil
+func (s *server) partUpload(id string) *partUpload {
+	if !uploadIDPattern.MatchString(id) {
+		return nil
+	}
+	meta, err := s.loadPartMeta(id)
+	if err != nil {
+		s.parts.drop(id)
+		return nil
+	}
+	s.parts.mu.Lock()
+	defer s.parts.mu.Unlock()
+	if u := s.parts.sessions[id]; u != nil {
+		return u
+	}
+	file, err := os.OpenFile(filepath.Join(s.opts.dir, meta.Temp), os.O_RDWR, 0)
+	if err != nil {
+		return nil
+	}
+	if s.parts.sessions == nil {
+		s.parts.sessions = map[string]*partUpload{}
+	}
+	u := &partUpload{id: id, meta: meta, file: file, window: s.opts.partWindow}
+	s.parts.sessions[id] = u
+	return u
+}
+
+func (s *server) loadPartMeta(id string) (partMeta, error) {
+	var meta partMeta
+	data, err := s.store.Get(partMetaKey(id))
+	if err != nil {
+		return meta, err
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, err
+	}
+	if filepath.Base(meta.Temp) != meta.Temp || !strings.HasPrefix(meta.Temp, ".upload-") {
+		return meta, fmt.Errorf("上传 %s 的临时文件名非法: %q", id, meta.Temp)
+	}
+	return meta, nil
+}
+
+// 刷新上传的活动时间并写回状态存储
+func (s *server) touchPart(u *partUpload) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.meta.Updated = time.Now()
+	data, err := json.Marshal(u.meta)
+	if err != nil {
+		return err
+	}
+	return s.store.Put(partMetaKey(u.id), data, 0)
+}
+
+// 已收到的块：序号 → [偏移量, 长度]
+func (s *server) receivedParts(id string) (map[int][2]int64, error) {
+	keys, err := s.store.List(partStorePrefix + id + "/parts/")
+	if err != nil {
+		return nil, err
+	}
+	parts := map[int][2]int64{}
+	for _, key := range keys {
+		index, err := strconv.Atoi(path.Base(key))
+		if err != nil {
+			continue
+		}
+		data, err := s.store.Get(key)
+		if err != nil {
+			continue
+		}
+		var r [2]int64
+		if json.Unmarshal(data, &r) == nil {
+			parts[index] = r
+		}
+	}
+	return parts, nil
+}
+
+// 删除上传在状态存储中的记录，removeTemp 时同时删除临时文件
+func (s *server) removeParts(id string, meta partMeta, removeTemp bool) {
+	s.parts.drop(id)
+	if removeTemp {
+		os.Remove(filepath.Join(s.opts.dir, meta.Temp))
+	}
+	keys, _ := s.store.List(partStorePrefix + id + "/")
+	for _, key := range keys {
+		s.store.Delete(key)
+	}
+	// 文件存储还会留下空目录
+	if f, ok := s.store.(fileStore); ok {
+		os.RemoveAll(f.path(partStorePrefix + id))
+	}
+}
+
+// 删除超过 idle 没有活动 (块和心跳) 的上传及其临时文件，本副本正有块在写入的除外；返回删除的上传的文件名
+func (s *server) reapParts(idle time.Duration) []string {
+	keys, err := s.store.List(partStorePrefix)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, key := range keys {
+		id, ok := strings.CutSuffix(strings.TrimPrefix(key, partStorePrefix), "/meta.json")
+		if !ok {
+			continue
+		}
+		meta, err := s.loadPartMeta(id)
+		if err != nil || time.Since(meta.Updated) <= idle {
+			continue
+		}
+		if u := s.parts.cached(id); u != nil && u.busy() {
+			continue
+		}
+		s.removeParts(id, meta, true)
+		names = append(names, meta.Name)
+	}
+	return names
+}
+
+// 定期清理客户端已退出的分块上传
+func (s *server) reapPartsLoop() {
+	ticker := time.NewTicker(max(min(s.opts.uploadIdleTimeout/2, time.Minute), time.Second))
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, name := range s.reapParts(s.opts.uploadIdleTimeout) {
+			fmt.Printf("🧹 分块上传 %s 已 %s 没有活动，删除已收到的块\n", name, s.opts.uploadIdleTimeout)
+		}
+	}
+}
+
+// 创建分块上传
+func (s *server) handleCreateParts(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+		Size int64  `json:"size"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxFormFieldSize)).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, uploadResult{Error: "请求格式错误: " + err.Error()})
+		return
+	}
+	name, err := safeFileName(req.Name)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, uploadResult{Error: err.Error()})
+		return
+	}
+	if req.Size <= 0 {
+		writeJSON(w, http.StatusBadRequest, uploadResult{Error: "分块上传需要文件大小"})
+		return
+	}
+	// 顺带回收超过 -upload-ttl 未更新的上传 (临时文件另由 gc 回收)
+	if s.opts.gcUploadTTL > 0 {
+		s.reapParts(s.opts.gcUploadTTL)
+	}
+
+	tmp, err := os.CreateTemp(s.opts.dir, ".upload-*")
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, uploadResult{Error: "创建临时文件失败: " + err.Error()})
+		return
+	}
+	tmp.Chmod(0o644)
+	if err := tmp.Truncate(req.Size); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		writeJSON(w, http.StatusInsufficientStorage, uploadResult{Error: "预留空间失败: " + err.Error()})
+		return
+	}
+	tmp.Close()
+
+	id := randomID()
+	now := time.Now()
+	data, err := json.Marshal(partMeta{Name: name, Size: req.Size, Temp: filepath.Base(tmp.Name()), Created: now, Updated: now})
+	if err == nil {
+		err = s.store.Create(partMetaKey(id), data)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		writeJSON(w, http.StatusInternalServerError, uploadResult{Error: "记录上传会话失败: " + err.Error()})
+		return
+	}
+	window := s.opts.partWindow
+	fmt.Printf("🧩 开始分块上传: %s (%s)\n", name, formatBytes(req.Size))
+	w.Header().Set(partWindowHeader, strconv.Itoa(window))
+	writeJSON(w, http.StatusCreated, map[string]any{"id": id, "window": window, "idle_timeout": int(s.opts.uploadIdleTimeout.Seconds())})
+}
+
+// partStatus 查询上传时返回的已收到的块
+type partStatus struct {
+	Index  int   `json:"index"`
+	Offset int64 `json:"offset"`
+	Size   int64 `json:"size"`
+}
+
+// 查询上传：会话信息和已收到的块，客户端续传时据此跳过已完成的块
+func (s *server) handlePartsStatus(w http.ResponseWriter, r *http.Request) {
+	u := s.partUpload(r.PathValue("id"))
+	if u == nil {
+		writeJSON(w, http.StatusNotFound, uploadResult{Error: "上传不存在或已过期"})
+		return
+	}
+	received, err := s.receivedParts(u.id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, uploadResult{Error: "读取上传记录失败: " + err.Error()})
+		return
+	}
+	parts := []partStatus{}
+	for index, p := range received {
+		parts = append(parts, partStatus{Index: index, Offset: p[0], Size: p[1]})
+	}
+	slices.SortFunc(parts, func(a, b partStatus) int { return a.Index - b.Index })
+	u.mu.Lock()
+	window := u.window
+	u.mu.Unlock()
+	w.Header().Set(partWindowHeader, strconv.Itoa(window))
+	writeJSON(w, http.StatusOK, map[string]any{
+		"id": u.id, "name": u.meta.Name, "size": u.meta.Size, "parts": parts,
+		"window": window, "idle_timeout": int(s.opts.uploadIdleTimeout.Seconds()),
+	})
+}
+
+// 接收一块，写入临时文件的对应位置；同一序号重复上传时以最后一次为准
+func (s *server) handlePutPart(w http.ResponseWriter, r *http.Request) {
+	u := s.partUpload(r.PathValue("id"))
+	if u == nil {
+		writeJSON(w, http.StatusNotFound, uploadResult{Error: "上传不存在或已过期"})
+		return
+	}
+	index, err1 := strconv.Atoi(r.Header.Get(partIndexHeader))
+	offset, err2 := strconv.ParseInt(r.Header.Get(partOffsetHeader), 10, 64)
+	if err1 != nil || err2 != nil || index < 0 || offset < 0 {
+		writeJSON(w, http.StatusBadRequest, uploadResult{Error: "缺少或非法的 " + partIndexHeader + " / " + partOffsetHeader})
+		return
+	}
+	n := r.ContentLength
+	if n <= 0 || offset+n > u.meta.Size {
+		writeJSON(w, http.StatusBadRequest, uploadResult{Error: fmt.Sprintf("块超出文件范围: 偏移量 %d，长度 %d，文件大小 %d", offset, n, u.meta.Size)})
+		return
+	}
+
+	// 超出窗口的块不读取请求体，由客户端稍后重发
+	window, ok := u.acquire()
+	if !ok {
+		w.Header().Set(partWindowHeader, strconv.Itoa(window))
+		w.Header().Set("Retry-After", "1")
+		writeJSON(w, http.StatusTooManyRequests, uploadResult{Error: fmt.Sprintf("同时写入的块数已达窗口上限 %d", window)})
+		return
+	}
+	h := sha256.New()
+	dst := &timedWriter{w: io.NewOffsetWriter(u.file, offset)}
+	written, err := io.Copy(dst, io.TeeReader(io.LimitReader(r.Body, n), h))
+	if err == nil && written != n {
+		err = io.ErrUnexpectedEOF
+	}
+	w.Header().Set(partWindowHeader, strconv.Itoa(u.release(index, dst.elapsed, s.opts.partWindow)))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, uploadResult{Error: "接收块失败: " + err.Error()})
+		return
+	}
+	if want := r.Header.Get(partSHA256Header); want != "" {
+		if got := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(want, got) {
+			writeJSON(w, http.StatusUnprocessableEntity, uploadResult{Error: fmt.Sprintf("块 %d 校验失败: 期望 %s，实际 %s", index, want, got)})
+			return
+		}
+	}
+
+	// 数据落盘后才记录该块，服务端崩溃时记录中的块都已写入临时文件
+	if err := u.file.Sync(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, uploadResult{Error: "写入块失败: " + err.Error()})
+		return
+	}
+	data, _ := json.Marshal([2]int64{offset, n})
+	if err := s.store.Put(partKey(u.id, index), data, 0); err != nil {
+		writeJSON(w, http.StatusInternalServerError, uploadResult{Error: "记录块失败: " + err.Error()})
+		return
+	}
+	s.touchPart(u)
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "index": index})
+}
+
+// 所有块到齐后计算整个文件的摘要，再按普通上传的流程校验和提交
+func (s *server) handleCompleteParts(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Fields map[string]string `json:"fields"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxFormFieldSize)).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, uploadResult{Error: "请求格式错误: " + err.Error()})
+		return
+	}
+	u := s.partUpload(r.PathValue("id"))
+	if u == nil {
+		writeJSON(w, http.StatusNotFound, uploadResult{Error: "上传不存在或已过期"})
+		return
+	}
+	received, err := s.receivedParts(u.id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, uploadResult{Error: "读取上传记录失败: " + err.Error()})
+		return
+	}
+	// 缺块时会话保留，客户端可以补传后再次提交
+	if missing := missingParts(received, u.meta.Size); missing != "" {
+		writeJSON(w, http.StatusConflict, uploadResult{Error: "块不完整: " + missing})
+		return
+	}
+	if err := s.store.Create(partCommitKey(u.id), []byte(time.Now().Format(time.RFC3339))); err != nil {
+		writeJSON(w, http.StatusConflict, uploadResult{Error: "上传已在提交中"})
+		return
+	}
+	// 无论提交结果如何，会话都到此结束；临时文件在提交成功时被移走，否则删除
+	meta := u.meta
+	defer s.removeParts(u.id, meta, false)
+
+	file := &receivedFile{tmpPath: u.file.Name(), name: meta.Name, size: meta.Size}
+	defer func() {
+		if file.tmpPath != "" {
+			os.Remove(file.tmpPath)
+		}
+	}()
+	h := sha256.New()
+	_, err = io.Copy(h, io.NewSectionReader(u.file, 0, meta.Size))
+	s.parts.drop(u.id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, uploadResult{Error: "读取文件失败: " + err.Error()})
+		return
+	}
+	file.sha256 = hex.EncodeToString(h.Sum(nil))
+	fmt.Printf("🧩 分块上传完成: %s (%d 块)\n", meta.Name, len(received))
+
+	fields := req.Fields
+	if fields == nil {
+		fields = map[string]string{}
+	}
+	s.acceptUpload(w, r, file, fields)
+}
+
+// 放弃分块上传
+func (s *server) handleAbortParts(w http.ResponseWriter, r *http.Request) {
+	u := s.partUpload(r.PathValue("id"))
+	if u == nil {
+		writeJSON(w, http.StatusNotFound, uploadResult{Error: "上传不存在或已过期"})
+		return
+	}
+	s.removeParts(u.id, u.meta, true)
+	fmt.Printf("🗑️  分块上传已放弃: %s\n", u.meta.Name)
+	writeJSON(w, http.StatusOK, uploadResult{OK: true, Name: u.meta.Name})
+}
+
+// 客户端心跳：刷新上传的活动时间
+func (s *server) handlePartsHeartbeat(w http.ResponseWriter, r *http.Request) {
+	u := s.partUpload(r.PathValue("id"))
+	if u == nil {
+		writeJSON(w, http.StatusNotFound, uploadResult{Error: "上传不存在或已过期"})
+		return
+	}
+	if err := s.touchPart(u); err != nil {
+		writeJSON(w, http.StatusInternalServerError, uploadResult{Error: "记录心跳失败: " + err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, uploadResult{OK: true, Name: u.meta.Name})
+}
+
+// 检查各块是否覆盖整个文件，返回第一处缺口的描述，完整时返回空
+func missingParts(parts map[int][2]int64, size int64) string {
+	ranges := make([][2]int64, 0, len(parts))
+	for _, p := range parts {
+		ranges = append(ranges, p)
+	}
+	slices.SortFunc(ranges, func(a, b [2]int64) int { return int(a[0] - b[0]) })
+
+	var covered int64
+	for _, p := range ranges {
+		if p[0] > covered {
+			break
+		}
+		covered = max(covered, p[0]+p[1])
+	}
+	if covered < size {
+		return fmt.Sprintf("缺少 %d 之后的数据 (文件大小 %d)", covered, size)
+	}
+	return ""
+}
+
+// 客户端：检查分块并行上传的参数。只支持本地普通文件，数据原样发送
+func checkPartsOptions(opts options) error {
+	switch {
+	case opts.tus:
+		return errors.New("-parallel-chunks 不能与 -tus 同时使用")
+	case isRemoteSource(opts.filePath):
+		return errors.New("-parallel-chunks 只能上传本地文件")
+	case opts.preset != "":
+		return errors.New("-parallel-chunks 不能与 -preset 同时使用")
+	case opts.pipeline != "" && opts.pipeline != defaultPipeline, opts.compress != "" && opts.compress != "none", opts.format != "" && opts.format != "tar", opts.encryptKey != "":
+		return errors.New("-parallel-chunks 不支持 -pipeline、-compress、-format 和 -encrypt-key (各块按原始文件的偏移量发送)")
+	case opts.extractTo != "" || opts.sums:
+		return errors.New("-parallel-chunks 不支持目录上传 (-extract-to、-sums)")
+	case opts.form.custom():
+		return errors.New("-parallel-chunks 不以 multipart 表单发送，不支持 -form、-field-name")
+	}
+	info, err := os.Stat(opts.filePath)
+	if err != nil {
+		return fmt.Errorf("无法获取文件信息: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return errors.New("-parallel-chunks 只能上传普通文件")
+	}
+	return nil
+}
+
+// partsState 分块上传的断点文件：记录服务端的上传和已确认的块，重新执行同一上传时继续该上传，只发送缺少的块
+type partsState struct {
+	ID       string    `json:"id"`
+	Location string    `json:"location"`
+	Source   string    `json:"source"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"` // 源文件变化后断点失效
+	PartSize int64     `json:"part_size"`
+	Done     []int     `json:"done"` // 服务端已确认的块
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+}
+
+// 断点文件路径：由源文件和上传地址共同决定
+func partsStatePath(source, endpoint string) string {
+	sum := sha256.Sum256([]byte(source + "\n" + endpoint))
+	return filepath.Join(stateDir(), "parts", hex.EncodeToString(sum[:8])+".json")
+}
+
+// 读取断点，不存在或与源文件不符时返回 nil
+func loadPartsState(path string, size int64, modTime time.Time) *partsState {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	st := &partsState{}
+	if json.Unmarshal(data, st) != nil || st.Location == "" || st.PartSize <= 0 || st.Size != size || !st.ModTime.Equal(modTime) {
+		return nil
+	}
+	return st
+}
+
+func (st *partsState) save(path string) error {
+	st.Updated = time.Now()
+	return saveStateFile(path, st)
+}
+
+// errPartsGone 服务端已不存在断点对应的上传 (已提交、放弃或过期)，需要重新创建
+var errPartsGone = errors.New("服务端已不存在该上传")
+
+// 查询服务端已收到的块，返回与本地分块方式一致的块序号，以及服务端的窗口和空闲超时
+func partsStatus(ctx context.Context, client *sessionClient, location string, size, partSize int64) (map[int]bool, int, int, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", location, nil)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, 0, 0, errPartsGone
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, 0, fmt.Errorf("服务端返回 %s", responseError(resp))
+	}
+	var status struct {
+		Size        int64        `json:"size"`
+		Parts       []partStatus `json:"parts"`
+		Window      int          `json:"window"`
+		IdleTimeout int          `json:"idle_timeout"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, 0, 0, fmt.Errorf("解析上传状态失败: %w", err)
+	}
+	if status.Size != size {
+		return nil, 0, 0, errPartsGone
+	}
+	done := map[int]bool{}
+	for _, p := range status.Parts {
+		offset := int64(p.Index) * partSize
+		if p.Offset == offset && p.Size == min(partSize, size-offset) {
+			done[p.Index] = true
+		}
+	}
+	return done, status.Window, status.IdleTimeout, nil
+}
+
+// 分块并行上传：创建上传后由 opts.parallelChunks 个协程同时发送各块，每块失败后单独重试，
+// 全部完成后提交并输出服务端的结果。每块确认后记入断点文件，中断后重新执行同一命令时只发送服务端缺少的块
+func partsUpload(ctx context.Context, client *sessionClient, opts options, sla *slaMonitor, rec *transferRecord) error {
+	file, err := os.Open(opts.filePath)
+	if err != nil {
+		return fmt.Errorf("无法打开文件: %w", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("无法获取文件信息: %w", err)
+	}
+	size := info.Size()
+	partSize := int64(opts.partSize)
+	if partSize <= 0 {
+		partSize = defaultPartSize
+	}
+
+	base, err := url.Parse(opts.serverURL)
+	if err != nil {
+		return fmt.Errorf("服务端地址格式错误: %w", err)
+	}
+	endpoint := base.ResolveReference(&url.URL{Path: "uploads"}).String()
+
+	fileName := info.Name()
+	if opts.asciiName && !isASCIIName(fileName) {
+		fileName = asciiName(fileName)
+	}
+
+	// 有断点时以服务端实际收到的块为准继续上传
+	source, _ := filepath.Abs(opts.filePath)
+	statePath := partsStatePath(source, endpoint)
+	st := loadPartsState(statePath, size, info.ModTime())
+	done := map[int]bool{}
+	var window, idleTimeout int
+	if st != nil {
+		done, window, idleTimeout, err = partsStatus(ctx, client, st.Location, size, st.PartSize)
+		switch {
+		case errors.Is(err, errPartsGone):
+			fmt.Println("♻️  服务端已不存在断点对应的上传，重新开始")
+			st, done = nil, map[int]bool{}
+		case err != nil:
+			return fmt.Errorf("查询断点上传失败: %w", windowError(ctx, err))
+		default:
+			if st.PartSize != partSize {
+				fmt.Printf("🧩 沿用断点的块大小 %s\n", formatBytes(st.PartSize))
+				partSize = st.PartSize
+			}
+		}
+	}
+	count := int((size + partSize - 1) / partSize)
+	workers := min(opts.parallelChunks, max(count-len(done), 1))
+
+	fmt.Printf("📁 文件: %s\n", fileName)
+	fmt.Printf("📊 大小: %s\n", formatBytes(size))
+	fmt.Printf("🎯 目标: %s\n", opts.serverURL)
+	fmt.Printf("🧩 分块: %d 块 × %s，并发 %d\n", count, formatBytes(partSize), workers)
+
+	// 删除源文件前需要由服务端确认摘要，-checksum 时先计算整个文件的摘要
+	var sum string
+	if opts.checksum {
+		h := sha256.New()
+		if _, err := io.Copy(h, io.NewSectionReader(file, 0, size)); err != nil {
+			return fmt.Errorf("读取文件失败: %w", err)
+		}
+		sum = hex.EncodeToString(h.Sum(nil))
+	}
+
+	if st == nil {
+		var created struct {
+			ID          string `json:"id"`
+			Window      int    `json:"window"`
+			IdleTimeout int    `json:"idle_timeout"`
+		}
+		if err := postPartsJSON(ctx, client, endpoint, map[string]any{"name": fileName, "size": size}, &created); err != nil {
+			return fmt.Errorf("创建分块上传失败: %w", err)
+		}
+		now := time.Now()
+		st = &partsState{ID: created.ID, Location: endpoint + "/" + created.ID, Source: source, Size: size, ModTime: info.ModTime(), PartSize: partSize, Created: now}
+		window, idleTimeout = created.Window, created.IdleTimeout
+	} else {
+		fmt.Printf("♻️  继续分块上传 %s: 服务端已有 %d/%d 块\n", st.ID, len(done), count)
+	}
+	st.Done = st.Done[:0]
+	for i := range done {
+		st.Done = append(st.Done, i)
+	}
+	slices.Sort(st.Done)
+	// 记录断点，进程异常退出后重新执行同一命令即可继续，也可用 cancel 子命令放弃
+	if err := st.save(statePath); err != nil {
+		fmt.Printf("⚠️  %v\n", err)
+	}
+	location := st.Location
+	if idleTimeout > 0 {
+		stopHeartbeat := startPartsHeartbeat(ctx, client, location, time.Duration(idleTimeout)*time.Second/3)
+		defer stopHeartbeat()
+	}
+	// 不通告窗口的旧版服务端不限制
+	gate := newPartGate(workers)
+	if window > 0 {
+		gate.update(window)
+		if window < workers {
+			fmt.Printf("🚦 服务端窗口: 同时 %d 块\n", window)
+		}
+	}
+
+	var already int64
+	for i := range done {
+		already += min(partSize, size-int64(i)*partSize)
+	}
+	bar := newTransferBar(size, "📤 上传 "+fileName)
+	if opts.hideBar {
+		bar = hiddenBar(size)
+	}
+	bar.Set64(already)
+	sendLimiter := opts.uploadLimiter()
+	rec.attempted = true
+	start := time.Now()
+
+	var sent atomic.Int64
+	sent.Store(already)
+	var progressMu, stateMu sync.Mutex
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				offset := int64(i) * partSize
+				n := min(partSize, size-offset)
+				if err := sendPart(ctx, client, opts, location, file, i, offset, n, bar, sla, sendLimiter, gate); err != nil {
+					cancel(fmt.Errorf("块 %d 上传失败: %w", i, err))
+					return
+				}
+				stateMu.Lock()
+				st.Done = append(st.Done, i)
+				if err := st.save(statePath); err != nil {
+					fmt.Printf("\n⚠️  %v\n", err)
+				}
+				stateMu.Unlock()
+				total := sent.Add(n)
+				if opts.progress != nil {
+					progressMu.Lock()
+					opts.progress(total, size)
+					progressMu.Unlock()
+				}
+			}
+		}()
+	}
+send:
+	for i := range count {
+		if done[i] {
+			continue
+		}
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	bar.Finish()
+	if err := context.Cause(ctx); err != nil {
+		// 只有被中断时才通知服务端删除已收到的块；窗口到期或出错时保留服务端的上传和断点，重新执行即可继续
+		if errors.Is(err, errInterrupted) {
+			if aerr := abortParts(client, location); aerr != nil {
+				fmt.Printf("⚠️  放弃服务端的上传失败 (%v)，可稍后执行 docker_save_shell cancel %s\n", aerr, st.ID)
+			} else {
+				os.Remove(statePath)
+			}
+			return errInterrupted
+		}
+		fmt.Printf("🧷 已保留服务端的上传 %s (已完成 %d/%d 块)，重新执行同一命令继续，或执行 docker_save_shell cancel %s 放弃\n", st.ID, len(st.Done), count, st.ID)
+		return windowError(ctx, err)
+	}
+
+	fields, err := uploadFields(opts, sum)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("\n📥 提交上传...")
+	body, err := json.Marshal(map[string]any{"fields": fields})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", location+"/complete", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("提交上传失败: %w", err)
+	}
+	defer resp.Body.Close()
+	// 服务端收到提交后会话即结束，断点不再有效
+	os.Remove(statePath)
+	rec.Bytes, rec.Duration = size-already, time.Since(start).Seconds()
+	return reportUploadResponse(resp, opts, sum)
+}
+
+// 提交时随数据发送的表单字段 (摘要、制品、远程加载)，与普通上传的表单字段相同
+func uploadFields(opts options, sum string) (map[string]string, error) {
+	fields := map[string]string{}
+	if sum != "" {
+		fields["sha256"] = sum
+	}
+	if opts.artifactName != "" {
+		fields["artifact_name"], fields["artifact_version"] = opts.artifactName, opts.artifactVersion
+		if len(opts.labels) > 0 {
+			labels, err := json.Marshal(labelMap(opts.labels))
+			if err != nil {
+				return nil, err
+			}
+			fields["labels"] = string(labels)
+		}
+	}
+	if opts.remoteLoad || opts.remoteTag != "" {
+		fields["docker_load"], fields["docker_tag"] = "true", opts.remoteTag
+		if opts.smoke != "" {
+			fields["smoke"] = opts.smoke
+		}
+	}
+	if opts.ifExists != "" {
+		fields["if_exists"] = opts.ifExists
+	}
+	return fields, nil
+}
+
+// 输出服务端对提交的响应，失败时返回 *statusError；sum 为发送数据的摘要，-prune-after-upload 时据此确认后删除源文件
+func reportUploadResponse(resp *http.Response, opts options, sum string) error {
+	responseBody, err := captureBody(resp.Body, int64(opts.maxResponse))
+	if err != nil {
+		return fmt.Errorf("读取响应失败: %w", err)
+	}
+
+	fmt.Printf("\n 响应状态码: %d\n", resp.StatusCode)
+	ok := resp.StatusCode == http.StatusOK
+	if ok {
+		fmt.Println("上传成功!")
+	} else {
+		fmt.Printf("上传失败\n")
+	}
+	fmt.Printf("📝 服务器返回: %s\n", responseBody.display(!ok))
+	if opts.smoke != "" {
+		printSmokeResult(responseBody.data)
+	}
+	if ok {
+		printCollision(responseBody.data)
+	}
+	if sum != "" {
+		fmt.Printf("🔐 SHA-256: %s\n", sum)
+	}
+	if !ok {
+		return &statusError{code: resp.StatusCode, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+	if opts.pruneAfterUpload {
+		if err := confirmDigest(responseBody.data, sum); err != nil {
+			fmt.Printf("⚠️  %v，保留源文件\n", err)
+			return nil
+		}
+		pruneSource(opts)
+	}
+	return nil
+}
+
+// partGate 按服务端通告的窗口限制同时发送的块数
+type partGate struct {
+	mu      sync.Mutex
+	limit   int // 客户端的并发数
+	window  int
+	active  int
+	changed chan struct{} // 名额释放或窗口变化时关闭
+}
+
+func newPartGate(limit int) *partGate {
+	return &partGate{limit: limit, window: limit, changed: make(chan struct{})}
+}
+
+// 等待一个发送名额
+func (g *partGate) acquire(ctx context.Context) error {
+	for {
+		g.mu.Lock()
+		if g.active < g.window {
+			g.active++
+			g.mu.Unlock()
+			return nil
+		}
+		changed := g.changed
+		g.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (g *partGate) release() {
+	g.mu.Lock()
+	g.active--
+	g.notify()
+	g.mu.Unlock()
+}
+
+// 采用服务端通告的窗口 (不超过客户端的并发数)
+func (g *partGate) update(window int) {
+	if window <= 0 {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	window = min(window, g.limit)
+	if window == g.window {
+		return
+	}
+	if window < g.window {
+		fmt.Printf("\n🚦 服务端存储写入缓慢，同时发送的块数降为 %d\n", window)
+	}
+	g.window = window
+	g.notify()
+}
+
+// 调用方持有 g.mu
+func (g *partGate) notify() {
+	close(g.changed)
+	g.changed = make(chan struct{})
+}
+
+// errPartBusy 服务端窗口已满，稍后重发该块，不计入失败次数
+type errPartBusy struct {
+	retryAfter time.Duration
+}
+
+func (e *errPartBusy) Error() string { return "服务端窗口已满" }
+
+// 发送一块，失败时从重试预算中申领重试
+func sendPart(ctx context.Context, client *sessionClient, opts options, location string, file *os.File, index int, offset, n int64, bar transferBar, sla *slaMonitor, limiter *rateLimiter, gate *partGate) error {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(file, offset, n)); err != nil {
+		return fmt.Errorf("读取文件失败: %w", err)
+	}
+	digest := hex.EncodeToString(h.Sum(nil))
+
+	for attempt := 1; ; attempt++ {
+		if err := gate.acquire(ctx); err != nil {
+			return err
+		}
+		var r io.Reader = io.NewSectionReader(file, offset, n)
+		if opts.readLimit > 0 {
+			r = newLimitedReader(ctx, r, int64(opts.readLimit))
+		}
+		if limiter != nil {
+			r = &limitedReader{ctx: ctx, r: r, l: limiter}
+		}
+		window, err := putPart(ctx, client, location, index, offset, n, digest, io.TeeReader(&slaCounter{r: &contextReader{ctx: ctx, r: r}, m: sla}, bar))
+		gate.release()
+		gate.update(window)
+		if busy, ok := err.(*errPartBusy); ok {
+			attempt--
+			select {
+			case <-time.After(max(busy.retryAfter, time.Second)):
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if err == nil || ctx.Err() != nil || attempt >= maxPartAttempts {
+			return err
+		}
+		delay := min(time.Second<<(attempt-1), 30*time.Second)
+		if berr := opts.retry.take("chunk", delay); berr != nil {
+			return fmt.Errorf("%w (%v)", err, berr)
+		}
+		fmt.Printf("\n🔁 块 %d 上传失败，%s 后重试: %v\n", index, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// 发送一块，返回服务端响应中通告的窗口 (未通告时为 0)
+func putPart(ctx context.Context, client *sessionClient, location string, index int, offset, n int64, digest string, body io.Reader) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "PUT", location, body)
+	if err != nil {
+		return 0, err
+	}
+	req.ContentLength = n
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(partIndexHeader, strconv.Itoa(index))
+	req.Header.Set(partOffsetHeader, strconv.FormatInt(offset, 10))
+	req.Header.Set(partSHA256Header, digest)
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	window, _ := strconv.Atoi(resp.Header.Get(partWindowHeader))
+	if resp.StatusCode == http.StatusTooManyRequests && window > 0 {
+		return window, &errPartBusy{retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return window, fmt.Errorf("服务端返回 %s", responseError(resp))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return window, nil
+}
+
+// 以 JSON POST 并解析 JSON 响应
+func postPartsJSON(ctx context.Context, client *sessionClient, endpoint string, in, out any) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("服务端返回 %s", responseError(resp))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// 放弃上传，让服务端立即释放临时文件；上传已不存在时也视为成功
+func abortParts(client *sessionClient, location string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "DELETE", location, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("服务端返回 %s", responseError(resp))
+	}
+	return nil
+}
+
+// 上传期间每隔 interval 发送心跳，服务端据此区分慢速上传和已退出的客户端；返回停止心跳的函数
+func startPartsHeartbeat(ctx context.Context, client *sessionClient, location string, interval time.Duration) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		ticker := time.NewTicker(max(interval, time.Second))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			req, err := http.NewRequestWithContext(ctx, "POST", location+"/heartbeat", nil)
+			if err != nil {
+				return
+			}
+			// 心跳失败不影响上传，块请求本身也会刷新活动时间
+			if resp, err := client.Do(req); err == nil {
+				resp.Body.Close()
+			}
+		}
+	}()
+	return cancel
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// 默认流水线：读取源文件后直接上传
+const defaultPipeline = "read,upload"
+
+// stage 流水线中的一个处理阶段
+type stage struct {
+	name   string
+	suffix string // 该阶段给上传文件名追加的后缀，例如 .gz
+	ext    string // 格式转换阶段替换文件名的 .tar 扩展名，例如 .sqsh
+	wrap   func(r io.Reader) io.Reader
+
+	// 压缩类阶段，数据不可压缩时可以自动跳过
+	compressor bool
+	encoding   string // 压缩格式对应的 Content-Encoding
+	mediaType  string // 压缩后数据的 Content-Type
+
+	encrypt bool // 加密阶段，wrap 由 useEncryptKey 按密钥设置
+}
+
+// 可插入 read 与 upload 之间的处理阶段
+var stageRegistry = map[string]stage{
+	"gzip":      {name: "gzip", suffix: ".gz", wrap: gzipStage, compressor: true, encoding: "gzip", mediaType: "application/gzip"},
+	"zstd":      {name: "zstd", suffix: ".zst", wrap: zstdStage(zstd.SpeedDefault), compressor: true, encoding: "zstd", mediaType: "application/zstd"},
+	"zstd-fast": {name: "zstd-fast", suffix: ".zst", wrap: zstdStage(zstd.SpeedFastest), compressor: true, encoding: "zstd", mediaType: "application/zstd"},
+	"zstd-high": {name: "zstd-high", suffix: ".zst", wrap: zstdStage(zstd.SpeedBestCompression), compressor: true, encoding: "zstd", mediaType: "application/zstd"},
+	"oci":       {name: "oci", ext: ".oci.tar", wrap: ociStage},
+	"squashfs":  {name: "squashfs", ext: ".sqsh", wrap: squashfsStage},
+	"encrypt":   {name: "encrypt", suffix: encryptSuffix, encrypt: true},
+}
+
+// 各 zstd 阶段的压缩级别
+var zstdLevels = map[string]zstd.EncoderLevel{
+	"zstd-fast": zstd.SpeedFastest,
+	"zstd":      zstd.SpeedDefault,
+	"zstd-high": zstd.SpeedBestCompression,
+}
+
+// 由 -compress 确定流水线：gzip/zstd 等同于 read,<格式>,upload，none 为默认流水线，
+// auto 也从默认流水线开始，由 build 根据测得的带宽和压缩速度插入压缩阶段；
+// 与自定义的 -pipeline 同时指定时报错，避免两者含义冲突
+func compressPipeline(spec, compress string) (string, error) {
+	switch compress {
+	case "":
+		return spec, nil
+	case "none", "auto":
+	default:
+		st, ok := stageRegistry[compress]
+		if !ok || !st.compressor {
+			return "", fmt.Errorf("不支持的压缩格式: %s (可选 gzip、zstd、zstd-fast、zstd-high、auto、none)", compress)
+		}
+	}
+	if spec != "" && spec != defaultPipeline {
+		return "", errors.New("-compress 不能与 -pipeline 同时使用")
+	}
+	if compress == "none" || compress == "auto" {
+		return defaultPipeline, nil
+	}
+	return "read," + compress + ",upload", nil
+}
+
+// stageMetric 单个阶段的统计信息
+type stageMetric struct {
+	name  string
+	bytes int64         // 该阶段输出的字节数
+	total time.Duration // 在该阶段及其上游 Read 中花费的总时间
+	self  time.Duration // 扣除上游后该阶段自身花费的时间
+}
+
+// pipeline 由 read → 若干处理阶段 → upload 组成的数据流水线
+type pipeline struct {
+	stages  []stage
+	active  []stage // build 之后实际生效的阶段
+	metrics []*stageMetric
+
+	forceCompress bool             // 关闭不可压缩内容的自动跳过
+	auto          *autoCompression // -compress auto 时由 build 选择压缩阶段
+	decisions     []string         // 自动决策记录，verbose 模式下输出
+}
+
+// 解析 --pipeline 参数，例如 "read,gzip,upload"
+// read 和 upload 分别固定为首尾阶段，可以省略不写。
+func parsePipeline(spec string) (*pipeline, error) {
+	if spec == "" {
+		spec = defaultPipeline
+	}
+
+	p := &pipeline{}
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "", "read", "upload":
+			continue
+		}
+		st, ok := stageRegistry[name]
+		if !ok {
+			return nil, fmt.Errorf("未知的流水线阶段: %s", name)
+		}
+		p.stages = append(p.stages, st)
+	}
+	return p, nil
+}
+
+// 经过各处理阶段后的文件名：格式转换替换扩展名，压缩追加后缀
+func (p *pipeline) fileName(name string) string {
+	for _, st := range p.active {
+		if st.ext != "" {
+			name = formatFileName(name, st.ext)
+		}
+		name += st.suffix
+	}
+	return name
+}
+
+// 是否有压缩类阶段实际生效
+func (p *pipeline) compressed() bool {
+	for _, st := range p.active {
+		if st.compressor {
+			return true
+		}
+	}
+	return false
+}
+
+// 是否加密上传
+func (p *pipeline) encrypted() bool {
+	for _, st := range p.active {
+		if st.encrypt {
+			return true
+		}
+	}
+	return false
+}
+
+// 实际生效的压缩阶段，没有时返回 nil
+func (p *pipeline) compressor() *stage {
+	for i := range p.active {
+		if p.active[i].compressor {
+			return &p.active[i]
+		}
+	}
+	return nil
+}
+
+// 原始数据和流水线输出的字节数
+func (p *pipeline) byteCounts() (raw, out int64) {
+	if len(p.metrics) == 0 {
+		return 0, 0
+	}
+	raw = p.metrics[0].bytes
+	for _, m := range p.metrics {
+		if m.name != "upload" {
+			out = m.bytes
+		}
+	}
+	return raw, out
+}
+
+// 将源 Reader 依次接入各处理阶段，返回最终输出的 Reader。
+// digest 接收格式转换之后、压缩和加密之前的数据，即服务端解压后应得到的内容，用于计算摘要
+func (p *pipeline) build(src io.Reader, digest io.Writer) io.Reader {
+	read := &meteredReader{r: src, metric: &stageMetric{name: "read"}}
+	p.metrics = append(p.metrics, read.metric)
+
+	var r io.Reader = read
+	if p.auto != nil {
+		br := bufio.NewReaderSize(r, compressSampleSize)
+		r = br
+		sample, _ := br.Peek(compressSampleSize)
+		if name := p.auto.choose(sample); name != "" {
+			p.stages = append(p.stages, stageRegistry[name])
+		}
+		p.decisions = append(p.decisions, p.auto.reason)
+	}
+	upstream := read.metric
+	tapped := false
+	tap := func() {
+		if !tapped {
+			tapped = true
+			r = io.TeeReader(r, digest)
+		}
+	}
+	for _, st := range p.stages {
+		if st.compressor || st.encrypt {
+			tap()
+		}
+		if st.compressor && !p.forceCompress && p.auto == nil {
+			br := bufio.NewReaderSize(r, compressSampleSize)
+			r = br
+			// Peek 出错时 (例如源数据不足采样大小) 仍然按已有数据判断，真正的读取错误会在后续 Read 中暴露
+			sample, _ := br.Peek(compressSampleSize)
+			if skip, reason := shouldSkipCompression(sample); skip {
+				p.decisions = append(p.decisions, fmt.Sprintf("跳过 %s 阶段: %s", st.name, reason))
+				continue
+			}
+			p.decisions = append(p.decisions, fmt.Sprintf("启用 %s 阶段: 采样数据可压缩", st.name))
+		}
+
+		p.active = append(p.active, st)
+		m := &meteredReader{r: st.wrap(r), metric: &stageMetric{name: st.name}, upstream: upstream}
+		p.metrics = append(p.metrics, m.metric)
+		r = m
+		upstream = m.metric
+	}
+	tap()
+	return r
+}
+
+// 记录流水线末端 upload 阶段的统计
+func (p *pipeline) recordUpload(bytes int64, d time.Duration) {
+	p.metrics = append(p.metrics, &stageMetric{name: "upload", bytes: bytes, total: d, self: d})
+}
+
+// 打印各阶段耗时与吞吐
+func (p *pipeline) report() {
+	fmt.Println("\n⏱️  流水线各阶段统计:")
+	fmt.Printf("   %-6s %10s %10s %12s\n", "阶段", "输出", "耗时", "吞吐")
+	for _, m := range p.metrics {
+		rate := "-"
+		if m.self > 0 {
+			rate = formatBytes(int64(float64(m.bytes)/m.self.Seconds())) + "/s"
+		}
+		fmt.Printf("   %-8s %12s %12s %14s\n", m.name, formatBytes(m.bytes), m.self.Round(time.Millisecond), rate)
+	}
+}
+
+// meteredReader 统计经过的字节数和 Read 耗时
+type meteredReader struct {
+	r        io.Reader
+	metric   *stageMetric
+	upstream *stageMetric
+}
+
+func (mr *meteredReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := mr.r.Read(p)
+	mr.metric.total += time.Since(start)
+	mr.metric.bytes += int64(n)
+
+	mr.metric.self = mr.metric.total
+	if mr.upstream != nil {
+		mr.metric.self -= mr.upstream.total
+	}
+	return n, err
+}
+
+// 判断是否压缩的采样大小
+const compressSampleSize = 1 << 20
+
+// 采样压缩后体积仍超过原始数据该比例时视为不可压缩
+const incompressibleRatio = 0.9
+
+// 常见压缩格式的文件头
+var compressedMagics = []struct {
+	name  string
+	magic []byte
+}{
+	{"gzip", []byte{0x1f, 0x8b}},
+	{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{"bzip2", []byte("BZh")},
+	{"zip", []byte("PK\x03\x04")},
+	{"7z", []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}},
+}
+
+// 根据采样数据判断是否应跳过压缩，返回原因说明
+func shouldSkipCompression(sample []byte) (bool, string) {
+	for _, m := range compressedMagics {
+		if bytes.HasPrefix(sample, m.magic) {
+			return true, fmt.Sprintf("数据已是 %s 压缩格式", m.name)
+		}
+	}
+
+	// 数据太少时压缩开销可以忽略，不做判断
+	if len(sample) < 4096 {
+		return false, ""
+	}
+
+	var buf bytes.Buffer
+	gw, _ := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	gw.Write(sample)
+	gw.Close()
+
+	ratio := float64(buf.Len()) / float64(len(sample))
+	if ratio > incompressibleRatio {
+		return true, fmt.Sprintf("采样 %s 压缩后仍为原来的 %.0f%%", formatBytes(int64(len(sample))), ratio*100)
+	}
+	return false, ""
+}
+
+// gzip 压缩阶段
+func gzipStage(r io.Reader) io.Reader {
+	cr := &compressReader{src: r, chunk: make([]byte, 32*1024)}
+	cr.w = gzip.NewWriter(&cr.buf)
+	return cr
+}
+
+// 指定级别的 zstd 压缩阶段
+func zstdStage(level zstd.EncoderLevel, opts ...zstd.EOption) func(r io.Reader) io.Reader {
+	return func(r io.Reader) io.Reader {
+		cr := &compressReader{src: r, chunk: make([]byte, 32*1024)}
+		// 只在选项无效时出错，这里使用的都是固定的有效选项
+		cr.w, _ = zstd.NewWriter(&cr.buf, append([]zstd.EOption{zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(level)}, opts...)...)
+		return cr
+	}
+}
+
+// 使用字典压缩 zstd 阶段 (-zstd-dict)，流水线中没有 zstd 阶段时返回 false
+func (p *pipeline) useZstdDict(dict []byte) bool {
+	for i := range p.stages {
+		if level, ok := zstdLevels[p.stages[i].name]; ok {
+			p.stages[i].wrap = zstdStage(level, zstd.WithEncoderDict(dict))
+			return true
+		}
+	}
+	return false
+}
+
+// compressReader 在调用方的 Read 中同步完成压缩，便于准确统计各阶段耗时
+type compressReader struct {
+	src   io.Reader
+	w     io.WriteCloser
+	buf   bytes.Buffer
+	chunk []byte
+	done  bool
+}
+
+func (cr *compressReader) Read(p []byte) (int, error) {
+	for cr.buf.Len() == 0 && !cr.done {
+		n, err := cr.src.Read(cr.chunk)
+		if n > 0 {
+			if _, werr := cr.w.Write(cr.chunk[:n]); werr != nil {
+				return 0, werr
+			}
+		}
+		if err == io.EOF {
+			if cerr := cr.w.Close(); cerr != nil {
+				return 0, cerr
+			}
+			cr.done = true
+		} else if err != nil {
+			return 0, err
+		}
+	}
+
+	if cr.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return cr.buf.Read(p)
+}
+
+// compressedProgress 在进度条描述中显示压缩后已发送的字节数，每秒最多刷新一次
+type compressedProgress struct {
+	r           io.Reader
+	bar         transferBar
+	description string
+	format      string // 压缩格式名称
+	sent        int64
+	updated     time.Time
+}
+
+func (cp *compressedProgress) Read(p []byte) (int, error) {
+	n, err := cp.r.Read(p)
+	cp.sent += int64(n)
+	if now := time.Now(); now.Sub(cp.updated) >= time.Second || err != nil {
+		cp.updated = now
+		cp.bar.Describe(fmt.Sprintf("%s (%s 已发送 %s)", cp.description, cp.format, formatBytes(cp.sent)))
+	}
+	return n, err
+}
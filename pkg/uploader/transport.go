@@ -0,0 +1,170 @@
+package uploader
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// 读取响应体的上限
+const maxResponseSize = 1 << 20
+
+// FormTransport 以 multipart 表单 POST 到本工具的服务端 (serve)。
+// 文件之后附上摘要、制品和加载等字段，由服务端校验数据并提交
+type FormTransport struct {
+	Client *http.Client // 为 nil 时使用 http.DefaultClient
+}
+
+func (t *FormTransport) Send(ctx context.Context, req *Request) (*Result, error) {
+	opts := req.Options
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	written := make(chan error, 1)
+	go func() {
+		err := writeForm(writer, req)
+		pw.CloseWithError(err)
+		written <- err
+	}()
+
+	hreq, err := http.NewRequestWithContext(ctx, "POST", opts.URL, pr)
+	if err != nil {
+		pr.Close()
+		return nil, err
+	}
+	hreq.Header.Set("Content-Type", writer.FormDataContentType())
+	setHeaders(hreq, opts)
+
+	resp, err := client(t.Client).Do(hreq)
+	pr.Close()
+	if werr := <-written; werr != nil && werr != io.ErrClosedPipe {
+		// 读取数据失败时，请求的错误只是其后果
+		return nil, werr
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return decodeResult(resp)
+}
+
+// 写出文件和其后的表单字段
+func writeForm(w *multipart.Writer, req *Request) error {
+	opts := req.Options
+	part, err := w.CreateFormFile("file", opts.FileName)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, req.Body); err != nil {
+		return err
+	}
+	fields := [][2]string{{"tree_sha256", req.TreeSHA256()}, {"sha256", req.SHA256()}}
+	if opts.ArtifactName != "" {
+		fields = append(fields, [2]string{"artifact_name", opts.ArtifactName}, [2]string{"artifact_version", opts.ArtifactVersion})
+		if len(opts.Labels) > 0 {
+			labels, err := json.Marshal(opts.Labels)
+			if err != nil {
+				return err
+			}
+			fields = append(fields, [2]string{"labels", string(labels)})
+		}
+	}
+	if opts.RemoteLoad || opts.RemoteTag != "" {
+		fields = append(fields, [2]string{"docker_load", "true"}, [2]string{"docker_tag", opts.RemoteTag})
+	}
+	for _, f := range fields {
+		if err := w.WriteField(f[0], f[1]); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// PutTransport 将数据作为请求体直接 PUT 到 URL (Nexus raw、Artifactory、WebDAV 等)。
+// URL 以 / 结尾时在其后拼接文件名。这类目标不校验摘要，Result 中的摘要为本地计算的值
+type PutTransport struct {
+	Client *http.Client // 为 nil 时使用 http.DefaultClient
+	Method string       // 为空时为 PUT
+}
+
+func (t *PutTransport) Send(ctx context.Context, req *Request) (*Result, error) {
+	opts := req.Options
+	target := opts.URL
+	if strings.HasSuffix(target, "/") {
+		target += url.PathEscape(opts.FileName)
+	}
+	method := t.Method
+	if method == "" {
+		method = "PUT"
+	}
+	hreq, err := http.NewRequestWithContext(ctx, method, target, req.Body)
+	if err != nil {
+		return nil, err
+	}
+	if req.Size >= 0 {
+		hreq.ContentLength = req.Size
+	}
+	hreq.Header.Set("Content-Type", "application/octet-stream")
+	setHeaders(hreq, opts)
+
+	resp, err := client(t.Client).Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+		return nil, &StatusError{Code: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseSize))
+	return &Result{
+		OK:         true,
+		TransferID: opts.TransferID,
+		Name:       opts.FileName,
+		Size:       req.sent.done,
+		SHA256:     req.SHA256(),
+		TreeSHA256: req.TreeSHA256(),
+	}, nil
+}
+
+func client(c *http.Client) *http.Client {
+	if c == nil {
+		return http.DefaultClient
+	}
+	return c
+}
+
+// 设置认证、传输 ID 和附加的请求头，附加的头部覆盖同名的其他头部
+func setHeaders(req *http.Request, opts Options) {
+	req.Header.Set(TransferIDHeader, opts.TransferID)
+	if opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+opts.Token)
+	}
+	for k, v := range opts.Header {
+		req.Header[http.CanonicalHeaderKey(k)] = v
+	}
+}
+
+// 解析服务端的 JSON 响应，非 2xx 时返回 *StatusError
+func decodeResult(resp *http.Response) (*Result, error) {
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	var res Result
+	jerr := json.Unmarshal(data, &res)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg := res.Error
+		if jerr != nil || msg == "" {
+			msg = strings.TrimSpace(string(data))
+		}
+		return nil, &StatusError{Code: resp.StatusCode, Message: msg}
+	}
+	if jerr != nil {
+		return nil, jerr
+	}
+	return &res, nil
+}
@@ -0,0 +1,77 @@
+package uploader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+)
+
+// 树形摘要的叶子大小，固定为 4 MiB
+const TreeLeafSize = 4 << 20
+
+// 树形摘要的文本前缀
+const TreeHashPrefix = "tree-sha256:"
+
+// LeafHash 计算一片数据的叶子摘要。
+// 树形摘要：文件按 TreeLeafSize 切分，每片计算 sha256(0x00 || 数据) 作为叶子，
+// 根为 sha256(0x01 || 叶子1 || 叶子2 || ...)。前缀字节用于区分叶子和根，
+// 叶子之间相互独立，因此可以并行计算、按片校验和续传。
+func LeafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x00})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// TreeRoot 由叶子摘要计算树根
+func TreeRoot(leaves [][]byte) string {
+	h := sha256.New()
+	h.Write([]byte{0x01})
+	for _, leaf := range leaves {
+		h.Write(leaf)
+	}
+	return TreeHashPrefix + hex.EncodeToString(h.Sum(nil))
+}
+
+// TreeHasher 以流的方式计算树形摘要 (即上传后的制品 ID)
+type TreeHasher struct {
+	leaf   hash.Hash
+	filled int
+	leaves [][]byte
+}
+
+func NewTreeHasher() *TreeHasher {
+	t := &TreeHasher{leaf: sha256.New()}
+	t.leaf.Write([]byte{0x00})
+	return t
+}
+
+func (t *TreeHasher) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		n := min(len(p), TreeLeafSize-t.filled)
+		t.leaf.Write(p[:n])
+		t.filled += n
+		p = p[n:]
+		if t.filled == TreeLeafSize {
+			t.flush()
+		}
+	}
+	return written, nil
+}
+
+// 结束当前叶子
+func (t *TreeHasher) flush() {
+	t.leaves = append(t.leaves, t.leaf.Sum(nil))
+	t.leaf.Reset()
+	t.leaf.Write([]byte{0x00})
+	t.filled = 0
+}
+
+// Sum 返回树根；只应在全部数据写入后调用一次
+func (t *TreeHasher) Sum() string {
+	if t.filled > 0 || len(t.leaves) == 0 {
+		t.flush()
+	}
+	return TreeRoot(t.leaves)
+}
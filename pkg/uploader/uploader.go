@@ -0,0 +1,168 @@
+// Package uploader 将数据上传到 docker_save_shell serve 等目标，供其他 Go 程序嵌入；命令行工具的上传也经由此包发送。
+//
+// 数据边读取边发送，内存占用与大小无关；同时计算发送数据的 SHA-256 和树形摘要 (制品 ID)，
+// 由服务端校验。发送方式由 Transport 决定，默认以 multipart 表单上传到本工具的服务端 (FormTransport)，
+// 也可以 PUT 到制品库 (PutTransport) 或自行实现。
+//
+//	u := uploader.New(nil)
+//	res, err := u.Upload(ctx, f, size, uploader.Options{URL: "http://host:8080", FileName: "app.tar"})
+package uploader
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"regexp"
+)
+
+// TransferIDHeader 传输 ID 的请求头，服务端记入日志和响应，用于关联同一次传输
+const TransferIDHeader = "X-Transfer-Id"
+
+// IDPattern 传输 ID (及服务端上传 ID) 的格式
+var IDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Options 一次上传的目标和选项
+type Options struct {
+	URL      string // 目标地址
+	FileName string // 上传的文件名
+
+	Token  string      // 以 Bearer 令牌认证
+	Header http.Header // 附加的请求头
+
+	// 版本化制品的名称和版本，及其标签
+	ArtifactName    string
+	ArtifactVersion string
+	Labels          map[string]string
+
+	// 上传后在服务端 docker load，RemoteTag 为加载后打上的标签
+	RemoteLoad bool
+	RemoteTag  string
+
+	// 本次传输的 ID，为空时自动生成
+	TransferID string
+
+	// 读取进度回调，total 为 Upload 的 size (未知时为 -1)
+	Progress func(done, total int64)
+}
+
+// Result 上传结果，字段与服务端返回的 JSON 相同
+type Result struct {
+	OK         bool   `json:"ok"`
+	UploadID   string `json:"upload_id,omitempty"`
+	TransferID string `json:"transfer_id,omitempty"`
+	Name       string `json:"name,omitempty"`
+	Size       int64  `json:"size,omitempty"`
+	SHA256     string `json:"sha256,omitempty"`
+	TreeSHA256 string `json:"tree_sha256,omitempty"`
+	Artifact   string `json:"artifact,omitempty"`
+	Version    string `json:"version,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// StatusError 目标返回的非 2xx 状态
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("服务器返回状态码 %d", e.Code)
+	}
+	return fmt.Sprintf("服务器返回状态码 %d: %s", e.Code, e.Message)
+}
+
+// Request 交给 Transport 发送的一次上传
+type Request struct {
+	Body    io.Reader // 读取时计算摘要并回报进度
+	Size    int64     // Body 的长度，未知时为 -1
+	Options Options
+
+	sent *digestReader
+}
+
+// SHA256 返回 Body 的 SHA-256 (十六进制)；只在 Body 读完后有效
+func (r *Request) SHA256() string {
+	return hex.EncodeToString(r.sent.sha.Sum(nil))
+}
+
+// TreeSHA256 返回 Body 的树形摘要，即服务端的制品 ID；只在 Body 读完后有效
+func (r *Request) TreeSHA256() string {
+	return r.sent.tree.Sum()
+}
+
+// Sent 返回已从 Body 读出的字节数
+func (r *Request) Sent() int64 {
+	return r.sent.done
+}
+
+// Transport 将一次上传发送到目标；目标返回非 2xx 时应返回 *StatusError
+type Transport interface {
+	Send(ctx context.Context, req *Request) (*Result, error)
+}
+
+// Uploader 以指定的 Transport 上传
+type Uploader struct {
+	transport Transport
+}
+
+// New 创建 Uploader；t 为 nil 时使用 FormTransport (上传到本工具的服务端)
+func New(t Transport) *Uploader {
+	if t == nil {
+		t = &FormTransport{}
+	}
+	return &Uploader{transport: t}
+}
+
+// Upload 上传 src 中的 size 字节 (未知时为 -1)
+func (u *Uploader) Upload(ctx context.Context, src io.Reader, size int64, opts Options) (*Result, error) {
+	if opts.URL == "" || opts.FileName == "" {
+		return nil, errors.New("必须指定 URL 和 FileName")
+	}
+	if opts.ArtifactName != "" && opts.ArtifactVersion == "" {
+		return nil, errors.New("指定 ArtifactName 时必须同时指定 ArtifactVersion")
+	}
+	if opts.TransferID == "" {
+		b := make([]byte, 8)
+		rand.Read(b)
+		opts.TransferID = hex.EncodeToString(b)
+	} else if !IDPattern.MatchString(opts.TransferID) {
+		return nil, fmt.Errorf("非法的传输 ID: %q", opts.TransferID)
+	}
+
+	r := &digestReader{ctx: ctx, r: src, sha: sha256.New(), tree: NewTreeHasher(), total: size, progress: opts.Progress}
+	return u.transport.Send(ctx, &Request{Body: r, Size: size, Options: opts, sent: r})
+}
+
+// digestReader 读取时计算摘要、回报进度，并在 ctx 取消后停止
+type digestReader struct {
+	ctx      context.Context
+	r        io.Reader
+	sha      hash.Hash
+	tree     *TreeHasher
+	done     int64
+	total    int64
+	progress func(done, total int64)
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	if err := d.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := d.r.Read(p)
+	if n > 0 {
+		d.sha.Write(p[:n])
+		d.tree.Write(p[:n])
+		d.done += int64(n)
+		if d.progress != nil {
+			d.progress(d.done, d.total)
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 接收端在 /ping 响应中通告自身平台 (os/arch) 的响应头
+const platformHeader = "X-Dss-Platform"
+
+// imagePlatform 镜像配置中的平台信息
+type imagePlatform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant"`
+}
+
+func (p imagePlatform) String() string {
+	if p.OS == "" || p.Architecture == "" {
+		return ""
+	}
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// 接收端的平台：启用 --allow-load 时以本机 docker 守护进程为准，否则为服务进程自身的平台
+func (s *server) platform() string {
+	s.platformOnce.Do(func() {
+		s.platformName = runtime.GOOS + "/" + runtime.GOARCH
+		if !s.opts.allowLoad {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if out, err := dockerPlatform(ctx); err == nil {
+			s.platformName = out
+		}
+	})
+	return s.platformName
+}
+
+// 比较平台时只看 os 和 arch，忽略 variant (如 arm64/v8)
+func samePlatform(a, b string) bool {
+	trim := func(p string) string {
+		parts := strings.SplitN(p, "/", 3)
+		return strings.Join(parts[:min(len(parts), 2)], "/")
+	}
+	return trim(a) == trim(b)
+}
+
+// 查询接收端通告的平台，旧版本服务端不通告时返回空字符串。
+// -url 可能是服务根地址，也可能是其下的某个上传路径 (如 /upload)，依次尝试两者对应的 /ping
+func receiverPlatform(ctx context.Context, client *sessionClient, serverURL string) (string, error) {
+	h, err := pingReceiver(ctx, client, serverURL)
+	if err != nil {
+		return "", err
+	}
+	return h.Get(platformHeader), nil
+}
+
+// 向接收端的 /ping 发送 HEAD 请求，返回其通告能力的响应头；
+// 上传地址带路径前缀时先尝试前缀下的 /ping，再尝试根路径
+func pingReceiver(ctx context.Context, client *sessionClient, serverURL string) (http.Header, error) {
+	base, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, preflightTimeout)
+	defer cancel()
+	var lastErr error
+	for _, ping := range []*url.URL{base.JoinPath("ping"), base.ResolveReference(&url.URL{Path: "ping"})} {
+		req, err := http.NewRequestWithContext(ctx, "HEAD", ping.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+		if resp.Header.Get(platformHeader) != "" {
+			return resp.Header, nil
+		}
+	}
+	return http.Header{}, lastErr
+}
+
+// 要上传的镜像及其平台：镜像归档读取配置，docker-daemon: 数据源查询本机镜像；不是镜像时返回空
+func sourcePlatforms(p string, docker dockerEndpoint) (map[string]string, error) {
+	platforms := map[string]string{}
+	if isImageSource(p) {
+		for _, ref := range imageRefs(p) {
+			out, err := docker.run("image", "inspect", "--format", "{{.Os}}/{{.Architecture}}", ref)
+			if err != nil {
+				return nil, err
+			}
+			platforms[ref] = strings.TrimSpace(out)
+		}
+		return platforms, nil
+	}
+	if isRemoteSource(p) {
+		return nil, nil
+	}
+	if info, err := os.Stat(p); err != nil || !info.Mode().IsRegular() {
+		return nil, nil
+	}
+	images, err := parseDockerArchive(p)
+	if err != nil {
+		return nil, err
+	}
+	for _, img := range images {
+		name := img.ID
+		if len(img.Tags) > 0 {
+			name = img.Tags[0]
+		}
+		if img.Platform != "" {
+			platforms[name] = img.Platform
+		}
+	}
+	return platforms, nil
+}
+
+// 检查要上传的镜像能否在接收端运行，避免把只有 amd64 的镜像发到 arm64 站点，直到容器启动时才发现。
+// 不匹配时给出警告，启用 -require-arch-match 时返回错误
+func checkPlatform(ctx context.Context, client *sessionClient, opts options) error {
+	images, err := sourcePlatforms(opts.filePath, opts.docker)
+	if err != nil || len(images) == 0 {
+		if err != nil && opts.requireArch {
+			return fmt.Errorf("读取镜像平台失败: %w", err)
+		}
+		return nil
+	}
+	receiver, err := receiverPlatform(ctx, client, opts.serverURL)
+	if err != nil || receiver == "" {
+		if opts.requireArch {
+			return errors.New("无法获取接收端的平台 (服务端版本过旧或不可达)，无法执行 -require-arch-match 检查")
+		}
+		return nil
+	}
+
+	var mismatched []string
+	for name, p := range images {
+		if !samePlatform(p, receiver) {
+			mismatched = append(mismatched, fmt.Sprintf("%s (%s)", name, p))
+		}
+	}
+	if len(mismatched) == 0 {
+		if opts.verbose {
+			fmt.Printf("✅ 镜像平台与接收端一致: %s\n", receiver)
+		}
+		return nil
+	}
+	sort.Strings(mismatched)
+	msg := fmt.Sprintf("镜像平台与接收端 (%s) 不一致: %s", receiver, strings.Join(mismatched, ", "))
+	if opts.requireArch {
+		return errors.New(msg)
+	}
+	fmt.Printf("⚠️  %s，加载后可能无法运行\n", msg)
+	return nil
+}
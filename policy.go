@@ -0,0 +1,293 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 管理员下发的系统策略文件，用户无法通过命令行绕过
+const systemPolicyPath = "/etc/docker_save_shell/policy.yaml"
+
+// Policy 限制允许导出的镜像 / 文件以及允许使用的目标地址
+type Policy struct {
+	Targets policyRule `yaml:"targets"`
+	Sources policyRule `yaml:"sources"`
+	Images  policyRule `yaml:"images"`
+
+	// 外部策略钩子 (例如封装了 opa eval 的脚本)
+	// 以 JSON 形式从标准输入接收 policyInput，退出码非 0 表示拒绝，标准输出作为拒绝原因
+	Hook string `yaml:"hook"`
+}
+
+// policyRule 通配符规则，* 匹配任意字符；deny 优先于 allow，allow 为空表示不限制
+type policyRule struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// policyInput 待评估的一次传输
+type policyInput struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Image  string `json:"image,omitempty"`
+}
+
+// 按策略评估一次传输，任何一个策略文件拒绝都会返回错误
+// 依次评估系统策略和 DOCKER_SAVE_SHELL_POLICY 指定的附加策略，附加策略只能进一步收紧限制。
+func enforcePolicy(in policyInput) error {
+	for _, path := range policyPaths() {
+		policy, err := loadPolicy(path)
+		if err != nil {
+			return err
+		}
+		if policy == nil {
+			continue
+		}
+		if err := policy.evaluate(in); err != nil {
+			return fmt.Errorf("策略 %s 拒绝了本次传输: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// 系统策略和 DOCKER_SAVE_SHELL_POLICY 指定的附加策略
+func policyPaths() []string {
+	paths := []string{systemPolicyPath}
+	if p := os.Getenv("DOCKER_SAVE_SHELL_POLICY"); p != "" {
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+// 是否有任何策略文件存在
+func policyConfigured() bool {
+	for _, path := range policyPaths() {
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			return true
+		}
+	}
+	return false
+}
+
+// 按策略评估一次上传：源为本机镜像 (docker-daemon:) 或 docker save 归档时对其中的每个镜像分别评估 images 规则，
+// 没有标签的镜像以镜像 ID 评估；没有策略文件时不读取归档。
+// known 不为 nil 时表示源中的镜像已知 (如 bundle 打包的镜像)，不再读取源；
+// 无法检查源中的镜像 (标准输入、http(s) 地址、没有 manifest.json 的 tar 归档) 而策略配置了 images 规则时拒绝上传
+func enforceSourcePolicy(source, target string, known []string) error {
+	if !policyConfigured() {
+		return nil
+	}
+	images := known
+	if images == nil {
+		var ok bool
+		var err error
+		images, ok, err = sourceImages(source)
+		if err != nil {
+			return fmt.Errorf("读取 %s 中的镜像失败，无法按策略评估: %w", source, err)
+		}
+		if !ok {
+			limited, err := imagesRuleConfigured()
+			if err != nil {
+				return err
+			}
+			if limited {
+				return fmt.Errorf("策略限制了允许上传的镜像，但无法检查 %s 中是否包含镜像，拒绝上传", source)
+			}
+		}
+	}
+	in := policyInput{Source: source, Target: target}
+	return enforceImagesPolicy(in, images)
+}
+
+// 对每个镜像分别评估 in；images 为空时只评估源和目标
+func enforceImagesPolicy(in policyInput, images []string) error {
+	if len(images) == 0 {
+		return enforcePolicy(in)
+	}
+	for _, image := range images {
+		in.Image = image
+		if err := enforcePolicy(in); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 源中的镜像：docker-daemon: 指定的镜像或 docker save 归档中的标签，不是镜像时为空；
+// ok 为 false 表示无法在上传前检查源中是否有镜像
+func sourceImages(source string) (images []string, ok bool, err error) {
+	if isImageSource(source) {
+		return imageRefs(source), true, nil
+	}
+	if isRemoteSource(source) {
+		// 标准输入和 http(s) 地址只能读取一次
+		return nil, false, nil
+	}
+	if info, err := os.Stat(source); err != nil || info.IsDir() {
+		return nil, true, nil
+	}
+	archive, err := parseDockerArchive(source)
+	if err != nil {
+		return nil, false, err
+	}
+	if archive == nil {
+		// 没有 manifest.json 的 tar 归档中仍可能嵌套着镜像归档
+		tarball, err := isTarArchive(source)
+		return nil, !tarball, err
+	}
+	for _, img := range archive {
+		if len(img.Tags) == 0 {
+			images = append(images, img.ID)
+		}
+		images = append(images, img.Tags...)
+	}
+	return images, true, nil
+}
+
+// 文件 (可以是压缩的) 是否为 tar 归档
+func isTarArchive(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	dr, _, err := decompressReader(br)
+	if err != nil {
+		return false, err
+	}
+	if dr != nil {
+		defer dr.Close()
+		r = dr
+	}
+	_, err = tar.NewReader(r).Next()
+	return err == nil, nil
+}
+
+// 是否有策略文件配置了 images 规则
+func imagesRuleConfigured() (bool, error) {
+	for _, path := range policyPaths() {
+		policy, err := loadPolicy(path)
+		if err != nil {
+			return false, err
+		}
+		if policy != nil && len(policy.Images.Allow)+len(policy.Images.Deny) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// 读取策略文件，文件不存在时返回 nil
+func loadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("读取策略文件 %s 失败: %w", path, err)
+	}
+
+	policy := &Policy{}
+	if err := yaml.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("解析策略文件 %s 失败: %w", path, err)
+	}
+	return policy, nil
+}
+
+// 评估单个策略
+func (p *Policy) evaluate(in policyInput) error {
+	if err := p.Targets.check("目标地址", in.Target); err != nil {
+		return err
+	}
+	if err := p.Sources.check("源文件", in.Source); err != nil {
+		return err
+	}
+	if in.Image != "" {
+		if err := p.Images.check("镜像", in.Image); err != nil {
+			return err
+		}
+	}
+	if p.Hook != "" {
+		return runPolicyHook(p.Hook, in)
+	}
+	return nil
+}
+
+// 检查取值是否满足 allow/deny 规则
+func (r policyRule) check(kind, value string) error {
+	for _, pattern := range r.Deny {
+		if globMatch(pattern, value) {
+			return fmt.Errorf("%s %s 命中禁止规则 %s", kind, value, pattern)
+		}
+	}
+	if len(r.Allow) == 0 {
+		return nil
+	}
+	for _, pattern := range r.Allow {
+		if globMatch(pattern, value) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s %s 不在允许列表中", kind, value)
+}
+
+// 通配符匹配，* 匹配任意字符 (包括 /)，? 匹配单个字符
+func globMatch(pattern, value string) bool {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+
+	re, err := regexp.Compile(sb.String())
+	return err == nil && re.MatchString(value)
+}
+
+// 调用外部策略钩子
+func runPolicyHook(hook string, in policyInput) error {
+	words, err := splitArgs(hook)
+	if err != nil || len(words) == 0 {
+		return fmt.Errorf("策略钩子配置错误: %s", hook)
+	}
+
+	input, _ := json.Marshal(in)
+	cmd := exec.Command(words[0], words[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return fmt.Errorf("执行策略钩子失败: %w", err)
+		}
+		reason := strings.TrimSpace(out.String())
+		if reason == "" {
+			reason = fmt.Sprintf("策略钩子返回 %d", exitErr.ExitCode())
+		}
+		return errors.New(reason)
+	}
+	return nil
+}
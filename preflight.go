@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// 预检请求的超时时间
+const preflightTimeout = 10 * time.Second
+
+// preflightError 预检失败的分类结果
+type preflightError struct {
+	Stage string // dns / connect / timeout / tls / auth / route / server
+	Hint  string
+	Err   error
+}
+
+func (e *preflightError) Error() string {
+	msg := fmt.Sprintf("预检失败 [%s]: %v", e.Stage, e.Err)
+	if e.Hint != "" {
+		msg += "\n💡 " + e.Hint
+	}
+	return msg
+}
+
+func (e *preflightError) Unwrap() error {
+	return e.Err
+}
+
+// 在开始传输前用一个廉价的 HEAD 请求确认 DNS、TLS、鉴权和路由均正常，失败时尽早返回分类的错误。
+// 优先请求服务端的 /ping，旧版本服务端没有该接口时退回到对上传地址本身发 HEAD。
+func preflight(ctx context.Context, client *sessionClient, serverURL string) error {
+	ctx, cancel := context.WithTimeout(ctx, preflightTimeout)
+	defer cancel()
+
+	ping, err := url.JoinPath(serverURL, "ping")
+	if err != nil {
+		return &preflightError{Stage: "route", Hint: "请检查 -url 的格式", Err: err}
+	}
+	status, err := headStatus(ctx, client.Client, ping)
+	if err != nil {
+		return classifyNetError(err)
+	}
+	if status == http.StatusNotFound {
+		if status, err = headStatus(ctx, client.Client, serverURL); err != nil {
+			return classifyNetError(err)
+		}
+		// 上传接口只接受 POST，返回 405 说明路由存在
+		if status == http.StatusMethodNotAllowed {
+			status = http.StatusOK
+		}
+	}
+
+	switch {
+	case status == http.StatusUnauthorized:
+		return &preflightError{Stage: "auth", Hint: authHint(client, "服务端启用了令牌鉴权，请提供有效的访问令牌"), Err: errors.New("缺少或无效的访问令牌")}
+	case status == http.StatusForbidden:
+		return &preflightError{Stage: "auth", Hint: authHint(client, "访问令牌需要 upload 权限"), Err: errors.New("访问令牌权限不足")}
+	case status == http.StatusNotFound:
+		return &preflightError{Stage: "route", Hint: "请确认 -url 指向接收端的上传地址，以及反向代理的路径转发配置", Err: fmt.Errorf("%s 不存在", serverURL)}
+	case status >= 500:
+		return &preflightError{Stage: "server", Hint: "服务端或其前面的网关异常，可检查服务端日志和 /readyz", Err: fmt.Errorf("服务端返回状态码 %d", status)}
+	case status >= 400:
+		return &preflightError{Stage: "route", Err: fmt.Errorf("服务端返回状态码 %d", status)}
+	}
+	return nil
+}
+
+// 鉴权失败的建议，检测到时钟偏差时优先提示
+func authHint(client *sessionClient, hint string) string {
+	if h := client.clock.authHint(); h != "" {
+		return h
+	}
+	return hint
+}
+
+// 发送 HEAD 请求并返回状态码
+func headStatus(ctx context.Context, client *http.Client, endpoint string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", endpoint, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// 将连接阶段的错误归类，并附上排查建议
+func classifyNetError(err error) *preflightError {
+	var dnsErr *net.DNSError
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	switch {
+	case errors.As(err, &dnsErr):
+		return &preflightError{Stage: "dns", Hint: "域名解析失败，请检查地址拼写和 DNS 配置", Err: err}
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return &preflightError{Stage: "connect", Hint: "连接被拒绝，请确认服务端已启动 (serve) 且端口正确", Err: err}
+	case errors.As(err, &certErr), errors.As(err, &unknownAuthority), errors.As(err, &hostnameErr):
+		return &preflightError{Stage: "tls", Hint: "TLS 证书校验失败，请检查服务端证书是否过期、是否与域名匹配以及本机是否信任其 CA (内部 CA 可用 -ca-cert 指定)", Err: err}
+	case strings.Contains(err.Error(), "server gave HTTP response to HTTPS client"):
+		return &preflightError{Stage: "tls", Hint: "服务端未启用 TLS，请将地址改为 http://", Err: err}
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return &preflightError{Stage: "timeout", Hint: "连接超时，请检查防火墙、安全组或代理设置", Err: err}
+	}
+	return &preflightError{Stage: "connect", Err: err}
+}
+
+// 预检接口：只验证鉴权和路由，不做任何操作
+func (s *server) handlePing(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(platformHeader, s.platform())
+	w.Header().Set(ifExistsHeader, s.opts.ifExists)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// priorityOptions 降低本进程的调度优先级，使后台批量传输不与生产主机上对延迟敏感的服务争抢资源
+type priorityOptions struct {
+	nice     int    // CPU 调度优先级，-20 (最高) 到 19 (最低)
+	ionice   string // IO 调度: idle 或 be[:0-7]
+	cpus     string // 只在这些 CPU 上运行，例如 0-3,6 (可用于绑定到某个 NUMA 节点)
+	maxProcs int    // 同时运行 Go 代码的最大线程数 (GOMAXPROCS)
+}
+
+// 注册优先级相关参数，上传和守护进程共用
+func registerPriorityFlags(fs *flag.FlagSet, p *priorityOptions) {
+	fs.IntVar(&p.nice, "nice", 0, "CPU 调度优先级 (0-19，越大越低)，仅 Linux")
+	fs.StringVar(&p.ionice, "ionice", "", "IO 调度类别: idle 或 be[:0-7] (best-effort，7 最低)，仅 Linux")
+	fs.StringVar(&p.cpus, "cpus", "", "只在指定的 CPU 上运行，例如 0-3,6 (可绑定到单个 NUMA 节点)，仅 Linux")
+	fs.IntVar(&p.maxProcs, "max-procs", 0, "限制同时使用的 CPU 数 (GOMAXPROCS，0 表示不限制)")
+}
+
+// 应用优先级设置
+func (p priorityOptions) apply() error {
+	if p.maxProcs > 0 {
+		runtime.GOMAXPROCS(p.maxProcs)
+	}
+	if p.nice == 0 && p.ionice == "" && p.cpus == "" {
+		return nil
+	}
+	if p.nice < -20 || p.nice > 19 {
+		return fmt.Errorf("-nice 超出范围 (-20 到 19): %d", p.nice)
+	}
+	class, level, err := parseIOPriority(p.ionice)
+	if err != nil {
+		return err
+	}
+	cpus, err := parseCPUList(p.cpus)
+	if err != nil {
+		return err
+	}
+	return setPriority(p.nice, class, level, cpus)
+}
+
+// IO 调度类别 (与 ioprio_set 的取值一致)
+const (
+	ioClassNone       = 0
+	ioClassBestEffort = 2
+	ioClassIdle       = 3
+)
+
+// 解析 -ionice，例如 idle、be、be:7
+func parseIOPriority(s string) (class, level int, err error) {
+	if s == "" {
+		return ioClassNone, 0, nil
+	}
+	name, lv, hasLevel := strings.Cut(s, ":")
+	switch name {
+	case "idle":
+		if hasLevel {
+			return 0, 0, errors.New("-ionice idle 不支持指定级别")
+		}
+		return ioClassIdle, 0, nil
+	case "be", "best-effort":
+		level = 4
+		if hasLevel {
+			if level, err = strconv.Atoi(lv); err != nil || level < 0 || level > 7 {
+				return 0, 0, fmt.Errorf("-ionice 级别应为 0-7: %s", lv)
+			}
+		}
+		return ioClassBestEffort, level, nil
+	}
+	return 0, 0, fmt.Errorf("不支持的 -ionice: %s (可选 idle 或 be[:0-7])", s)
+}
+
+// 解析 CPU 列表，例如 0-3,6
+func parseCPUList(s string) ([]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var cpus []int
+	for _, part := range strings.Split(s, ",") {
+		lo, hi, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		start, err := strconv.Atoi(lo)
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("非法的 CPU 列表: %s", s)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(hi); err != nil || end < start {
+				return nil, fmt.Errorf("非法的 CPU 列表: %s", s)
+			}
+		}
+		for c := start; c <= end; c++ {
+			cpus = append(cpus, c)
+		}
+	}
+	return cpus, nil
+}
@@ -0,0 +1,50 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// 对进程内的所有线程设置优先级和 CPU 亲和性。
+// Linux 上这些属性是按线程的，之后新建的线程会继承创建它的线程的设置，因此启动时逐个设置一遍即可。
+func setPriority(nice, ioClass, ioLevel int, cpus []int) error {
+	entries, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return fmt.Errorf("读取线程列表失败: %w", err)
+	}
+
+	var set unix.CPUSet
+	for _, c := range cpus {
+		set.Set(c)
+	}
+
+	for _, e := range entries {
+		tid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		if nice != 0 {
+			if err := unix.Setpriority(unix.PRIO_PROCESS, tid, nice); err != nil {
+				return fmt.Errorf("设置 nice 失败: %w", err)
+			}
+		}
+		if ioClass != ioClassNone {
+			// ioprio 的取值为 类别<<13 | 级别
+			prio := uintptr(ioClass<<13 | ioLevel)
+			if _, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, 1 /* IOPRIO_WHO_PROCESS */, uintptr(tid), prio); errno != 0 {
+				return fmt.Errorf("设置 ionice 失败: %w", errno)
+			}
+		}
+		if len(cpus) > 0 {
+			if err := unix.SchedSetaffinity(tid, &set); err != nil {
+				return fmt.Errorf("设置 CPU 亲和性失败: %w", err)
+			}
+		}
+	}
+	return nil
+}
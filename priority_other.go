@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// 其他平台不支持按线程设置优先级
+func setPriority(nice, ioClass, ioLevel int, cpus []int) error {
+	return errors.New("-nice、-ionice 和 -cpus 仅支持 Linux")
+}
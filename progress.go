@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// progressInterval -progress-interval 参数：按时长 (如 10s) 或按百分比 (如 5%) 输出进度事件
+type progressInterval struct {
+	every   time.Duration
+	percent float64
+}
+
+func (p *progressInterval) String() string {
+	switch {
+	case p.percent > 0:
+		return strconv.FormatFloat(p.percent, 'f', -1, 64) + "%"
+	case p.every > 0:
+		return p.every.String()
+	}
+	return ""
+}
+
+func (p *progressInterval) Set(v string) error {
+	if s, ok := strings.CutSuffix(strings.TrimSpace(v), "%"); ok {
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil || n <= 0 || n > 100 {
+			return fmt.Errorf("非法的进度间隔: %q (百分比应在 0~100 之间)", v)
+		}
+		*p = progressInterval{percent: n}
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("非法的进度间隔: %q (应为时长如 10s 或百分比如 5%%)", v)
+	}
+	*p = progressInterval{every: d}
+	return nil
+}
+
+// progressGate 按 progressInterval 筛选进度回调：第一次和传输完成时总是放行；
+// 按百分比时每跨过一个刻度放行一次，总大小未知时退回按时间间隔
+type progressGate struct {
+	interval progressInterval
+	last     time.Time
+	lastDone int64
+}
+
+func (g *progressGate) due(done, total int64) bool {
+	if done < g.lastDone {
+		// 重试后从头开始计数
+		g.lastDone = 0
+	}
+	now := time.Now()
+	var ok bool
+	switch {
+	case g.last.IsZero() || done == total && done != g.lastDone:
+		ok = true
+	case g.interval.percent > 0 && total > 0:
+		step := g.interval.percent / 100 * float64(total)
+		ok = int64(float64(done)/step) > int64(float64(g.lastDone)/step)
+	default:
+		ok = now.Sub(g.last) >= cmp.Or(g.interval.every, progressEventInterval)
+	}
+	if ok {
+		g.last, g.lastDone = now, done
+	}
+	return ok
+}
+
+// progressPoster 将上传的进度事件以 JSON POST 到 -progress-url，格式与 daemon -events json 相同。
+// 事件在后台逐个发送，不拖慢上传；接收方跟不上时丢弃积压的中间进度，其余事件总会发送
+type progressPoster struct {
+	url    string
+	base   jobEvent
+	client *http.Client
+	gate   progressGate
+	events chan jobEvent
+	done   chan struct{}
+}
+
+func newProgressPoster(opts options) *progressPoster {
+	p := &progressPoster{
+		url:    opts.progressURL,
+		base:   jobEvent{Job: opts.transferID, Source: opts.filePath, Target: opts.serverURL},
+		client: &http.Client{Timeout: 10 * time.Second},
+		gate:   progressGate{interval: opts.progressInterval},
+		events: make(chan jobEvent, 64),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(p.done)
+		warned := false
+		for ev := range p.events {
+			if err := p.post(ev); err != nil && !warned {
+				warned = true
+				fmt.Printf("⚠️  发送进度事件失败: %v\n", err)
+			}
+		}
+	}()
+	return p
+}
+
+// 将进度回调接到 poster 上：按间隔发送 chunk-progress，-progress-chunks 时每确认一块发送 chunk 事件
+func (p *progressPoster) attach(opts *options) {
+	progress, chunkDone := opts.progress, opts.chunkDone
+	opts.progress = func(done, total int64) {
+		if progress != nil {
+			progress(done, total)
+		}
+		if p.gate.due(done, total) {
+			ev := p.event("chunk-progress")
+			ev.Done, ev.Total = done, total
+			p.send(ev, done == total)
+		}
+	}
+	if opts.progressChunks {
+		opts.chunkDone = func(offset, n int64) {
+			if chunkDone != nil {
+				chunkDone(offset, n)
+			}
+			ev := p.event("chunk")
+			ev.Done, ev.Chunk = offset, n
+			p.send(ev, true)
+		}
+	}
+}
+
+func (p *progressPoster) event(name string) jobEvent {
+	ev := p.base
+	ev.Event = name
+	return ev
+}
+
+// 投递事件；keep 为 false 时队列已满则丢弃
+func (p *progressPoster) send(ev jobEvent, keep bool) {
+	ev.Time = time.Now()
+	if keep {
+		p.events <- ev
+		return
+	}
+	select {
+	case p.events <- ev:
+	default:
+	}
+}
+
+// 发送结束事件 (done 或 failed) 并等待队列中的事件发送完
+func (p *progressPoster) finish(err error) {
+	ev := p.event(jobDone)
+	if err != nil {
+		ev.Event, ev.Error = jobFailed, err.Error()
+	}
+	p.send(ev, true)
+	close(p.events)
+	<-p.done
+}
+
+func (p *progressPoster) post(ev jobEvent) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Post(p.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("进度地址返回状态码 %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/schollz/progressbar/v3"
+	"golang.org/x/term"
+)
+
+// 进度的显示方式 (-progress)
+const (
+	progressStyleBar   = "bar"   // 终端进度条
+	progressStylePlain = "plain" // 定期输出单行百分比，不含回车和控制字符，适合 CI 日志
+	progressStyleNone  = "none"  // 不显示进度
+)
+
+// plain 方式默认的输出间隔
+const plainProgressInterval = 10 * time.Second
+
+// 当前的进度显示方式。未指定 -progress 时 stderr 是终端则绘制进度条，否则 (Jenkins 等 CI 日志) 输出单行进度
+var progressStyle = autoProgressStyle()
+
+func autoProgressStyle() string {
+	if term.IsTerminal(int(os.Stderr.Fd())) {
+		return progressStyleBar
+	}
+	return progressStylePlain
+}
+
+// 按 -quiet / -progress 设置进度显示方式
+func setProgressStyle(opts options) error {
+	switch {
+	case opts.quiet:
+		progressStyle = progressStyleNone
+	case opts.progressStyle == "":
+	case opts.progressStyle == progressStyleBar || opts.progressStyle == progressStylePlain || opts.progressStyle == progressStyleNone:
+		progressStyle = opts.progressStyle
+	default:
+		return fmt.Errorf("不支持的进度显示方式: %s (可选 bar、plain、none)", opts.progressStyle)
+	}
+	return nil
+}
+
+// transferBar 传输进度的显示：进度条、单行进度或不显示
+type transferBar interface {
+	io.Writer
+	Add(n int) error
+	Set64(n int64) error
+	Describe(description string)
+	Finish() error
+}
+
+// 不显示的进度，仍然作为 io.Writer 接在数据流上
+func hiddenBar(size int64) transferBar {
+	return progressbar.NewOptions64(size, progressbar.OptionSetVisibility(false))
+}
+
+// plainBar -progress plain：每隔一段时间向 stderr 输出一行进度，完成时再输出一行
+type plainBar struct {
+	mu          sync.Mutex
+	description string
+	done, total int64
+	start       time.Time
+	gate        progressGate
+	finished    bool
+}
+
+func newPlainBar(size int64, description string) *plainBar {
+	return &plainBar{
+		description: description,
+		total:       size,
+		start:       time.Now(),
+		gate:        progressGate{interval: progressInterval{every: plainProgressInterval}},
+	}
+}
+
+func (b *plainBar) Write(p []byte) (int, error) {
+	b.Add(len(p))
+	return len(p), nil
+}
+
+func (b *plainBar) Add(n int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.set(b.done + int64(n))
+	return nil
+}
+
+func (b *plainBar) Set64(n int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.set(n)
+	return nil
+}
+
+// 更新进度并按间隔输出，调用方持有 mu
+func (b *plainBar) set(n int64) {
+	b.done = n
+	if b.finished || n == 0 {
+		return
+	}
+	if b.gate.due(n, b.total) {
+		b.print()
+	}
+	if b.total >= 0 && n >= b.total {
+		b.finished = true
+	}
+}
+
+func (b *plainBar) Describe(description string) {
+	b.mu.Lock()
+	b.description = description
+	b.mu.Unlock()
+}
+
+func (b *plainBar) Finish() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.finished {
+		b.finished = true
+		b.print()
+	}
+	return nil
+}
+
+// 输出一行进度: 📤 上传 app.tar 45% 12 MB / 30 MB 3.2 MB/s，调用方持有 mu
+func (b *plainBar) print() {
+	speed := ""
+	if elapsed := time.Since(b.start).Seconds(); elapsed > 0 {
+		speed = " " + formatBytes(int64(float64(b.done)/elapsed)) + "/s"
+	}
+	if b.total <= 0 {
+		fmt.Fprintf(os.Stderr, "%s %s%s\n", b.description, formatBytes(b.done), speed)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s %d%% %s / %s%s\n", b.description, min(b.done*100/b.total, 100), formatBytes(b.done), formatBytes(b.total), speed)
+}
+
+// -quiet 时丢弃输出到 os.Stdout 的过程信息；返回的函数恢复 os.Stdout
+func silenceStdout() (restore func()) {
+	null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		return func() {}
+	}
+	orig := os.Stdout
+	os.Stdout = null
+	return func() {
+		os.Stdout = orig
+		null.Close()
+	}
+}
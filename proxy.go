@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// 为客户端指定代理 (http、https、socks5 或 socks5h)，取代 HTTP_PROXY/HTTPS_PROXY；
+// NO_PROXY 中的地址仍然直接连接。未指定时沿用环境变量中的代理设置
+func (c *sessionClient) useProxy(raw string) error {
+	proxy, u, err := parseProxy(raw)
+	if err != nil {
+		return err
+	}
+	c.transport.Proxy = proxy
+	fmt.Printf("🌐 代理: %s\n", u.Redacted())
+	return nil
+}
+
+// 解析 -proxy，返回 http.Transport 按请求选择代理的函数
+func parseProxy(raw string) (func(*http.Request) (*url.URL, error), *url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return nil, nil, fmt.Errorf("-proxy 格式错误: %q (应为 http://host:port、socks5://host:port 等)", raw)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, nil, fmt.Errorf("不支持的代理协议: %s (可选 http、https、socks5、socks5h)", u.Scheme)
+	}
+
+	cfg := httpproxy.FromEnvironment()
+	cfg.HTTPProxy, cfg.HTTPSProxy = raw, raw
+	proxyFunc := cfg.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}, u, nil
+}
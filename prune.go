@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// -prune-after-upload 只能删除本地的普通文件或本机镜像，目录、设备和远程地址不处理
+func checkPruneSource(opts options) error {
+	if opts.tus {
+		return errors.New("-prune-after-upload 不能与 -tus 同时使用 (tus 上传没有摘要确认)")
+	}
+	if isImageSource(opts.filePath) {
+		return nil
+	}
+	if isRemoteSource(opts.filePath) {
+		return errors.New("-prune-after-upload 只能用于本地文件或 docker-daemon: 镜像")
+	}
+	info, err := os.Stat(opts.filePath)
+	if err == nil && !info.Mode().IsRegular() {
+		return errors.New("-prune-after-upload 只能用于普通文件，不能用于目录或设备")
+	}
+	return nil
+}
+
+// 服务端响应中确认的摘要与实际发送的数据一致时返回 nil
+func confirmDigest(data []byte, sent string) error {
+	var res struct {
+		SHA256 string `json:"sha256"`
+	}
+	if json.Unmarshal(data, &res) != nil || res.SHA256 == "" {
+		return errors.New("服务端没有返回摘要，无法确认")
+	}
+	if !strings.EqualFold(res.SHA256, sent) {
+		return fmt.Errorf("服务端确认的摘要 %s 与发送的数据 %s 不一致", res.SHA256, sent)
+	}
+	return nil
+}
+
+// 上传确认后删除源文件或本机镜像 (docker rmi)，为磁盘紧张的构建机腾出空间。
+// 上传已经成功，删除失败只给出警告
+func pruneSource(opts options) {
+	if isImageSource(opts.filePath) {
+		refs := imageRefs(opts.filePath)
+		if _, err := opts.docker.run(append([]string{"rmi"}, refs...)...); err != nil {
+			fmt.Printf("⚠️  删除本机镜像失败: %v\n", err)
+			return
+		}
+		fmt.Printf("🧹 已删除本机镜像: %s\n", strings.Join(refs, " "))
+		return
+	}
+	if err := os.Remove(opts.filePath); err != nil {
+		fmt.Printf("⚠️  删除源文件失败: %v\n", err)
+		return
+	}
+	fmt.Printf("🧹 已删除源文件: %s\n", opts.filePath)
+}
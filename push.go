@@ -0,0 +1,574 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// 推送到镜像仓库时的清单和配置类型 (层为 docker save 中未压缩的 tar)
+const (
+	ociManifestType = "application/vnd.oci.image.manifest.v1+json"
+	ociConfigType   = "application/vnd.oci.image.config.v1+json"
+	ociLayerType    = "application/vnd.oci.image.layer.v1.tar"
+)
+
+// push 子命令：解析 docker save 归档，按 Distribution API v2 将层、配置和清单直接推送到镜像仓库，
+// 不经过本工具的服务端
+func runPush(args []string) {
+	var to, basicAuth string
+	var plainHTTP, insecure bool
+	fs := newCommandFlags("push")
+	fs.StringVar(&to, "to", "", "推送到的镜像 (仓库地址/名称:标签)，默认按归档中的标签 (归档中只能有一个镜像)")
+	fs.StringVar(&basicAuth, "basic-auth", "", "仓库的用户名和密码，格式 user[:password]，省略密码时读取 "+basicPasswordEnv+"；默认使用 ~/.docker/config.json 中保存的凭据")
+	fs.BoolVar(&plainHTTP, "plain-http", false, "以 HTTP 而不是 HTTPS 访问仓库")
+	fs.BoolVar(&insecure, "insecure", false, "不校验仓库的 HTTPS 证书")
+	files := parseInterspersed(fs, args)
+	if len(files) != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	archive, err := openPushArchive(files[0])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer archive.file.Close()
+
+	client := &http.Client{}
+	if insecure {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		client.Transport = transport
+	}
+	if to != "" && len(archive.manifest) != 1 {
+		fmt.Printf("错误：归档中有 %d 个镜像，-to 只能用于一个镜像\n", len(archive.manifest))
+		os.Exit(1)
+	}
+	source, _ := filepath.Abs(files[0])
+	// 先确定所有推送目标并按策略评估，任何一个被拒绝时不推送任何数据
+	type pushTarget struct {
+		m   archiveManifestEntry
+		ref imageRef
+		reg *registryClient
+	}
+	var targets []pushTarget
+	for _, m := range archive.manifest {
+		refs := m.RepoTags
+		if to != "" {
+			refs = []string{to}
+		}
+		if len(refs) == 0 {
+			fmt.Printf("错误：镜像 %s 没有标签，需用 -to 指定推送到的镜像\n", archiveDigest(m.Config))
+			os.Exit(1)
+		}
+		images := m.RepoTags
+		if len(images) == 0 {
+			images = []string{archiveDigest(m.Config)}
+		}
+		for _, ref := range refs {
+			target, err := parseImageRef(ref)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			reg, err := newRegistryClient(client, target.registry, plainHTTP, basicAuth)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			for _, image := range images {
+				in := policyInput{Source: source, Target: reg.base + "/" + target.repo + ":" + target.tag, Image: image}
+				if err := enforcePolicy(in); err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+			}
+			targets = append(targets, pushTarget{m: m, ref: target, reg: reg})
+		}
+	}
+	for _, t := range targets {
+		digest, err := archive.push(t.reg, t.ref, t.m)
+		if err != nil {
+			fmt.Printf("推送 %s 失败: %v\n", t.ref, err)
+			os.Exit(1)
+		}
+		fmt.Printf("✅ 已推送 %s@%s\n", t.ref, digest)
+	}
+}
+
+// pushArchive 打开的 docker save 归档：记录各条目在文件中的位置，推送时按需读取
+type pushArchive struct {
+	file     *os.File
+	manifest []archiveManifestEntry
+	entries  map[string]*io.SectionReader
+	links    map[string]string // 新版 docker save 中指向 blobs/ 的符号链接
+}
+
+// 扫描未压缩的归档，记录 manifest.json 和各条目的位置
+func openPushArchive(name string) (*pushArchive, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	a := &pushArchive{file: f, entries: map[string]*io.SectionReader{}, links: map[string]string{}}
+	if err := a.scan(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", filepath.Base(name), err)
+	}
+	return a, nil
+}
+
+func (a *pushArchive) scan() error {
+	br := bufio.NewReader(a.file)
+	if dr, format, err := decompressReader(br); err != nil {
+		return err
+	} else if dr != nil {
+		dr.Close()
+		return fmt.Errorf("归档为 %s 压缩，push 需要按位置读取各层，请先解压", format)
+	}
+	if _, err := a.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	// tar.Reader 直接读取文件时只读到条目头部为止，此时文件位置即条目数据的起点
+	tr := tar.NewReader(a.file)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("读取归档失败: %w", err)
+		}
+		name := strings.TrimPrefix(hdr.Name, "./")
+		switch hdr.Typeflag {
+		case tar.TypeSymlink:
+			a.links[name] = path.Join(path.Dir(name), hdr.Linkname)
+		case tar.TypeReg:
+			offset, err := a.file.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return err
+			}
+			a.entries[name] = io.NewSectionReader(a.file, offset, hdr.Size)
+			if name == "manifest.json" {
+				if err := json.NewDecoder(tr).Decode(&a.manifest); err != nil {
+					return fmt.Errorf("解析 manifest.json 失败: %w", err)
+				}
+			}
+		}
+	}
+	if a.manifest == nil {
+		return errors.New("归档中没有 manifest.json，不是 docker save 的输出")
+	}
+	return nil
+}
+
+// 按路径取条目，跟随符号链接
+func (a *pushArchive) entry(name string) (*io.SectionReader, error) {
+	for range 8 {
+		target, ok := a.links[name]
+		if !ok {
+			break
+		}
+		name = target
+	}
+	e, ok := a.entries[name]
+	if !ok {
+		return nil, fmt.Errorf("归档中缺少 %s", name)
+	}
+	return io.NewSectionReader(e, 0, e.Size()), nil
+}
+
+// 推送一个镜像的层、配置和清单，返回清单的摘要
+func (a *pushArchive) push(reg *registryClient, target imageRef, m archiveManifestEntry) (string, error) {
+	configEntry, err := a.entry(m.Config)
+	if err != nil {
+		return "", err
+	}
+	config, err := io.ReadAll(configEntry)
+	if err != nil {
+		return "", err
+	}
+	var image struct {
+		RootFS struct {
+			DiffIDs []string `json:"diff_ids"`
+		} `json:"rootfs"`
+	}
+	if err := json.Unmarshal(config, &image); err != nil {
+		return "", fmt.Errorf("解析镜像配置失败: %w", err)
+	}
+	if len(image.RootFS.DiffIDs) != len(m.Layers) {
+		return "", fmt.Errorf("镜像配置中有 %d 层，manifest.json 中有 %d 层", len(image.RootFS.DiffIDs), len(m.Layers))
+	}
+
+	// 层未压缩，其摘要即配置中的 diff_id，仓库收到后会再次校验
+	layers := make([]ociDescriptor, len(m.Layers))
+	var total int64
+	for i, l := range m.Layers {
+		e, err := a.entry(l)
+		if err != nil {
+			return "", err
+		}
+		layers[i] = ociDescriptor{MediaType: ociLayerType, Digest: image.RootFS.DiffIDs[i], Size: e.Size()}
+		total += e.Size()
+	}
+
+	fmt.Printf("📦 镜像: %s (%d 层，%s)\n", target, len(layers), formatBytes(total))
+	bar := newTransferBar(total, "📤 推送 "+target.repo)
+	var done int64
+	for i, l := range m.Layers {
+		e, _ := a.entry(l)
+		exists, err := reg.blobExists(target.repo, layers[i].Digest)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			if err := reg.putBlob(target.repo, layers[i].Digest, e, bar); err != nil {
+				return "", fmt.Errorf("推送层 %s 失败: %w", shortDigest(layers[i].Digest), err)
+			}
+		}
+		// 仓库中已有的层直接计入进度
+		done += layers[i].Size
+		bar.Set64(done)
+	}
+	bar.Finish()
+	fmt.Println()
+
+	sum := sha256.Sum256(config)
+	configDesc := ociDescriptor{MediaType: ociConfigType, Digest: "sha256:" + hex.EncodeToString(sum[:]), Size: int64(len(config))}
+	if exists, err := reg.blobExists(target.repo, configDesc.Digest); err != nil {
+		return "", err
+	} else if !exists {
+		if err := reg.putBlob(target.repo, configDesc.Digest, io.NewSectionReader(bytes.NewReader(config), 0, int64(len(config))), nil); err != nil {
+			return "", fmt.Errorf("推送镜像配置失败: %w", err)
+		}
+	}
+
+	manifest, err := json.Marshal(struct {
+		SchemaVersion int             `json:"schemaVersion"`
+		MediaType     string          `json:"mediaType"`
+		Config        ociDescriptor   `json:"config"`
+		Layers        []ociDescriptor `json:"layers"`
+	}{2, ociManifestType, configDesc, layers})
+	if err != nil {
+		return "", err
+	}
+	return reg.putManifest(target.repo, target.tag, manifest)
+}
+
+// 显示用的短摘要
+func shortDigest(digest string) string {
+	d := strings.TrimPrefix(digest, "sha256:")
+	return d[:min(len(d), 12)]
+}
+
+// imageRef 推送目标：仓库地址、仓库内的名称和标签
+type imageRef struct {
+	registry string
+	repo     string
+	tag      string
+}
+
+func (r imageRef) String() string {
+	return r.registry + "/" + r.repo + ":" + r.tag
+}
+
+// 解析 [仓库地址/]名称[:标签]；第一段不含 . 或 : 且不是 localhost 时为 Docker Hub，与 docker 的规则相同
+func parseImageRef(ref string) (imageRef, error) {
+	if strings.Contains(ref, "@") {
+		return imageRef{}, fmt.Errorf("推送目标 %q 需要标签而不是摘要", ref)
+	}
+	r := imageRef{registry: "docker.io", tag: "latest"}
+	name := ref
+	if i := strings.LastIndexByte(name, ':'); i > strings.LastIndexByte(name, '/') {
+		name, r.tag = name[:i], name[i+1:]
+	}
+	if first, rest, ok := strings.Cut(name, "/"); ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		r.registry, name = first, rest
+	}
+	if r.registry == "docker.io" && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	if name == "" || r.tag == "" {
+		return imageRef{}, fmt.Errorf("非法的镜像名称: %q", ref)
+	}
+	r.repo = name
+	return r, nil
+}
+
+// registryClient 访问 Distribution API v2，按仓库的质询获取 Bearer 令牌或使用 Basic 认证
+type registryClient struct {
+	client   *http.Client
+	base     string // scheme://host
+	user     string
+	password string
+	auth     string // 已获得的 Authorization 头
+}
+
+func newRegistryClient(client *http.Client, registry string, plainHTTP bool, basicAuth string) (*registryClient, error) {
+	host := registry
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+	scheme := "https"
+	if plainHTTP {
+		scheme = "http"
+	}
+	c := &registryClient{client: client, base: scheme + "://" + host}
+	if basicAuth != "" {
+		user, password, ok := strings.Cut(basicAuth, ":")
+		if !ok {
+			password = os.Getenv(basicPasswordEnv)
+		}
+		if user == "" || password == "" {
+			return nil, errors.New("-basic-auth 格式应为 user[:password]，未写密码时需设置 " + basicPasswordEnv)
+		}
+		c.user, c.password = user, password
+	} else {
+		c.user, c.password = dockerConfigAuth(registry)
+	}
+	return c, nil
+}
+
+// 读取 ~/.docker/config.json 中该仓库保存的凭据 (不支持 credential helper)
+func dockerConfigAuth(registry string) (string, string) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", ""
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return "", ""
+	}
+	var config struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	if json.Unmarshal(data, &config) != nil {
+		return "", ""
+	}
+	keys := []string{registry, "https://" + registry}
+	if registry == "docker.io" {
+		keys = append(keys, "https://index.docker.io/v1/")
+	}
+	for _, k := range keys {
+		raw, err := base64.StdEncoding.DecodeString(config.Auths[k].Auth)
+		if err != nil {
+			continue
+		}
+		if user, password, ok := strings.Cut(string(raw), ":"); ok {
+			return user, password
+		}
+	}
+	return "", ""
+}
+
+// 发送请求；收到 401 时按质询认证后重发一次。body 为 nil 或可以重新读取的 *io.SectionReader
+func (c *registryClient) do(method, rawURL, repo string, header http.Header, body *io.SectionReader, progress io.Writer) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		var r io.Reader
+		if body != nil {
+			if _, err := body.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+			r = body
+			if progress != nil {
+				r = io.TeeReader(body, progress)
+			}
+		}
+		req, err := http.NewRequest(method, rawURL, r)
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			req.ContentLength = body.Size()
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		if c.auth != "" {
+			req.Header.Set("Authorization", c.auth)
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return resp, nil
+		}
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if err := c.authenticate(challenge, repo); err != nil {
+			return nil, err
+		}
+		// 已经发送的数据在重发时会再次计入进度，认证通常发生在第一个请求 (HEAD) 上
+	}
+}
+
+// 按 WWW-Authenticate 质询认证：Basic 直接使用凭据，Bearer 向令牌服务申请推送权限的令牌
+func (c *registryClient) authenticate(challenge, repo string) error {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if c.user == "" {
+			return errors.New("仓库要求认证，请用 -basic-auth 指定凭据或先 docker login")
+		}
+		c.auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(c.user+":"+c.password))
+		return nil
+	case "bearer":
+	default:
+		return fmt.Errorf("不支持的认证方式: %q", challenge)
+	}
+
+	fields := parseChallengeParams(params)
+	realm, err := url.Parse(fields["realm"])
+	if err != nil || realm.Host == "" {
+		return fmt.Errorf("令牌服务地址非法: %q", fields["realm"])
+	}
+	q := realm.Query()
+	if fields["service"] != "" {
+		q.Set("service", fields["service"])
+	}
+	q.Set("scope", "repository:"+repo+":pull,push")
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequest("GET", realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if c.user != "" {
+		req.SetBasicAuth(c.user, c.password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("申请令牌失败: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("申请令牌失败: %s", responseError(resp))
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("解析令牌失败: %w", err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return errors.New("令牌服务未返回令牌")
+	}
+	c.auth = "Bearer " + token.Token
+	return nil
+}
+
+// 解析质询中的 key="value" 参数
+func parseChallengeParams(s string) map[string]string {
+	fields := map[string]string{}
+	for s != "" {
+		s = strings.TrimLeft(s, ", ")
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			break
+		}
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		fields[strings.ToLower(strings.TrimSpace(key))] = value
+		s = rest
+	}
+	return fields
+}
+
+func (c *registryClient) blobExists(repo, digest string) (bool, error) {
+	resp, err := c.do("HEAD", c.base+"/v2/"+repo+"/blobs/"+digest, repo, nil, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("查询层 %s 失败: 仓库返回状态码 %d", shortDigest(digest), resp.StatusCode)
+}
+
+// 以单个 PUT 上传 blob：先 POST 开始上传会话，再把数据 PUT 到返回的地址
+func (c *registryClient) putBlob(repo, digest string, body *io.SectionReader, progress io.Writer) error {
+	resp, err := c.do("POST", c.base+"/v2/"+repo+"/blobs/uploads/", repo, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("开始上传失败: 仓库返回状态码 %d", resp.StatusCode)
+	}
+	location, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.Header.Get("Location") == "" {
+		return errors.New("仓库未返回上传地址")
+	}
+	q := location.Query()
+	q.Set("digest", digest)
+	location.RawQuery = q.Encode()
+
+	resp, err = c.do("PUT", location.String(), repo, http.Header{"Content-Type": {"application/octet-stream"}}, body, progress)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("仓库返回 %s", responseError(resp))
+	}
+	return nil
+}
+
+// 上传清单并打上标签，返回仓库确认的清单摘要
+func (c *registryClient) putManifest(repo, tag string, manifest []byte) (string, error) {
+	body := io.NewSectionReader(bytes.NewReader(manifest), 0, int64(len(manifest)))
+	resp, err := c.do("PUT", c.base+"/v2/"+repo+"/manifests/"+tag, repo, http.Header{"Content-Type": {ociManifestType}}, body, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("上传清单失败: 仓库返回 %s", responseError(resp))
+	}
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+	sum := sha256.Sum256(manifest)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
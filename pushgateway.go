@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// 推送到 Pushgateway 时使用的 job 标签
+const pushgatewayJob = "docker_save_shell"
+
+// 传输结束后将本次传输的指标推送到 Prometheus Pushgateway。
+// 分组键为 job + instance (主机名)，每次推送覆盖该主机上一次的指标，
+// 大量构建节点各自推送后即可在 Prometheus 中按主机、目标聚合。
+// 推送失败只输出警告，不影响传输结果
+func pushTransferMetrics(gateway string, rec *transferRecord, retries int, err error) {
+	if perr := pushMetrics(gateway, transferMetrics(rec, retries, err)); perr != nil {
+		fmt.Printf("⚠️  推送指标到 Pushgateway 失败: %v\n", perr)
+	}
+}
+
+// 以 Prometheus 文本格式生成传输指标
+func transferMetrics(rec *transferRecord, retries int, err error) []byte {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	labels := fmt.Sprintf(`{target="%s",result="%s"}`, promEscaper.Replace(rec.Target), result)
+	success := 0
+	if err == nil {
+		success = 1
+	}
+
+	var buf bytes.Buffer
+	metric := func(name, help, typ string, value any) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n%s%s %v\n", name, help, name, typ, name, labels, value)
+	}
+	metric("docker_save_shell_transfer_bytes", "Bytes sent in the last transfer.", "gauge", rec.Bytes)
+	metric("docker_save_shell_transfer_duration_seconds", "Duration of the last transfer.", "gauge", rec.Duration)
+	metric("docker_save_shell_transfer_success", "Whether the last transfer succeeded (1) or failed (0).", "gauge", success)
+	metric("docker_save_shell_transfer_retries", "Retries before the last transfer finished.", "gauge", retries)
+	metric("docker_save_shell_transfer_last_completion_timestamp_seconds", "Unix time the last transfer finished.", "gauge", time.Now().Unix())
+	return buf.Bytes()
+}
+
+// Prometheus 文本格式中标签值的转义
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// PUT 到 /metrics/job/<job>/instance/<host>，替换该分组下的全部指标
+func pushMetrics(gateway string, body []byte) error {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "unknown"
+	}
+	endpoint, err := url.JoinPath(gateway, "metrics", "job", pushgatewayJob, "instance", host)
+	if err != nil {
+		return fmt.Errorf("Pushgateway 地址格式错误: %w", err)
+	}
+	req, err := http.NewRequest("PUT", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Pushgateway 返回状态码 %d", resp.StatusCode)
+	}
+	return nil
+}
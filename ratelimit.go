@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// rateLimiter 令牌桶限速，允许短时突发不超过一秒的量
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // 字节/秒
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	return &rateLimiter{rate: float64(bytesPerSec), tokens: float64(bytesPerSec), last: time.Now()}
+}
+
+// 单次读取的最大字节数，避免一次读取消耗远超一秒的额度
+func (l *rateLimiter) chunk() int {
+	return max(int(l.rate/10), 4096)
+}
+
+// 消耗 n 字节的额度，额度不足时等待，ctx 结束时提前返回
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 本次上传使用的发送限速 (-limit-rate)，未限速时返回 nil
+func (opts options) uploadLimiter() *rateLimiter {
+	if opts.sendLimiter != nil {
+		return opts.sendLimiter
+	}
+	if opts.limitRate > 0 {
+		return newRateLimiter(int64(opts.limitRate))
+	}
+	return nil
+}
+
+// limitedReader 按限速读取
+type limitedReader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *rateLimiter
+}
+
+func newLimitedReader(ctx context.Context, r io.Reader, bytesPerSec int64) *limitedReader {
+	return &limitedReader{ctx: ctx, r: r, l: newRateLimiter(bytesPerSec)}
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > lr.l.chunk() {
+		p = p[:lr.l.chunk()]
+	}
+	n, err := lr.r.Read(p)
+	if werr := lr.l.wait(lr.ctx, n); werr != nil && err == nil {
+		err = werr
+	}
+	return n, err
+}
+
+// limitedListener 限制接收方向的带宽：每个连接不超过 perConn，所有连接合计不超过 total 的额度
+type limitedListener struct {
+	net.Listener
+	total   *rateLimiter // 为 nil 时不限制合计带宽
+	perConn int64        // 为 0 时不限制单个连接
+}
+
+// 为监听器加上接收限速，两个限制都为 0 时原样返回
+func limitListener(ln net.Listener, total, perConn int64) net.Listener {
+	if total <= 0 && perConn <= 0 {
+		return ln
+	}
+	l := &limitedListener{Listener: ln, perConn: perConn}
+	if total > 0 {
+		l.total = newRateLimiter(total)
+	}
+	return l
+}
+
+func (l *limitedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	lc := &limitedConn{Conn: conn, total: l.total}
+	if l.perConn > 0 {
+		lc.own = newRateLimiter(l.perConn)
+	}
+	return lc, nil
+}
+
+// limitedConn 读取时依次消耗连接自身和合计的额度
+type limitedConn struct {
+	net.Conn
+	own   *rateLimiter
+	total *rateLimiter
+}
+
+func (c *limitedConn) Read(p []byte) (int, error) {
+	for _, l := range []*rateLimiter{c.own, c.total} {
+		if l != nil && len(p) > l.chunk() {
+			p = p[:l.chunk()]
+		}
+	}
+	n, err := c.Conn.Read(p)
+	for _, l := range []*rateLimiter{c.own, c.total} {
+		if l != nil {
+			l.wait(context.Background(), n)
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 发布包格式标识，写在清单中，unbundle 据此拒绝不认识的格式
+const releaseFormat = "dss-release/v1"
+
+// 多个镜像合并后的归档在发布包内的文件名 (images/ 下)
+const mergedImagesName = "images.tar"
+
+// 发布包内清单及其签名的文件名，总是位于归档的最前面，解包时可以先校验签名再读取内容
+const (
+	releaseManifestName  = "MANIFEST.json"
+	releaseSignatureName = "MANIFEST.json.sig"
+)
+
+// releaseDescriptor 发布描述文件：列出要打进发布包的镜像、文件和脚本
+type releaseDescriptor struct {
+	Name     string            `yaml:"name"`
+	Version  string            `yaml:"version"`
+	Images   []string          `yaml:"images,omitempty"`  // 本机 docker 镜像，打包时执行 docker save
+	Files    []string          `yaml:"files,omitempty"`   // 相对路径以描述文件所在目录为准
+	Scripts  []string          `yaml:"scripts,omitempty"` // 同 files，解包后保留可执行权限，不会自动执行
+	Metadata map[string]string `yaml:"metadata,omitempty"`
+}
+
+// releaseManifest 发布包清单，记录每个条目的摘要，签名覆盖整个清单
+type releaseManifest struct {
+	Format   string            `json:"format"`
+	Name     string            `json:"name"`
+	Version  string            `json:"version"`
+	Created  time.Time         `json:"created"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Entries  []releaseEntry    `json:"entries"`
+}
+
+// releaseEntry 发布包中的一个条目
+type releaseEntry struct {
+	Path   string `json:"path"` // 归档内路径，如 images/nginx_1.25.tar
+	Kind   string `json:"kind"` // image、file 或 script
+	Source string `json:"source"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+
+	file string // 打包时的本地文件
+}
+
+// bundle 子命令：按发布描述文件生成带清单 (可签名) 的发布包，并可直接上传
+func runBundle(args []string, cfg *Config) {
+	var opts options
+	var output, signKey string
+	var saveJobs int
+	var separate bool
+	fs := newCommandFlags("bundle")
+	registerUploadFlags(fs, &opts)
+	fs.StringVar(&output, "o", "", "发布包的保存路径 (未指定时只上传，不保留)")
+	fs.StringVar(&signKey, "sign-key", "", "用该 Ed25519 私钥 (PEM) 对发布包清单签名")
+	fs.IntVar(&saveJobs, "save-jobs", defaultSaveJobs, "同时执行的 docker save 数")
+	fs.BoolVar(&separate, "separate-images", false, "每个镜像单独保存为一个归档 (默认合并为 images/images.tar，共享的层只保留一份)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: docker_save_shell bundle <release.yaml> [-o 发布包.tar] [-sign-key 私钥] [-url 地址 上传参数]")
+		fmt.Fprintln(fs.Output(), "将描述文件中的镜像 (docker save)、文件和脚本连同清单打成一个发布包，接收方用 unbundle 校验并解包")
+		fs.PrintDefaults()
+	}
+	positional := parseInterspersed(fs, args)
+	if err := applyConfig(fs, &opts, cfg); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if len(positional) != 1 || (output == "" && opts.serverURL == "") {
+		fmt.Println("错误：需要一个发布描述文件，以及 -o 或 -url")
+		fs.Usage()
+		os.Exit(1)
+	}
+	if opts.filePath != "" {
+		fmt.Println("错误：bundle 上传的是生成的发布包，不能同时指定 -file")
+		os.Exit(1)
+	}
+
+	if err := opts.docker.validate(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	desc, err := loadReleaseDescriptor(positional[0])
+	if err != nil {
+		fmt.Printf("读取发布描述失败: %v\n", err)
+		os.Exit(1)
+	}
+	// 策略拒绝的镜像不检查、不拉取，也不开始打包；只生成发布包时以其保存路径作为目标评估
+	if len(desc.Images) > 0 {
+		target := opts.serverURL
+		if target == "" {
+			if target, err = filepath.Abs(output); err != nil {
+				target = output
+			}
+		}
+		in := policyInput{Source: imageSourcePrefix + strings.Join(desc.Images, ","), Target: target}
+		if err := enforceImagesPolicy(in, desc.Images); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	if err := checkImages(opts.docker, desc.Images, opts.pull); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	keep := output != ""
+	tmpDir := ""
+	if !keep {
+		// 上传的文件名取发布名称和版本
+		if tmpDir, err = os.MkdirTemp("", "dss-release-"); err != nil {
+			fmt.Printf("创建临时目录失败: %v\n", err)
+			os.Exit(1)
+		}
+		defer os.RemoveAll(tmpDir)
+		output = filepath.Join(tmpDir, releaseFileName(desc))
+	}
+	if err := buildRelease(desc, filepath.Dir(positional[0]), signKey, output, opts.docker, saveJobs, separate); err != nil {
+		os.RemoveAll(tmpDir)
+		fmt.Printf("生成发布包失败: %v\n", err)
+		os.Exit(1)
+	}
+	if keep {
+		fmt.Printf("📦 发布包: %s\n", output)
+	}
+	if opts.serverURL == "" {
+		return
+	}
+
+	// 未指定 -name 时作为版本化制品保存
+	if opts.artifactName == "" && opts.preset == "" {
+		opts.artifactName, opts.artifactVersion = desc.Name, desc.Version
+	}
+	opts.filePath = output
+	// 发布包中的镜像都已在打包前评估，没有镜像时为空切片 (而非 nil)
+	opts.policyImages = append([]string{}, desc.Images...)
+	opts.retry = newRetryBudget(opts.retryBudget, opts.retryDeadline)
+	if err := upload(context.Background(), opts); err != nil {
+		os.RemoveAll(tmpDir)
+		fmt.Printf("上传发布包失败: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// 发布包的文件名: <name>-<version>.release.tar
+func releaseFileName(desc *releaseDescriptor) string {
+	return strings.NewReplacer("/", "_", ":", "_").Replace(desc.Name+"-"+desc.Version) + ".release.tar"
+}
+
+func loadReleaseDescriptor(path string) (*releaseDescriptor, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	desc := &releaseDescriptor{}
+	if err := yaml.Unmarshal(data, desc); err != nil {
+		return nil, fmt.Errorf("解析 %s 失败: %w", path, err)
+	}
+	if desc.Name == "" || desc.Version == "" {
+		return nil, errors.New("发布描述缺少 name 或 version")
+	}
+	if len(desc.Images)+len(desc.Files)+len(desc.Scripts) == 0 {
+		return nil, errors.New("发布描述中没有任何镜像、文件或脚本")
+	}
+	return desc, nil
+}
+
+// 准备各条目 (导出镜像、计算摘要)，再按 清单、签名、条目 的顺序写出归档
+func buildRelease(desc *releaseDescriptor, baseDir, signKey, output string, docker dockerEndpoint, saveJobs int, separate bool) error {
+	manifest := &releaseManifest{
+		Format:   releaseFormat,
+		Name:     desc.Name,
+		Version:  desc.Version,
+		Created:  time.Now().UTC(),
+		Metadata: desc.Metadata,
+	}
+	seen := map[string]bool{}
+	add := func(e releaseEntry) error {
+		if seen[e.Path] {
+			return fmt.Errorf("发布包中有重名的条目: %s", e.Path)
+		}
+		seen[e.Path] = true
+		f, err := os.Open(e.file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h := sha256.New()
+		if e.Size, err = io.Copy(h, f); err != nil {
+			return fmt.Errorf("读取 %s 失败: %w", e.file, err)
+		}
+		e.SHA256 = hex.EncodeToString(h.Sum(nil))
+		manifest.Entries = append(manifest.Entries, e)
+		return nil
+	}
+
+	tmpDir, err := os.MkdirTemp("", "dss-release-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	files, err := saveImages(docker, desc.Images, tmpDir, saveJobs)
+	if err != nil {
+		return err
+	}
+	if separate || len(files) < 2 {
+		for i, ref := range desc.Images {
+			if err := add(releaseEntry{Path: "images/" + imageFileName([]string{ref}), Kind: "image", Source: ref, file: files[i]}); err != nil {
+				return err
+			}
+		}
+	} else {
+		// 同一系列的镜像往往共享基础层，合并为一个归档后共享的层只保存和传输一份
+		merged := filepath.Join(tmpDir, mergedImagesName)
+		shared, saved, err := mergeImageArchives(files, merged)
+		if err != nil {
+			return fmt.Errorf("合并镜像归档失败: %w", err)
+		}
+		fmt.Printf("🧩 %d 个镜像合并为 %s，共享的条目 %d 个，节省 %s\n", len(files), mergedImagesName, shared, formatBytes(saved))
+		if err := add(releaseEntry{Path: "images/" + mergedImagesName, Kind: "image", Source: strings.Join(desc.Images, ","), file: merged}); err != nil {
+			return err
+		}
+	}
+	for kind, list := range map[string][]string{"file": desc.Files, "script": desc.Scripts} {
+		for _, p := range list {
+			file := p
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(baseDir, p)
+			}
+			if err := add(releaseEntry{Path: kind + "s/" + filepath.Base(file), Kind: kind, Source: p, file: file}); err != nil {
+				return err
+			}
+		}
+	}
+	sort.SliceStable(manifest.Entries, func(i, j int) bool { return manifest.Entries[i].Path < manifest.Entries[j].Path })
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	var sig []byte
+	if signKey != "" {
+		if sig, err = signManifest(signKey, data); err != nil {
+			return err
+		}
+	}
+
+	out, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(out)
+	err = writeRelease(tw, manifest, data, sig)
+	if cerr := tw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("📋 %s@%s: %d 个条目", desc.Name, desc.Version, len(manifest.Entries))
+	if sig != nil {
+		fmt.Print("，清单已签名")
+	}
+	fmt.Println()
+	return nil
+}
+
+func writeRelease(tw *tar.Writer, manifest *releaseManifest, data, sig []byte) error {
+	writeBytes := func(name string, b []byte) error {
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(b)), ModTime: manifest.Created, Format: tar.FormatPAX}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(b)
+		return err
+	}
+	if err := writeBytes(releaseManifestName, data); err != nil {
+		return err
+	}
+	if sig != nil {
+		if err := writeBytes(releaseSignatureName, sig); err != nil {
+			return err
+		}
+	}
+	for _, e := range manifest.Entries {
+		mode := int64(0o644)
+		if e.Kind == "script" {
+			mode = 0o755
+		}
+		hdr := &tar.Header{Name: e.Path, Mode: mode, Size: e.Size, ModTime: manifest.Created, Format: tar.FormatPAX}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		f, err := os.Open(e.file)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("写入 %s 失败: %w", e.Path, err)
+		}
+	}
+	return nil
+}
+
+// unbundle 子命令：校验发布包的签名和各条目摘要后解包，可选 docker load 其中的镜像
+func runUnbundle(args []string) {
+	var dir, pubKey string
+	var load bool
+	fs := newCommandFlags("unbundle")
+	fs.StringVar(&dir, "dir", "", "解包目录 (必须，已存在时整体替换)")
+	fs.StringVar(&pubKey, "pub-key", "", "校验清单签名的 Ed25519 公钥 (PEM)，指定后未签名或签名不符的发布包将被拒绝")
+	fs.BoolVar(&load, "load", false, "解包后 docker load 发布包中的镜像")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: docker_save_shell unbundle <发布包.tar> -dir <目录> [-pub-key 公钥] [-load]")
+		fs.PrintDefaults()
+	}
+	positional := parseInterspersed(fs, args)
+	if len(positional) != 1 || dir == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	manifest, err := unbundle(positional[0], dir, pubKey)
+	if err != nil {
+		fmt.Printf("解包失败: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("✅ %s@%s 已解包到 %s\n", manifest.Name, manifest.Version, dir)
+	for _, e := range manifest.Entries {
+		fmt.Printf("   %-7s %s (%s)\n", e.Kind, e.Path, formatBytes(e.Size))
+	}
+	if !load {
+		return
+	}
+	for _, e := range manifest.Entries {
+		if e.Kind != "image" {
+			continue
+		}
+		loaded, err := dockerLoad(filepath.Join(dir, filepath.FromSlash(e.Path)))
+		if err != nil {
+			fmt.Printf("加载镜像 %s 失败: %v\n", e.Source, err)
+			os.Exit(1)
+		}
+		fmt.Printf("🐳 已加载: %s\n", strings.Join(loaded, ", "))
+	}
+}
+
+// 先校验清单签名，再边解包边核对每个条目的摘要，全部通过后才替换目标目录
+func unbundle(archive, dest, pubKey string) (*releaseManifest, error) {
+	var pub ed25519.PublicKey
+	if pubKey != "" {
+		var err error
+		if pub, err = loadEd25519PublicKey(pubKey); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+
+	readSmall := func(name string) ([]byte, error) {
+		hdr, err := tr.Next()
+		if err != nil {
+			return nil, fmt.Errorf("读取 %s 失败: %w", name, err)
+		}
+		if hdr.Name != name {
+			return nil, fmt.Errorf("不是发布包：第一个条目应为 %s", releaseManifestName)
+		}
+		return io.ReadAll(io.LimitReader(tr, 16<<20))
+	}
+	data, err := readSmall(releaseManifestName)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &releaseManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("解析清单失败: %w", err)
+	}
+	if manifest.Format != releaseFormat {
+		return nil, fmt.Errorf("不支持的发布包格式: %q", manifest.Format)
+	}
+
+	hdr, err := tr.Next()
+	signed := err == nil && hdr.Name == releaseSignatureName
+	if signed {
+		sig, err := io.ReadAll(io.LimitReader(tr, 1024))
+		if err != nil {
+			return nil, err
+		}
+		if pub != nil && !ed25519.Verify(pub, data, sig) {
+			return nil, errors.New("清单签名校验失败")
+		}
+		hdr, err = tr.Next()
+	}
+	switch {
+	case pub != nil && !signed:
+		return nil, errors.New("发布包未签名")
+	case pub != nil:
+		fmt.Println("🔏 清单签名校验通过")
+	case signed:
+		fmt.Println("⚠️  发布包已签名，但未指定 -pub-key，未校验签名")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filepath.Clean(dest)), 0o755); err != nil {
+		return nil, err
+	}
+	staging, err := os.MkdirTemp(filepath.Dir(filepath.Clean(dest)), ".unbundle-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+	os.Chmod(staging, 0o755)
+
+	expected := map[string]releaseEntry{}
+	for _, e := range manifest.Entries {
+		expected[e.Path] = e
+	}
+	for ; err == nil; hdr, err = tr.Next() {
+		e, ok := expected[hdr.Name]
+		if !ok {
+			return nil, fmt.Errorf("发布包中有清单之外的条目: %s", hdr.Name)
+		}
+		delete(expected, hdr.Name)
+		if path.IsAbs(e.Path) || !localPath(e.Path) {
+			return nil, fmt.Errorf("发布包中包含非法路径: %s", e.Path)
+		}
+		if err := extractReleaseEntry(tr, staging, e); err != nil {
+			return nil, err
+		}
+	}
+	if err != io.EOF {
+		return nil, fmt.Errorf("读取发布包失败: %w", err)
+	}
+	for p := range expected {
+		return nil, fmt.Errorf("发布包缺少清单中的条目: %s", p)
+	}
+
+	if err := os.WriteFile(filepath.Join(staging, releaseManifestName), data, 0o644); err != nil {
+		return nil, err
+	}
+	return manifest, swapDir(staging, dest)
+}
+
+// 写出一个条目并核对大小和摘要
+func extractReleaseEntry(r io.Reader, dir string, e releaseEntry) error {
+	target := filepath.Join(dir, filepath.FromSlash(e.Path))
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	mode := os.FileMode(0o644)
+	if e.Kind == "script" {
+		mode = 0o755
+	}
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(out, h), r)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("解包 %s 失败: %w", e.Path, err)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); n != e.Size || sum != e.SHA256 {
+		return fmt.Errorf("%s 与清单不符 (大小 %d/%d，摘要 %s)", e.Path, n, e.Size, sum)
+	}
+	return nil
+}
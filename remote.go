@@ -0,0 +1,289 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 访问服务端的子命令 (list、download 等) 所用的客户端：与上传使用相同的 -token、-header、-ca-cert 等参数，
+// 未指定客户端证书时同样使用 enroll 登记的证书；返回的函数关闭 -via 建立的隧道
+func newRemoteClient(opts options) (*http.Client, func(), error) {
+	if opts.tls.clientCert == "" && opts.tls.clientKey == "" {
+		opts.tls.clientCert, opts.tls.clientKey = enrolledIdentity(defaultProfile)
+	}
+	client, tunnel, err := newUploadClient(context.Background(), opts, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tunnel == nil {
+		return client.Client, func() {}, nil
+	}
+	return client.Client, tunnel.Close, nil
+}
+
+// 解析参数后创建访问服务端的客户端，参数错误时退出
+func mustRemoteClient(opts options) (*http.Client, func()) {
+	client, closeClient, err := newRemoteClient(opts)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	return client, closeClient
+}
+
+// list 子命令：列出服务端保存的版本化制品
+func runList(args []string) {
+	var opts options
+	var name string
+	fs := newCommandFlags("list")
+	fs.StringVar(&opts.serverURL, "url", "", "服务端地址 (必须)")
+	fs.StringVar(&name, "name", "", "只列出指定名称的制品")
+	registerClientFlags(fs, &opts)
+	fs.Parse(args)
+
+	if opts.serverURL == "" {
+		fmt.Println("错误：缺少必要参数 -url")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	client, closeClient := mustRemoteClient(opts)
+	err := listArtifacts(client, opts.serverURL, name)
+	closeClient()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func listArtifacts(client *http.Client, serverURL, name string) error {
+	endpoint, err := url.JoinPath(serverURL, "artifacts")
+	if err != nil {
+		return fmt.Errorf("服务端地址格式错误: %w", err)
+	}
+	if name != "" {
+		endpoint += "?name=" + url.QueryEscape(name)
+	}
+
+	resp, err := client.Get(endpoint)
+	if err != nil {
+		return fmt.Errorf("请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("请求失败: %s", responseError(resp))
+	}
+
+	var list []*artifactMeta
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return fmt.Errorf("解析响应失败: %w", err)
+	}
+	if len(list) == 0 {
+		fmt.Println("暂无制品")
+		return nil
+	}
+
+	printArtifacts(list)
+	return nil
+}
+
+// 以表格输出制品列表，latest 版本以 * 标记
+func printArtifacts(list []*artifactMeta) {
+	fmt.Printf("%-24s %-16s %10s  %-19s  %s\n", "NAME", "VERSION", "SIZE", "CREATED", "FILE")
+	for _, a := range list {
+		version := a.Version
+		if a.Latest {
+			version += " *"
+		}
+		fmt.Printf("%-24s %-16s %10s  %-19s  %s\n", a.Name, version, formatBytes(a.Size), a.Created.Local().Format(time.DateTime), a.File)
+	}
+}
+
+// download 子命令：下载服务端文件或版本化制品，显示进度，中断后重新执行可续传，完成后校验服务端记录的 SHA-256。
+// 指定 name[@version] 时 -url 为服务端地址，省略版本号表示 latest；否则 -url 为完整的下载地址
+func runDownload(args []string) {
+	var opts options
+	var out string
+	fs := newCommandFlags("download")
+	fs.StringVar(&opts.serverURL, "url", "", "服务端地址或文件下载地址 (必须)")
+	fs.StringVar(&out, "out", "", "保存路径 (默认使用服务端提供的文件名)")
+	registerClientFlags(fs, &opts)
+	fs.Parse(args)
+
+	if opts.serverURL == "" || fs.NArg() > 1 {
+		fmt.Println("用法: docker_save_shell download -url URL [-out FILE] [name[@version]]")
+		os.Exit(1)
+	}
+
+	target := opts.serverURL
+	if ref := fs.Arg(0); ref != "" {
+		name, version, _ := strings.Cut(ref, "@")
+		if version == "" {
+			version = latestVersion
+		}
+		var err error
+		target, err = url.JoinPath(opts.serverURL, "artifacts", name, version)
+		if err != nil {
+			fmt.Printf("服务端地址格式错误: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	client, closeClient := mustRemoteClient(opts)
+	err := download(client, target, out)
+	closeClient()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// 下载文件并显示进度。服务端提供下载会话令牌时，中断后重新执行同一命令即从断点续传；
+// 续传由服务端核对令牌，文件已被替换 (如 latest 指向了新版本) 时丢弃已下载的部分重新下载
+func download(client *http.Client, target, out string) error {
+	st := loadDownloadState(target)
+	var offset int64
+	if st != nil {
+		info, err := os.Stat(st.Out + ".part")
+		if (out != "" && out != st.Out) || err != nil || info.Size() >= st.Size {
+			st.remove()
+			st = nil
+		} else {
+			offset = info.Size()
+		}
+	}
+
+	req, err := http.NewRequest("GET", target, nil)
+	if err != nil {
+		return fmt.Errorf("下载地址格式错误: %w", err)
+	}
+	if st != nil {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set(downloadSessionHeader, st.Session)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("下载失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPreconditionFailed && st != nil:
+		fmt.Println("⚠️  服务端的文件已变化，丢弃已下载的部分重新下载")
+		os.Remove(st.Out + ".part")
+		st.remove()
+		return download(client, target, cmp.Or(out, st.Out))
+	case resp.StatusCode == http.StatusPartialContent && st != nil:
+		out = st.Out
+	case resp.StatusCode == http.StatusOK:
+		// 服务端不支持续传时从头下载
+		if st != nil {
+			out = st.Out
+		}
+		offset = 0
+	default:
+		return fmt.Errorf("下载失败: %s", responseError(resp))
+	}
+
+	if out == "" {
+		out = remoteFileName(resp)
+	}
+
+	fmt.Printf("📥 下载: %s\n", target)
+	fmt.Printf("💾 保存到: %s\n", out)
+	if offset > 0 {
+		fmt.Printf("⏩ 从 %s 处续传\n", formatBytes(offset))
+	}
+
+	part := out + ".part"
+	sum := sha256.New()
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 {
+		// 摘要需要覆盖整个文件，先读入已下载的部分
+		prev, err := os.Open(part)
+		if err != nil {
+			return fmt.Errorf("读取已下载的部分失败: %w", err)
+		}
+		_, err = io.Copy(sum, io.LimitReader(prev, offset))
+		prev.Close()
+		if err != nil {
+			return fmt.Errorf("读取已下载的部分失败: %w", err)
+		}
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(part, flags, 0o644)
+	if err != nil {
+		return fmt.Errorf("创建文件失败: %w", err)
+	}
+
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+	session := resp.Header.Get(downloadSessionHeader)
+	if session != "" && total >= 0 {
+		st = &downloadState{URL: target, Out: out, Session: session, Size: total}
+		if err := st.save(); err != nil {
+			st = nil
+		}
+	} else {
+		st = nil
+	}
+
+	bar := newTransferBar(total, fmt.Sprintf("📥 下载 %s", filepath.Base(out)))
+	bar.Set64(offset)
+	_, err = io.Copy(io.MultiWriter(f, sum, bar), resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		if st != nil {
+			fmt.Println("\n💡 重新执行同一命令即可从断点续传")
+		} else {
+			os.Remove(part)
+		}
+		return fmt.Errorf("下载失败: %w", err)
+	}
+
+	if st != nil {
+		st.remove()
+	}
+	if want := sessionDigest(session); want != "" {
+		if got := hex.EncodeToString(sum.Sum(nil)); got != want {
+			os.Remove(part)
+			return fmt.Errorf("下载内容校验失败: 期望 %s，实际 %s", want, got)
+		}
+		fmt.Println("🔐 SHA-256 校验通过")
+	}
+	if err := os.Rename(part, out); err != nil {
+		return fmt.Errorf("保存文件失败: %w", err)
+	}
+	fmt.Println("✅ 下载完成")
+	return nil
+}
+
+// 从错误响应中提取服务端给出的错误信息
+func responseError(resp *http.Response) string {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+
+	var result uploadResult
+	if json.Unmarshal(body, &result) == nil && result.Error != "" {
+		return fmt.Sprintf("%s (%s)", result.Error, resp.Status)
+	}
+	if msg := strings.TrimSpace(string(body)); msg != "" {
+		return fmt.Sprintf("%s (%s)", msg, resp.Status)
+	}
+	return resp.Status
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"command_tool/pkg/uploader"
+)
+
+// treeInfo 文件的树形摘要明细
+type treeInfo struct {
+	Root     string   `json:"root"`
+	Size     int64    `json:"size"`
+	LeafSize int64    `json:"leaf_size"`
+	Leaves   []string `json:"leaves"`
+}
+
+// 将请求指向的对象解析为服务端文件路径：/files/{name} 或 /artifacts/{name}/{version}
+func (s *server) storedTarget(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if version := r.PathValue("version"); version != "" {
+		meta, err := s.loadArtifact(r.PathValue("name"), version)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, uploadResult{Error: "制品不存在"})
+			return "", false
+		}
+		return s.artifactFilePath(meta), true
+	}
+	return s.storedPath(w, r.PathValue("name"))
+}
+
+// 返回已存储文件的树形摘要明细
+func (s *server) handleTree(w http.ResponseWriter, r *http.Request) {
+	path, ok := s.storedTarget(w, r)
+	if !ok {
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, uploadResult{Error: "文件不存在"})
+		return
+	}
+
+	root, leaves, err := parallelTreeHash(path, s.opts.verifyWorkers, nil)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, uploadResult{Error: "计算树形摘要失败: " + err.Error()})
+		return
+	}
+
+	tree := treeInfo{Root: root, Size: info.Size(), LeafSize: treeLeafSize}
+	for _, leaf := range leaves {
+		tree.Leaves = append(tree.Leaves, hex.EncodeToString(leaf))
+	}
+	writeJSON(w, http.StatusOK, tree)
+}
+
+// 上传时记录的树形摘要明细，保存在 <存储目录>/.dss/trees/ 下：
+// 普通文件为 files/<文件名>.json，制品为 artifacts/<名称>/<版本>.json。
+// 修复时以它为准校验客户端提交的每一片，而不是信任客户端自己给出的摘要。
+func (s *server) treePath(name, version string) string {
+	if version != "" {
+		return filepath.Join(serverMetaDir(s.opts.dir), "trees", "artifacts", name, version+".json")
+	}
+	return filepath.Join(serverMetaDir(s.opts.dir), "trees", "files", name+".json")
+}
+
+// 记录刚提交的文件的树形摘要明细；上传时没有计算树形摘要的，删除同名文件遗留的旧记录
+func (s *server) recordTree(treePath, stored string, rf *receivedFile) error {
+	if rf.treeLeaves == nil {
+		if err := os.Remove(treePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	info, err := os.Stat(stored)
+	if err != nil {
+		return err
+	}
+	tree := treeInfo{Root: rf.treeSHA256, Size: info.Size(), LeafSize: treeLeafSize}
+	for _, leaf := range rf.treeLeaves {
+		tree.Leaves = append(tree.Leaves, hex.EncodeToString(leaf))
+	}
+	if err := os.MkdirAll(filepath.Dir(treePath), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(treePath, tree)
+}
+
+// 读取记录的树形摘要明细
+func loadTree(treePath string) (*treeInfo, error) {
+	data, err := os.ReadFile(treePath)
+	if err != nil {
+		return nil, err
+	}
+	tree := &treeInfo{}
+	if err := json.Unmarshal(data, tree); err != nil {
+		return nil, err
+	}
+	if tree.LeafSize != treeLeafSize {
+		return nil, errors.New("记录的树形摘要片大小与当前版本不一致")
+	}
+	return tree, nil
+}
+
+// 覆盖写入文件中的一片数据
+// 请求参数: ?leaf=片序号&size=文件总大小。请求体必须与上传时记录的该片叶子摘要一致，
+// 文件大小也必须与记录一致：修复只能把文件恢复为上传时的内容，不能借此改写或截断已提交的文件和制品版本。
+func (s *server) handlePatchLeaf(w http.ResponseWriter, r *http.Request) {
+	path, ok := s.storedTarget(w, r)
+	if !ok {
+		return
+	}
+
+	leaf, err1 := strconv.ParseInt(r.URL.Query().Get("leaf"), 10, 64)
+	size, err2 := strconv.ParseInt(r.URL.Query().Get("size"), 10, 64)
+	if err1 != nil || err2 != nil || leaf < 0 || size < 0 || leaf*treeLeafSize > size {
+		writeJSON(w, http.StatusBadRequest, uploadResult{Error: "leaf 或 size 参数错误"})
+		return
+	}
+
+	tree, err := loadTree(s.treePath(r.PathValue("name"), r.PathValue("version")))
+	if err != nil {
+		writeJSON(w, http.StatusConflict, uploadResult{Error: "服务端没有该文件上传时的树形摘要记录，无法校验修复数据"})
+		return
+	}
+	if size != tree.Size {
+		writeJSON(w, http.StatusConflict, uploadResult{Error: fmt.Sprintf("文件大小应为 %d，修复不能改变文件大小", tree.Size)})
+		return
+	}
+	if leaf >= int64(len(tree.Leaves)) {
+		writeJSON(w, http.StatusBadRequest, uploadResult{Error: "leaf 参数超出文件范围"})
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, treeLeafSize+1))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, uploadResult{Error: "读取请求失败: " + err.Error()})
+		return
+	}
+	want := min(treeLeafSize, size-leaf*treeLeafSize)
+	if int64(len(data)) != want {
+		writeJSON(w, http.StatusBadRequest, uploadResult{Error: fmt.Sprintf("数据长度应为 %d，实际 %d", want, len(data))})
+		return
+	}
+	if got := hex.EncodeToString(uploader.LeafHash(data)); got != tree.Leaves[leaf] {
+		writeJSON(w, http.StatusUnprocessableEntity, uploadResult{Error: "数据与上传时记录的叶子摘要不一致"})
+		return
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, uploadResult{Error: "文件不存在"})
+		return
+	}
+	defer f.Close()
+
+	if info, err := f.Stat(); err != nil || info.Size() != tree.Size {
+		writeJSON(w, http.StatusConflict, uploadResult{Error: "文件大小与上传时的记录不一致，无法按片修复"})
+		return
+	}
+	if _, err := f.WriteAt(data, leaf*treeLeafSize); err != nil {
+		writeJSON(w, http.StatusInternalServerError, uploadResult{Error: "写入失败: " + err.Error()})
+		return
+	}
+	if err := f.Sync(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, uploadResult{Error: "写入失败: " + err.Error()})
+		return
+	}
+	if r.PathValue("version") == "" {
+		s.refreshSum(path)
+	}
+	writeJSON(w, http.StatusOK, uploadResult{OK: true})
+}
+
+// repair 子命令：对比远程文件与本地文件的树形摘要，只重新上传损坏的片段
+func runRepair(args []string) {
+	var opts options
+	var local string
+	fs := newCommandFlags("repair")
+	fs.StringVar(&opts.serverURL, "url", "", "服务端地址 (必须)")
+	fs.StringVar(&local, "file", "", "本地的正确文件 (必须)")
+	registerClientFlags(fs, &opts)
+	fs.Parse(args)
+
+	if opts.serverURL == "" || local == "" || fs.NArg() != 1 {
+		fmt.Println("用法: docker_save_shell repair -url URL -file LOCAL [-token 令牌] <远程文件名 | name@version>")
+		os.Exit(1)
+	}
+
+	// 会话客户端固定连接同一个服务端地址，修复期间的所有请求都发往同一处
+	client, closeClient := mustRemoteClient(opts)
+	err := repair(client, opts.serverURL, local, fs.Arg(0))
+	closeClient()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func repair(client *http.Client, serverURL, local, remote string) error {
+	var endpoint string
+	var err error
+	if name, version, ok := strings.Cut(remote, "@"); ok {
+		endpoint, err = url.JoinPath(serverURL, "artifacts", name, version)
+	} else {
+		endpoint, err = url.JoinPath(serverURL, "files", remote)
+	}
+	if err != nil {
+		return fmt.Errorf("服务端地址格式错误: %w", err)
+	}
+
+	fmt.Printf("🔍 计算本地文件摘要: %s\n", local)
+	localRoot, localLeaves, err := parallelTreeHash(local, runtime.NumCPU(), nil)
+	if err != nil {
+		return fmt.Errorf("计算本地摘要失败: %w", err)
+	}
+	info, err := os.Stat(local)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("🔍 获取远程文件摘要: %s\n", remote)
+	remoteTree, err := fetchTree(client, endpoint)
+	if err != nil {
+		return err
+	}
+
+	if remoteTree.Root == localRoot {
+		fmt.Println("✅ 远程文件与本地一致，无需修复")
+		return nil
+	}
+
+	// 服务端只接受与上传时记录一致的数据，大小不同说明不是同一个文件或已被截断，只能重新上传
+	if remoteTree.Size != info.Size() {
+		return fmt.Errorf("远程文件大小 (%d) 与本地 (%d) 不同，无法按片修复，请重新上传", remoteTree.Size, info.Size())
+	}
+
+	var bad []int
+	for i, leaf := range localLeaves {
+		if i >= len(remoteTree.Leaves) || remoteTree.Leaves[i] != hex.EncodeToString(leaf) {
+			bad = append(bad, i)
+		}
+	}
+
+	var total int64
+	for _, i := range bad {
+		total += min(treeLeafSize, info.Size()-int64(i)*treeLeafSize)
+	}
+	fmt.Printf("🩹 共 %d 片，需要修复 %d 片 (%s)\n", len(localLeaves), len(bad), formatBytes(total))
+
+	f, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	bar := newTransferBar(total, "🩹 修复")
+	buf := make([]byte, treeLeafSize)
+	for _, i := range bad {
+		n, err := f.ReadAt(buf, int64(i)*treeLeafSize)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("读取本地文件失败: %w", err)
+		}
+		if err := patchLeaf(client, endpoint, i, info.Size(), buf[:n]); err != nil {
+			return fmt.Errorf("修复第 %d 片失败: %w", i, err)
+		}
+		bar.Add(n)
+	}
+
+	remoteTree, err = fetchTree(client, endpoint)
+	if err != nil {
+		return err
+	}
+	if remoteTree.Root != localRoot {
+		return fmt.Errorf("修复后摘要仍不一致: 本地 %s，远程 %s", localRoot, remoteTree.Root)
+	}
+	fmt.Printf("✅ 修复完成，摘要: %s\n", localRoot)
+	return nil
+}
+
+// 获取远程文件的树形摘要明细
+func fetchTree(client *http.Client, endpoint string) (*treeInfo, error) {
+	resp, err := client.Get(endpoint + "/tree")
+	if err != nil {
+		return nil, fmt.Errorf("获取远程摘要失败: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("获取远程摘要失败: %s", responseError(resp))
+	}
+
+	tree := &treeInfo{}
+	if err := json.NewDecoder(resp.Body).Decode(tree); err != nil {
+		return nil, fmt.Errorf("解析远程摘要失败: %w", err)
+	}
+	if tree.LeafSize != treeLeafSize {
+		return nil, errors.New("服务端的树形摘要片大小与本地不一致，请升级两端到相同版本")
+	}
+	return tree, nil
+}
+
+// 上传一片数据覆盖远程文件的对应位置
+func patchLeaf(client *http.Client, endpoint string, leaf int, size int64, data []byte) error {
+	target := fmt.Sprintf("%s?leaf=%d&size=%d", endpoint, leaf, size)
+	req, err := http.NewRequest("PATCH", target, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(responseError(resp))
+	}
+	return nil
+}
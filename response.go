@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	defaultMaxResponse  = 1 << 20 // 默认最多缓存的响应体大小
+	responsePreviewSize = 4 << 10 // 响应体过大时终端只显示开头部分
+)
+
+// capturedBody 读取到的服务端响应体；超过上限时整体写入临时文件，内存中只保留开头部分
+type capturedBody struct {
+	data []byte // 完整的响应体，或超过上限时的开头部分
+	size int64  // 响应体的实际大小
+	file string // 超过上限时保存完整响应体的文件
+}
+
+// 读取响应体，最多在内存中缓存 limit 字节 (limit <= 0 时使用默认值)，
+// 超出的部分连同已读取的内容一起写入临时文件，避免异常的响应 (如整页 HTML 报错) 占满内存
+func captureBody(r io.Reader, limit int64) (*capturedBody, error) {
+	if limit <= 0 {
+		limit = defaultMaxResponse
+	}
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return &capturedBody{data: data, size: int64(len(data))}, err
+	}
+	if int64(len(data)) <= limit {
+		return &capturedBody{data: data, size: int64(len(data))}, nil
+	}
+
+	f, err := os.CreateTemp("", "dss-response-*")
+	if err != nil {
+		return nil, fmt.Errorf("创建响应文件失败: %w", err)
+	}
+	defer f.Close()
+	n, err := io.Copy(f, io.MultiReader(bytes.NewReader(data), r))
+	body := &capturedBody{data: data[:min(int64(len(data)), responsePreviewSize)], size: n, file: f.Name()}
+	if err != nil {
+		return body, err
+	}
+	if err := f.Close(); err != nil {
+		return body, fmt.Errorf("保存响应文件失败: %w", err)
+	}
+	return body, nil
+}
+
+// 用于终端显示的响应内容：失败时 JSON 响应格式化输出，过长的内容只显示开头部分
+func (b *capturedBody) display(failed bool) string {
+	data := b.data
+	if failed && b.file == "" {
+		var buf bytes.Buffer
+		if json.Indent(&buf, bytes.TrimSpace(data), "", "  ") == nil {
+			data = buf.Bytes()
+		}
+	}
+	if len(data) <= responsePreviewSize && b.file == "" {
+		return string(data)
+	}
+
+	preview := data
+	if len(data) > responsePreviewSize {
+		// 在字符边界处截断，不截断多字节字符
+		cut := responsePreviewSize
+		for i := cut; i > cut-utf8.UTFMax; i-- {
+			if utf8.RuneStart(data[i]) {
+				cut = i
+				break
+			}
+		}
+		preview = data[:cut]
+	}
+	var sb strings.Builder
+	sb.Write(preview)
+	fmt.Fprintf(&sb, "\n... (共 %s，仅显示开头 %s)", formatBytes(b.size), formatBytes(int64(len(preview))))
+	if b.file != "" {
+		fmt.Fprintf(&sb, "\n💾 完整响应已保存到 %s", b.file)
+	}
+	return sb.String()
+}
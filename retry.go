@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 默认的重试预算：一次传输中各层重试合计的次数上限
+const defaultRetryBudget = 20
+
+// retryBudget 一次传输中各层重试共用的预算。整体重试 (守护进程 -retries) 和分块重试等内层重试
+// 都先从这里申领，避免多层重试次数相乘，在异常的服务端上形成重试风暴
+type retryBudget struct {
+	limit    int       // 合计重试次数上限，0 表示不限
+	deadline time.Time // 此后不再发起重试，零值表示不限
+
+	mu   sync.Mutex
+	used map[string]int // 各层已用的重试次数，键为层名 (transfer、chunk 等)
+}
+
+func newRetryBudget(limit int, window time.Duration) *retryBudget {
+	b := &retryBudget{limit: limit, used: map[string]int{}}
+	if window > 0 {
+		b.deadline = time.Now().Add(window)
+	}
+	return b
+}
+
+// 申领一次重试：delay 为重试前的等待时间，等待结束时已超过截止时间同样视为预算耗尽。
+// 预算为 nil 时不做限制
+func (b *retryBudget) take(layer string, delay time.Duration) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.limit > 0 && b.total() >= b.limit {
+		return fmt.Errorf("重试预算已用完 (共 %d 次: %s)", b.limit, b.breakdown())
+	}
+	if !b.deadline.IsZero() && time.Now().Add(delay).After(b.deadline) {
+		return fmt.Errorf("已到重试截止时间 %s", b.deadline.Format(time.DateTime))
+	}
+	b.used[layer]++
+	return nil
+}
+
+// 已用的重试次数合计，调用方需持有 b.mu
+func (b *retryBudget) total() int {
+	n := 0
+	for _, v := range b.used {
+		n += v
+	}
+	return n
+}
+
+// 各层的重试次数，如 "transfer 2, chunk 5"，调用方需持有 b.mu
+func (b *retryBudget) breakdown() string {
+	layers := make([]string, 0, len(b.used))
+	for layer := range b.used {
+		layers = append(layers, layer)
+	}
+	sort.Strings(layers)
+	parts := make([]string, len(layers))
+	for i, layer := range layers {
+		parts[i] = fmt.Sprintf("%s %d", layer, b.used[layer])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// 已用的重试次数合计
+func (b *retryBudget) retries() int {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.total()
+}
+
+// 各层已用次数的副本，用于任务状态和事件
+func (b *retryBudget) usage() map[string]int {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.used) == 0 {
+		return nil
+	}
+	m := make(map[string]int, len(b.used))
+	for k, v := range b.used {
+		m[k] = v
+	}
+	return m
+}
+
+// 传输结束时的重试统计，没有发生重试时返回空字符串
+func (b *retryBudget) summary() string {
+	if b == nil {
+		return ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := b.total()
+	if n == 0 {
+		return ""
+	}
+	s := fmt.Sprintf("共重试 %d 次 (%s)", n, b.breakdown())
+	if b.limit > 0 {
+		s += fmt.Sprintf("，预算剩余 %d 次", b.limit-n)
+	}
+	return s
+}
+
+// statusError 服务端以非成功状态码拒绝了上传
+type statusError struct {
+	code       int
+	hint       string        // 鉴权失败时的建议 (如时钟偏差)
+	retryAfter time.Duration // 服务端 Retry-After 要求的等待时间
+}
+
+func (e *statusError) Error() string {
+	if e.hint != "" {
+		return fmt.Sprintf("服务端返回状态码 %d (%s)", e.code, e.hint)
+	}
+	return fmt.Sprintf("服务端返回状态码 %d", e.code)
+}
+
+// 判断上传失败后是否值得重试：网络错误、408/429/5xx 以及违反 SLA 中止的传输可以重试；
+// 参数错误、鉴权失败、读取源文件失败和传输时间窗到期则重试也无济于事
+func retryableError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, errWindowExpired) {
+		return false
+	}
+	var serr *statusError
+	if errors.As(err, &serr) {
+		return serr.code == http.StatusRequestTimeout || serr.code == http.StatusTooManyRequests || serr.code >= 500
+	}
+	var sla *slaViolation
+	var uerr *url.Error
+	var nerr net.Error
+	return errors.As(err, &sla) || errors.As(err, &uerr) || errors.As(err, &nerr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// 第 attempt 次失败后的等待时间：base 起指数增长，最长 5 分钟
+func backoffDelay(base time.Duration, attempt int) time.Duration {
+	if base <= 0 {
+		base = 2 * time.Second
+	}
+	d := base
+	for i := 1; i < attempt && d < 5*time.Minute; i++ {
+		d *= 2
+	}
+	return min(d, 5*time.Minute)
+}
+
+// 解析 Retry-After：秒数或 HTTP 日期，无法解析时返回 0
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
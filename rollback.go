@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 每个标签最多保留的历史记录数
+const maxTagHistory = 20
+
+// tagHistoryEntry 标签被重新指向前所指向的镜像
+type tagHistoryEntry struct {
+	ImageID string    `json:"image_id"`
+	Time    time.Time `json:"time"`
+}
+
+// loadResult 服务端执行 docker load 后的结果
+type loadResult struct {
+	Loaded   []string `json:"loaded,omitempty"`
+	Tag      string   `json:"tag,omitempty"`
+	Previous string   `json:"previous_image,omitempty"`
+
+	Smoke *smokeResult `json:"smoke,omitempty"` // 请求了冒烟测试时的结果
+}
+
+// 标签历史文件路径
+func (s *server) tagHistoryPath() string {
+	return filepath.Join(serverMetaDir(s.opts.dir), "tags.json")
+}
+
+// 读取标签历史
+func (s *server) loadTagHistory() (map[string][]tagHistoryEntry, error) {
+	history := map[string][]tagHistoryEntry{}
+	data, err := os.ReadFile(s.tagHistoryPath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return history, nil
+		}
+		return nil, fmt.Errorf("读取标签历史失败: %w", err)
+	}
+	if err := json.Unmarshal(data, &history); err != nil {
+		return nil, fmt.Errorf("解析标签历史失败: %w", err)
+	}
+	return history, nil
+}
+
+// 保存标签历史
+func (s *server) saveTagHistory(history map[string][]tagHistoryEntry) error {
+	if err := os.MkdirAll(serverMetaDir(s.opts.dir), 0o700); err != nil {
+		return fmt.Errorf("创建元数据目录失败: %w", err)
+	}
+	return writeFileAtomic(s.tagHistoryPath(), history)
+}
+
+// 在本机执行 docker load，指定 tag 时把加载的镜像重新打上该标签，并记录标签原先指向的镜像
+func (s *server) loadImage(path, tag string) (*loadResult, error) {
+	loaded, err := dockerLoad(path)
+	if err != nil {
+		return nil, err
+	}
+	result := &loadResult{Loaded: loaded}
+	if tag == "" {
+		return result, nil
+	}
+
+	if len(loaded) != 1 {
+		return result, fmt.Errorf("归档中包含 %d 个镜像，无法确定要打标签 %s 的镜像", len(loaded), tag)
+	}
+	return result, s.retag(loaded[0], tag, result)
+}
+
+// 给已加载的镜像打上 tag，记录标签原先指向的镜像以便回滚
+func (s *server) retag(image, tag string, result *loadResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	newID := dockerImageID(image)
+	previous := dockerImageID(tag)
+	if err := dockerTag(image, tag); err != nil {
+		return err
+	}
+	result.Tag = tag
+
+	if previous == "" || previous == newID {
+		return nil
+	}
+	result.Previous = previous
+
+	history, err := s.loadTagHistory()
+	if err != nil {
+		return err
+	}
+	entries := append(history[tag], tagHistoryEntry{ImageID: previous, Time: time.Now()})
+	if len(entries) > maxTagHistory {
+		entries = entries[len(entries)-maxTagHistory:]
+	}
+	history[tag] = entries
+	return s.saveTagHistory(history)
+}
+
+// 将标签回滚到上一次重新打标签之前指向的镜像
+func (s *server) handleRollback(w http.ResponseWriter, r *http.Request) {
+	tag := r.URL.Query().Get("tag")
+	if tag == "" {
+		writeJSON(w, http.StatusBadRequest, uploadResult{Error: "缺少 tag 参数"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	history, err := s.loadTagHistory()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, uploadResult{Error: err.Error()})
+		return
+	}
+
+	entries := history[tag]
+	if len(entries) == 0 {
+		writeJSON(w, http.StatusNotFound, uploadResult{Error: fmt.Sprintf("标签 %s 没有可回滚的历史记录", tag)})
+		return
+	}
+	prev := entries[len(entries)-1]
+	current := dockerImageID(tag)
+
+	if err := dockerTag(prev.ImageID, tag); err != nil {
+		writeJSON(w, http.StatusInternalServerError, uploadResult{Error: err.Error()})
+		return
+	}
+
+	history[tag] = entries[:len(entries)-1]
+	if err := s.saveTagHistory(history); err != nil {
+		writeJSON(w, http.StatusInternalServerError, uploadResult{Error: err.Error()})
+		return
+	}
+
+	fmt.Printf("⏪ 已回滚标签 %s: %s -> %s\n", tag, shortImageID(current), shortImageID(prev.ImageID))
+	writeJSON(w, http.StatusOK, map[string]any{
+		"ok":       true,
+		"tag":      tag,
+		"image_id": prev.ImageID,
+		"replaced": current,
+	})
+}
+
+// 截短镜像 ID 便于显示
+func shortImageID(id string) string {
+	if len(id) > 19 {
+		return id[:19]
+	}
+	return id
+}
+
+// rollback 子命令：让服务端把标签指回上一次加载前的镜像
+func runRollback(args []string) {
+	var opts options
+	fs := newCommandFlags("rollback")
+	fs.StringVar(&opts.serverURL, "url", "", "服务端地址 (必须)")
+	registerClientFlags(fs, &opts)
+	fs.Parse(args)
+
+	if opts.serverURL == "" || fs.NArg() != 1 {
+		fmt.Println("用法: docker_save_shell rollback -url URL <tag>")
+		os.Exit(1)
+	}
+
+	client, closeClient := mustRemoteClient(opts)
+	err := rollback(client, opts.serverURL, fs.Arg(0))
+	closeClient()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func rollback(client *http.Client, serverURL, tag string) error {
+	endpoint, err := url.JoinPath(serverURL, "rollback")
+	if err != nil {
+		return fmt.Errorf("服务端地址格式错误: %w", err)
+	}
+
+	resp, err := client.Post(endpoint+"?tag="+url.QueryEscape(tag), "", nil)
+	if err != nil {
+		return fmt.Errorf("请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("回滚失败: %s", responseError(resp))
+	}
+
+	var result struct {
+		ImageID  string `json:"image_id"`
+		Replaced string `json:"replaced"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("解析响应失败: %w", err)
+	}
+	fmt.Printf("⏪ 标签 %s 已回滚: %s -> %s\n", tag, shortImageID(result.Replaced), shortImageID(result.ImageID))
+	return nil
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -url s3://bucket/key 直接以 S3 分段上传写入对象存储 (AWS S3、MinIO 等兼容实现)，不需要接收服务端。
+// 凭据取自 AWS_ACCESS_KEY_ID、AWS_SECRET_ACCESS_KEY 和可选的 AWS_SESSION_TOKEN
+const s3Scheme = "s3://"
+
+const (
+	// S3 要求除最后一块外每块不小于 5 MiB，一次上传最多 10000 块
+	minS3PartSize = 5 << 20
+	maxS3Parts    = 10000
+)
+
+// s3Options 对象存储的地址和区域
+type s3Options struct {
+	endpoint string // MinIO 等兼容实现的地址，为空时使用 AWS 的区域地址
+	region   string
+}
+
+func isS3URL(raw string) bool {
+	return strings.HasPrefix(raw, s3Scheme)
+}
+
+// s3Target 一次上传的目标对象
+type s3Target struct {
+	endpoint  *url.URL
+	pathStyle bool // 以 <地址>/<bucket>/<key> 访问，MinIO 等自建服务通常不支持虚拟主机方式
+	region    string
+	bucket    string
+	key       string
+
+	accessKey, secretKey, sessionToken string
+}
+
+// 检查 s3:// 上传的参数；依赖本工具服务端的功能无法使用
+func checkS3Options(opts options) error {
+	switch {
+	case opts.preset != "":
+		return errors.New("s3:// 地址不能与 -preset 同时使用")
+	case opts.tus || opts.parallelChunks > 0:
+		return errors.New("s3:// 地址使用 S3 分段上传，不能与 -tus、-parallel-chunks 同时使用")
+	case opts.remoteLoad || opts.remoteTag != "" || opts.smoke != "":
+		return errors.New("s3:// 地址不支持 -remote-load/-remote-tag/-smoke")
+	case opts.extractTo != "" || opts.sums || opts.sumsKey != "":
+		return errors.New("s3:// 地址不支持 -extract-to、-sums")
+	case len(opts.labels) > 0:
+		return errors.New("s3:// 地址不支持 -label")
+	case opts.zstdDict:
+		return errors.New("-zstd-dict 只能用于本工具的服务端")
+	case opts.token != "" || opts.basicAuth != "" || opts.negotiate:
+		return errors.New("s3:// 地址以 AWS 签名认证，不能与 -token、-basic-auth、-negotiate 同时使用")
+	case opts.preflight:
+		return errors.New("s3:// 地址不支持 -preflight")
+	case opts.form.custom():
+		return errors.New("s3:// 地址不以 multipart 表单发送，不支持 -form、-field-name")
+	}
+	if opts.partSize < minS3PartSize {
+		return fmt.Errorf("S3 分段上传的 -part-size 不能小于 %s", formatBytes(minS3PartSize))
+	}
+	_, err := newS3Target(opts, "")
+	return err
+}
+
+// 由 -url、-s3-endpoint、-s3-region 和环境变量确定上传目标。
+// key 为空或以 / 结尾时视为目录，在其后拼接文件名 (指定了版本化制品时为 <name>/<version>/<文件名>)
+func newS3Target(opts options, fileName string) (*s3Target, error) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(opts.serverURL, s3Scheme), "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("S3 地址格式错误: %s (应为 s3://bucket/key)", opts.serverURL)
+	}
+	if key == "" || strings.HasSuffix(key, "/") {
+		if opts.artifactName != "" {
+			key += opts.artifactName + "/" + opts.artifactVersion + "/"
+		}
+		key += fileName
+	}
+
+	t := &s3Target{
+		bucket:       bucket,
+		key:          key,
+		region:       opts.s3.region,
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if t.accessKey == "" || t.secretKey == "" {
+		return nil, errors.New("s3:// 上传需要设置 AWS_ACCESS_KEY_ID 和 AWS_SECRET_ACCESS_KEY")
+	}
+	if t.region == "" {
+		t.region = cmp.Or(os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1")
+	}
+	endpoint := cmp.Or(opts.s3.endpoint, os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_ENDPOINT_URL"))
+	if endpoint == "" {
+		endpoint = "https://s3." + t.region + ".amazonaws.com"
+	} else {
+		t.pathStyle = true
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("S3 地址格式错误: %s", endpoint)
+	}
+	t.endpoint = u
+	return t, nil
+}
+
+// 对象 (query 为空时) 或其子资源的地址
+func (t *s3Target) objectURL(query url.Values) *url.URL {
+	u := *t.endpoint
+	p := strings.TrimSuffix(u.Path, "/") + "/" + t.key
+	if t.pathStyle {
+		p = strings.TrimSuffix(u.Path, "/") + "/" + t.bucket + "/" + t.key
+	} else {
+		u.Host = t.bucket + "." + u.Host
+	}
+	u.Path = p
+	u.RawPath = s3Escape(p, false)
+	u.RawQuery = s3Query(query)
+	return &u
+}
+
+func (t *s3Target) String() string {
+	return s3Scheme + t.bucket + "/" + t.key
+}
+
+// 创建签名的请求，请求体的 SHA-256 参与签名
+func (t *s3Target) request(ctx context.Context, client *sessionClient, method string, query url.Values, body []byte, contentType string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, t.objectURL(query).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	sum := sha256.Sum256(body)
+	t.sign(req, hex.EncodeToString(sum[:]), client.clock.now())
+	return req, nil
+}
+
+// AWS Signature Version 4 签名，见 https://docs.aws.amazon.com/IAM/latest/UserGuide/reference_sigv-create-signed-request.html
+func (t *s3Target) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if t.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", t.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-amz-") || lk == "content-type" {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + t.region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := hmacSHA256([]byte("AWS4"+t.secretKey), day)
+	for _, part := range []string{t.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", t.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// 按 SigV4 的规则转义：只保留 A-Z a-z 0-9 - _ . ~，encodeSlash 为 false 时保留路径中的 /
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// 按参数名排序的规范查询串
+func s3Query(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// 发送请求，非 2xx 时解析 S3 的 XML 错误
+func (t *s3Target) do(client *sessionClient, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, s3ResponseError(resp, data, client.clock)
+	}
+	// CompleteMultipartUpload 在开始处理后即返回 200，失败时在响应体中给出错误
+	if bytes.Contains(data, []byte("<Error>")) {
+		return nil, s3ResponseError(resp, data, client.clock)
+	}
+	return data, nil
+}
+
+func s3ResponseError(resp *http.Response, data []byte, clock *clockSync) error {
+	var e struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	serr := &statusError{code: resp.StatusCode, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	if resp.StatusCode == http.StatusForbidden {
+		serr.hint = clock.authHint()
+	}
+	if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+		return fmt.Errorf("S3 %s: %s: %w", e.Code, e.Message, serr)
+	}
+	return serr
+}
+
+// 以 S3 分段上传发送 r 中的数据：每读满一块即上传，块失败后单独重试，最后提交。
+// 每块的 SHA-256 参与签名，由 S3 校验内容；上传失败时放弃分段上传，避免残留的块占用存储
+func s3Upload(ctx context.Context, client *sessionClient, opts options, r io.Reader, size int64, fileName, contentType string, rec *transferRecord) error {
+	t, err := newS3Target(opts, fileName)
+	if err != nil {
+		return err
+	}
+	partSize := int64(opts.partSize)
+	if size > 0 && (size+partSize-1)/partSize > maxS3Parts {
+		partSize = (size + maxS3Parts - 1) / maxS3Parts
+	}
+
+	fmt.Printf("\n🪣 S3 分段上传: %s (%s，每块 %s)\n", t, t.endpoint.Host, formatBytes(partSize))
+	query := url.Values{"uploads": {""}}
+	req, err := t.request(ctx, client, "POST", query, nil, contentType)
+	if err != nil {
+		return err
+	}
+	data, err := t.do(client, req)
+	if err != nil {
+		return fmt.Errorf("创建分段上传失败: %w", err)
+	}
+	var created struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(data, &created); err != nil || created.UploadID == "" {
+		return fmt.Errorf("解析分段上传 ID 失败: %s", data)
+	}
+	uploadID := created.UploadID
+
+	type completedPart struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+	var parts []completedPart
+	var sent int64
+	start := time.Now()
+	rec.attempted = true
+	buf := make([]byte, partSize)
+	for number := 1; ; number++ {
+		n, rerr := io.ReadFull(r, buf)
+		if rerr != nil && rerr != io.ErrUnexpectedEOF && rerr != io.EOF {
+			t.abort(client, uploadID)
+			return windowError(ctx, fmt.Errorf("读取文件失败: %w", rerr))
+		}
+		// 空文件也要上传一块
+		if n == 0 && number > 1 {
+			break
+		}
+		if number > maxS3Parts {
+			t.abort(client, uploadID)
+			return fmt.Errorf("超过 S3 分段上传的块数上限 %d，请增大 -part-size", maxS3Parts)
+		}
+		etag, err := t.uploadPart(ctx, client, opts, uploadID, number, buf[:n])
+		if err != nil {
+			t.abort(client, uploadID)
+			return windowError(ctx, fmt.Errorf("块 %d 上传失败: %w", number, err))
+		}
+		parts = append(parts, completedPart{PartNumber: number, ETag: etag})
+		sent += int64(n)
+		if rerr != nil {
+			break
+		}
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	req, err = t.request(ctx, client, "POST", url.Values{"uploadId": {uploadID}}, body, "application/xml")
+	if err != nil {
+		return err
+	}
+	data, err = t.do(client, req)
+	if err != nil {
+		t.abort(client, uploadID)
+		return fmt.Errorf("提交分段上传失败: %w", err)
+	}
+	var result struct {
+		ETag string `xml:"ETag"`
+	}
+	xml.Unmarshal(data, &result)
+	rec.Bytes, rec.Duration = sent, time.Since(start).Seconds()
+	fmt.Printf("\n✅ 已上传到 %s (%d 块，ETag %s)\n", t, len(parts), result.ETag)
+	return nil
+}
+
+// 上传一块并返回 ETag，失败时从重试预算中申领重试
+func (t *s3Target) uploadPart(ctx context.Context, client *sessionClient, opts options, uploadID string, number int, data []byte) (string, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+	for attempt := 1; ; attempt++ {
+		req, err := t.request(ctx, client, "PUT", query, data, "")
+		if err != nil {
+			return "", err
+		}
+		resp, err := client.Do(req)
+		if err == nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return resp.Header.Get("ETag"), nil
+			}
+			err = &statusError{code: resp.StatusCode, hint: client.clock.authHint()}
+		}
+		if !retryableError(ctx, err) || attempt >= maxPartAttempts {
+			return "", err
+		}
+		delay := min(time.Second<<(attempt-1), 30*time.Second)
+		if berr := opts.retry.take("chunk", delay); berr != nil {
+			return "", fmt.Errorf("%w (%v)", err, berr)
+		}
+		fmt.Printf("\n🔁 块 %d 上传失败，%s 后重试: %v\n", number, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// 放弃分段上传，释放已上传的块
+func (t *s3Target) abort(client *sessionClient, uploadID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := t.request(ctx, client, "DELETE", url.Values{"uploadId": {uploadID}}, nil, "")
+	if err != nil {
+		return
+	}
+	if resp, err := client.Do(req); err == nil {
+		resp.Body.Close()
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// 本机 docker 镜像作为数据源的前缀，例如 docker-daemon:nginx:1.25，多个镜像以逗号分隔
+const imageSourcePrefix = "docker-daemon:"
+
+// 判断 --file 是否为本机 docker 镜像
+func isImageSource(p string) bool {
+	return strings.HasPrefix(p, imageSourcePrefix)
+}
+
+// save 子命令：docker save 导出镜像并直接上传，导出的 tar 不落盘
+func runSave(args []string, cfg *Config) {
+	var opts options
+	fs := newCommandFlags("save")
+	registerUploadFlags(fs, &opts)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: docker_save_shell save <镜像>... -url <地址> [上传参数]")
+		fmt.Fprintln(fs.Output(), "导出一个或多个本机镜像 (同 docker save) 并边导出边上传，不生成临时文件")
+		fs.PrintDefaults()
+	}
+	images := parseInterspersed(fs, args)
+	if err := applyConfig(fs, &opts, cfg); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if opts.filePath != "" {
+		fmt.Println("错误：save 上传的是镜像，不能同时指定 -file")
+		os.Exit(1)
+	}
+	if len(images) == 0 || opts.serverURL == "" {
+		fmt.Println("错误：缺少要导出的镜像或 -url")
+		fs.Usage()
+		os.Exit(1)
+	}
+	opts.filePath = imageSourcePrefix + strings.Join(images, ",")
+	runUpload(opts)
+}
+
+// 解析参数，允许选项出现在位置参数之后 (如 save nginx:1.25 -url ...)，返回位置参数
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		if args[0] == "--" {
+			return append(positional, args[1:]...)
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// docker-daemon: 数据源中的镜像
+func imageRefs(p string) []string {
+	return strings.Split(strings.TrimPrefix(p, imageSourcePrefix), ",")
+}
+
+// 导出前确认镜像都存在并输出大小和层数，避免 docker save 中途才失败；
+// pull 为 true 时先拉取不存在的镜像
+func checkImages(docker dockerEndpoint, refs []string, pull bool) error {
+	var total int64
+	for _, ref := range refs {
+		if ref == "" || strings.HasPrefix(ref, "-") {
+			return fmt.Errorf("镜像名称无效: %q", ref)
+		}
+		img, err := docker.inspectImage(ref)
+		if isNoSuchImage(err) && pull {
+			fmt.Printf("⬇️  拉取镜像: %s\n", ref)
+			if err := docker.pull(ref); err != nil {
+				return err
+			}
+			img, err = docker.inspectImage(ref)
+		}
+		if isNoSuchImage(err) {
+			where := "本机"
+			if d := docker.String(); d != "" {
+				where = "docker 守护进程 " + d + " 上"
+			}
+			return fmt.Errorf("%s不存在镜像 %s (指定 -pull 可自动拉取)", where, ref)
+		}
+		if err != nil {
+			return err
+		}
+		total += img.Size
+		fmt.Printf("🐳 %s: %s，%d 层\n", ref, formatBytes(img.Size), img.Layers)
+	}
+	if len(refs) > 1 {
+		fmt.Printf("🐳 %d 个镜像合计 %s (共享的层只导出一次)\n", len(refs), formatBytes(total))
+	}
+	return nil
+}
+
+// 以 docker save 的输出作为数据源，大小未知
+func openImageSource(ctx context.Context, p string, docker dockerEndpoint) (*source, error) {
+	images := imageRefs(p)
+	for _, ref := range images {
+		if ref == "" || strings.HasPrefix(ref, "-") {
+			return nil, fmt.Errorf("镜像名称无效: %q", ref)
+		}
+	}
+
+	cmd := docker.command(ctx, append([]string{"save"}, images...)...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	r := &imageReader{cmd: cmd, stdout: stdout}
+	cmd.Stderr = &r.stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("执行 docker save 失败: %w", err)
+	}
+	if d := docker.String(); d != "" {
+		fmt.Printf("🐳 导出镜像: %s (守护进程 %s)\n", strings.Join(images, " "), d)
+	} else {
+		fmt.Printf("🐳 导出镜像: %s\n", strings.Join(images, " "))
+	}
+	return &source{ReadCloser: r, name: imageFileName(images), size: -1}, nil
+}
+
+// 由镜像名生成上传的文件名，例如 library/nginx:1.25 → nginx_1.25.tar
+func imageFileName(images []string) string {
+	names := make([]string, len(images))
+	for i, ref := range images {
+		if j := strings.LastIndexByte(ref, '/'); j >= 0 {
+			ref = ref[j+1:]
+		}
+		names[i] = strings.NewReplacer(":", "_", "@", "_").Replace(ref)
+	}
+	return strings.Join(names, "+") + ".tar"
+}
+
+// imageReader 读取 docker save 的输出；读到结尾时检查命令是否成功，
+// 避免导出中途失败时把不完整的 tar 当作完整文件上传
+type imageReader struct {
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	stderr bytes.Buffer
+	err    error // 命令结束后的结果
+	done   bool
+}
+
+func (r *imageReader) Read(p []byte) (int, error) {
+	n, err := r.stdout.Read(p)
+	if errors.Is(err, io.EOF) {
+		if werr := r.wait(); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (r *imageReader) wait() error {
+	if !r.done {
+		r.done = true
+		if err := r.cmd.Wait(); err != nil {
+			msg := strings.TrimSpace(r.stderr.String())
+			if msg == "" {
+				msg = err.Error()
+			}
+			r.err = fmt.Errorf("docker save 失败: %s", msg)
+		}
+	}
+	return r.err
+}
+
+// 提前关闭时结束 docker save 进程
+func (r *imageReader) Close() error {
+	if !r.done {
+		r.cmd.Process.Kill()
+		r.wait()
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errScrubRunning 上一次巡检尚未结束
+var errScrubRunning = errors.New("巡检正在进行")
+
+// scrubItem 校验未通过的制品
+type scrubItem struct {
+	Artifact string `json:"artifact"`
+	Version  string `json:"version"`
+	Path     string `json:"path"`
+	Problem  string `json:"problem"` // corrupt (摘要不符) / missing (文件丢失) / unreadable
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+// scrubReport 一次巡检的结果
+type scrubReport struct {
+	Started  time.Time   `json:"started"`
+	Duration string      `json:"duration"`
+	Checked  int         `json:"checked"`
+	Bytes    int64       `json:"bytes"`
+	Failed   []scrubItem `json:"failed"`
+}
+
+// scrubMetrics 服务启动以来的巡检统计，通过 GET /scrub 查询；最近一次结果同时保存在元数据目录，重启后仍可查询
+type scrubMetrics struct {
+	mu           sync.Mutex
+	running      bool
+	Runs         int          `json:"runs"`
+	CheckedBytes int64        `json:"checked_bytes"`
+	Last         *scrubReport `json:"last,omitempty"`
+}
+
+// 最近一次巡检结果的保存路径
+func (s *server) scrubReportPath() string {
+	return filepath.Join(serverMetaDir(s.opts.dir), "scrub.json")
+}
+
+// 重新计算每个制品文件的 SHA-256 并与上传时记录的摘要比较，发现静默损坏 (bit-rot) 和丢失的文件。
+// 读取速度受 -scrub-rate 限制，避免巡检挤占上传和下载的磁盘带宽
+func (s *server) scrub(ctx context.Context) (*scrubReport, error) {
+	s.scrubStats.mu.Lock()
+	if s.scrubStats.running {
+		s.scrubStats.mu.Unlock()
+		return nil, errScrubRunning
+	}
+	s.scrubStats.running = true
+	s.scrubStats.mu.Unlock()
+	defer func() {
+		s.scrubStats.mu.Lock()
+		s.scrubStats.running = false
+		s.scrubStats.mu.Unlock()
+	}()
+
+	report := &scrubReport{Started: time.Now(), Failed: []scrubItem{}}
+	artifacts, err := s.allArtifacts()
+	if err != nil {
+		return nil, err
+	}
+	for _, meta := range artifacts {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if meta.SHA256 == "" {
+			continue
+		}
+		item := scrubItem{Artifact: meta.Name, Version: meta.Version, Path: s.artifactFilePath(meta), Expected: meta.SHA256}
+		sum, n, err := s.hashStored(ctx, item.Path)
+		report.Checked++
+		report.Bytes += n
+		switch {
+		case os.IsNotExist(err):
+			item.Problem = "missing"
+		case err != nil:
+			item.Problem, item.Actual = "unreadable", err.Error()
+		case !strings.EqualFold(sum, meta.SHA256):
+			item.Problem, item.Actual = "corrupt", sum
+		default:
+			continue
+		}
+		report.Failed = append(report.Failed, item)
+		s.logEvent(severityError, "scrub_failed", fmt.Sprintf("巡检发现制品异常 (%s): %s@%s", item.Problem, meta.Name, meta.Version),
+			map[string]string{"artifact": meta.Name, "version": meta.Version, "problem": item.Problem})
+	}
+	report.Duration = time.Since(report.Started).Round(time.Millisecond).String()
+
+	s.scrubStats.mu.Lock()
+	s.scrubStats.Runs++
+	s.scrubStats.CheckedBytes += report.Bytes
+	s.scrubStats.Last = report
+	s.scrubStats.mu.Unlock()
+	if err := os.MkdirAll(serverMetaDir(s.opts.dir), 0o700); err == nil {
+		writeFileAtomic(s.scrubReportPath(), report)
+	}
+	return report, nil
+}
+
+func (s *server) hashStored(ctx context.Context, path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if s.opts.scrubRate > 0 {
+		r = newLimitedReader(ctx, f, int64(s.opts.scrubRate))
+	}
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return "", n, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// 读取上次保存的巡检结果，使重启后 GET /scrub 仍能反映已发现的损坏
+func (s *server) loadScrubReport() {
+	data, err := os.ReadFile(s.scrubReportPath())
+	if err != nil {
+		return
+	}
+	report := &scrubReport{}
+	if json.Unmarshal(data, report) == nil {
+		s.scrubStats.Last = report
+	}
+}
+
+// 按固定间隔在后台执行巡检
+func (s *server) scrubLoop() {
+	ticker := time.NewTicker(s.opts.scrubInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		report, err := s.scrub(context.Background())
+		if err != nil {
+			fmt.Printf("⚠️  巡检失败: %v\n", err)
+			continue
+		}
+		printScrubReport(report)
+	}
+}
+
+// 输出巡检结果
+func printScrubReport(report *scrubReport) {
+	if len(report.Failed) == 0 {
+		fmt.Printf("🩺 巡检完成: %d 个制品 (%s) 全部完好，用时 %s\n", report.Checked, formatBytes(report.Bytes), report.Duration)
+		return
+	}
+	fmt.Printf("🩺 巡检完成: %d 个制品中 %d 个异常，用时 %s\n", report.Checked, len(report.Failed), report.Duration)
+	for _, item := range report.Failed {
+		fmt.Printf("   ❌ [%s] %s@%s (%s)\n", item.Problem, item.Artifact, item.Version, item.Path)
+	}
+}
+
+// 查询巡检统计；?format=prometheus 时以 Prometheus 文本格式输出，可直接配置为抓取目标
+func (s *server) handleScrubStats(w http.ResponseWriter, r *http.Request) {
+	s.scrubStats.mu.Lock()
+	defer s.scrubStats.mu.Unlock()
+	if r.URL.Query().Get("format") != "prometheus" {
+		writeJSON(w, http.StatusOK, &s.scrubStats)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# TYPE dss_scrub_runs_total counter\ndss_scrub_runs_total %d\n", s.scrubStats.Runs)
+	fmt.Fprintf(w, "# TYPE dss_scrub_checked_bytes_total counter\ndss_scrub_checked_bytes_total %d\n", s.scrubStats.CheckedBytes)
+	if last := s.scrubStats.Last; last != nil {
+		fmt.Fprintf(w, "# TYPE dss_scrub_last_run_timestamp_seconds gauge\ndss_scrub_last_run_timestamp_seconds %d\n", last.Started.Unix())
+		fmt.Fprintf(w, "# TYPE dss_scrub_last_checked_artifacts gauge\ndss_scrub_last_checked_artifacts %d\n", last.Checked)
+		counts := map[string]int{"corrupt": 0, "missing": 0, "unreadable": 0}
+		for _, item := range last.Failed {
+			counts[item.Problem]++
+		}
+		fmt.Fprintln(w, "# TYPE dss_scrub_failed_artifacts gauge")
+		for _, problem := range []string{"corrupt", "missing", "unreadable"} {
+			fmt.Fprintf(w, "dss_scrub_failed_artifacts{problem=%q} %d\n", problem, counts[problem])
+		}
+	}
+}
+
+// 立即执行一次巡检并返回结果
+func (s *server) handleScrub(w http.ResponseWriter, r *http.Request) {
+	report, err := s.scrub(r.Context())
+	if errors.Is(err, errScrubRunning) {
+		writeJSON(w, http.StatusConflict, uploadResult{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, uploadResult{Error: err.Error()})
+		return
+	}
+	printScrubReport(report)
+	writeJSON(w, http.StatusOK, report)
+}
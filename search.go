@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 每页默认条数和上限
+const (
+	defaultPerPage = 50
+	maxPerPage     = 500
+)
+
+// searchResult 搜索结果的一页
+type searchResult struct {
+	Total   int             `json:"total"`
+	Page    int             `json:"page"`
+	PerPage int             `json:"per_page"`
+	Items   []*artifactMeta `json:"items"`
+}
+
+// 上传者标识：令牌的标签 (没有标签时为令牌 ID)，未启用鉴权时为客户端地址
+func (s *server) uploader(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		tokens, _, _ := loadTokens(s.opts.dir)
+		hash := hashToken(strings.TrimSpace(token))
+		for _, t := range tokens {
+			if t.Hash == hash {
+				if t.Label != "" {
+					return t.Label
+				}
+				return t.ID
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// 解析日期参数，支持 2006-01-02 和 RFC3339 两种格式；until 只给日期时包含当天
+func parseSearchTime(v string, endOfDay bool) (time.Time, error) {
+	if t, err := time.ParseInLocation(time.DateOnly, v, time.Local); err == nil {
+		if endOfDay {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// 按条件搜索制品版本:
+// name (支持通配)、tag (归档内的镜像标签，支持通配)、digest (文件摘要、树形摘要、镜像 ID 或层摘要的前缀)、
+// uploader、since/until、label (key=value 或 key，可重复，需全部满足)，以及 page/per_page 分页
+func (s *server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	var since, until time.Time
+	var err error
+	if v := q.Get("since"); v != "" {
+		if since, err = parseSearchTime(v, false); err != nil {
+			writeJSON(w, http.StatusBadRequest, uploadResult{Error: "since 参数格式错误: " + v})
+			return
+		}
+	}
+	if v := q.Get("until"); v != "" {
+		if until, err = parseSearchTime(v, true); err != nil {
+			writeJSON(w, http.StatusBadRequest, uploadResult{Error: "until 参数格式错误: " + v})
+			return
+		}
+	}
+
+	page, perPage := 1, defaultPerPage
+	if v := q.Get("page"); v != "" {
+		if page, err = strconv.Atoi(v); err != nil || page < 1 {
+			writeJSON(w, http.StatusBadRequest, uploadResult{Error: "page 参数错误"})
+			return
+		}
+	}
+	if v := q.Get("per_page"); v != "" {
+		if perPage, err = strconv.Atoi(v); err != nil || perPage < 1 {
+			writeJSON(w, http.StatusBadRequest, uploadResult{Error: "per_page 参数错误"})
+			return
+		}
+		perPage = min(perPage, maxPerPage)
+	}
+
+	all, err := s.allArtifacts()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, uploadResult{Error: err.Error()})
+		return
+	}
+
+	matched := []*artifactMeta{}
+	for _, meta := range all {
+		if name := q.Get("name"); name != "" && !globMatch(name, meta.Name) {
+			continue
+		}
+		if uploader := q.Get("uploader"); uploader != "" && meta.Uploader != uploader {
+			continue
+		}
+		if !since.IsZero() && meta.Created.Before(since) {
+			continue
+		}
+		if !until.IsZero() && !meta.Created.Before(until) {
+			continue
+		}
+		if !matchLabels(meta.Labels, q["label"]) {
+			continue
+		}
+		if tag := q.Get("tag"); tag != "" && !s.artifactHasImage(meta, tag) {
+			continue
+		}
+		if digest := q.Get("digest"); digest != "" && !s.artifactHasDigest(meta, digest) {
+			continue
+		}
+		matched = append(matched, meta)
+	}
+
+	result := searchResult{Total: len(matched), Page: page, PerPage: perPage, Items: []*artifactMeta{}}
+	if start := (page - 1) * perPage; start < len(matched) {
+		result.Items = matched[start:min(start+perPage, len(matched))]
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// 判断元数据标签是否满足所有条件，条件为 key=value 或只有 key (要求存在)
+func matchLabels(labels map[string]string, conds []string) bool {
+	for _, c := range conds {
+		k, v, hasValue := strings.Cut(c, "=")
+		got, ok := labels[k]
+		if !ok || (hasValue && got != v) {
+			return false
+		}
+	}
+	return true
+}
+
+// 判断制品归档中是否包含标签匹配的镜像
+func (s *server) artifactHasImage(meta *artifactMeta, tag string) bool {
+	contents, err := s.fileContents(s.artifactFilePath(meta))
+	if err != nil {
+		return false
+	}
+	for _, img := range contents.Images {
+		for _, t := range img.Tags {
+			if globMatch(tag, t) || globMatch(tag, strings.TrimPrefix(t, "docker.io/")) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 判断摘要前缀是否匹配制品的文件摘要、树形摘要或归档内的镜像 ID、层摘要
+func (s *server) artifactHasDigest(meta *artifactMeta, digest string) bool {
+	digest = strings.ToLower(digest)
+	for _, d := range []string{meta.SHA256, "sha256:" + meta.SHA256, meta.Tree, strings.TrimPrefix(meta.Tree, treeHashPrefix)} {
+		if d != "" && d != "sha256:" && strings.HasPrefix(d, digest) {
+			return true
+		}
+	}
+	contents, err := s.fileContents(s.artifactFilePath(meta))
+	if err != nil {
+		return false
+	}
+	for _, img := range contents.Images {
+		if strings.HasPrefix(img.ID, digest) || strings.HasPrefix(img.ID, "sha256:"+digest) {
+			return true
+		}
+		for _, l := range img.Layers {
+			if strings.HasPrefix(l.Digest, digest) || strings.HasPrefix(l.Digest, "sha256:"+digest) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// search 子命令：按条件搜索服务端的制品
+func runSearch(args []string) {
+	var opts options
+	var labels stringList
+	q := url.Values{}
+	fs := newCommandFlags("search")
+	fs.StringVar(&opts.serverURL, "url", "", "服务端地址 (必须)")
+	name := fs.String("name", "", "制品名称，支持 * 和 ? 通配")
+	tag := fs.String("tag", "", "归档内的镜像标签，例如 nginx:1.25，支持通配")
+	digest := fs.String("digest", "", "文件摘要、制品 ID、镜像 ID 或层摘要 (可只写前缀)")
+	uploader := fs.String("uploader", "", "上传者 (令牌标签)")
+	since := fs.String("since", "", "起始日期，如 2024-01-02 或 RFC3339 时间")
+	until := fs.String("until", "", "截止日期 (包含当天)")
+	fs.Var(&labels, "label", "元数据标签 key=value 或 key，可重复指定")
+	page := fs.Int("page", 1, "页码")
+	perPage := fs.Int("per-page", defaultPerPage, "每页条数")
+	registerClientFlags(fs, &opts)
+	fs.Parse(args)
+
+	if opts.serverURL == "" {
+		fmt.Println("错误：缺少必要参数 -url")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	for k, v := range map[string]string{"name": *name, "tag": *tag, "digest": *digest, "uploader": *uploader, "since": *since, "until": *until} {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	for _, l := range labels {
+		q.Add("label", l)
+	}
+	q.Set("page", strconv.Itoa(*page))
+	q.Set("per_page", strconv.Itoa(*perPage))
+
+	client, closeClient := mustRemoteClient(opts)
+	err := search(client, opts.serverURL, q)
+	closeClient()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func search(client *http.Client, serverURL string, q url.Values) error {
+	endpoint, err := url.JoinPath(serverURL, "search")
+	if err != nil {
+		return fmt.Errorf("服务端地址格式错误: %w", err)
+	}
+
+	resp, err := client.Get(endpoint + "?" + q.Encode())
+	if err != nil {
+		return fmt.Errorf("请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("搜索失败: %s", responseError(resp))
+	}
+
+	var result searchResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("解析响应失败: %w", err)
+	}
+	if result.Total == 0 {
+		fmt.Println("没有匹配的制品")
+		return nil
+	}
+
+	printArtifacts(result.Items)
+	pages := (result.Total + result.PerPage - 1) / result.PerPage
+	fmt.Printf("\n第 %d/%d 页，共 %d 个制品\n", result.Page, pages, result.Total)
+	return nil
+}
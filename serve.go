@@ -0,0 +1,909 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// 普通表单字段的最大长度
+const maxFormFieldSize = 64 * 1024
+
+// 服务端选项
+type serveOptions struct {
+	listen            string
+	dir               string
+	storeDecompressed bool
+	decryptKey        string // 解密客户端 -encrypt-key 上传的数据所用的密钥文件
+	allowLoad         bool
+	autoLoad          bool // 收到镜像归档后总是 docker load，不需要客户端请求
+	allowSmoke        bool
+	smokeTimeout      time.Duration
+	treeHash          bool // 客户端未要求时也计算树形摘要
+	verifyWorkers     int
+	minFreeSpace      byteSize // 低于该剩余空间时 /readyz 返回未就绪
+	stateStore        string   // 共享状态存储: file 或 redis://
+	extractRoot       string   // 目录归档的解包根目录，为空时不允许解包
+	hooks             string   // 钩子配置文件
+	logSink           string   // 结构化日志输出: syslog+udp/tcp/tls:// 或 eventlog
+	tlsCert           string   // 服务端证书，与 tlsKey 同时指定时启用 HTTPS
+	tlsKey            string
+	mdns              bool   // 在局域网内通过 mDNS 通告本服务
+	mdnsName          string // mDNS 服务实例名，默认为主机名
+
+	// 垃圾回收
+	gcInterval  time.Duration
+	gcDryRun    bool
+	gcUploadTTL time.Duration
+	artifactTTL time.Duration
+
+	// 接收方向的带宽上限 (字节/秒)，0 表示不限
+	limitRate        byteSize
+	limitRatePerConn byteSize
+
+	// 外部校验命令，为空时不执行
+	verifyCmd     string
+	verifyTimeout time.Duration
+
+	// 制品完整性巡检
+	scrubInterval time.Duration
+	scrubRate     byteSize
+
+	// 每个分块上传同时写入的块数上限，存储写入缓慢时自动减小
+	partWindow int
+	// 分块上传超过该时长没有收到块或心跳即视为客户端已退出
+	uploadIdleTimeout time.Duration
+
+	// 同名文件已存在时的默认处理方式，客户端可按上传指定
+	ifExists string
+}
+
+// server 接收本工具上传文件的服务端
+type server struct {
+	opts serveOptions
+	mu   sync.Mutex // 保护镜像标签历史
+
+	extractMu sync.Mutex // 串行执行解包和目录替换
+
+	store  stateStore
+	hooks  *hookConfig
+	logs   logSink // 未配置 -log-sink 时为 nil
+	status statusTracker
+	gc     gcMetrics
+
+	scrubStats scrubMetrics
+	parts      partSessions  // 进行中的分块上传
+	requests   imageRequests // 接收方登记的镜像请求
+
+	platformOnce sync.Once
+	platformName string // 通告给客户端的平台，见 platform()
+
+	decryptKey []byte // 由 -decrypt-key 读取，为 nil 时加密的上传按密文保存
+}
+
+// serve 子命令：启动接收服务
+func runServe(args []string) {
+	if len(args) > 0 && args[0] == "token" {
+		runToken(args[1:])
+		return
+	}
+	if len(args) > 0 && args[0] == "gc" {
+		runGC(args[1:])
+		return
+	}
+
+	var opts serveOptions
+	var initContainer bool
+	fs := newCommandFlags("serve")
+	fs.BoolVar(&initContainer, "init-container", false, "在当前目录生成部署本服务的 Dockerfile 和 compose.yaml (其他参数写入 compose 的环境变量) 后退出")
+	fs.StringVar(&opts.listen, "listen", ":8080", "监听地址")
+	fs.StringVar(&opts.dir, "dir", "./data", "文件存储目录")
+	fs.BoolVar(&opts.storeDecompressed, "store-decompressed", false, "收到 gzip/zstd 压缩的文件时解压后再存储")
+	fs.StringVar(&opts.decryptKey, "decrypt-key", "", "收到客户端 -encrypt-key 加密的上传时用该密钥文件解密后再存储 (同时解压)，为空时只保存密文")
+	fs.BoolVar(&opts.allowLoad, "allow-load", false, "允许客户端请求在本机执行 docker load 并重新打标签")
+	fs.BoolVar(&opts.autoLoad, "auto-load", false, "收到的每个 docker 镜像归档都立即 docker load 到本机 (不需要客户端 -remote-load)，用于隔离网络主机上的镜像传输")
+	fs.BoolVar(&opts.allowSmoke, "allow-smoke", false, "允许客户端在加载的镜像中执行冒烟命令 (无网络的临时容器，需同时启用 --allow-load)")
+	fs.DurationVar(&opts.smokeTimeout, "smoke-timeout", 2*time.Minute, "冒烟命令的最长执行时间")
+	fs.BoolVar(&opts.treeHash, "tree-hash", false, "对每个上传都计算树形摘要并记录 (客户端提供 tree_sha256 时总会校验)")
+	fs.IntVar(&opts.verifyWorkers, "verify-workers", runtime.NumCPU(), "并行校验的协程数")
+	fs.StringVar(&opts.stateStore, "state-store", "file", "制品索引等共享状态的存储: file 或 redis://host:6379/0 (多副本部署时使用)")
+	fs.StringVar(&opts.hooks, "hooks", "", "钩子配置文件 (YAML)，文件保存后执行 post_receive 中匹配的动作")
+	fs.StringVar(&opts.logSink, "log-sink", "", "将接收、删除、加载等事件发送到集中日志: syslog+udp://host:514、syslog+tcp://、syslog+tls://host:6514 或 eventlog (Windows)")
+	fs.StringVar(&opts.tlsCert, "tls-cert", "", "服务端证书 (PEM)，与 -tls-key 同时指定时启用 HTTPS")
+	fs.StringVar(&opts.tlsKey, "tls-key", "", "服务端证书私钥 (PEM)")
+	fs.BoolVar(&opts.mdns, "mdns", false, "通过 mDNS 在局域网内通告本服务，客户端可用 discover 发现")
+	fs.StringVar(&opts.mdnsName, "mdns-name", "", "mDNS 通告的服务名 (默认主机名)")
+	fs.StringVar(&opts.extractRoot, "extract-root", "", "允许客户端将目录归档解包到该目录下 (-extract-to)，为空时不允许")
+	fs.Var(&opts.minFreeSpace, "min-free-space", "存储目录剩余空间低于该值时 /readyz 返回未就绪，例如 10G (0 表示不检查)")
+	fs.DurationVar(&opts.gcInterval, "gc-interval", time.Hour, "后台垃圾回收的间隔 (0 表示不自动回收)")
+	fs.BoolVar(&opts.gcDryRun, "gc-dry-run", false, "后台垃圾回收只报告不删除")
+	registerGCFlags(fs, &opts)
+	fs.DurationVar(&opts.scrubInterval, "scrub-interval", 0, "后台巡检的间隔：重新计算制品的摘要并与上传时的记录比较，发现磁盘静默损坏 (0 表示不巡检，如 168h)")
+	fs.Var(&opts.limitRate, "limit-rate", "所有连接合计的接收速度上限 (每秒，如 20M)，与生产流量共用窄带链路时避免上传占满带宽 (0 表示不限)")
+	fs.Var(&opts.limitRatePerConn, "limit-rate-per-conn", "单个连接的接收速度上限 (每秒，如 5M)，避免一个客户端占用全部额度 (0 表示不限)")
+	fs.StringVar(&opts.verifyCmd, "verify-cmd", "", "接受上传前执行的外部校验命令 (如签名校验工具)，文件信息通过 DSS_FILE、DSS_NAME、DSS_SHA256 等环境变量传入，非 0 退出即拒绝")
+	fs.DurationVar(&opts.verifyTimeout, "verify-timeout", defaultHookTimeout, "外部校验命令的最长执行时间")
+	fs.IntVar(&opts.partWindow, "part-window", defaultPartWindow, "每个分块上传 (-parallel-chunks) 同时写入的块数上限，通告给客户端；存储写入缓慢时自动减小，恢复后逐步增大")
+	fs.DurationVar(&opts.uploadIdleTimeout, "upload-idle-timeout", defaultUploadIdleTimeout, "分块上传超过该时长没有收到块或客户端心跳即视为客户端已退出，立即删除已收到的块 (0 表示只按 -upload-ttl 回收)")
+	fs.StringVar(&opts.ifExists, "if-exists", ifExistsOverwrite, "上传的文件与已有文件同名时的默认处理方式: "+strings.Join(ifExistsPolicies, "、")+"，客户端可用 -if-exists 按上传指定 (默认不覆盖时，客户端要求覆盖需要 delete 权限)")
+	fs.Var(&opts.scrubRate, "scrub-rate", "巡检读取文件的速度上限 (每秒，如 50M)，避免影响正常的上传下载 (0 表示不限)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "用法: docker_save_shell serve [参数]\n所有参数都可以用环境变量 %s<参数名> 设置 (如 %s)，命令行优先\n", serveEnvPrefix, flagEnvName(serveEnvPrefix, "state-store"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if initContainer {
+		if err := writeContainerManifests(fs, &opts); err != nil {
+			fmt.Printf("生成部署文件失败: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("✅ 已生成 Dockerfile 和 compose.yaml，在仓库根目录执行 docker compose up -d 启动")
+		return
+	}
+	if err := applyEnvFlags(fs, serveEnvPrefix); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	// 容器平台通过 PORT 指定端口时沿用
+	if port := os.Getenv("PORT"); port != "" && !flagSet(fs, "listen") {
+		opts.listen = ":" + port
+	}
+
+	if opts.partWindow < 1 {
+		fmt.Printf("非法的 -part-window: %d\n", opts.partWindow)
+		os.Exit(1)
+	}
+	if err := validIfExists(opts.ifExists); err != nil {
+		fmt.Printf("-if-exists: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := os.MkdirAll(opts.dir, 0o755); err != nil {
+		fmt.Printf("创建存储目录失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	store, err := openStateStore(opts.stateStore, opts.dir)
+	if err != nil {
+		fmt.Printf("打开状态存储失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	s := &server{opts: opts, store: store}
+	if opts.decryptKey != "" {
+		if s.decryptKey, err = loadEncryptKey(opts.decryptKey); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	if opts.hooks != "" {
+		if s.hooks, err = loadHooks(opts.hooks); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	if opts.logSink != "" {
+		if s.logs, err = openLogSink(opts.logSink); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer s.logs.Close()
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.handleHealthz)
+	mux.HandleFunc("GET /readyz", s.handleReadyz)
+	mux.HandleFunc("POST /", s.authorized(scopeUpload, s.handleUpload))
+	mux.HandleFunc("GET /files", s.authorized(scopeList, s.handleList))
+	mux.HandleFunc("GET /files/{name}", s.authorized(scopeDownload, s.handleDownload))
+	mux.HandleFunc("DELETE /files/{name}", s.authorized(scopeDelete, s.handleDelete))
+	mux.HandleFunc("GET /artifacts", s.authorized(scopeList, s.handleListArtifacts))
+	mux.HandleFunc("GET /artifacts/{name}/{version}", s.authorized(scopeDownload, s.handleDownloadArtifact))
+	mux.HandleFunc("GET /files/{name}/tree", s.authorized(scopeList, s.handleTree))
+	mux.HandleFunc("PATCH /files/{name}", s.authorized(scopeDelete, s.handlePatchLeaf))
+	mux.HandleFunc("GET /artifacts/{name}/{version}/tree", s.authorized(scopeList, s.handleTree))
+	mux.HandleFunc("PATCH /artifacts/{name}/{version}", s.authorized(scopeDelete, s.handlePatchLeaf))
+	mux.HandleFunc("GET /contents", s.authorized(scopeList, s.handleListContents))
+	mux.HandleFunc("GET /files/{name}/contents", s.authorized(scopeList, s.handleContents))
+	mux.HandleFunc("GET /artifacts/{name}/{version}/contents", s.authorized(scopeList, s.handleContents))
+	mux.HandleFunc("GET /search", s.authorized(scopeList, s.handleSearch))
+	mux.HandleFunc("GET /gc", s.authorized(scopeList, s.handleGCStats))
+	mux.HandleFunc("POST /gc", s.authorized(scopeDelete, s.handleGC))
+	mux.HandleFunc("GET /ping", s.authorized(scopeUpload, s.handlePing))
+	mux.HandleFunc("POST /probe", s.authorized(scopeUpload, s.handleProbe))
+	mux.HandleFunc("POST /rollback", s.authorized(scopeLoad, s.handleRollback))
+	mux.HandleFunc("GET /status/{id}", s.authorized(scopeList, s.handleStatus))
+	mux.HandleFunc("GET /scrub", s.authorized(scopeList, s.handleScrubStats))
+	mux.HandleFunc("POST /scrub", s.authorized(scopeDelete, s.handleScrub))
+	mux.HandleFunc("GET /dicts", s.authorized(scopeList, s.handleListDicts))
+	mux.HandleFunc("GET /dicts/{id}", s.authorized(scopeDownload, s.handleDownloadDict))
+	mux.HandleFunc("POST /dicts", s.authorized(scopeUpload, s.handleTrainDict))
+	mux.HandleFunc("POST /uploads", s.authorized(scopeUpload, s.handleCreateParts))
+	mux.HandleFunc("GET /uploads/{id}", s.authorized(scopeUpload, s.handlePartsStatus))
+	mux.HandleFunc("PUT /uploads/{id}", s.authorized(scopeUpload, s.handlePutPart))
+	mux.HandleFunc("POST /uploads/{id}/complete", s.authorized(scopeUpload, s.handleCompleteParts))
+	mux.HandleFunc("DELETE /uploads/{id}", s.authorized(scopeUpload, s.handleAbortParts))
+	mux.HandleFunc("POST /uploads/{id}/heartbeat", s.authorized(scopeUpload, s.handlePartsHeartbeat))
+	mux.HandleFunc("POST /layers/check", s.authorized(scopeUpload, s.handleCheckLayers))
+	mux.HandleFunc("POST /layers/upload", s.authorized(scopeUpload, s.handleDedupUpload))
+	mux.HandleFunc("POST /requests", s.authorized(scopeLoad, s.handleCreateRequest))
+	mux.HandleFunc("GET /requests", s.authorized(scopeList, s.handleListRequests))
+	mux.HandleFunc("GET /requests/next", s.authorized(scopeUpload, s.handleNextRequest))
+	mux.HandleFunc("POST /requests/{id}/result", s.authorized(scopeUpload, s.handleRequestResult))
+
+	if opts.gcInterval > 0 {
+		go s.gcLoop()
+	}
+	if opts.uploadIdleTimeout > 0 {
+		go s.reapPartsLoop()
+	}
+	s.loadScrubReport()
+	if opts.scrubInterval > 0 {
+		go s.scrubLoop()
+	}
+
+	if (opts.tlsCert == "") != (opts.tlsKey == "") {
+		fmt.Println("-tls-cert 和 -tls-key 需要同时指定")
+		os.Exit(1)
+	}
+	if opts.mdns {
+		txt, err := opts.mdnsTXT()
+		if err != nil {
+			fmt.Printf("启动 mDNS 通告失败: %v\n", err)
+			os.Exit(1)
+		}
+		_, port, _ := net.SplitHostPort(opts.listen)
+		portNum, _ := strconv.Atoi(port)
+		if err := advertiseMDNS(context.Background(), opts.mdnsName, portNum, txt); err != nil {
+			fmt.Printf("启动 mDNS 通告失败: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("📢 已通过 mDNS 通告本服务")
+	}
+
+	ln, err := net.Listen("tcp", opts.listen)
+	if err != nil {
+		fmt.Printf("监听 %s 失败: %v\n", opts.listen, err)
+		os.Exit(1)
+	}
+	ln = limitListener(ln, int64(opts.limitRate), int64(opts.limitRatePerConn))
+
+	fmt.Printf("📡 接收服务已启动: %s\n", opts.listen)
+	fmt.Printf("📂 存储目录: %s\n", opts.dir)
+	if opts.limitRate > 0 || opts.limitRatePerConn > 0 {
+		fmt.Printf("🐢 接收限速: 合计 %s，单连接 %s\n", rateString(opts.limitRate), rateString(opts.limitRatePerConn))
+	}
+	if opts.tlsCert != "" {
+		err = http.ServeTLS(ln, mux, opts.tlsCert, opts.tlsKey)
+	} else {
+		err = http.Serve(ln, mux)
+	}
+	if err != nil {
+		fmt.Printf("服务异常退出: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// mDNS 通告的 TXT 记录：上传路径、是否 HTTPS 以及证书指纹 (供客户端核对)
+func (opts *serveOptions) mdnsTXT() ([]string, error) {
+	txt := []string{"txtvers=1", "path=/"}
+	if opts.tlsCert == "" {
+		return append(txt, "tls=0"), nil
+	}
+	cert, err := tls.LoadX509KeyPair(opts.tlsCert, opts.tlsKey)
+	if err != nil {
+		return nil, fmt.Errorf("读取证书失败: %w", err)
+	}
+	sum := sha256.Sum256(cert.Certificate[0])
+	return append(txt, "tls=1", "fp="+hex.EncodeToString(sum[:])), nil
+}
+
+// uploadResult 上传完成后返回给客户端的 JSON
+type uploadResult struct {
+	OK           bool         `json:"ok"`
+	UploadID     string       `json:"upload_id,omitempty"`
+	TransferID   string       `json:"transfer_id,omitempty"`
+	Name         string       `json:"name,omitempty"`
+	Size         int64        `json:"size,omitempty"`
+	SHA256       string       `json:"sha256,omitempty"`
+	Decompressed bool         `json:"decompressed,omitempty"`
+	Decrypted    bool         `json:"decrypted,omitempty"`
+	InnerSHA256  string       `json:"inner_sha256,omitempty"`
+	TreeSHA256   string       `json:"tree_sha256,omitempty"`
+	Artifact     string       `json:"artifact,omitempty"`
+	Version      string       `json:"version,omitempty"`
+	Load         *loadResult  `json:"load,omitempty"`
+	Extracted    string       `json:"extracted,omitempty"`
+	Collision    string       `json:"collision,omitempty"` // 同名文件已存在时所做的处理: overwritten、renamed、skipped
+	Hooks        []hookResult `json:"hooks,omitempty"`
+	Verify       *hookResult  `json:"verify,omitempty"` // 外部校验命令的结果
+	Error        string       `json:"error,omitempty"`
+}
+
+// receivedFile 已写入临时文件、尚未提交的上传
+type receivedFile struct {
+	tmpPath      string
+	name         string
+	size         int64
+	sha256       string // 客户端实际发送的数据摘要
+	decompressed bool
+	decrypted    bool
+	innerSHA256  string   // 解密、解压后数据的摘要
+	treeSHA256   string   // 存储内容的树形摘要 (计算过时才有)
+	treeLeaves   [][]byte // 服务端计算树形摘要时得到的叶子，提交后记录下来供 repair 校验
+	transferID   string   // 客户端的传输 ID (X-Transfer-Id)
+}
+
+// 存储内容的摘要：解密或解压后存储时为处理后数据的摘要
+func (rf *receivedFile) storedSHA256() string {
+	if rf.decompressed || rf.decrypted {
+		return rf.innerSHA256
+	}
+	return rf.sha256
+}
+
+// 为处理函数加上令牌鉴权
+func (s *server) authorized(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if status, err := authorizeRequest(s.opts.dir, r, scope); err != nil {
+			writeJSON(w, status, uploadResult{Error: err.Error()})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// storedFile 文件列表中的一项
+type storedFile struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// 列出已存储的文件
+func (s *server) handleList(w http.ResponseWriter, r *http.Request) {
+	entries, err := os.ReadDir(s.opts.dir)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, uploadResult{Error: "读取存储目录失败: " + err.Error()})
+		return
+	}
+
+	files := []storedFile{}
+	for _, e := range entries {
+		if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, storedFile{Name: e.Name(), Size: info.Size(), Modified: info.ModTime()})
+	}
+	writeJSON(w, http.StatusOK, files)
+}
+
+// 下载已存储的文件，支持 Range 请求
+func (s *server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	path, ok := s.storedPath(w, r.PathValue("name"))
+	if !ok {
+		return
+	}
+	s.serveStoredFile(w, r, path, s.storedDigest(path))
+}
+
+// 输出存储的文件内容，支持 Range 请求。响应带有下载会话令牌 (大小和摘要 digest)，
+// 续传请求带回的令牌与当前文件不符时返回 412，避免把新文件的内容接在旧文件后面
+func (s *server) serveStoredFile(w http.ResponseWriter, r *http.Request, path, digest string) {
+	f, err := os.Open(path)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, uploadResult{Error: "文件不存在"})
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		writeJSON(w, http.StatusNotFound, uploadResult{Error: "文件不存在"})
+		return
+	}
+	session := downloadSession(info, digest)
+	if !checkDownloadSession(w, r, session) {
+		return
+	}
+	w.Header().Set(downloadSessionHeader, session)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": info.Name()}))
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// 删除已存储的文件
+func (s *server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	path, ok := s.storedPath(w, r.PathValue("name"))
+	if !ok {
+		return
+	}
+
+	if err := os.Remove(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			writeJSON(w, http.StatusNotFound, uploadResult{Error: "文件不存在"})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, uploadResult{Error: "删除文件失败: " + err.Error()})
+		return
+	}
+	os.Remove(s.contentsCachePath(path))
+	os.Remove(s.sumPath(filepath.Base(path)))
+	os.Remove(s.treePath(filepath.Base(path), ""))
+	fmt.Printf("🗑️  已删除: %s\n", filepath.Base(path))
+	s.logEvent(severityInfo, "deleted", "已删除 "+filepath.Base(path), map[string]string{"name": filepath.Base(path), "by": s.uploader(r)})
+	writeJSON(w, http.StatusOK, uploadResult{OK: true, Name: filepath.Base(path)})
+}
+
+// 将请求中的文件名解析为存储目录下的路径，非法文件名直接返回 400
+func (s *server) storedPath(w http.ResponseWriter, name string) (string, bool) {
+	clean, err := safeFileName(name)
+	if err != nil || clean != name {
+		writeJSON(w, http.StatusBadRequest, uploadResult{Error: "非法的文件名"})
+		return "", false
+	}
+	return filepath.Join(s.opts.dir, clean), true
+}
+
+// 处理 multipart 上传请求，流式写入磁盘
+func (s *server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, uploadResult{Error: "请求不是 multipart 格式: " + err.Error()})
+		return
+	}
+
+	var received *receivedFile
+	defer func() {
+		if received != nil && received.tmpPath != "" {
+			os.Remove(received.tmpPath)
+		}
+	}()
+
+	fields := map[string]string{}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, uploadResult{Error: "读取请求失败: " + err.Error()})
+			return
+		}
+
+		// 文件分段的字段名通常为 file，客户端用 -field-name 改名时按带文件名的第一个分段接收
+		if (part.FormName() == "file" || part.FileName() != "") && received == nil {
+			received, err = s.receiveFile(part)
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, uploadResult{Error: err.Error()})
+				return
+			}
+			continue
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, maxFormFieldSize))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, uploadResult{Error: "读取表单字段失败: " + err.Error()})
+			return
+		}
+		fields[part.FormName()] = string(value)
+	}
+
+	if received == nil {
+		writeJSON(w, http.StatusBadRequest, uploadResult{Error: "缺少 file 字段"})
+		return
+	}
+	s.acceptUpload(w, r, received, fields)
+}
+
+// 校验收到的文件并按表单字段提交：保存为普通文件或制品，按请求解包、加载镜像并执行钩子。
+// 成功提交后 received.tmpPath 被清空，否则由调用方删除临时文件
+func (s *server) acceptUpload(w http.ResponseWriter, r *http.Request, received *receivedFile, fields map[string]string) {
+	received.transferID = transferID(r)
+	// 客户端提供了发送数据的摘要 (-checksum) 时，校验收到的数据
+	if want := fields["sha256"]; want != "" && !strings.EqualFold(want, received.sha256) {
+		writeJSON(w, http.StatusUnprocessableEntity, uploadResult{
+			Error: fmt.Sprintf("数据校验失败: 期望 %s，实际 %s", want, received.sha256),
+		})
+		return
+	}
+
+	// 客户端提供了压缩前数据的摘要时，校验解压结果
+	if want := fields["inner_sha256"]; want != "" && received.decompressed && !strings.EqualFold(want, received.innerSHA256) {
+		writeJSON(w, http.StatusUnprocessableEntity, uploadResult{
+			Error: fmt.Sprintf("解压后数据校验失败: 期望 %s，实际 %s", want, received.innerSHA256),
+		})
+		return
+	}
+
+	// 树形摘要描述的是未压缩的原始数据，存储的仍是压缩数据时无法与客户端的摘要比较
+	id := uploadID(r)
+	wantTree := fields["tree_sha256"]
+	storedRaw := fields["inner_sha256"] == "" || received.decompressed
+	if (wantTree != "" && storedRaw) || s.opts.treeHash {
+		root, leaves, err := s.verifyTree(id, received)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, uploadResult{UploadID: id, Error: "计算树形摘要失败: " + err.Error()})
+			return
+		}
+		if wantTree != "" && storedRaw && root != wantTree {
+			writeJSON(w, http.StatusUnprocessableEntity, uploadResult{
+				UploadID: id,
+				Error:    fmt.Sprintf("树形摘要校验失败: 期望 %s，实际 %s", wantTree, root),
+			})
+			return
+		}
+		received.treeSHA256, received.treeLeaves = root, leaves
+	} else if wantTree != "" {
+		// 存储的是压缩数据，直接记录客户端计算的原始数据摘要作为制品 ID
+		received.treeSHA256 = wantTree
+	}
+
+	result := uploadResult{
+		OK:           true,
+		UploadID:     id,
+		TransferID:   received.transferID,
+		TreeSHA256:   received.treeSHA256,
+		Name:         received.name,
+		Size:         received.size,
+		SHA256:       received.sha256,
+		Decompressed: received.decompressed,
+		Decrypted:    received.decrypted,
+		InnerSHA256:  received.innerSHA256,
+	}
+
+	var err error
+	if s.opts.verifyCmd != "" {
+		result.Verify, err = s.verifyExternal(id, received, fields)
+		if err != nil {
+			result.OK = false
+			result.Error = err.Error()
+			writeJSON(w, http.StatusUnprocessableEntity, result)
+			return
+		}
+	}
+
+	// 客户端请求加载镜像时，先确认服务端允许且令牌具有 load 权限，再提交文件
+	wantLoad := fields["docker_load"] == "true"
+	if wantLoad {
+		if !s.opts.allowLoad {
+			writeJSON(w, http.StatusForbidden, uploadResult{Error: "服务端未启用 --allow-load"})
+			return
+		}
+		if status, err := authorizeRequest(s.opts.dir, r, scopeLoad); err != nil {
+			writeJSON(w, status, uploadResult{Error: err.Error()})
+			return
+		}
+		if fields["smoke"] != "" && !s.opts.allowSmoke {
+			writeJSON(w, http.StatusForbidden, uploadResult{Error: "服务端未启用 --allow-smoke"})
+			return
+		}
+	}
+
+	// 请求解包时先校验目标路径，避免提交文件后才发现无法解包
+	var extractDest string
+	if to := fields["extract_to"]; to != "" {
+		if fields["bundle"] != "dir" {
+			writeJSON(w, http.StatusBadRequest, uploadResult{Error: "只有目录打包的归档才能解包"})
+			return
+		}
+		extractDest, err = s.extractTarget(to)
+		if err != nil {
+			writeJSON(w, http.StatusForbidden, uploadResult{Error: err.Error()})
+			return
+		}
+	}
+
+	var finalPath string
+	if name := fields["artifact_name"]; name != "" {
+		var labels map[string]string
+		if raw := fields["labels"]; raw != "" {
+			if err := json.Unmarshal([]byte(raw), &labels); err != nil {
+				writeJSON(w, http.StatusBadRequest, uploadResult{Error: "labels 字段格式错误: " + err.Error()})
+				return
+			}
+		}
+		meta, status, err := s.commitArtifact(received, name, fields["artifact_version"], s.uploader(r), labels)
+		if err != nil {
+			writeJSON(w, status, uploadResult{Error: err.Error()})
+			return
+		}
+		result.Artifact, result.Version = meta.Name, meta.Version
+		finalPath = s.artifactFilePath(meta)
+		if err := s.recordTree(s.treePath(meta.Name, meta.Version), finalPath, received); err != nil {
+			fmt.Printf("⚠️  记录树形摘要失败: %v\n", err)
+		}
+		fmt.Printf("✅ 已接收制品: %s@%s (%s)\n", meta.Name, meta.Version, formatBytes(received.size))
+	} else {
+		name, collision, status, err := s.storeFile(r, received, fields["if_exists"])
+		if err != nil {
+			writeJSON(w, status, uploadResult{UploadID: id, Error: err.Error()})
+			return
+		}
+		received.name, result.Name, result.Collision = name, name, collision
+		finalPath = filepath.Join(s.opts.dir, name)
+		switch collision {
+		case collisionSkipped:
+			fmt.Printf("⏭️  已有内容相同的 %s，未覆盖\n", name)
+		case collisionRenamed:
+			fmt.Printf("📛 同名文件已存在，另存为 %s\n", name)
+		}
+		if collision != collisionSkipped {
+			if err := s.recordSum(finalPath, received.storedSHA256()); err != nil {
+				fmt.Printf("⚠️  记录文件摘要失败: %v\n", err)
+			}
+			if err := s.recordTree(s.treePath(name, ""), finalPath, received); err != nil {
+				fmt.Printf("⚠️  记录树形摘要失败: %v\n", err)
+			}
+			fmt.Printf("✅ 已接收: %s (%s)\n", name, formatBytes(received.size))
+		}
+	}
+	if received.transferID != "" {
+		fmt.Printf("🔖 传输 ID: %s\n", received.transferID)
+	}
+	s.logEvent(severityInfo, "received", "已接收 "+received.name, map[string]string{
+		"name":        received.name,
+		"size":        strconv.FormatInt(received.size, 10),
+		"sha256":      received.sha256,
+		"artifact":    result.Artifact,
+		"version":     result.Version,
+		"upload_id":   result.UploadID,
+		"transfer_id": received.transferID,
+		"by":          s.uploader(r),
+	})
+
+	// 解析归档内容并缓存，供 /contents 查询；失败不影响上传结果
+	contents, err := s.indexContents(finalPath)
+	if err != nil {
+		fmt.Printf("⚠️  索引归档内容失败: %v\n", err)
+	}
+	// --auto-load 时镜像归档总是加载，其他文件照常保存
+	if s.opts.autoLoad && contents != nil && contents.Format == "docker-archive" {
+		wantLoad = true
+	}
+
+	if extractDest != "" {
+		if err := s.extractBundle(finalPath, extractDest); err != nil {
+			result.OK = false
+			result.Error = "解包失败: " + err.Error()
+			writeJSON(w, http.StatusInternalServerError, result)
+			return
+		}
+		result.Extracted = extractDest
+		fmt.Printf("📂 已解包到: %s\n", extractDest)
+		s.logEvent(severityInfo, "extracted", "已解包到 "+extractDest, map[string]string{"name": received.name, "dest": extractDest, "transfer_id": received.transferID})
+	}
+
+	if wantLoad {
+		var load *loadResult
+		if smoke := fields["smoke"]; smoke != "" {
+			load, err = s.loadImageCanary(finalPath, fields["docker_tag"], smoke)
+		} else {
+			load, err = s.loadImage(finalPath, fields["docker_tag"])
+		}
+		result.Load = load
+		if err != nil {
+			s.logEvent(severityError, "load_failed", "docker load 失败: "+err.Error(), map[string]string{"name": received.name, "error": err.Error(), "transfer_id": received.transferID})
+			result.OK = false
+			result.Error = err.Error()
+			writeJSON(w, http.StatusInternalServerError, result)
+			return
+		}
+		fmt.Printf("🐳 已加载镜像: %s\n", strings.Join(load.Loaded, ", "))
+		s.logEvent(severityInfo, "loaded", "已加载镜像 "+strings.Join(load.Loaded, ", "), map[string]string{"name": received.name, "images": strings.Join(load.Loaded, ","), "transfer_id": received.transferID})
+	}
+
+	// 内容相同而未保存时没有新文件，不再触发钩子
+	if result.Collision == collisionSkipped {
+		writeJSON(w, http.StatusOK, result)
+		return
+	}
+	result.Hooks, err = s.runPostReceiveHooks(receivedInfo{
+		path:     finalPath,
+		name:     received.name,
+		sha256:   received.sha256,
+		artifact: result.Artifact,
+		version:  result.Version,
+		transfer: received.transferID,
+	})
+	if err != nil {
+		fmt.Printf("⚠️  %v\n", err)
+		s.logEvent(severityError, "hook_failed", err.Error(), map[string]string{"name": received.name, "error": err.Error(), "transfer_id": received.transferID})
+		result.OK = false
+		result.Error = "文件已保存，但" + err.Error()
+		writeJSON(w, http.StatusInternalServerError, result)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// 将上传的文件内容写入存储目录下的临时文件
+func (s *server) receiveFile(part *multipart.Part) (*receivedFile, error) {
+	name, err := safeFileName(part.FileName())
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp(s.opts.dir, ".upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	// CreateTemp 默认权限为 0600，接收的文件需要能被其他用户 (如 docker load) 读取
+	tmp.Chmod(0o644)
+	rf := &receivedFile{tmpPath: tmp.Name(), name: name}
+
+	outer := sha256.New()
+	src := bufio.NewReader(io.TeeReader(part, outer))
+
+	var inner hash.Hash
+	var data io.Reader = src
+	// 持有密钥时先解密，解密后的数据总是解压后存储，与客户端加密前的原始数据一致
+	plain := src
+	if s.decryptKey != nil && isEncrypted(src) {
+		dr, err := newDecryptReader(src, s.decryptKey)
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return nil, err
+		}
+		plain = bufio.NewReader(dr)
+		data = plain
+		rf.decrypted = true
+		if trimmed := strings.TrimSuffix(name, encryptSuffix); trimmed != "" {
+			rf.name = trimmed
+		}
+	}
+	// 使用字典压缩的数据离开字典无法解压，总是解压后存储
+	var dicts [][]byte
+	if id := zstdFrameDict(plain); id != 0 {
+		d, err := s.loadZstdDict(id)
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return nil, fmt.Errorf("数据使用了服务端没有的 zstd 字典 %08x", id)
+		}
+		dicts = append(dicts, d)
+	}
+	if s.opts.storeDecompressed || rf.decrypted || len(dicts) > 0 {
+		dr, format, err := decompressReader(plain, dicts...)
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return nil, err
+		}
+		if dr != nil {
+			defer dr.Close()
+			data = dr
+			rf.decompressed = true
+			rf.name = stripCompressSuffix(rf.name, format)
+		}
+	}
+	if rf.decompressed || rf.decrypted {
+		inner = sha256.New()
+		data = io.TeeReader(data, inner)
+	}
+
+	rf.size, err = io.Copy(tmp, data)
+	if err == nil && rf.decompressed {
+		// 解压器可能不会读完压缩流末尾的填充数据，补齐以保证外层摘要完整；
+		// 解密时还要读到末段才能确认数据没有被截断
+		if _, err = io.Copy(io.Discard, plain); err == nil {
+			_, err = io.Copy(io.Discard, src)
+		}
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("写入文件失败: %w", err)
+	}
+
+	rf.sha256 = hex.EncodeToString(outer.Sum(nil))
+	if inner != nil {
+		rf.innerSHA256 = hex.EncodeToString(inner.Sum(nil))
+	}
+	return rf, nil
+}
+
+// 根据数据头识别 gzip/zstd 压缩流，返回解压 Reader 和格式名；不是压缩数据时返回 nil。
+// dicts 为解压 zstd 数据可用的字典
+func decompressReader(br *bufio.Reader, dicts ...[]byte) (io.ReadCloser, string, error) {
+	head, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, "", fmt.Errorf("解析 gzip 数据失败: %w", err)
+		}
+		return gr, "gzip", nil
+	case bytes.HasPrefix(head, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		zr, err := zstd.NewReader(br, zstd.WithDecoderDicts(dicts...))
+		if err != nil {
+			return nil, "", fmt.Errorf("解析 zstd 数据失败: %w", err)
+		}
+		return zr.IOReadCloser(), "zstd", nil
+	}
+	return nil, "", nil
+}
+
+// 去掉压缩格式对应的文件名后缀
+func stripCompressSuffix(name, format string) string {
+	var suffixes []string
+	switch format {
+	case "gzip":
+		suffixes = []string{".gz", ".gzip"}
+	case "zstd":
+		suffixes = []string{".zst", ".zstd"}
+	}
+	for _, suffix := range suffixes {
+		if trimmed := strings.TrimSuffix(name, suffix); trimmed != name && trimmed != "" {
+			return trimmed
+		}
+	}
+	return name
+}
+
+// 校验并清理客户端提供的文件名，防止路径穿越
+func safeFileName(name string) (string, error) {
+	name = filepath.Base(filepath.Clean("/" + strings.ReplaceAll(name, "\\", "/")))
+	// 以 . 开头的名字保留给临时文件和元数据目录
+	if name == "" || name == "/" || strings.HasPrefix(name, ".") {
+		return "", errors.New("非法的文件名")
+	}
+	return name, nil
+}
+
+// 限速值的显示文字，0 为不限
+func rateString(b byteSize) string {
+	if b <= 0 {
+		return "不限"
+	}
+	return formatBytes(int64(b)) + "/s"
+}
+
+// 输出 JSON 响应
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// 发送结构化日志 (-log-sink)，空字段不发送
+func (s *server) logEvent(sev logSeverity, event, msg string, fields map[string]string) {
+	if s.logs == nil {
+		return
+	}
+	for k, v := range fields {
+		if v == "" {
+			delete(fields, k)
+		}
+	}
+	sendLog(s.logs, &logEntry{Severity: sev, Event: event, Message: msg, Fields: fields})
+}
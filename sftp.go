@@ -0,0 +1,684 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -url sftp://user@host[:port]/path 经 SFTP 写入只开放 SSH 的目标主机，不需要接收服务端。
+// 与 -via 相同，使用系统的 ssh 命令 (ssh -s host sftp) 建立连接，沿用用户的密钥、agent、known_hosts 和 ~/.ssh/config，
+// 本工具只在其标准输入输出上实现 SFTP (版本 3) 客户端。
+// 数据先写入 <路径>.part，完成后改名；中断后再次上传同一目标时从 .part 的末尾续传
+const sftpScheme = "sftp://"
+
+const (
+	sftpInit     = 1
+	sftpVersion  = 2
+	sftpOpen     = 3
+	sftpClose    = 4
+	sftpRead     = 5
+	sftpWrite    = 6
+	sftpRemove   = 13
+	sftpMkdir    = 14
+	sftpStat     = 17
+	sftpRename   = 18
+	sftpStatus   = 101
+	sftpHandle   = 102
+	sftpData     = 103
+	sftpAttrs    = 105
+	sftpExtended = 200
+
+	sftpFlagRead  = 0x01
+	sftpFlagWrite = 0x02
+	sftpFlagCreat = 0x08
+	sftpFlagTrunc = 0x10
+
+	sftpStatusOK     = 0
+	sftpStatusEOF    = 1
+	sftpStatusNoFile = 2
+
+	sftpAttrSize        = 0x01
+	sftpAttrPermissions = 0x04
+)
+
+const (
+	// 每个 WRITE 请求的数据量 (OpenSSH 接受的包长上限为 256 KiB，32 KiB 是各实现都支持的大小)
+	sftpChunkSize = 32 << 10
+	// 同时未确认的 WRITE 请求数，高延迟链路上不必逐块等待确认
+	sftpWindow = 64
+	// 续传前比对 .part 末尾的字节数，用于确认已上传部分与本次的数据一致
+	sftpResumeCheck = 64 << 10
+)
+
+func isSFTPURL(raw string) bool {
+	return strings.HasPrefix(raw, sftpScheme)
+}
+
+// sftpTarget 上传的目标主机和路径
+type sftpTarget struct {
+	host string // [user@]host，原样交给 ssh
+	port string
+	path string
+}
+
+func (t *sftpTarget) String() string {
+	if t.port != "" {
+		return sftpScheme + t.host + ":" + t.port + t.path
+	}
+	return sftpScheme + t.host + ":" + t.path
+}
+
+// 检查 sftp:// 上传的参数；依赖本工具服务端或 HTTP 的功能无法使用
+func checkSFTPOptions(opts options) error {
+	switch {
+	case opts.preset != "":
+		return errors.New("sftp:// 地址不能与 -preset 同时使用")
+	case opts.tus || opts.parallelChunks > 0:
+		return errors.New("sftp:// 地址不能与 -tus、-parallel-chunks 同时使用")
+	case opts.remoteLoad || opts.remoteTag != "" || opts.smoke != "":
+		return errors.New("sftp:// 地址不支持 -remote-load/-remote-tag/-smoke")
+	case opts.extractTo != "" || opts.sums || opts.sumsKey != "":
+		return errors.New("sftp:// 地址不支持 -extract-to、-sums")
+	case len(opts.labels) > 0:
+		return errors.New("sftp:// 地址不支持 -label")
+	case opts.zstdDict:
+		return errors.New("-zstd-dict 只能用于本工具的服务端")
+	case opts.token != "" || opts.basicAuth != "" || opts.negotiate:
+		return errors.New("sftp:// 地址以 SSH 密钥认证，不能与 -token、-basic-auth、-negotiate 同时使用")
+	case opts.preflight:
+		return errors.New("sftp:// 地址不支持 -preflight")
+	case opts.proxy != "" || opts.via != "":
+		return errors.New("sftp:// 地址不能与 -proxy、-via 同时使用，跳板机请在 ~/.ssh/config 中配置 ProxyJump")
+	case opts.form.custom():
+		return errors.New("sftp:// 地址不以 multipart 表单发送，不支持 -form、-field-name")
+	}
+	_, err := newSFTPTarget(opts, "")
+	return err
+}
+
+// 解析 sftp://[user@]host[:port]/path，也接受 scp 风格的 sftp://user@host:/path。
+// 没有路径或以 /~/ 开头时相对于登录用户的主目录；路径为空或以 / 结尾时视为目录，在其后拼接文件名
+// (指定了版本化制品时为 <name>/<version>/<文件名>)
+func newSFTPTarget(opts options, fileName string) (*sftpTarget, error) {
+	authority, p, _ := strings.Cut(strings.TrimPrefix(opts.serverURL, sftpScheme), "/")
+	user, hostport, ok := strings.Cut(authority, "@")
+	if !ok {
+		user, hostport = "", authority
+	}
+	host, port := hostport, ""
+	if h, pt, err := net.SplitHostPort(hostport); err == nil {
+		host, port = h, pt
+	}
+	host = strings.Trim(host, "[]")
+	if host == "" || strings.HasPrefix(host, "-") || strings.HasPrefix(user, "-") {
+		return nil, fmt.Errorf("SFTP 地址格式错误: %s (应为 sftp://user@host:/path)", opts.serverURL)
+	}
+	if user != "" {
+		host = user + "@" + host
+	}
+
+	if rest, ok := strings.CutPrefix(p, "~/"); ok || p == "" {
+		p = rest
+	} else {
+		p = "/" + p
+	}
+	if p == "" || strings.HasSuffix(p, "/") {
+		if opts.artifactName != "" {
+			p += opts.artifactName + "/" + opts.artifactVersion + "/"
+		}
+		p += fileName
+	}
+	return &sftpTarget{host: host, port: port, path: p}, nil
+}
+
+// sftpUpload 将 r 的内容经 SFTP 写入目标，.part 已存在时从其末尾续传 (跳过 r 中对应的数据)
+func sftpUpload(ctx context.Context, opts options, r io.Reader, fileName string, rec *transferRecord) error {
+	t, err := newSFTPTarget(opts, fileName)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\n🔐 SFTP 上传: %s\n", t)
+	c, err := dialSFTP(ctx, t, opts.sshKey)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if opts.artifactName != "" {
+		c.mkdirAll(path.Dir(t.path))
+	}
+	part := t.path + ".part"
+	start := time.Now()
+	rec.attempted = true
+
+	offset, err := c.resumeOffset(part, r)
+	if err != nil {
+		return windowError(ctx, err)
+	}
+	flags := uint32(sftpFlagWrite | sftpFlagCreat)
+	if offset == 0 {
+		flags |= sftpFlagTrunc
+	} else {
+		fmt.Printf("⏩ 续传: 已上传 %s，从断点继续\n", formatBytes(offset))
+	}
+	handle, err := c.open(part, flags)
+	if err != nil {
+		return fmt.Errorf("打开 %s 失败: %w", part, err)
+	}
+	w := &sftpWriter{c: c, handle: handle, offset: offset}
+	n, err := io.Copy(w, r)
+	if ferr := w.flush(); err == nil {
+		err = ferr
+	}
+	if cerr := c.close(handle); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Printf("\n💾 已上传的部分保留在 %s，再次上传同一目标时续传\n", part)
+		return windowError(ctx, fmt.Errorf("SFTP 上传失败: %w", err))
+	}
+
+	size, _, err := c.stat(part)
+	if err != nil {
+		return err
+	}
+	if size != offset+n {
+		return fmt.Errorf("上传后 %s 的大小为 %d，应为 %d", part, size, offset+n)
+	}
+	if err := c.rename(part, t.path); err != nil {
+		return fmt.Errorf("将 %s 改名为 %s 失败: %w", part, t.path, err)
+	}
+	rec.Bytes, rec.Duration = n, time.Since(start).Seconds()
+	fmt.Printf("\n✅ 已上传到 %s\n", t)
+	return nil
+}
+
+// 确定续传位置：.part 不存在或为空时返回 0；否则从 r 中跳过已上传的数据，
+// 并比对 .part 末尾与 r 中对应的字节，不一致说明数据源已变化，删除 .part 后报错
+func (c *sftpClient) resumeOffset(part string, r io.Reader) (int64, error) {
+	size, exists, err := c.stat(part)
+	if err != nil {
+		return 0, err
+	}
+	if !exists || size == 0 {
+		return 0, nil
+	}
+	handle, err := c.open(part, sftpFlagRead)
+	if err != nil {
+		return 0, fmt.Errorf("打开 %s 失败: %w", part, err)
+	}
+	check := min(size, sftpResumeCheck)
+	remote, err := c.readAt(handle, size-check, int(check))
+	c.close(handle)
+	if err != nil {
+		return 0, fmt.Errorf("读取 %s 失败: %w", part, err)
+	}
+
+	local := make([]byte, check)
+	if _, err = io.CopyN(io.Discard, r, size-check); err == nil {
+		_, err = io.ReadFull(r, local)
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF || err == nil && !bytes.Equal(local, remote) {
+		c.remove(part)
+		return 0, fmt.Errorf("%s 与本次上传的数据不一致 (数据源已变化?)，已删除，请重新上传", part)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("读取文件失败: %w", err)
+	}
+	return size, nil
+}
+
+// sftpClient 在 ssh 子进程的标准输入输出上运行的 SFTP 会话，请求可以并发，响应按 ID 分发
+type sftpClient struct {
+	cmd    *exec.Cmd
+	w      io.WriteCloser
+	stderr *tailBuffer
+
+	extensions map[string]string
+
+	mu      sync.Mutex // 保护以下字段和写入
+	nextID  uint32
+	pending map[uint32]chan sftpPacket
+	err     error // 连接中断的原因，之后的请求都返回该错误
+}
+
+type sftpPacket struct {
+	typ  byte
+	data []byte
+}
+
+// 启动 ssh -s <host> sftp 并完成版本协商
+func dialSFTP(ctx context.Context, t *sftpTarget, key string) (*sftpClient, error) {
+	args := []string{"-o", "PreferredAuthentications=publickey", "-o", "ServerAliveInterval=30"}
+	if key != "" {
+		args = append(args, "-i", key, "-o", "IdentitiesOnly=yes")
+	}
+	if t.port != "" {
+		args = append(args, "-p", t.port)
+	}
+	args = append(args, "-s", t.host, "sftp")
+
+	cmd := exec.CommandContext(ctx, "ssh", args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	c := &sftpClient{cmd: cmd, w: stdin, stderr: &tailBuffer{max: 4096}, pending: map[uint32]chan sftpPacket{}}
+	cmd.Stderr = c.stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("启动 ssh 失败: %w", err)
+	}
+
+	br := bufio.NewReaderSize(stdout, 64<<10)
+	var version []byte
+	version = binary.BigEndian.AppendUint32(version, 3)
+	if err := c.writePacket(sftpInit, version); err == nil {
+		var p sftpPacket
+		if p, err = readSFTPPacket(br); err == nil && p.typ != sftpVersion {
+			err = fmt.Errorf("意外的响应类型 %d", p.typ)
+		}
+		if err == nil {
+			c.extensions = parseSFTPExtensions(p.data)
+			go c.readLoop(br)
+			return c, nil
+		}
+	}
+	stdin.Close()
+	cmd.Wait()
+	return nil, fmt.Errorf("建立 SFTP 会话失败: %s", c.sshError(err))
+}
+
+// 附上 ssh 的错误输出 (认证失败、主机指纹不符等)
+func (c *sftpClient) sshError(err error) string {
+	if msg := strings.TrimSpace(c.stderr.String()); msg != "" {
+		return msg
+	}
+	return err.Error()
+}
+
+// VERSION 响应在版本号之后是扩展名与数据的列表
+func parseSFTPExtensions(data []byte) map[string]string {
+	ext := map[string]string{}
+	d := sftpDecoder{data: data}
+	d.uint32()
+	for d.err == nil && len(d.data) > 0 {
+		name, value := d.string(), d.string()
+		if d.err == nil {
+			ext[name] = value
+		}
+	}
+	return ext
+}
+
+func (c *sftpClient) readLoop(r *bufio.Reader) {
+	for {
+		p, err := readSFTPPacket(r)
+		if err != nil {
+			c.mu.Lock()
+			c.err = fmt.Errorf("SFTP 连接中断: %s: %w", c.sshError(err), io.ErrUnexpectedEOF)
+			for id, ch := range c.pending {
+				close(ch)
+				delete(c.pending, id)
+			}
+			c.mu.Unlock()
+			return
+		}
+		if len(p.data) < 4 {
+			continue
+		}
+		id := binary.BigEndian.Uint32(p.data)
+		c.mu.Lock()
+		ch := c.pending[id]
+		delete(c.pending, id)
+		c.mu.Unlock()
+		if ch != nil {
+			p.data = p.data[4:]
+			ch <- p
+		}
+	}
+}
+
+func readSFTPPacket(r io.Reader) (sftpPacket, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return sftpPacket{}, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:4])
+	if n < 1 || n > 1<<20 {
+		return sftpPacket{}, fmt.Errorf("非法的 SFTP 包长度 %d", n)
+	}
+	data := make([]byte, n-1)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return sftpPacket{}, err
+	}
+	return sftpPacket{typ: hdr[4], data: data}, nil
+}
+
+// 调用方持有 mu 或尚未启动 readLoop
+func (c *sftpClient) writePacket(typ byte, payload []byte) error {
+	buf := make([]byte, 5, 5+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(1+len(payload)))
+	buf[4] = typ
+	_, err := c.w.Write(append(buf, payload...))
+	return err
+}
+
+// 发送请求，返回接收响应的通道；连接中断时通道被关闭
+func (c *sftpClient) send(typ byte, payload []byte) (chan sftpPacket, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return nil, c.err
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan sftpPacket, 1)
+	c.pending[id] = ch
+	if err := c.writePacket(typ, append(binary.BigEndian.AppendUint32(nil, id), payload...)); err != nil {
+		delete(c.pending, id)
+		return nil, fmt.Errorf("SFTP 连接中断: %s: %w", c.sshError(err), io.ErrUnexpectedEOF)
+	}
+	return ch, nil
+}
+
+func (c *sftpClient) wait(ch chan sftpPacket) (sftpPacket, error) {
+	p, ok := <-ch
+	if !ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return p, c.err
+	}
+	return p, nil
+}
+
+func (c *sftpClient) call(typ byte, payload []byte) (sftpPacket, error) {
+	ch, err := c.send(typ, payload)
+	if err != nil {
+		return sftpPacket{}, err
+	}
+	return c.wait(ch)
+}
+
+// sftpStatusError 服务端返回的非 OK 状态
+type sftpStatusError struct {
+	code uint32
+	msg  string
+}
+
+func (e *sftpStatusError) Error() string {
+	if e.msg != "" {
+		return e.msg
+	}
+	return fmt.Sprintf("SFTP 状态码 %d", e.code)
+}
+
+// 期望 STATUS 响应，非 OK 时返回 *sftpStatusError
+func statusResult(p sftpPacket) error {
+	if p.typ != sftpStatus {
+		return fmt.Errorf("意外的响应类型 %d", p.typ)
+	}
+	d := sftpDecoder{data: p.data}
+	code, msg := d.uint32(), d.string()
+	if d.err != nil {
+		return d.err
+	}
+	if code != sftpStatusOK {
+		return &sftpStatusError{code: code, msg: msg}
+	}
+	return nil
+}
+
+func (c *sftpClient) callStatus(typ byte, payload []byte) error {
+	p, err := c.call(typ, payload)
+	if err != nil {
+		return err
+	}
+	return statusResult(p)
+}
+
+// 文件大小；不存在时 exists 为 false
+func (c *sftpClient) stat(name string) (size int64, exists bool, err error) {
+	p, err := c.call(sftpStat, sftpString(nil, name))
+	if err != nil {
+		return 0, false, err
+	}
+	if p.typ == sftpStatus {
+		err := statusResult(p)
+		var serr *sftpStatusError
+		if errors.As(err, &serr) && serr.code == sftpStatusNoFile {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	if p.typ != sftpAttrs {
+		return 0, false, fmt.Errorf("意外的响应类型 %d", p.typ)
+	}
+	d := sftpDecoder{data: p.data}
+	if flags := d.uint32(); flags&sftpAttrSize != 0 {
+		size = int64(d.uint64())
+	}
+	return size, true, d.err
+}
+
+func (c *sftpClient) open(name string, flags uint32) (string, error) {
+	payload := sftpString(nil, name)
+	payload = binary.BigEndian.AppendUint32(payload, flags)
+	payload = binary.BigEndian.AppendUint32(payload, sftpAttrPermissions)
+	payload = binary.BigEndian.AppendUint32(payload, 0o644)
+	p, err := c.call(sftpOpen, payload)
+	if err != nil {
+		return "", err
+	}
+	if p.typ != sftpHandle {
+		return "", statusResult(p)
+	}
+	d := sftpDecoder{data: p.data}
+	handle := d.string()
+	return handle, d.err
+}
+
+func (c *sftpClient) close(handle string) error {
+	return c.callStatus(sftpClose, sftpString(nil, handle))
+}
+
+// 从 offset 处读取 n 字节，文件不足 n 字节时返回 io.ErrUnexpectedEOF
+func (c *sftpClient) readAt(handle string, offset int64, n int) ([]byte, error) {
+	buf := make([]byte, 0, n)
+	for len(buf) < n {
+		payload := sftpString(nil, handle)
+		payload = binary.BigEndian.AppendUint64(payload, uint64(offset)+uint64(len(buf)))
+		payload = binary.BigEndian.AppendUint32(payload, uint32(min(n-len(buf), sftpChunkSize)))
+		p, err := c.call(sftpRead, payload)
+		if err != nil {
+			return nil, err
+		}
+		if p.typ != sftpData {
+			err := statusResult(p)
+			var serr *sftpStatusError
+			if errors.As(err, &serr) && serr.code == sftpStatusEOF {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		d := sftpDecoder{data: p.data}
+		data := d.string()
+		if d.err != nil {
+			return nil, d.err
+		}
+		buf = append(buf, data...)
+	}
+	return buf, nil
+}
+
+func (c *sftpClient) remove(name string) error {
+	return c.callStatus(sftpRemove, sftpString(nil, name))
+}
+
+// 改名并覆盖已有文件：服务端支持 posix-rename@openssh.com 时原子替换，否则先删除再改名
+func (c *sftpClient) rename(from, to string) error {
+	if _, ok := c.extensions["posix-rename@openssh.com"]; ok {
+		payload := sftpString(nil, "posix-rename@openssh.com")
+		payload = sftpString(payload, from)
+		return c.callStatus(sftpExtended, sftpString(payload, to))
+	}
+	c.remove(to)
+	return c.callStatus(sftpRename, sftpString(sftpString(nil, from), to))
+}
+
+// 逐级创建目录，已存在等错误忽略，由之后的打开文件报告
+func (c *sftpClient) mkdirAll(dir string) {
+	var prefix string
+	for _, elem := range strings.Split(dir, "/") {
+		if elem == "" || elem == "." {
+			if prefix == "" && strings.HasPrefix(dir, "/") {
+				prefix = "/"
+			}
+			continue
+		}
+		prefix = path.Join(prefix, elem)
+		c.callStatus(sftpMkdir, binary.BigEndian.AppendUint32(sftpString(nil, prefix), 0))
+	}
+}
+
+// 结束会话并等待 ssh 退出
+func (c *sftpClient) Close() error {
+	c.w.Close()
+	return c.cmd.Wait()
+}
+
+// sftpWriter 以流水线方式发送 WRITE 请求，最多 sftpWindow 个未确认
+type sftpWriter struct {
+	c        *sftpClient
+	handle   string
+	offset   int64
+	inflight []chan sftpPacket
+}
+
+func (w *sftpWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), sftpChunkSize)]
+		payload := sftpString(nil, w.handle)
+		payload = binary.BigEndian.AppendUint64(payload, uint64(w.offset))
+		payload = sftpString(payload, string(chunk))
+		ch, err := w.c.send(sftpWrite, payload)
+		if err != nil {
+			return written, err
+		}
+		w.inflight = append(w.inflight, ch)
+		w.offset += int64(len(chunk))
+		written += len(chunk)
+		p = p[len(chunk):]
+		if len(w.inflight) >= sftpWindow {
+			if err := w.ack(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// 等待最早的一个 WRITE 确认
+func (w *sftpWriter) ack() error {
+	ch := w.inflight[0]
+	w.inflight = w.inflight[1:]
+	p, err := w.c.wait(ch)
+	if err != nil {
+		return err
+	}
+	return statusResult(p)
+}
+
+// 等待所有 WRITE 确认
+func (w *sftpWriter) flush() error {
+	for len(w.inflight) > 0 {
+		if err := w.ack(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sftpString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+// sftpDecoder 依次解析响应中的字段，数据不足时记录错误
+type sftpDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *sftpDecoder) take(n int) []byte {
+	if d.err != nil || len(d.data) < n {
+		if d.err == nil {
+			d.err = errors.New("SFTP 响应不完整")
+		}
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *sftpDecoder) uint32() uint32 {
+	if b := d.take(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *sftpDecoder) uint64() uint64 {
+	if b := d.take(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *sftpDecoder) string() string {
+	n := d.uint32()
+	if n > uint32(len(d.data)) {
+		d.take(len(d.data) + 1)
+		return ""
+	}
+	return string(d.take(int(n)))
+}
+
+// tailBuffer 只保留最后 max 字节的输出
+type tailBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+	max int
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.max {
+		b.buf = b.buf[len(b.buf)-b.max:]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf)
+}
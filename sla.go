@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// slaOptions 传输 SLA 阈值，超出时告警，可选择中止传输以便重试
+type slaOptions struct {
+	minSpeed    byteSize      // 每个统计窗口内的最低平均速度 (字节/秒)
+	window      time.Duration // 速度统计窗口
+	maxDuration time.Duration // 传输总时长上限
+	webhook     string        // 违反 SLA 时 POST 告警的地址
+	fail        bool          // 违反 SLA 时中止传输
+}
+
+// 是否配置了任何阈值
+func (o slaOptions) enabled() bool {
+	return o.minSpeed > 0 || o.maxDuration > 0
+}
+
+// slaViolation 违反 SLA 的原因，启用 -sla-fail 时作为传输的错误返回
+type slaViolation struct {
+	Kind      string    `json:"kind"` // min_speed / max_duration
+	Message   string    `json:"message"`
+	Transfer  string    `json:"transfer_id,omitempty"`
+	File      string    `json:"file"`
+	Target    string    `json:"target"`
+	Host      string    `json:"host"`
+	Elapsed   string    `json:"elapsed"`
+	Bytes     int64     `json:"bytes"`
+	Speed     int64     `json:"speed,omitempty"`
+	Threshold string    `json:"threshold"`
+	Time      time.Time `json:"time"`
+}
+
+func (v *slaViolation) Error() string {
+	return "违反传输 SLA: " + v.Message
+}
+
+// slaMonitor 在后台统计传输进度并检查阈值
+type slaMonitor struct {
+	opts   slaOptions
+	id     string // 传输 ID
+	file   string
+	target string
+	bytes  atomic.Int64
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+}
+
+// 启动监控；未配置阈值时返回 nil，此时 add/stop 均为空操作
+func startSLAMonitor(ctx context.Context, opts slaOptions, transfer, file, target string) (context.Context, *slaMonitor) {
+	if !opts.enabled() {
+		return ctx, nil
+	}
+	if opts.window <= 0 {
+		opts.window = 5 * time.Minute
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	m := &slaMonitor{opts: opts, id: transfer, file: file, target: target, cancel: cancel, done: make(chan struct{})}
+	go m.run()
+	return ctx, m
+}
+
+// 记录传输的字节数
+func (m *slaMonitor) add(n int) {
+	if m != nil {
+		m.bytes.Add(int64(n))
+	}
+}
+
+// 停止监控
+func (m *slaMonitor) stop() {
+	if m != nil {
+		close(m.done)
+	}
+}
+
+func (m *slaMonitor) run() {
+	start := time.Now()
+	var durationC <-chan time.Time
+	if m.opts.maxDuration > 0 {
+		timer := time.NewTimer(m.opts.maxDuration)
+		defer timer.Stop()
+		durationC = timer.C
+	}
+	var speedC <-chan time.Time
+	if m.opts.minSpeed > 0 {
+		ticker := time.NewTicker(m.opts.window)
+		defer ticker.Stop()
+		speedC = ticker.C
+	}
+
+	var last int64
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-durationC:
+			m.violate(start, &slaViolation{
+				Kind:      "max_duration",
+				Message:   fmt.Sprintf("传输已超过 %s 仍未完成", m.opts.maxDuration),
+				Threshold: m.opts.maxDuration.String(),
+			})
+		case <-speedC:
+			total := m.bytes.Load()
+			speed := int64(float64(total-last) / m.opts.window.Seconds())
+			last = total
+			if speed < int64(m.opts.minSpeed) {
+				m.violate(start, &slaViolation{
+					Kind:      "min_speed",
+					Message:   fmt.Sprintf("最近 %s 平均速度 %s/s，低于 %s/s", m.opts.window, formatBytes(speed), formatBytes(int64(m.opts.minSpeed))),
+					Speed:     speed,
+					Threshold: formatBytes(int64(m.opts.minSpeed)) + "/s",
+				})
+			}
+		}
+	}
+}
+
+// 处理一次违反：输出警告、发送 webhook，并按配置中止传输
+func (m *slaMonitor) violate(start time.Time, v *slaViolation) {
+	v.Transfer, v.File, v.Target = m.id, m.file, m.target
+	v.Host, _ = os.Hostname()
+	v.Elapsed = time.Since(start).Round(time.Second).String()
+	v.Bytes = m.bytes.Load()
+	v.Time = time.Now()
+
+	fmt.Printf("\n⚠️  %s\n", v.Error())
+	if m.opts.webhook != "" {
+		if err := postSLAWebhook(m.opts.webhook, v); err != nil {
+			fmt.Printf("⚠️  发送 SLA 告警失败: %v\n", err)
+		}
+	}
+	if m.opts.fail {
+		m.cancel(v)
+	}
+}
+
+// 以 JSON 格式 POST 告警
+func postSLAWebhook(url string, v *slaViolation) error {
+	data, err := json.Marshal(map[string]any{"event": "sla_violation", "violation": v})
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook 返回状态码 %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// slaCounter 将经过的字节数计入 SLA 监控
+type slaCounter struct {
+	r io.Reader
+	m *slaMonitor
+}
+
+func (c *slaCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.m.add(n)
+	return n, err
+}
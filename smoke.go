@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// 冒烟测试输出最多返回的字节数 (保留结尾部分)
+const maxSmokeOutput = 4 << 10
+
+// smokeResult 在加载的镜像中执行冒烟命令的结果
+type smokeResult struct {
+	Command  string `json:"command"`
+	Image    string `json:"image"`
+	Passed   bool   `json:"passed"`
+	ExitCode int    `json:"exit_code"`
+	Output   string `json:"output,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// 加载镜像后先打上临时标签执行冒烟测试，通过后才把 tag 指向新镜像；
+// 测试失败时 tag 保持原样，镜像损坏或架构不符等问题在切换前即可发现
+func (s *server) loadImageCanary(path, tag, smoke string) (*loadResult, error) {
+	loaded, err := dockerLoad(path)
+	if err != nil {
+		return nil, err
+	}
+	result := &loadResult{Loaded: loaded}
+	if len(loaded) != 1 {
+		return result, fmt.Errorf("归档中包含 %d 个镜像，无法确定要执行冒烟测试的镜像", len(loaded))
+	}
+
+	image := loaded[0]
+	if tag != "" {
+		// 临时标签保证测试的是刚加载的镜像，测试期间其他人拉取 tag 仍得到旧镜像
+		image = "dss-canary:" + randomID()
+		if err := dockerTag(loaded[0], image); err != nil {
+			return result, err
+		}
+		defer dockerCommand("rmi", image)
+	}
+
+	result.Smoke = runSmokeTest(image, smoke, s.opts.smokeTimeout)
+	if !result.Smoke.Passed {
+		return result, fmt.Errorf("冒烟测试失败 (退出码 %d)", result.Smoke.ExitCode)
+	}
+	if tag == "" {
+		return result, nil
+	}
+	return result, s.retag(loaded[0], tag, result)
+}
+
+// 以镜像启动一次性容器执行命令：命令的第一个词作为入口程序，禁用网络，结束后删除容器
+func runSmokeTest(image, command string, timeout time.Duration) *smokeResult {
+	result := &smokeResult{Command: command, Image: image, ExitCode: -1}
+	words, err := splitArgs(command)
+	if err != nil || len(words) == 0 {
+		result.Output = fmt.Sprintf("冒烟命令格式错误: %q", command)
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	name := "dss-smoke-" + randomID()
+	args := append([]string{"run", "--rm", "--name", name, "--network", "none", "--pull", "never", "--entrypoint", words[0], image}, words[1:]...)
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	start := time.Now()
+	err = cmd.Run()
+	result.Duration = time.Since(start).Round(time.Millisecond).String()
+	if ctx.Err() != nil {
+		// 结束 docker 客户端并不会停止容器
+		dockerCommand("rm", "-f", name)
+		fmt.Fprintf(&out, "\n执行超过 %s，已终止", timeout)
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		result.ExitCode, result.Passed = 0, true
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode()
+	default:
+		fmt.Fprintf(&out, "\n%v", err)
+	}
+	output := strings.TrimSpace(out.String())
+	if len(output) > maxSmokeOutput {
+		output = "..." + output[len(output)-maxSmokeOutput:]
+	}
+	result.Output = output
+	return result
+}
+
+// 输出服务端返回的冒烟测试结果
+func printSmokeResult(body []byte) {
+	var result uploadResult
+	if json.Unmarshal(body, &result) != nil || result.Load == nil || result.Load.Smoke == nil {
+		return
+	}
+	sr := result.Load.Smoke
+	if sr.Passed {
+		fmt.Printf("🧪 冒烟测试通过: %s (%s)\n", sr.Command, sr.Duration)
+	} else {
+		fmt.Printf("🧪 冒烟测试失败: %s (退出码 %d)\n", sr.Command, sr.ExitCode)
+	}
+	if sr.Output != "" {
+		fmt.Println(sr.Output)
+	}
+}
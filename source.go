@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// source 待上传的数据源 (本地文件或远程 URL)
+type source struct {
+	io.ReadCloser
+	name string
+	size int64 // -1 表示大小未知
+	dir  bool  // 由目录即时打包的 tar
+
+	sums *checksumManifest // 目录内各文件的摘要 (仅在请求生成校验清单时记录)
+}
+
+// -file 为该值时从标准输入读取 (如 docker save app | docker_save_shell -file - -url ...)
+const stdinSource = "-"
+
+// 从标准输入读取时默认的上传文件名
+const defaultStdinName = "stdin"
+
+// 判断 --file 是否为 http(s) 地址、本机 docker 镜像或标准输入 (即不是本地路径)
+func isRemoteSource(p string) bool {
+	return strings.HasPrefix(p, "http://") || strings.HasPrefix(p, "https://") || isImageSource(p) || p == stdinSource
+}
+
+// 打开数据源：本地路径直接打开 (包括块设备)，目录按 filter 即时打包为 tar，http(s) 地址则发起 GET 请求边下载边上传，
+// docker-daemon:<镜像> 则边 docker save 边上传，- 为标准输入 (大小未知，以分块传输编码发送)。
+// sums 为 true 时记录目录内各文件的摘要，用于生成校验清单；docker 为导出镜像的守护进程，client 用于下载 http(s) 地址。
+func openSource(ctx context.Context, p string, filter dirFilter, sums bool, docker dockerEndpoint, client *http.Client) (*source, error) {
+	if p == stdinSource {
+		if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			return nil, errors.New("-file - 从标准输入读取，但标准输入是终端；请通过管道传入数据 (如 docker save app | docker_save_shell -file - ...)")
+		}
+		return &source{ReadCloser: io.NopCloser(os.Stdin), name: defaultStdinName, size: -1}, nil
+	}
+	if isImageSource(p) {
+		return openImageSource(ctx, p, docker)
+	}
+	if isRemoteSource(p) {
+		return openRemoteSource(ctx, client, p)
+	}
+
+	file, err := os.Open(p)
+	if err != nil {
+		return nil, fmt.Errorf("无法打开文件: %w", err)
+	}
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("无法获取文件信息: %w", err)
+	}
+	if isBlockDevice(fileInfo) {
+		return openBlockDevice(file, p)
+	}
+	if fileInfo.IsDir() {
+		file.Close()
+		var m *checksumManifest
+		if sums {
+			m = newChecksumManifest()
+		}
+		src, err := openDirSource(p, filter, m)
+		if err != nil {
+			return nil, err
+		}
+		src.sums = m
+		return src, nil
+	}
+
+	return &source{ReadCloser: file, name: filepath.Base(p), size: fileInfo.Size()}, nil
+}
+
+// 以远程 URL 作为数据源
+func openRemoteSource(ctx context.Context, client *http.Client, rawURL string) (*source, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建下载请求失败: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("下载源文件失败: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("下载源文件失败: 服务器返回 %s", resp.Status)
+	}
+
+	return &source{ReadCloser: resp.Body, name: remoteFileName(resp), size: resp.ContentLength}, nil
+}
+
+// 下载 http(s) 数据源的客户端：与上传相同的 TLS (-ca-cert、-insecure 等)、代理 (-proxy、-proxy-ntlm) 和 -via 设置，
+// 但使用独立的连接，不带会话 ID、认证和附加的请求头 (这些只发给上传目标)；tunnel 为 -via 建立的隧道
+func newSourceClient(opts options, tunnel *sshTunnel) (*http.Client, error) {
+	c := newSessionClient(randomID(), 0)
+	cfg, err := opts.tls.config()
+	if err != nil {
+		return nil, err
+	}
+	if cfg != nil {
+		c.transport.TLSClientConfig = cfg
+	}
+	if opts.proxy != "" {
+		if c.transport.Proxy, _, err = parseProxy(opts.proxy); err != nil {
+			return nil, err
+		}
+	}
+	if opts.proxyNTLM != "" {
+		if err := c.useNTLMProxy(opts.proxyNTLM); err != nil {
+			return nil, err
+		}
+	}
+	if tunnel != nil {
+		c.transport.Proxy = http.ProxyURL(tunnel.proxyURL)
+	}
+	return &http.Client{Transport: c.transport}, nil
+}
+
+// 推断远程文件名：优先使用 Content-Disposition，其次取 URL 路径最后一段
+func remoteFileName(resp *http.Response) string {
+	if cd := resp.Header.Get("Content-Disposition"); cd != "" {
+		if _, params, err := mime.ParseMediaType(cd); err == nil && params["filename"] != "" {
+			return path.Base(params["filename"])
+		}
+	}
+
+	name := path.Base(resp.Request.URL.Path)
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		name = unescaped
+	}
+	if name == "" || name == "/" || name == "." {
+		return "download"
+	}
+	return name
+}
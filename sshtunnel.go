@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// 等待 SSH 隧道就绪的最长时间 (包括用户输入密码的时间)
+const sshTunnelTimeout = 60 * time.Second
+
+// sshTunnel 通过跳板机建立的 SOCKS 代理 (ssh -D)，上传请求经由跳板机转发到接收端，
+// 免去手工执行 ssh -L 再改写目标地址的步骤。使用系统的 ssh 命令，沿用用户的密钥、agent 和 ~/.ssh/config。
+type sshTunnel struct {
+	cmd      *exec.Cmd
+	proxyURL *url.URL
+	exited   chan error
+}
+
+// 启动 ssh -D 并等待本地 SOCKS 端口可用；via 为 [user@]host[:port]
+func openSSHTunnel(ctx context.Context, via string) (*sshTunnel, error) {
+	host, port := via, ""
+	if h, p, err := net.SplitHostPort(via); err == nil {
+		host, port = h, p
+	}
+
+	local, err := freeLocalPort()
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"-N",
+		"-D", "127.0.0.1:" + strconv.Itoa(local),
+		"-o", "ExitOnForwardFailure=yes",
+		"-o", "ServerAliveInterval=30",
+	}
+	if port != "" {
+		args = append(args, "-p", port)
+	}
+	args = append(args, host)
+
+	cmd := exec.Command("ssh", args...)
+	cmd.Stdin = os.Stdin // 需要时由用户输入密码或确认主机指纹
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("启动 ssh 失败: %w", err)
+	}
+	t := &sshTunnel{
+		cmd:      cmd,
+		proxyURL: &url.URL{Scheme: "socks5", Host: "127.0.0.1:" + strconv.Itoa(local)},
+		exited:   make(chan error, 1),
+	}
+	go func() { t.exited <- cmd.Wait() }()
+
+	fmt.Printf("🔐 正在通过 %s 建立 SSH 隧道...\n", via)
+	deadline := time.Now().Add(sshTunnelTimeout)
+	for {
+		conn, err := net.DialTimeout("tcp", t.proxyURL.Host, time.Second)
+		if err == nil {
+			conn.Close()
+			fmt.Printf("✅ SSH 隧道已建立 (SOCKS 代理 %s)\n", t.proxyURL.Host)
+			return t, nil
+		}
+		select {
+		case err := <-t.exited:
+			if err == nil {
+				err = errors.New("ssh 意外退出")
+			}
+			return nil, fmt.Errorf("建立 SSH 隧道失败: %w", err)
+		case <-ctx.Done():
+			t.Close()
+			return nil, ctx.Err()
+		case <-time.After(200 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			t.Close()
+			return nil, fmt.Errorf("建立 SSH 隧道超时 (%s)", sshTunnelTimeout)
+		}
+	}
+}
+
+// 关闭隧道
+func (t *sshTunnel) Close() {
+	t.cmd.Process.Kill()
+	<-t.exited
+}
+
+// 取一个当前空闲的本地端口
+func freeLocalPort() (int, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, fmt.Errorf("分配本地端口失败: %w", err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port, nil
+}
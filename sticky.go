@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 生成随机的会话/上传 ID
+func randomID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// stickyDialer 目标域名解析出多个地址时，同一会话固定连接其中一个，
+// 该地址不可用时按确定的顺序切换到下一个并固定在新地址上。
+// 顺序由会话 ID 和地址共同决定 (rendezvous hashing)：同一会话在重试和续传时选中同一地址，
+// 不同会话则均匀分布到各个地址。
+type stickyDialer struct {
+	session string
+	dialer  net.Dialer
+
+	mu     sync.Mutex
+	pinned map[string]string // host:port -> 当前固定的 IP
+	last   net.Conn          // 最近建立的连接，用于读取 TCP 统计
+}
+
+func newStickyDialer(session string) *stickyDialer {
+	return &stickyDialer{
+		session: session,
+		dialer:  net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+		pinned:  map[string]string{},
+	}
+}
+
+// 计算地址在当前会话中的优先级
+func (d *stickyDialer) score(ip string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(d.session))
+	h.Write([]byte{0})
+	h.Write([]byte(ip))
+	return h.Sum64()
+}
+
+func (d *stickyDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		conn, err := d.dialer.DialContext(ctx, network, addr)
+		if err == nil {
+			d.mu.Lock()
+			d.last = conn
+			d.mu.Unlock()
+		}
+		return conn, err
+	}
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(addrs, func(i, j int) bool { return d.score(addrs[i]) > d.score(addrs[j]) })
+
+	// 已固定的地址仍在解析结果中时优先使用，保证会话期间 DNS 轮转也不会换地址
+	d.mu.Lock()
+	pinned := d.pinned[addr]
+	d.mu.Unlock()
+	for i, ip := range addrs {
+		if ip == pinned {
+			copy(addrs[1:i+1], addrs[:i])
+			addrs[0] = ip
+			break
+		}
+	}
+
+	var errs []error
+	for _, ip := range addrs {
+		conn, err := d.dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if err != nil {
+			errs = append(errs, err)
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		if pinned != "" && ip != pinned {
+			fmt.Printf("\n⚠️  %s 的地址 %s 不可用，切换到 %s\n", host, pinned, ip)
+		}
+		d.mu.Lock()
+		d.pinned[addr], d.last = ip, conn
+		d.mu.Unlock()
+		return conn, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+// 最近建立的连接
+func (d *stickyDialer) lastConn() net.Conn {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.last
+}
+
+// sessionClient 固定连接地址的 HTTP 客户端
+type sessionClient struct {
+	*http.Client
+	transport *http.Transport
+	dialer    *stickyDialer
+	clock     *clockSync
+}
+
+// 创建固定连接地址的 HTTP 客户端，每个请求都带上会话 ID (X-Upload-Id)，
+// 负载均衡可据此做会话亲和，服务端也用它作为校验进度的查询 ID
+func newSessionClient(session string, timeout time.Duration) *sessionClient {
+	dialer := newStickyDialer(session)
+	clock := &clockSync{mode: clockSkewWarn}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	return &sessionClient{
+		Client: &http.Client{
+			Timeout:   timeout,
+			Transport: sessionHeader{session: session, clock: clock, next: transport},
+		},
+		transport: transport,
+		dialer:    dialer,
+		clock:     clock,
+	}
+}
+
+// sessionHeader 为请求加上会话 ID，并根据响应检测时钟偏差
+type sessionHeader struct {
+	session string
+	clock   *clockSync
+	next    http.RoundTripper
+}
+
+func (t sessionHeader) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("X-Upload-Id") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("X-Upload-Id", t.session)
+	}
+	sent := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err == nil {
+		t.clock.observe(resp, sent, time.Now())
+	}
+	return resp, err
+}
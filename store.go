@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 访问共享状态存储的超时时间
+const stateStoreTimeout = 5 * time.Second
+
+// stateStore 服务端的共享状态 (制品索引、续传会话等)
+// 默认保存在存储目录下；多个接收端副本部署在负载均衡之后时，改为保存在 Redis 中，
+// 使任一副本都能看到其他副本写入的状态。键使用 / 分隔的相对路径，例如 artifacts/web/1/meta.json。
+type stateStore interface {
+	// Get 读取键的值，不存在时返回 os.ErrNotExist
+	Get(key string) ([]byte, error)
+	// Put 写入键的值，ttl 为 0 表示永不过期
+	Put(key string, value []byte, ttl time.Duration) error
+	// Create 仅当键不存在时写入，已存在时返回 os.ErrExist
+	Create(key string, value []byte) error
+	// Delete 删除键，键不存在时不报错
+	Delete(key string) error
+	// List 按字典序列出以 prefix 开头的键
+	List(prefix string) ([]string, error)
+}
+
+// 根据 -state-store 参数创建状态存储：file (默认) 或 redis://[:password@]host:port/db
+func openStateStore(spec, dir string) (stateStore, error) {
+	switch {
+	case spec == "" || spec == "file":
+		return fileStore{root: dir}, nil
+	case strings.HasPrefix(spec, "redis://"), strings.HasPrefix(spec, "rediss://"):
+		opt, err := redis.ParseURL(spec)
+		if err != nil {
+			return nil, fmt.Errorf("解析 Redis 地址失败: %w", err)
+		}
+		store := &redisStore{client: redis.NewClient(opt), prefix: "dss:"}
+		if err := store.ping(); err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+	return nil, fmt.Errorf("不支持的状态存储: %s (可选 file 或 redis://)", spec)
+}
+
+// fileStore 以文件保存状态，键即相对于存储目录的路径
+type fileStore struct {
+	root string
+}
+
+func (f fileStore) path(key string) string {
+	return filepath.Join(f.root, filepath.FromSlash(key))
+}
+
+func (f fileStore) Get(key string) ([]byte, error) {
+	return os.ReadFile(f.path(key))
+}
+
+func (f fileStore) Put(key string, value []byte, ttl time.Duration) error {
+	path := f.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// 先写临时文件再重命名，避免读到半个文件
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, value, 0o644); err != nil {
+		return fmt.Errorf("写入 %s 失败: %w", filepath.Base(path), err)
+	}
+	return os.Rename(tmp, path)
+}
+
+func (f fileStore) Create(key string, value []byte) error {
+	path := f.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// 先写临时文件，再用硬链接原子地创建目标，目标已存在时 Link 失败
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(value); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	os.Chmod(tmp.Name(), 0o644)
+	if err := os.Link(tmp.Name(), path); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return os.ErrExist
+		}
+		return err
+	}
+	return nil
+}
+
+func (f fileStore) Delete(key string) error {
+	err := os.Remove(f.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (f fileStore) List(prefix string) ([]string, error) {
+	// 从前缀中最后一个 / 之前的目录开始遍历
+	dir, _ := filepath.Split(filepath.FromSlash(prefix))
+	var keys []string
+	err := filepath.WalkDir(filepath.Join(f.root, dir), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(f.root, path)
+		if err != nil {
+			return nil
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) && !strings.HasSuffix(key, ".tmp") {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// redisStore 以 Redis 保存状态，所有键加上统一前缀
+type redisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func (r *redisStore) ctx() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), stateStoreTimeout)
+}
+
+func (r *redisStore) ping() error {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	if err := r.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("连接 Redis 失败: %w", err)
+	}
+	return nil
+}
+
+func (r *redisStore) Get(key string) ([]byte, error) {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	value, err := r.client.Get(ctx, r.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, os.ErrNotExist
+	}
+	return value, err
+}
+
+func (r *redisStore) Put(key string, value []byte, ttl time.Duration) error {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	return r.client.Set(ctx, r.prefix+key, value, ttl).Err()
+}
+
+func (r *redisStore) Create(key string, value []byte) error {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	ok, err := r.client.SetNX(ctx, r.prefix+key, value, 0).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return os.ErrExist
+	}
+	return nil
+}
+
+func (r *redisStore) Delete(key string) error {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	return r.client.Del(ctx, r.prefix+key).Err()
+}
+
+func (r *redisStore) List(prefix string) ([]string, error) {
+	ctx, cancel := r.ctx()
+	defer cancel()
+
+	var keys []string
+	iter := r.client.Scan(ctx, 0, r.prefix+redisEscapeGlob(prefix)+"*", 1000).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, strings.TrimPrefix(iter.Val(), r.prefix))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// 转义 Redis SCAN 匹配模式中的特殊字符
+func redisEscapeGlob(s string) string {
+	var sb strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`*?[]\`, c) {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// -checksum 时制品库预设上传以此 trailer (或请求头) 发送数据的 SHA-256
+const checksumHeader = "X-Content-Sha256"
+
+const (
+	// -response-timeout 的默认值：服务端校验、解压或 docker load 大文件可能需要较长时间
+	defaultResponseTimeout = 30 * time.Minute
+	// 等待服务器响应期间提示的间隔
+	responseNoticeInterval = 15 * time.Second
+)
+
+// streamBody 边生成边发送的请求体：后台 goroutine 读取文件写入管道，HTTP 请求从管道读取，
+// 内存占用与文件大小无关，进度条也随实际的发送进度推进
+type streamBody struct {
+	pr   *io.PipeReader
+	w    *io.PipeWriter // 生成请求体时写入的一端
+	sent atomic.Int64   // 已被请求读走的字节数
+	done chan struct{}
+	err  error // 生成请求体时的错误，done 关闭后有效
+}
+
+func newStreamBody() *streamBody {
+	pr, pw := io.Pipe()
+	return &streamBody{pr: pr, w: pw, done: make(chan struct{})}
+}
+
+// 在后台生成请求体；produce 返回的错误会使请求读取失败，从而中止请求
+func (b *streamBody) start(produce func() error) {
+	go func() {
+		defer close(b.done)
+		b.err = produce()
+		b.w.CloseWithError(b.err)
+	}()
+}
+
+func (b *streamBody) Read(p []byte) (int, error) {
+	n, err := b.pr.Read(p)
+	b.sent.Add(int64(n))
+	return n, err
+}
+
+// 关闭读取端，后台的写入随之返回 io.ErrClosedPipe；请求结束后由 http.Client 调用，可重复调用
+func (b *streamBody) Close() error {
+	return b.pr.Close()
+}
+
+// 等待后台生成结束，返回读取文件等过程中的错误；
+// 服务端提前响应、请求体未被读完导致的 io.ErrClosedPipe 不视为错误
+func (b *streamBody) wait() error {
+	<-b.done
+	if errors.Is(b.err, io.ErrClosedPipe) {
+		return nil
+	}
+	return b.err
+}
+
+// sentNotifier 请求体读到末尾时关闭 sent，用于区分发送数据和等待响应两个阶段
+type sentNotifier struct {
+	r    io.Reader
+	once sync.Once
+	sent chan struct{}
+}
+
+func newSentNotifier(r io.Reader) *sentNotifier {
+	return &sentNotifier{r: r, sent: make(chan struct{})}
+}
+
+func (s *sentNotifier) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if err == io.EOF {
+		s.once.Do(func() { close(s.sent) })
+	}
+	return n, err
+}
+
+// 请求体发送完后定期提示仍在等待服务器响应，避免长时间的校验或加载看起来像卡住；返回停止提示的函数
+func (s *sentNotifier) notice(timeout time.Duration) (stop func()) {
+	done := make(chan struct{})
+	var once sync.Once
+	go func() {
+		select {
+		case <-s.sent:
+		case <-done:
+			return
+		}
+		start := time.Now()
+		fmt.Println("\n⏳ 数据已全部发送，等待服务器处理...")
+		ticker := time.NewTicker(responseNoticeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				waited := time.Since(start).Round(time.Second)
+				if timeout > 0 {
+					fmt.Printf("⏳ 已等待服务器响应 %s (-response-timeout %s)\n", waited, timeout)
+				} else {
+					fmt.Printf("⏳ 已等待服务器响应 %s\n", waited)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// 请求体发送完后等待响应超时 (Transport.ResponseHeaderTimeout)
+func responseTimedOut(s *sentNotifier, err error) bool {
+	select {
+	case <-s.sent:
+	default:
+		return false
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// 将请求体完整写入临时文件，用于不接受分块传输、需要预先知道长度的目标；用完后由调用方删除
+func spoolBody(r io.Reader) (*os.File, int64, error) {
+	f, err := os.CreateTemp("", "dss-body-*")
+	if err != nil {
+		return nil, 0, fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	n, err := io.Copy(f, r)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, 0, err
+	}
+	return f, n, nil
+}
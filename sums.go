@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+)
+
+// checksumManifest 一批文件的 SHA256SUMS 清单，格式与 sha256sum 的输出一致，
+// 接收方可直接用 sha256sum -c 校验整批文件
+type checksumManifest struct {
+	mu   sync.Mutex
+	sums map[string]string // 文件名 -> 十六进制摘要
+}
+
+func newChecksumManifest() *checksumManifest {
+	return &checksumManifest{sums: map[string]string{}}
+}
+
+// 记录一个文件的摘要
+func (m *checksumManifest) add(name, sum string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sums[name] = sum
+}
+
+// 按文件名排序生成清单内容
+func (m *checksumManifest) bytes() []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.sums))
+	for name := range m.sums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s  %s\n", m.sums[name], name)
+	}
+	return buf.Bytes()
+}
+
+// 用 Ed25519 私钥 (PKCS#8 PEM，可由 openssl genpkey -algorithm ed25519 生成) 对清单签名，
+// 返回原始的 64 字节签名，可用 openssl pkeyutl -verify -rawin 校验
+func signManifest(keyPath string, data []byte) ([]byte, error) {
+	raw, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("读取签名私钥失败: %w", err)
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("签名私钥不是 PEM 格式")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("解析签名私钥失败: %w", err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("签名私钥必须是 Ed25519 密钥")
+	}
+	return priv.Sign(nil, data, crypto.Hash(0))
+}
+
+// 上传清单 (以及签名) 作为批次的附属文件，与批次内的文件保存在同一位置
+func uploadManifest(ctx context.Context, client *http.Client, serverURL, name string, m *checksumManifest, keyPath string, form formOptions) error {
+	data := m.bytes()
+	if err := postSmallFile(ctx, client, serverURL, name, data, form); err != nil {
+		return fmt.Errorf("上传 %s 失败: %w", name, err)
+	}
+	fmt.Printf("🧾 已上传校验清单: %s\n", name)
+
+	if keyPath == "" {
+		return nil
+	}
+	sig, err := signManifest(keyPath, data)
+	if err != nil {
+		return err
+	}
+	if err := postSmallFile(ctx, client, serverURL, name+".sig", sig, form); err != nil {
+		return fmt.Errorf("上传 %s.sig 失败: %w", name, err)
+	}
+	fmt.Printf("🔏 已上传清单签名: %s.sig\n", name)
+	return nil
+}
+
+// 以普通上传的格式发送一个小文件
+func postSmallFile(ctx context.Context, client *http.Client, serverURL, name string, data []byte, form formOptions) error {
+	body := &bytes.Buffer{}
+	writer := form.newWriter(body)
+	part, err := writer.CreateUploadFile(name, "")
+	if err != nil {
+		return err
+	}
+	part.Write(data)
+	writer.Close()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", serverURL, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("X-Upload-Id", randomID())
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("服务端返回状态码 %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// 通过 TCP_INFO 读取连接的内核统计
+func readTCPStats(conn net.Conn) (*tcpStats, error) {
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil, errors.New("不是 TCP 连接")
+	}
+	raw, err := tc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var info *unix.TCPInfo
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		info, sockErr = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	}); err != nil {
+		return nil, err
+	}
+	if sockErr != nil {
+		return nil, sockErr
+	}
+
+	return &tcpStats{
+		RTT:     time.Duration(info.Rtt) * time.Microsecond,
+		MinRTT:  time.Duration(info.Min_rtt) * time.Microsecond,
+		MSS:     info.Snd_mss,
+		PMTU:    info.Pmtu,
+		Retrans: info.Total_retrans,
+		SegsOut: info.Segs_out,
+		Cwnd:    info.Snd_cwnd,
+	}, nil
+}
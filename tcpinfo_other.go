@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// 非 Linux 平台不支持 TCP_INFO
+func readTCPStats(conn net.Conn) (*tcpStats, error) {
+	return nil, errors.ErrUnsupported
+}
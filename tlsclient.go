@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// tlsClientOptions 访问 HTTPS 服务端时的证书设置
+type tlsClientOptions struct {
+	caCert     string // 额外信任的 CA 证书 (PEM)，与系统信任的 CA 一并使用
+	clientCert string // mTLS 客户端证书
+	clientKey  string
+	insecure   bool // 不校验服务端证书
+}
+
+// 按选项构造 tls.Config，没有任何设置时返回 nil (使用默认配置)
+func (o tlsClientOptions) config() (*tls.Config, error) {
+	if o == (tlsClientOptions{}) {
+		return nil, nil
+	}
+	cfg := &tls.Config{InsecureSkipVerify: o.insecure}
+	if o.caCert != "" {
+		data, err := os.ReadFile(o.caCert)
+		if err != nil {
+			return nil, fmt.Errorf("读取 CA 证书失败: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("CA 证书 %s 中没有有效的证书", o.caCert)
+		}
+		cfg.RootCAs = pool
+	}
+	if (o.clientCert == "") != (o.clientKey == "") {
+		return nil, errors.New("-client-cert 和 -client-key 需要同时指定")
+	}
+	if o.clientCert != "" {
+		cert, err := tls.LoadX509KeyPair(o.clientCert, o.clientKey)
+		if err != nil {
+			return nil, fmt.Errorf("加载客户端证书失败: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// 为客户端应用证书设置
+func (c *sessionClient) useTLS(o tlsClientOptions) error {
+	cfg, err := o.config()
+	if err != nil || cfg == nil {
+		return err
+	}
+	if o.insecure {
+		fmt.Println("⚠️  -insecure: 不校验服务端证书，连接可能被中间人截获")
+	}
+	c.transport.TLSClientConfig = cfg
+	return nil
+}
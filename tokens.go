@@ -0,0 +1,270 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 令牌权限范围
+const (
+	scopeUpload   = "upload"
+	scopeDownload = "download"
+	scopeList     = "list"
+	scopeDelete   = "delete"
+	scopeLoad     = "load" // 在服务端执行 docker load 及回滚标签
+)
+
+var allScopes = []string{scopeUpload, scopeDownload, scopeList, scopeDelete, scopeLoad}
+
+// 令牌前缀，便于在日志和密钥扫描中识别
+const tokenPrefix = "dss_"
+
+// tokenRecord 令牌记录，只保存令牌的摘要
+type tokenRecord struct {
+	ID      string    `json:"id"`
+	Hash    string    `json:"hash"`
+	Scopes  []string  `json:"scopes"`
+	Label   string    `json:"label,omitempty"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires,omitzero"`
+}
+
+// 令牌是否已过期
+func (t tokenRecord) expired(now time.Time) bool {
+	return !t.Expires.IsZero() && now.After(t.Expires)
+}
+
+// 服务端元数据目录 (令牌等)，位于存储目录下
+func serverMetaDir(dir string) string {
+	return filepath.Join(dir, ".dss")
+}
+
+// 令牌文件路径
+func tokenFilePath(dir string) string {
+	return filepath.Join(serverMetaDir(dir), "tokens.json")
+}
+
+// 读取令牌列表，enabled 表示令牌文件是否存在 (即是否启用鉴权)
+func loadTokens(dir string) (tokens []tokenRecord, enabled bool, err error) {
+	data, err := os.ReadFile(tokenFilePath(dir))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("读取令牌文件失败: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, true, fmt.Errorf("解析令牌文件失败: %w", err)
+	}
+	return tokens, true, nil
+}
+
+// 保存令牌列表 (先写临时文件再重命名，避免服务端读到半个文件)
+func saveTokens(dir string, tokens []tokenRecord) error {
+	if err := os.MkdirAll(serverMetaDir(dir), 0o700); err != nil {
+		return fmt.Errorf("创建元数据目录失败: %w", err)
+	}
+
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := tokenFilePath(dir)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("写入令牌文件失败: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// 计算令牌摘要
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// 校验请求携带的令牌是否具有指定权限
+// 从未创建过令牌时服务端不做鉴权，保持与旧版本行为一致；
+// 一旦创建过令牌，即使之后全部吊销也会继续要求鉴权。
+func authorizeRequest(dir string, r *http.Request, scope string) (int, error) {
+	tokens, enabled, err := loadTokens(dir)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !enabled {
+		return http.StatusOK, nil
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return http.StatusUnauthorized, errors.New("缺少访问令牌")
+	}
+
+	hash := hashToken(strings.TrimSpace(token))
+	now := time.Now()
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Hash), []byte(hash)) != 1 {
+			continue
+		}
+		if t.expired(now) {
+			return http.StatusUnauthorized, errors.New("访问令牌已过期")
+		}
+		if !slices.Contains(t.Scopes, scope) {
+			return http.StatusForbidden, fmt.Errorf("访问令牌没有 %s 权限", scope)
+		}
+		return http.StatusOK, nil
+	}
+	return http.StatusUnauthorized, errors.New("访问令牌无效")
+}
+
+// serve token 子命令：管理服务端访问令牌
+func runToken(args []string) {
+	if len(args) == 0 {
+		fmt.Println("用法: docker_save_shell serve token create|list|revoke ...")
+		os.Exit(1)
+	}
+
+	var err error
+	switch args[0] {
+	case "create":
+		err = runTokenCreate(args[1:])
+	case "list":
+		err = runTokenList(args[1:])
+	case "revoke":
+		err = runTokenRevoke(args[1:])
+	default:
+		err = fmt.Errorf("未知的 token 子命令: %s", args[0])
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// 创建令牌，明文只在创建时显示一次
+func runTokenCreate(args []string) error {
+	var dir, scopes, label string
+	var ttl time.Duration
+	fs := flag.NewFlagSet("serve token create", flag.ExitOnError)
+	fs.StringVar(&dir, "dir", "./data", "服务端存储目录")
+	fs.StringVar(&scopes, "scopes", scopeUpload, "权限范围，逗号分隔: "+strings.Join(allScopes, ","))
+	fs.DurationVar(&ttl, "ttl", 0, "有效期 (如 24h)，0 表示永不过期")
+	fs.StringVar(&label, "label", "", "备注，例如令牌发放对象")
+	fs.Parse(args)
+
+	var scopeList []string
+	for _, s := range strings.Split(scopes, ",") {
+		s = strings.TrimSpace(s)
+		if !slices.Contains(allScopes, s) {
+			return fmt.Errorf("未知的权限范围: %s", s)
+		}
+		scopeList = append(scopeList, s)
+	}
+
+	tokens, _, err := loadTokens(dir)
+	if err != nil {
+		return err
+	}
+
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		return fmt.Errorf("生成令牌失败: %w", err)
+	}
+	token := tokenPrefix + hex.EncodeToString(raw)
+	hash := hashToken(token)
+
+	rec := tokenRecord{
+		ID:      hash[:12],
+		Hash:    hash,
+		Scopes:  scopeList,
+		Label:   label,
+		Created: time.Now(),
+	}
+	if ttl > 0 {
+		rec.Expires = rec.Created.Add(ttl)
+	}
+
+	if err := saveTokens(dir, append(tokens, rec)); err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ 令牌已创建 (ID: %s)\n", rec.ID)
+	fmt.Printf("🔑 %s\n", token)
+	fmt.Println("⚠️  令牌明文只显示这一次，请妥善保存")
+	return nil
+}
+
+// 列出令牌
+func runTokenList(args []string) error {
+	var dir string
+	fs := flag.NewFlagSet("serve token list", flag.ExitOnError)
+	fs.StringVar(&dir, "dir", "./data", "服务端存储目录")
+	fs.Parse(args)
+
+	tokens, enabled, err := loadTokens(dir)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		fmt.Println("暂无令牌 (服务端当前不做鉴权)")
+		return nil
+	}
+	if len(tokens) == 0 {
+		fmt.Println("暂无有效令牌 (所有请求都会被拒绝)")
+		return nil
+	}
+
+	now := time.Now()
+	for _, t := range tokens {
+		expires := "永不过期"
+		if !t.Expires.IsZero() {
+			expires = t.Expires.Format(time.DateTime)
+			if t.expired(now) {
+				expires += " (已过期)"
+			}
+		}
+		fmt.Printf("%s  %-28s  %-20s  %s\n", t.ID, strings.Join(t.Scopes, ","), expires, t.Label)
+	}
+	return nil
+}
+
+// 吊销令牌
+func runTokenRevoke(args []string) error {
+	var dir string
+	fs := flag.NewFlagSet("serve token revoke", flag.ExitOnError)
+	fs.StringVar(&dir, "dir", "./data", "服务端存储目录")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("用法: docker_save_shell serve token revoke [-dir DIR] <ID>")
+	}
+	id := fs.Arg(0)
+
+	tokens, _, err := loadTokens(dir)
+	if err != nil {
+		return err
+	}
+	kept := slices.DeleteFunc(tokens, func(t tokenRecord) bool { return t.ID == id })
+	if len(kept) == len(tokens) {
+		return fmt.Errorf("未找到令牌: %s", id)
+	}
+	if err := saveTokens(dir, kept); err != nil {
+		return err
+	}
+	fmt.Printf("✅ 令牌已吊销: %s\n", id)
+	return nil
+}
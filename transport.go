@@ -0,0 +1,217 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"command_tool/pkg/uploader"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// cliTransport 命令行上传所用的 uploader.Transport：按 -form 的格式以 multipart 表单发送到本工具的服务端，
+// 或按预设直接发送到制品库。请求经会话客户端发出，沿用 TLS、代理、鉴权和附加请求头等设置；
+// 服务端的原始响应保留下来，由 uploadFile 显示和进一步处理
+type cliTransport struct {
+	client   *sessionClient
+	opts     options
+	preset   *uploadPreset
+	rec      *transferRecord
+	partType string // 表单中文件分段的 Content-Type (压缩格式)
+	encoding string // 发送到制品库时的 Content-Encoding
+
+	// 表单中写在文件之后的字段，依赖文件内容的摘要，在文件读完后生成
+	fields func(req *uploader.Request) ([][2]string, error)
+
+	// Send 返回后有效
+	start    time.Time     // 开始发送请求的时间
+	status   int           // 响应状态码
+	header   http.Header   // 响应头
+	response *capturedBody // 响应体
+	sent     int64         // 实际发送的请求体字节数
+	sha256   string        // 发送的文件数据 (压缩后) 的 SHA-256
+}
+
+func (t *cliTransport) Send(ctx context.Context, req *uploader.Request) (*uploader.Result, error) {
+	opts, preset := t.opts, t.preset
+
+	// 请求体在后台边读取边发送，内存占用与文件大小无关
+	body := newStreamBody()
+	defer body.Close()
+	contentType := "application/octet-stream"
+	bodySize := int64(-1)
+	var trailer http.Header
+	if preset != nil {
+		// 制品库直接接收文件内容，长度已知时即请求体长度；
+		// 摘要只能在数据发送完后以 trailer 发送，因此 -checksum 时改用分块传输
+		if !(opts.checksum && !preset.sized) {
+			bodySize = req.Size
+		}
+		if opts.checksum {
+			trailer = http.Header{checksumHeader: nil}
+		}
+		body.start(func() error {
+			if _, err := io.Copy(body.w, req.Body); err != nil {
+				return err
+			}
+			if trailer != nil {
+				trailer.Set(checksumHeader, req.SHA256())
+			}
+			return nil
+		})
+	} else {
+		writer := opts.form.newWriter(body.w)
+		contentType = writer.FormDataContentType()
+		body.start(func() error {
+			// 创建multipart部分
+			part, err := writer.CreateUploadFile(req.Options.FileName, t.partType)
+			if err != nil {
+				return fmt.Errorf("创建表单字段失败: %w", err)
+			}
+
+			// 复制文件内容到表单（通过进度条Reader）
+			if _, err := io.Copy(part, req.Body); err != nil {
+				return err
+			}
+
+			fields, err := t.fields(req)
+			if err != nil {
+				return err
+			}
+			for _, f := range fields {
+				if err := writer.WriteField(f[0], f[1]); err != nil {
+					return fmt.Errorf("写入表单字段失败: %w", err)
+				}
+			}
+			return writer.Close()
+		})
+	}
+
+	var reqBody io.Reader = body
+	if preset != nil && preset.sized && bodySize < 0 {
+		// 目标不接受分块传输，只能先缓存到临时文件得到长度
+		fmt.Printf("\n💾 %s 需要预先知道请求体长度，先缓存到临时文件\n", preset.name)
+		spool, n, err := spoolBody(body)
+		if err == nil {
+			err = body.wait()
+		}
+		if err = windowError(ctx, err); err != nil {
+			return nil, fmt.Errorf("读取文件失败: %w", err)
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+		reqBody, bodySize = spool, n
+	}
+
+	// ==================== 5. 发送请求（带上传进度） ====================
+	fmt.Println("\n🚀 正在连接到服务器...")
+
+	sent := newSentNotifier(reqBody)
+	reqBody = sent
+
+	// 创建请求
+	var hreq *http.Request
+	var err error
+	if preset != nil {
+		hreq, err = preset.request(ctx, t.client.Client, opts, req.Options.FileName, reqBody)
+	} else {
+		hreq, err = http.NewRequestWithContext(ctx, "POST", req.Options.URL, reqBody)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %w", err)
+	}
+	hreq.ContentLength = bodySize
+	hreq.Header.Set("Content-Type", contentType)
+	// 制品库直接保存请求体，以 Content-Encoding 标明压缩格式；multipart 中由文件分段的 Content-Type 标明
+	if t.encoding != "" {
+		hreq.Header.Set("Content-Encoding", t.encoding)
+	}
+	if trailer != nil {
+		if bodySize < 0 {
+			hreq.Trailer = trailer
+		} else if v := trailer.Get(checksumHeader); v != "" {
+			// 已缓存到临时文件，摘要在发送前即可得到
+			hreq.Header.Set(checksumHeader, v)
+		}
+	}
+
+	// 发送请求
+	t.start = time.Now()
+	t.rec.attempted = true
+	stopNotice := sent.notice(opts.responseTimeout)
+	resp, err := t.client.Do(hreq)
+	stopNotice()
+	if err != nil {
+		// 读取文件出错时请求随之中止，此时报告读取错误
+		body.Close()
+		if perr := body.wait(); perr != nil {
+			if err = windowError(ctx, perr); err != nil {
+				return nil, fmt.Errorf("读取文件失败: %w", err)
+			}
+		}
+	}
+	if err = windowError(ctx, err); err != nil {
+		if responseTimedOut(sent, err) {
+			return nil, fmt.Errorf("数据已全部发送，但 %s 内未收到服务器响应 (可用 -response-timeout 调整): %w", opts.responseTimeout, err)
+		}
+		return nil, fmt.Errorf("发送请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// ==================== 6. 读取响应（带下载进度） ====================
+	fmt.Println("\n📥 正在接收服务器响应...")
+
+	// 获取响应体大小（如果服务器提供了Content-Length）
+	contentLength := resp.ContentLength
+
+	if contentLength > 0 && !opts.hideBar && progressStyle == progressStyleBar {
+		// 如果知道响应体大小，显示进度条
+		bar2 := progressbar.NewOptions64(
+			contentLength,
+			progressbar.OptionSetDescription("📥 下载响应"),
+			progressbar.OptionSetWriter(os.Stderr),
+			progressbar.OptionShowBytes(true),
+			progressbar.OptionSetWidth(30),
+		)
+
+		// 使用带进度条的Reader读取响应
+		respBodyReader := progressbar.NewReader(resp.Body, bar2)
+		t.response, err = captureBody(&respBodyReader, int64(opts.maxResponse))
+	} else {
+		// 不知道大小，直接读取
+		t.response, err = captureBody(resp.Body, int64(opts.maxResponse))
+	}
+
+	if err = windowError(ctx, err); err != nil {
+		return nil, fmt.Errorf("读取响应失败: %w", err)
+	}
+
+	// 服务端也可能未读完请求体就返回响应，关闭管道让后台的读取退出
+	body.Close()
+	if err = windowError(ctx, body.wait()); err != nil {
+		return nil, fmt.Errorf("读取文件失败: %w", err)
+	}
+	t.sent, t.sha256 = body.sent.Load(), req.SHA256()
+	t.status, t.header = resp.StatusCode, resp.Header
+
+	// 制品库创建文件时可能返回 201/204
+	if !(resp.StatusCode == http.StatusOK || preset != nil && resp.StatusCode/100 == 2) {
+		return nil, &uploader.StatusError{Code: resp.StatusCode, Message: resp.Status}
+	}
+	res := &uploader.Result{}
+	if preset == nil {
+		// 本工具的服务端返回 JSON 结果，超过 -max-response 被截断时只保留已知的字段
+		json.Unmarshal(t.response.data, res)
+	}
+	res.OK = true
+	res.TransferID = req.Options.TransferID
+	res.Name = cmp.Or(res.Name, req.Options.FileName)
+	res.SHA256 = cmp.Or(res.SHA256, t.sha256)
+	return res, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"command_tool/pkg/uploader"
+)
+
+// 树形摘要的算法和流式计算在 pkg/uploader 中，与嵌入该包的程序共用
+const (
+	treeLeafSize   = uploader.TreeLeafSize
+	treeHashPrefix = uploader.TreeHashPrefix
+)
+
+// 使用 workers 个协程并行计算文件的树形摘要，progress 在每片完成后以累计字节数回调
+func parallelTreeHash(path string, workers int, progress func(done int64)) (string, [][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", nil, err
+	}
+
+	count := int((info.Size() + treeLeafSize - 1) / treeLeafSize)
+	if count == 0 {
+		// 空文件也有一个空叶子，保证根的定义唯一
+		count = 1
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	leaves := make([][]byte, count)
+	jobs := make(chan int)
+	var (
+		wg       sync.WaitGroup
+		done     atomic.Int64
+		errOnce  sync.Once
+		firstErr error
+	)
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, treeLeafSize)
+			for i := range jobs {
+				n, err := f.ReadAt(buf, int64(i)*treeLeafSize)
+				if err != nil && err != io.EOF {
+					errOnce.Do(func() { firstErr = fmt.Errorf("读取第 %d 片失败: %w", i, err) })
+					continue
+				}
+				leaves[i] = uploader.LeafHash(buf[:n])
+				total := done.Add(int64(n))
+				if progress != nil {
+					progress(total)
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < count; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return "", nil, firstErr
+	}
+	return uploader.TreeRoot(leaves), leaves, nil
+}
+
+// hash 子命令：并行计算本地文件的树形摘要 (即上传后的制品 ID)
+func runHash(args []string) {
+	fs := newCommandFlags("hash")
+	workers := fs.Int("workers", runtime.NumCPU(), "并行计算的协程数")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fmt.Println("用法: docker_save_shell hash [-workers N] FILE...")
+		os.Exit(1)
+	}
+
+	failed := false
+	for _, path := range fs.Args() {
+		root, _, err := parallelTreeHash(path, *workers, nil)
+		if err != nil {
+			fmt.Printf("计算 %s 的摘要失败: %v\n", path, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("%s  %s\n", root, path)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// 实现的 tus 协议版本
+const tusVersion = "1.0.0"
+
+// 默认的 PATCH 分块大小：每块确认后才记入断点，块越小中断时重传越少
+const defaultTusChunkSize = 16 << 20
+
+// errTusUploadGone 服务端已不存在断点对应的上传 (过期或被清理)，需要重新创建
+var errTusUploadGone = errors.New("服务端已不存在该上传")
+
+// tusState 断点文件：记录上传地址和服务端已确认的偏移量，重新执行同一上传时从该处继续
+type tusState struct {
+	Location string    `json:"location"`
+	Source   string    `json:"source"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"` // 源文件变化后断点失效
+	Offset   int64     `json:"offset"`
+	Updated  time.Time `json:"updated"`
+}
+
+// 断点文件路径：由源文件和上传地址共同决定
+func tusStatePath(source, endpoint string) string {
+	sum := sha256.Sum256([]byte(source + "\n" + endpoint))
+	return filepath.Join(stateDir(), "tus", hex.EncodeToString(sum[:8])+".json")
+}
+
+// 读取断点，不存在或与源文件不符时返回 nil
+func loadTusState(path string, size int64, modTime time.Time) *tusState {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	st := &tusState{}
+	if json.Unmarshal(data, st) != nil || st.Location == "" || st.Size != size || !st.ModTime.Equal(modTime) {
+		return nil
+	}
+	return st
+}
+
+// 写入断点并 fsync 后再替换，保证掉电或崩溃后记录的偏移量都已被服务端确认
+func (st *tusState) save(path string) error {
+	st.Updated = time.Now()
+	return saveStateFile(path, st)
+}
+
+// 以 JSON 写入断点文件：fsync 临时文件后再替换，崩溃后读到的要么是旧断点，要么是完整的新断点
+func saveStateFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("创建断点目录失败: %w", err)
+	}
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("写入断点失败: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("写入断点失败: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("写入断点失败: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("写入断点失败: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// tus 不支持的上传选项
+func checkTusOptions(opts options) error {
+	switch {
+	case isRemoteSource(opts.filePath):
+		return errors.New("-tus 只能上传本地文件")
+	case opts.preset != "":
+		return errors.New("-tus 不能与 -preset 同时使用")
+	case opts.pipeline != "" && opts.pipeline != defaultPipeline, opts.compress != "" && opts.compress != "none", opts.format != "" && opts.format != "tar", opts.encryptKey != "":
+		return errors.New("-tus 不支持 -pipeline、-compress、-format 和 -encrypt-key (续传需要按原始文件的偏移量定位)")
+	case opts.remoteLoad || opts.remoteTag != "" || opts.artifactName != "" || opts.extractTo != "" || opts.sums:
+		return errors.New("-tus 上传到通用的 tus 服务，不支持 -remote-load、-name、-extract-to、-sums 等本工具服务端的功能")
+	case opts.form.custom():
+		return errors.New("-tus 不以 multipart 表单发送，不支持 -form、-field-name")
+	}
+	return nil
+}
+
+// 以 tus 协议上传：创建上传后按块 PATCH，每块确认后把偏移量记入断点文件；
+// 重新执行时先向服务端查询实际偏移量 (以服务端为准)，从该处继续
+func tusUpload(ctx context.Context, client *sessionClient, opts options, sla *slaMonitor, rec *transferRecord) error {
+	file, err := os.Open(opts.filePath)
+	if err != nil {
+		return fmt.Errorf("无法打开文件: %w", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("无法获取文件信息: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return errors.New("-tus 只能上传普通文件")
+	}
+	source, _ := filepath.Abs(opts.filePath)
+	size := info.Size()
+	fileName := filepath.Base(opts.filePath)
+	if opts.asciiName && !isASCIIName(fileName) {
+		fileName = asciiName(fileName)
+	}
+
+	fmt.Printf("📁 文件: %s\n", fileName)
+	fmt.Printf("📊 大小: %s\n", formatBytes(size))
+	fmt.Printf("🎯 目标: %s (tus)\n", opts.serverURL)
+
+	statePath := tusStatePath(source, opts.serverURL)
+	st := loadTusState(statePath, size, info.ModTime())
+	if st != nil {
+		offset, err := tusOffset(ctx, client, st.Location)
+		switch {
+		case errors.Is(err, errTusUploadGone):
+			fmt.Println("⚠️  断点对应的上传已不存在，重新上传")
+			st = nil
+		case err != nil:
+			return fmt.Errorf("查询续传位置失败: %w", err)
+		default:
+			// 服务端可能保存了中断的块中已收到的部分，偏移量超过断点是正常的；小于断点说明服务端丢失了数据
+			if offset < st.Offset {
+				fmt.Printf("⚠️  服务端确认的偏移量 %s 小于本地断点 %s，以服务端为准\n", formatBytes(offset), formatBytes(st.Offset))
+			}
+			st.Offset = offset
+			fmt.Printf("⏯️  从 %s 处继续上传 (%s)\n", formatBytes(offset), st.Location)
+		}
+	}
+	if st == nil {
+		location, err := tusCreate(ctx, client, opts.serverURL, fileName, size)
+		if err != nil {
+			return err
+		}
+		st = &tusState{Location: location, Source: source, Size: size, ModTime: info.ModTime()}
+		fmt.Printf("🆕 已创建上传: %s\n", location)
+	}
+	if err := st.save(statePath); err != nil {
+		return err
+	}
+
+	chunkSize := int64(opts.tusChunk)
+	if chunkSize <= 0 {
+		chunkSize = defaultTusChunkSize
+	}
+	bar := newTransferBar(size, "📤 上传 "+fileName)
+	bar.Set64(st.Offset)
+	start, startOffset := time.Now(), st.Offset
+	rec.attempted = true
+	sendLimiter := opts.uploadLimiter()
+
+	for failures := 0; st.Offset < size; {
+		n := min(chunkSize, size-st.Offset)
+		var r io.Reader = io.NewSectionReader(file, st.Offset, n)
+		if opts.readLimit > 0 {
+			r = newLimitedReader(ctx, r, int64(opts.readLimit))
+		}
+		if sendLimiter != nil {
+			r = &limitedReader{ctx: ctx, r: r, l: sendLimiter}
+		}
+		offset, err := tusPatch(ctx, client, st.Location, st.Offset, io.TeeReader(&slaCounter{r: &contextReader{ctx: ctx, r: r}, m: sla}, bar), n)
+		if err == nil {
+			st.Offset, failures = offset, 0
+			if err := st.save(statePath); err != nil {
+				return err
+			}
+			if opts.chunkDone != nil {
+				opts.chunkDone(st.Offset, n)
+			}
+			if opts.progress != nil {
+				opts.progress(st.Offset, size)
+			}
+			continue
+		}
+		if ctx.Err() != nil {
+			return windowError(ctx, err)
+		}
+
+		// 块上传失败：从重试预算中申领一次重试，再向服务端确认实际收到的位置
+		failures++
+		delay := min(time.Second<<(failures-1), 30*time.Second)
+		if berr := opts.retry.take("chunk", delay); berr != nil {
+			return fmt.Errorf("上传中断于 %s，再次执行同一命令可继续: %w (%v)", formatBytes(st.Offset), err, berr)
+		}
+		fmt.Printf("\n🔁 块上传失败，%s 后重试: %v\n", delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return windowError(ctx, ctx.Err())
+		}
+		if offset, herr := tusOffset(ctx, client, st.Location); herr == nil {
+			st.Offset = offset
+			bar.Set64(offset)
+		}
+	}
+	bar.Finish()
+
+	os.Remove(statePath)
+	rec.Bytes, rec.Duration = size-startOffset, time.Since(start).Seconds()
+	fmt.Println("上传成功!")
+	fmt.Printf("🔗 上传地址: %s\n", st.Location)
+	return nil
+}
+
+// 创建上传，返回服务端分配的上传地址
+func tusCreate(ctx context.Context, client *sessionClient, endpoint, name string, size int64) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, nil)
+	if err != nil {
+		return "", fmt.Errorf("创建请求失败: %w", err)
+	}
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Upload-Length", strconv.FormatInt(size, 10))
+	req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte(name)))
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("创建上传失败: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("创建上传失败: %s", responseError(resp))
+	}
+	loc, err := resp.Location()
+	if err != nil {
+		return "", fmt.Errorf("服务端未返回上传地址: %w", err)
+	}
+	return loc.String(), nil
+}
+
+// 查询服务端已收到的偏移量
+func tusOffset(ctx context.Context, client *sessionClient, location string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", location, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Tus-Resumable", tusVersion)
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return parseUploadOffset(resp)
+	case http.StatusNotFound, http.StatusGone, http.StatusForbidden:
+		return 0, errTusUploadGone
+	}
+	return 0, fmt.Errorf("服务端返回 %s", resp.Status)
+}
+
+// 从 offset 处发送 n 字节，返回服务端确认后的偏移量
+func tusPatch(ctx context.Context, client *sessionClient, location string, offset int64, body io.Reader, n int64) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, "PATCH", location, body)
+	if err != nil {
+		return 0, err
+	}
+	req.ContentLength = n
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("服务端返回 %s", responseError(resp))
+	}
+	return parseUploadOffset(resp)
+}
+
+func parseUploadOffset(resp *http.Response) (int64, error) {
+	offset, err := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("服务端返回的 Upload-Offset 无效: %q", resp.Header.Get("Upload-Offset"))
+	}
+	return offset, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"command_tool/pkg/uploader"
+)
+
+// 最多保留的已完成校验状态数
+const maxFinishedStatuses = 100
+
+// 客户端可指定的上传 ID 格式
+var uploadIDPattern = uploader.IDPattern
+
+// verifyStatus 服务端对一次上传的校验进度
+type verifyStatus struct {
+	ID       string    `json:"id"`
+	Transfer string    `json:"transfer_id,omitempty"`
+	File     string    `json:"file"`
+	State    string    `json:"state"` // verifying / done / failed
+	Done     int64     `json:"done"`
+	Total    int64     `json:"total"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished,omitzero"`
+	Error    string    `json:"error,omitempty"`
+	Output   string    `json:"output,omitempty"` // 外部校验命令 (-verify-cmd) 的输出
+}
+
+// statusTracker 记录正在进行和最近完成的校验
+type statusTracker struct {
+	mu       sync.Mutex
+	statuses map[string]*verifyStatus
+	finished []string // 已完成的 ID，按完成顺序排列，用于淘汰旧记录
+}
+
+// 传输 ID 的请求头。客户端为每次传输生成一个 ID (重试时不变)，附在所有请求上，
+// 服务端记入日志、审计日志和响应，便于在客户端日志、代理和接收端之间关联同一次传输
+const transferIDHeader = uploader.TransferIDHeader
+
+// 取请求携带的传输 ID，没有或格式不对时为空
+func transferID(r *http.Request) string {
+	if id := r.Header.Get(transferIDHeader); uploadIDPattern.MatchString(id) {
+		return id
+	}
+	return ""
+}
+
+// 取请求指定的上传 ID (X-Upload-Id)，未指定时随机生成
+func uploadID(r *http.Request) string {
+	if id := r.Header.Get("X-Upload-Id"); uploadIDPattern.MatchString(id) {
+		return id
+	}
+	return randomID()
+}
+
+// 开始跟踪一次校验
+func (t *statusTracker) start(id, transfer, file string, total int64) *verifyStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.statuses == nil {
+		t.statuses = map[string]*verifyStatus{}
+	}
+	st := &verifyStatus{ID: id, Transfer: transfer, File: file, State: "verifying", Total: total, Started: time.Now()}
+	t.statuses[id] = st
+	return st
+}
+
+// 更新进度
+func (t *statusTracker) progress(st *verifyStatus, done int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if done > st.Done {
+		st.Done = done
+	}
+}
+
+// 记录外部校验命令的输出
+func (t *statusTracker) setOutput(st *verifyStatus, output string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st.Output = output
+}
+
+// 结束校验，err 为 nil 表示校验通过
+func (t *statusTracker) finish(st *verifyStatus, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	st.Finished = time.Now()
+	st.State = "done"
+	if err != nil {
+		st.State = "failed"
+		st.Error = err.Error()
+	}
+
+	t.finished = append(t.finished, st.ID)
+	for len(t.finished) > maxFinishedStatuses {
+		delete(t.statuses, t.finished[0])
+		t.finished = t.finished[1:]
+	}
+}
+
+// 取状态快照
+func (t *statusTracker) get(id string) (verifyStatus, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st, ok := t.statuses[id]
+	if !ok {
+		return verifyStatus{}, false
+	}
+	return *st, true
+}
+
+// 查询校验进度
+func (s *server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	st, ok := s.status.get(r.PathValue("id"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, uploadResult{Error: "未找到该上传的校验状态"})
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+// 使用工作池并行计算已接收文件的树形摘要，进度可通过 /status/{id} 查询
+func (s *server) verifyTree(id string, rf *receivedFile) (string, [][]byte, error) {
+	st := s.status.start(id, rf.transferID, rf.name, rf.size)
+	root, leaves, err := parallelTreeHash(rf.tmpPath, s.opts.verifyWorkers, func(done int64) {
+		s.status.progress(st, done)
+	})
+	s.status.finish(st, err)
+	return root, leaves, err
+}
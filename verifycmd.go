@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// 外部校验命令的输出最多保留的长度，超出部分从开头截断 (结论通常在最后)
+const maxVerifyOutput = 8 * 1024
+
+// 在提交文件前执行 -verify-cmd 指定的外部校验命令 (如厂商提供的签名校验工具)。
+// 文件信息通过与钩子相同的 DSS_* 环境变量传入，DSS_FILE 指向尚未提交的临时文件；
+// 命令以非 0 退出即拒绝该上传。输出记入审计日志，并可通过 /status/{id} 查询
+func (s *server) verifyExternal(id string, rf *receivedFile, fields map[string]string) (*hookResult, error) {
+	st := s.status.start(id, rf.transferID, rf.name, rf.size)
+	ctx, cancel := context.WithTimeout(context.Background(), s.opts.verifyTimeout)
+	output, err := runHookCommand(ctx, s.opts.verifyCmd, "", receivedInfo{
+		path:     rf.tmpPath,
+		name:     rf.name,
+		sha256:   rf.sha256,
+		artifact: fields["artifact_name"],
+		version:  fields["artifact_version"],
+		transfer: rf.transferID,
+	})
+	cancel()
+	if len(output) > maxVerifyOutput {
+		output = "..." + output[len(output)-maxVerifyOutput:]
+	}
+	s.status.setOutput(st, output)
+	s.status.finish(st, err)
+
+	res := &hookResult{Name: "verify-cmd", OK: err == nil, Output: output}
+	logFields := map[string]string{"name": rf.name, "sha256": rf.sha256, "upload_id": id, "transfer_id": rf.transferID, "output": output}
+	if err != nil {
+		res.Error = err.Error()
+		logFields["error"] = err.Error()
+		s.logEvent(severityError, "verify_rejected", "外部校验未通过: "+rf.name, logFields)
+		fmt.Printf("⛔ 外部校验未通过: %s: %v\n", rf.name, err)
+		if output != "" {
+			fmt.Printf("   %s\n", strings.ReplaceAll(output, "\n", "\n   "))
+		}
+		return res, fmt.Errorf("外部校验未通过: %w", err)
+	}
+	s.logEvent(severityInfo, "verified", "外部校验通过: "+rf.name, logFields)
+	fmt.Printf("🔏 外部校验通过: %s\n", rf.name)
+	return res, nil
+}
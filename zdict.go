@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// 训练字典默认的大小上限 (与 zstd --train 的默认值相同)
+	defaultDictSize = 112 * 1024
+	// 训练时最多使用的制品版本数 (取最新的)
+	maxDictSources = 8
+	// 每个版本最多读取的样本数据量和样本块大小
+	dictSampleBytes = 8 << 20
+	dictSampleChunk = 64 * 1024
+)
+
+// zstdDictInfo 服务端由历史制品训练的 zstd 字典。
+// 压缩帧头部带有字典 ID，服务端据此选择字典解压，不需要额外的表单字段
+type zstdDictInfo struct {
+	ID       uint32    `json:"id"`
+	Artifact string    `json:"artifact,omitempty"` // 训练所用的制品，为空表示全部制品
+	Size     int       `json:"size"`
+	Sources  int       `json:"sources"` // 训练使用的制品版本数
+	Created  time.Time `json:"created"`
+}
+
+// 字典的保存目录: <存储目录>/.dss/dicts/<id>.zdict，元数据为同名 .json
+func (s *server) dictDir() string {
+	return filepath.Join(serverMetaDir(s.opts.dir), "dicts")
+}
+
+// 已训练的字典，按创建时间从新到旧排列；artifact 不为空时只返回该制品的字典
+func (s *server) zstdDicts(artifact string) ([]*zstdDictInfo, error) {
+	entries, err := os.ReadDir(s.dictDir())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	list := []*zstdDictInfo{}
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.dictDir(), e.Name()))
+		if err != nil {
+			continue
+		}
+		info := &zstdDictInfo{}
+		if json.Unmarshal(data, info) != nil || (artifact != "" && info.Artifact != artifact) {
+			continue
+		}
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.After(list[j].Created) })
+	return list, nil
+}
+
+// 读取字典内容
+func (s *server) loadZstdDict(id uint32) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.dictDir(), fmt.Sprintf("%08x.zdict", id)))
+}
+
+// 从制品的最新若干版本中抽取样本训练字典；artifact 为空时使用所有制品。
+// 存储的是压缩数据时先解压，字典描述的是原始内容
+func (s *server) trainZstdDict(artifact string, size int) (*zstdDictInfo, error) {
+	all, err := s.allArtifacts()
+	if err != nil {
+		return nil, err
+	}
+	var sources []*artifactMeta
+	for _, meta := range all {
+		if artifact == "" || meta.Name == artifact {
+			sources = append(sources, meta)
+		}
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].Created.After(sources[j].Created) })
+	sources = sources[:min(len(sources), maxDictSources)]
+	if len(sources) == 0 {
+		return nil, errors.New("没有可用于训练的制品")
+	}
+
+	var samples [][]byte
+	for _, meta := range sources {
+		chunks, err := sampleArtifact(s.artifactFilePath(meta))
+		if err != nil {
+			return nil, fmt.Errorf("读取 %s@%s 失败: %w", meta.Name, meta.Version, err)
+		}
+		samples = append(samples, chunks...)
+	}
+	data, err := dict.BuildZstdDict(samples, dict.Options{MaxDictSize: size, HashBytes: 6})
+	if err != nil {
+		return nil, fmt.Errorf("训练字典失败: %w", err)
+	}
+	id, err := zstdDictID(data)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &zstdDictInfo{ID: id, Artifact: artifact, Size: len(data), Sources: len(sources), Created: time.Now()}
+	if err := os.MkdirAll(s.dictDir(), 0o755); err != nil {
+		return nil, fmt.Errorf("创建字典目录失败: %w", err)
+	}
+	name := filepath.Join(s.dictDir(), fmt.Sprintf("%08x", id))
+	if err := os.WriteFile(name+".zdict", data, 0o644); err != nil {
+		return nil, fmt.Errorf("保存字典失败: %w", err)
+	}
+	if err := writeFileAtomic(name+".json", info); err != nil {
+		return nil, fmt.Errorf("保存字典失败: %w", err)
+	}
+	return info, nil
+}
+
+// 读取文件开头的数据 (最多 dictSampleBytes，压缩文件读取解压后的内容) 并切分为样本块
+func sampleArtifact(path string) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	dr, _, err := decompressReader(br)
+	if err != nil {
+		return nil, err
+	}
+	if dr != nil {
+		defer dr.Close()
+		r = dr
+	}
+
+	var chunks [][]byte
+	for total := 0; total < dictSampleBytes; {
+		buf := make([]byte, dictSampleChunk)
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			chunks = append(chunks, buf[:n])
+			total += n
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return chunks, nil
+}
+
+// 字典的 ID (zstd 字典格式: 4 字节魔数后为小端序的 ID)
+func zstdDictID(data []byte) (uint32, error) {
+	if len(data) < 8 || string(data[:4]) != "\x37\xa4\x30\xec" {
+		return 0, errors.New("不是 zstd 字典")
+	}
+	return uint32(data[4]) | uint32(data[5])<<8 | uint32(data[6])<<16 | uint32(data[7])<<24, nil
+}
+
+// 压缩帧使用的字典 ID，不是 zstd 数据或未使用字典时返回 0
+func zstdFrameDict(br *bufio.Reader) uint32 {
+	head, _ := br.Peek(zstd.HeaderMaxSize)
+	var h zstd.Header
+	if h.Decode(head) != nil {
+		return 0
+	}
+	return h.DictionaryID
+}
+
+// 列出字典，?artifact= 只列出该制品的字典
+func (s *server) handleListDicts(w http.ResponseWriter, r *http.Request) {
+	list, err := s.zstdDicts(r.URL.Query().Get("artifact"))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, uploadResult{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// 下载字典
+func (s *server) handleDownloadDict(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 16, 32)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, uploadResult{Error: "字典 ID 格式错误"})
+		return
+	}
+	data, err := s.loadZstdDict(uint32(id))
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, uploadResult{Error: "字典不存在"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(data)
+}
+
+// 由历史制品训练字典: POST /dicts?artifact=<名称>&size=<字节数>
+func (s *server) handleTrainDict(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	size := defaultDictSize
+	if v := q.Get("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1024 || n > 1<<20 {
+			writeJSON(w, http.StatusBadRequest, uploadResult{Error: "size 应在 1024 到 1048576 之间"})
+			return
+		}
+		size = n
+	}
+	info, err := s.trainZstdDict(q.Get("artifact"), size)
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, uploadResult{Error: err.Error()})
+		return
+	}
+	fmt.Printf("📚 已训练 zstd 字典 %08x: %s (%d 个版本)\n", info.ID, formatBytes(int64(info.Size)), info.Sources)
+	s.logEvent(severityInfo, "dict_trained", fmt.Sprintf("已训练 zstd 字典 %08x", info.ID), map[string]string{"artifact": info.Artifact, "by": s.uploader(r)})
+	writeJSON(w, http.StatusOK, info)
+}
+
+// 与服务端协商 zstd 字典：取服务端为该制品 (未指定 -name 时为任意制品) 训练的最新字典，
+// 本地按 ID 缓存。服务端没有字典或不支持时返回 nil，按普通 zstd 压缩
+func negotiateZstdDict(ctx context.Context, client *sessionClient, opts options) ([]byte, uint32, error) {
+	base, err := url.JoinPath(opts.serverURL, "dicts")
+	if err != nil {
+		return nil, 0, fmt.Errorf("服务端地址格式错误: %w", err)
+	}
+	endpoint := base
+	if opts.artifactName != "" {
+		endpoint += "?artifact=" + url.QueryEscape(opts.artifactName)
+	}
+	resp, err := fetchDict(ctx, client, endpoint)
+	if err != nil {
+		return nil, 0, fmt.Errorf("查询 zstd 字典失败: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed {
+		return nil, 0, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("查询 zstd 字典失败: %s", responseError(resp))
+	}
+	var list []*zstdDictInfo
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, 0, fmt.Errorf("解析 zstd 字典列表失败: %w", err)
+	}
+	if len(list) == 0 {
+		return nil, 0, nil
+	}
+	id := list[0].ID
+
+	cache := filepath.Join(stateDir(), "dicts", fmt.Sprintf("%08x.zdict", id))
+	if data, err := os.ReadFile(cache); err == nil {
+		return data, id, nil
+	}
+	resp, err = fetchDict(ctx, client, fmt.Sprintf("%s/%08x", base, id))
+	if err != nil {
+		return nil, 0, fmt.Errorf("下载 zstd 字典失败: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("下载 zstd 字典失败: %s", responseError(resp))
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 2<<20))
+	if err != nil {
+		return nil, 0, fmt.Errorf("下载 zstd 字典失败: %w", err)
+	}
+	if got, err := zstdDictID(data); err != nil || got != id {
+		return nil, 0, errors.New("服务端返回的 zstd 字典无效")
+	}
+	if err := os.MkdirAll(filepath.Dir(cache), 0o700); err == nil {
+		os.WriteFile(cache, data, 0o600)
+	}
+	return data, id, nil
+}
+
+func fetchDict(ctx context.Context, client *sessionClient, endpoint string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+// dict 子命令：在服务端训练字典 (train) 或列出已有字典 (list)
+func runDict(args []string) {
+	if len(args) == 0 || (args[0] != "train" && args[0] != "list") {
+		fmt.Println("用法: docker_save_shell dict train|list -url <服务端地址> [-artifact 名称]")
+		os.Exit(1)
+	}
+	var opts options
+	var artifact string
+	size := byteSize(defaultDictSize)
+	fs := flag.NewFlagSet("dict "+args[0], flag.ExitOnError)
+	fs.StringVar(&opts.serverURL, "url", "", "服务端地址 (必须)")
+	fs.StringVar(&artifact, "artifact", "", "由该制品的最新版本训练 (list 时只列出该制品的字典)，为空时使用全部制品")
+	fs.Var(&size, "size", "字典大小上限，如 112K")
+	registerClientFlags(fs, &opts)
+	fs.Parse(args[1:])
+	if opts.serverURL == "" {
+		fmt.Println("错误：缺少必要参数 -url")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	endpoint, err := url.JoinPath(opts.serverURL, "dicts")
+	if err != nil {
+		fmt.Printf("服务端地址格式错误: %v\n", err)
+		os.Exit(1)
+	}
+	q := url.Values{}
+	if artifact != "" {
+		q.Set("artifact", artifact)
+	}
+	client, closeClient := mustRemoteClient(opts)
+	if args[0] == "list" {
+		err = listDicts(client, endpoint, q)
+	} else {
+		q.Set("size", strconv.Itoa(int(size)))
+		err = trainDict(client, endpoint, q)
+	}
+	closeClient()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func listDicts(client *http.Client, endpoint string, q url.Values) error {
+	resp, err := client.Get(endpoint + "?" + q.Encode())
+	if err != nil {
+		return fmt.Errorf("请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("请求失败: %s", responseError(resp))
+	}
+	var list []*zstdDictInfo
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return fmt.Errorf("解析响应失败: %w", err)
+	}
+	fmt.Printf("%-10s %-24s %10s %8s  %s\n", "ID", "ARTIFACT", "SIZE", "SOURCES", "CREATED")
+	for _, d := range list {
+		fmt.Printf("%08x   %-24s %10s %8d  %s\n", d.ID, d.Artifact, formatBytes(int64(d.Size)), d.Sources, d.Created.Local().Format(time.DateTime))
+	}
+	return nil
+}
+
+func trainDict(client *http.Client, endpoint string, q url.Values) error {
+	fmt.Println("📚 正在由历史制品训练 zstd 字典...")
+	resp, err := client.Post(endpoint+"?"+q.Encode(), "", nil)
+	if err != nil {
+		return fmt.Errorf("请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("训练失败: %s", responseError(resp))
+	}
+	var info zstdDictInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return fmt.Errorf("解析响应失败: %w", err)
+	}
+	fmt.Printf("✅ 已训练字典 %08x (%s，%d 个版本)，上传时加 -compress zstd -zstd-dict 即可使用\n", info.ID, formatBytes(int64(info.Size)), info.Sources)
+	return nil
+}